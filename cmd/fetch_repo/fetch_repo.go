@@ -23,12 +23,18 @@ limitations under the License.
 //
 // These differences help us to manage external Go repositories in the manner of
 // Bazel.
+//
+// When --version is given, fetch_repo works in module mode instead. It
+// downloads the zip file for the module at --importpath and --version from
+// a module proxy, verifies it against --sum, and extracts it. No version
+// control tools are needed in this mode.
 package main
 
 import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"golang.org/x/tools/go/vcs"
 )
@@ -39,6 +45,9 @@ var (
 	rev        = flag.String("rev", "", "target revision")
 	dest       = flag.String("dest", "", "destination directory")
 	importpath = flag.String("importpath", "", "Go importpath to the repository fetch")
+	version    = flag.String("version", "", "module version to download from a module proxy, instead of checking out a revision")
+	sum        = flag.String("sum", "", "expected go.sum hash (h1:...) of the module files. Only used with --version.")
+	proxy      = flag.String("proxy", os.Getenv("GOPROXY"), "comma-separated list of module proxy URLs to download modules from, in the same format as GOPROXY. URLs may be file:// URLs. Only used with --version.")
 
 	// Used for overriding in tests to disable network calls.
 	repoRootForImportPath = vcs.RepoRootForImportPath
@@ -74,6 +83,16 @@ func getRepoRoot(remote, cmd, importpath string) (*vcs.RepoRoot, error) {
 }
 
 func run() error {
+	if *version != "" {
+		if *remote != "" || *cmd != "" || *rev != "" {
+			return fmt.Errorf("--remote, --vcs, and --rev may not be used with --version")
+		}
+		return fetchModule(*proxy, *dest, *importpath, *version, *sum)
+	}
+	if *sum != "" {
+		return fmt.Errorf("--sum may only be used with --version")
+	}
+
 	r, err := getRepoRoot(*remote, *cmd, *importpath)
 	if err != nil {
 		return err
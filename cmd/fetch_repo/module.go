@@ -0,0 +1,213 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/repos"
+)
+
+// fetchModule downloads the zip file for a version of a module from a module
+// proxy, verifies its files against sum, a go.sum-style "h1:" hash, and
+// extracts them into dest. Nothing is extracted if verification fails. If
+// sum is empty, the files are not verified, and the hash is logged so it can
+// be added to the repository rule.
+//
+// proxyList has the same format as GOPROXY: a comma-separated list of proxy
+// URLs, tried in order until one has the module. "off" disallows downloads.
+// "direct" means the module should be fetched from its version control
+// repository, which fetch_repo does not support in this mode.
+func fetchModule(proxyList, dest, importpath, version, sum string) error {
+	if importpath == "" {
+		return fmt.Errorf("--importpath must be set with --version")
+	}
+	if proxyList == "" {
+		return fmt.Errorf("--proxy or GOPROXY must be set with --version")
+	}
+	if sum != "" && !strings.HasPrefix(sum, "h1:") {
+		return fmt.Errorf("unsupported module sum %q: only h1: sums are supported", sum)
+	}
+
+	// Download the zip to a temporary file. archive/zip needs random access.
+	tmp, err := ioutil.TempFile("", "fetch_repo")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := downloadModuleZip(tmp, proxyList, importpath, version); err != nil {
+		return err
+	}
+
+	zr, err := zip.OpenReader(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("error opening zip for %s@%s: %v", importpath, version, err)
+	}
+	defer zr.Close()
+	files, err := moduleZipFiles(zr, importpath, version)
+	if err != nil {
+		return err
+	}
+	got, err := hashModuleZip(files, importpath+"@"+version)
+	if err != nil {
+		return err
+	}
+	if sum == "" {
+		log.Printf("%s@%s: sum is not set; downloaded files have sum %q", importpath, version, got)
+	} else if got != sum {
+		return fmt.Errorf("%s@%s: checksum mismatch:\n\tdownloaded: %s\n\texpected:   %s", importpath, version, got, sum)
+	}
+
+	for _, f := range files {
+		if err := extractZipFile(f.f, filepath.Join(dest, filepath.FromSlash(f.name))); err != nil {
+			return fmt.Errorf("%s@%s: error extracting %q: %v", importpath, version, f.f.Name, err)
+		}
+	}
+	return nil
+}
+
+// downloadModuleZip copies the zip file for a version of a module into w
+// from the first proxy in proxyList that has it. Later proxies are only
+// tried when a proxy reports that it doesn't have the module.
+func downloadModuleZip(w io.Writer, proxyList, importpath, version string) error {
+	var notFound error
+	for _, proxyURL := range strings.Split(proxyList, ",") {
+		proxyURL = strings.TrimSpace(proxyURL)
+		switch proxyURL {
+		case "":
+			continue
+		case "off":
+			return fmt.Errorf("%s@%s: module downloads are disabled by GOPROXY=off", importpath, version)
+		case "direct":
+			return fmt.Errorf("%s@%s: not found in any module proxy before \"direct\" in GOPROXY; fetch_repo can't download modules from version control with --version, so set commit or tag instead", importpath, version)
+		}
+		proxy, err := repos.NewModuleProxy(proxyURL)
+		if err != nil {
+			return err
+		}
+		r, err := proxy.Zip(importpath, version)
+		if repos.IsModuleNotFound(err) {
+			notFound = err
+			continue
+		} else if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("error downloading %s@%s: %v", importpath, version, err)
+		}
+		return nil
+	}
+	if notFound == nil {
+		return fmt.Errorf("--proxy or GOPROXY must name at least one proxy with --version")
+	}
+	return notFound
+}
+
+// moduleZipFile is a regular file in a module zip. name is the slash-separated
+// path of the file within the module.
+type moduleZipFile struct {
+	name string
+	f    *zip.File
+}
+
+// moduleZipFiles lists the regular files in a module zip file. Every file in
+// the zip must be under a directory named "<importpath>@<version>/"; this
+// prefix is removed from the names of the returned files.
+func moduleZipFiles(zr *zip.ReadCloser, importpath, version string) ([]moduleZipFile, error) {
+	var files []moduleZipFile
+	prefix := importpath + "@" + version + "/"
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, prefix) {
+			return nil, fmt.Errorf("%s@%s: unexpected file %q in zip; all files must be in %s", importpath, version, f.Name, prefix)
+		}
+		rel := f.Name[len(prefix):]
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			continue // directory entry
+		}
+		if path.Clean(rel) != rel || strings.HasPrefix(rel, "../") || path.IsAbs(rel) || strings.Contains(rel, `\`) {
+			return nil, fmt.Errorf("%s@%s: invalid file name %q in zip", importpath, version, f.Name)
+		}
+		if !f.Mode().IsRegular() {
+			return nil, fmt.Errorf("%s@%s: %q in zip is not a regular file", importpath, version, f.Name)
+		}
+		files = append(files, moduleZipFile{name: rel, f: f})
+	}
+	return files, nil
+}
+
+func extractZipFile(f *zip.File, outPath string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
+		return err
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// hashModuleZip computes the go.sum "h1:" hash of files in a module zip, as
+// if they were under the directory prefix. The hash is the SHA-256 of a
+// summary listing the SHA-256 and name of each file, sorted by name,
+// base64-encoded.
+func hashModuleZip(files []moduleZipFile, prefix string) (string, error) {
+	files = append([]moduleZipFile(nil), files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	summary := sha256.New()
+	for _, file := range files {
+		if strings.Contains(file.name, "\n") {
+			return "", fmt.Errorf("file name %q contains a newline", file.name)
+		}
+		r, err := file.f.Open()
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(summary, "%x  %s\n", h.Sum(nil), prefix+"/"+file.name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
@@ -0,0 +1,154 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testModuleSum = "h1:NKT+wdPiqd3tltsSYvQl1tAvhOYG1uiFOJsG56v7lls="
+
+// writeModuleZip writes a zip file for example.com/mod@v1.0.0 into a file
+// proxy in dir. extra files are added to the zip verbatim.
+func writeModuleZip(t *testing.T, dir string, extra map[string]string) {
+	zipDir := filepath.Join(dir, "example.com", "mod", "@v")
+	if err := os.MkdirAll(zipDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(zipDir, "v1.0.0.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	files := map[string]string{
+		"example.com/mod@v1.0.0/go.mod":   "module example.com/mod\n",
+		"example.com/mod@v1.0.0/a.go":     "package a\n",
+		"example.com/mod@v1.0.0/sub/b.go": "package b\n",
+	}
+	for name, content := range extra {
+		files[name] = content
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetchModule(t *testing.T) {
+	for _, tc := range []struct {
+		desc, sum string
+		extra     map[string]string
+
+		// proxy is the value of GOPROXY. PROXY is replaced with the URL of
+		// the test proxy. Defaults to PROXY.
+		proxy string
+
+		wantErr string
+	}{
+		{
+			desc: "ok",
+			sum:  testModuleSum,
+		}, {
+			desc: "no_sum",
+		}, {
+			desc:    "mismatch",
+			sum:     testModuleSum,
+			extra:   map[string]string{"example.com/mod@v1.0.0/c.go": "package c\n"},
+			wantErr: "checksum mismatch",
+		}, {
+			desc:    "wrong_prefix",
+			extra:   map[string]string{"example.com/other@v1.0.0/c.go": "package c\n"},
+			wantErr: "unexpected file",
+		}, {
+			desc:    "escape",
+			extra:   map[string]string{"example.com/mod@v1.0.0/../c.go": "package c\n"},
+			wantErr: "invalid file name",
+		}, {
+			desc:    "bad_sum",
+			sum:     "h2:abc=",
+			wantErr: "only h1: sums",
+		}, {
+			desc:  "proxy_list",
+			sum:   testModuleSum,
+			proxy: "file:///nonexistent,PROXY,direct",
+		}, {
+			desc:    "off",
+			proxy:   "off,PROXY",
+			wantErr: "GOPROXY=off",
+		}, {
+			desc:    "direct",
+			proxy:   "file:///nonexistent,direct,PROXY",
+			wantErr: `before "direct"`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestFetchModule")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			proxyDir := filepath.Join(dir, "proxy")
+			dest := filepath.Join(dir, "dest")
+			writeModuleZip(t, proxyDir, tc.extra)
+
+			proxy := tc.proxy
+			if proxy == "" {
+				proxy = "PROXY"
+			}
+			proxy = strings.Replace(proxy, "PROXY", "file://"+filepath.ToSlash(proxyDir), -1)
+			err = fetchModule(proxy, dest, "example.com/mod", "v1.0.0", tc.sum)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, tc.wantErr)
+				}
+				// Nothing should be extracted unless the files were verified.
+				if _, err := os.Stat(dest); !os.IsNotExist(err) {
+					t.Errorf("%s exists after error", dest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range map[string]string{
+				"go.mod":   "module example.com/mod\n",
+				"a.go":     "package a\n",
+				"sub/b.go": "package b\n",
+			} {
+				got, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+				if err != nil {
+					t.Error(err)
+				} else if string(got) != want {
+					t.Errorf("%s: got %q; want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
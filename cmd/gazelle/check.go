@@ -0,0 +1,48 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// errStaleBuildFile is returned by checkFile when a build file would be
+// changed. It is not reported as an error for individual files; instead,
+// the command fails after all files have been checked.
+var errStaleBuildFile = errors.New("build file is not up to date")
+
+// checkFile prints the path of a build file, relative to the repository root,
+// if content differs from the file on disk. Nothing is written.
+func checkFile(repoRoot string, content []byte, path string) error {
+	oldContents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && bytes.Equal(oldContents, content) {
+		return nil
+	}
+	rel, err := filepath.Rel(repoRoot, path)
+	if err != nil {
+		rel = path
+	}
+	fmt.Println(filepath.ToSlash(rel))
+	return errStaleBuildFile
+}
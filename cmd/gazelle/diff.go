@@ -17,39 +17,296 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
-
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
-	bzl "github.com/bazelbuild/buildtools/build"
+	"path/filepath"
+	"strings"
 )
 
-func diffFile(c *config.Config, file *bzl.File, path string) error {
-	oldContents, err := ioutil.ReadFile(file.Path)
-	if err != nil {
-		oldContents = nil
+// diffFile prints a unified diff between the build file at path and
+// newContents. Diffs are computed in-process. File names in headers are
+// relative to the repository root with "a/" and "b/" prefixes, so the output
+// for a whole run is a single patch that may be applied with "git apply" or
+// "patch -p1".
+func diffFile(repoRoot string, newContents []byte, path string) error {
+	oldContents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
 	}
-	newContents := bzl.Format(file)
-	if bytes.Equal(oldContents, newContents) {
+	oldExists := err == nil
+	if oldExists && bytes.Equal(oldContents, newContents) {
 		return nil
 	}
-	f, err := ioutil.TempFile("", c.DefaultBuildFileName())
+
+	rel, err := filepath.Rel(repoRoot, path)
 	if err != nil {
-		return err
+		rel = path
 	}
-	f.Close()
-	defer os.Remove(f.Name())
-	if err := ioutil.WriteFile(f.Name(), newContents, 0666); err != nil {
-		return err
-	}
-	cmd := exec.Command("diff", "-u", "--new-file", path, f.Name())
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if _, ok := err.(*exec.ExitError); ok {
-		// diff returns non-zero when files are different. This is not an error.
-		return nil
+	rel = filepath.ToSlash(rel)
+	oldName := "a/" + rel
+	if !oldExists {
+		oldName = "/dev/null"
 	}
+	_, err = os.Stdout.Write(unifiedDiff(oldName, "b/"+rel, oldContents, newContents))
 	return err
 }
+
+// diffContext is the number of unchanged lines printed around each change.
+const diffContext = 3
+
+// unifiedDiff returns a unified diff between old and new, using oldName and
+// newName in the header. An empty slice is returned if the contents are
+// the same.
+func unifiedDiff(oldName, newName string, old, new []byte) []byte {
+	a, b := splitLines(old), splitLines(new)
+	ops := diffLines(a, b)
+
+	var buf bytes.Buffer
+	for i := 0; i < len(ops); {
+		// Find the next change.
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// Extend the hunk until there are more than 2*diffContext unchanged
+		// lines between changes, or until the end.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			j := end
+			for j < len(ops) && ops[j].kind == ' ' {
+				j++
+			}
+			if j == len(ops) || j-end > 2*diffContext {
+				break
+			}
+			end = j
+		}
+		end += diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		hunk := ops[start:end]
+		aStart, bStart := ops[start].aLine, ops[start].bLine
+		var aCount, bCount int
+		for _, op := range hunk {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, op := range hunk {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return buf.Bytes()
+}
+
+// hunkRange formats the range of lines in one side of a hunk. start is the
+// zero-based index of the first line.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// splitLines splits data into lines. Each line includes its terminating
+// newline, except possibly the last.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if i == 0 {
+			i = len(data)
+		}
+		lines = append(lines, string(data[:i]))
+		data = data[i:]
+	}
+	return lines
+}
+
+// diffOp is a line in an edit script. kind is ' ' for an unchanged line, '-'
+// for a deleted line, or '+' for an inserted line. aLine and bLine are the
+// zero-based indices in the old and new files where the line occurs, or
+// would occur.
+type diffOp struct {
+	kind         byte
+	line         string
+	aLine, bLine int
+}
+
+// diffLines computes a minimal edit script that transforms a into b using
+// the linear space variant of the algorithm in "An O(ND) Difference Algorithm
+// and Its Variations" by Eugene Myers. Memory use is proportional to the
+// number of lines, even when the files are very different.
+func diffLines(a, b []string) []diffOp {
+	d := &lineDiffer{
+		a:  a,
+		b:  b,
+		vf: make([]int, 2*(len(a)+len(b))+3),
+		vb: make([]int, 2*(len(a)+len(b))+3),
+	}
+	d.diff(0, len(a), 0, len(b))
+	d.orderChanges()
+	return d.ops
+}
+
+// lineDiffer holds the state of diffLines. vf and vb hold the furthest
+// reaching paths on each diagonal in the forward and backward searches.
+type lineDiffer struct {
+	a, b   []string
+	vf, vb []int
+	ops    []diffOp
+}
+
+// diff appends an edit script that transforms a[x0:x1] into b[y0:y1] to
+// d.ops.
+func (d *lineDiffer) diff(x0, x1, y0, y1 int) {
+	for x0 < x1 && y0 < y1 && d.a[x0] == d.b[y0] {
+		d.ops = append(d.ops, diffOp{kind: ' ', line: d.a[x0], aLine: x0, bLine: y0})
+		x0++
+		y0++
+	}
+	suffix := 0
+	for x0 < x1 && y0 < y1 && d.a[x1-1] == d.b[y1-1] {
+		x1--
+		y1--
+		suffix++
+	}
+
+	switch {
+	case x0 == x1:
+		for y := y0; y < y1; y++ {
+			d.ops = append(d.ops, diffOp{kind: '+', line: d.b[y], aLine: x0, bLine: y})
+		}
+	case y0 == y1:
+		for x := x0; x < x1; x++ {
+			d.ops = append(d.ops, diffOp{kind: '-', line: d.a[x], aLine: x, bLine: y0})
+		}
+	default:
+		x, y := d.split(x0, x1, y0, y1)
+		d.diff(x0, x, y0, y)
+		d.diff(x, x1, y, y1)
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.ops = append(d.ops, diffOp{kind: ' ', line: d.a[x1+i], aLine: x1 + i, bLine: y1 + i})
+	}
+}
+
+// split finds the middle snake of a minimal edit script that transforms
+// a[x0:x1] into b[y0:y1] by searching forward from the start and backward
+// from the end at the same time. It returns a point on the snake, which
+// divides the problem into two smaller ones. Both ranges must be non-empty,
+// and their first and last lines must differ.
+func (d *lineDiffer) split(x0, x1, y0, y1 int) (int, int) {
+	n, m := x1-x0, y1-y0
+	delta := n - m
+	odd := delta%2 != 0
+	off := len(d.vf) / 2
+	d.vf[off+1] = 0
+	d.vb[off+1] = 0
+	for step := 0; step <= (n+m+1)/2; step++ {
+		// Forward search. x and y are offsets from x0 and y0.
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && d.vf[off+k-1] < d.vf[off+k+1]) {
+				x = d.vf[off+k+1]
+			} else {
+				x = d.vf[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[x0+x] == d.b[y0+y] {
+				x++
+				y++
+			}
+			d.vf[off+k] = x
+			if kb := delta - k; odd && -(step-1) <= kb && kb <= step-1 && x+d.vb[off+kb] >= n {
+				return x0 + x, y0 + y
+			}
+		}
+
+		// Backward search. u and v are offsets from x1 and y1 toward the
+		// start, so diagonal k here is diagonal delta-k in the forward search.
+		for k := -step; k <= step; k += 2 {
+			var u int
+			if k == -step || (k != step && d.vb[off+k-1] < d.vb[off+k+1]) {
+				u = d.vb[off+k+1]
+			} else {
+				u = d.vb[off+k-1] + 1
+			}
+			v := u - k
+			for u < n && v < m && d.a[x1-u-1] == d.b[y1-v-1] {
+				u++
+				v++
+			}
+			d.vb[off+k] = u
+			if kf := delta - k; !odd && -step <= kf && kf <= step && d.vf[off+kf]+u >= n {
+				return x1 - u, y1 - v
+			}
+		}
+	}
+	panic("unreachable")
+}
+
+// orderChanges reorders each run of changed lines in d.ops so that deleted
+// lines come before inserted lines, as in the output of diff.
+func (d *lineDiffer) orderChanges() {
+	var run []diffOp
+	for i := 0; i < len(d.ops); {
+		if d.ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(d.ops) && d.ops[j].kind != ' ' {
+			j++
+		}
+		run = append(run[:0], d.ops[i:j]...)
+		x, y := run[0].aLine, run[0].bLine
+		k := i
+		for _, op := range run {
+			if op.kind == '-' {
+				d.ops[k] = diffOp{kind: '-', line: op.line, aLine: x, bLine: y}
+				x++
+				k++
+			}
+		}
+		for _, op := range run {
+			if op.kind == '+' {
+				d.ops[k] = diffOp{kind: '+', line: op.line, aLine: x, bLine: y}
+				y++
+				k++
+			}
+		}
+		i = j
+	}
+}
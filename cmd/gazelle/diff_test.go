@@ -0,0 +1,171 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, tc := range []struct {
+		desc, old, new, want string
+		oldName              string
+	}{
+		{
+			desc: "same",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		}, {
+			desc:    "new_file",
+			oldName: "/dev/null",
+			new:     "a\nb\n",
+			want: `--- /dev/null
++++ b/BUILD.bazel
+@@ -0,0 +1,2 @@
++a
++b
+`,
+		}, {
+			desc: "delete_all",
+			old:  "a\n",
+			want: `--- a/BUILD.bazel
++++ b/BUILD.bazel
+@@ -1 +0,0 @@
+-a
+`,
+		}, {
+			desc: "context",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			new:  "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: `--- a/BUILD.bazel
++++ b/BUILD.bazel
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`,
+		}, {
+			desc: "separate_hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: `--- a/BUILD.bazel
++++ b/BUILD.bazel
+@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -7,4 +7,4 @@
+ 7
+ 8
+ 9
+-10
++ten
+`,
+		}, {
+			desc: "merged_hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n",
+			new:  "one\n2\n3\n4\n5\n6\nseven\n",
+			want: `--- a/BUILD.bazel
++++ b/BUILD.bazel
+@@ -1,7 +1,7 @@
+-1
++one
+ 2
+ 3
+ 4
+ 5
+ 6
+-7
++seven
+`,
+		}, {
+			desc: "no_newline",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: `--- a/BUILD.bazel
++++ b/BUILD.bazel
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			oldName := tc.oldName
+			if oldName == "" {
+				oldName = "a/BUILD.bazel"
+			}
+			got := string(unifiedDiff(oldName, "b/BUILD.bazel", []byte(tc.old), []byte(tc.new)))
+			if got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffLarge(t *testing.T) {
+	var oldBuf, newBuf bytes.Buffer
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&oldBuf, "old %d\n", i)
+		fmt.Fprintf(&newBuf, "new %d\n", i)
+	}
+	for _, tc := range []struct {
+		desc     string
+		old, new []byte
+	}{
+		{desc: "new_file", new: newBuf.Bytes()},
+		{desc: "delete_all", old: oldBuf.Bytes()},
+		{desc: "replace_all", old: oldBuf.Bytes(), new: newBuf.Bytes()},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			got := unifiedDiff("a/BUILD.bazel", "b/BUILD.bazel", tc.old, tc.new)
+			runtime.ReadMemStats(&after)
+
+			var added, deleted int
+			for _, line := range bytes.Split(got, []byte("\n")) {
+				if bytes.HasPrefix(line, []byte("+")) && !bytes.HasPrefix(line, []byte("+++")) {
+					added++
+				} else if bytes.HasPrefix(line, []byte("-")) && !bytes.HasPrefix(line, []byte("---")) {
+					deleted++
+				}
+			}
+			if wantAdded := bytes.Count(tc.new, []byte("\n")); added != wantAdded {
+				t.Errorf("got %d added lines; want %d", added, wantAdded)
+			}
+			if wantDeleted := bytes.Count(tc.old, []byte("\n")); deleted != wantDeleted {
+				t.Errorf("got %d deleted lines; want %d", deleted, wantDeleted)
+			}
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+				t.Errorf("diff allocated %d bytes; want at most %d", alloc, 16<<20)
+			}
+		})
+	}
+}
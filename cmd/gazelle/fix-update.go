@@ -16,68 +16,84 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
-	"github.com/bazelbuild/bazel-gazelle/internal/generator"
-	"github.com/bazelbuild/bazel-gazelle/internal/label"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
 	"github.com/bazelbuild/bazel-gazelle/internal/merger"
-	"github.com/bazelbuild/bazel-gazelle/internal/packages"
-	"github.com/bazelbuild/bazel-gazelle/internal/repos"
-	"github.com/bazelbuild/bazel-gazelle/internal/resolve"
-	"github.com/bazelbuild/bazel-gazelle/internal/rule"
 	"github.com/bazelbuild/bazel-gazelle/internal/wspace"
+	"github.com/bazelbuild/bazel-gazelle/packages"
+	"github.com/bazelbuild/bazel-gazelle/repos"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/update"
 	bzl "github.com/bazelbuild/buildtools/build"
 )
 
 // updateConfig holds configuration information needed to run the fix and
-// update commands. This includes everything in config.Config, but it also
-// includes some additional fields that aren't relevant to other packages.
+// update commands. This includes the options passed to update.Update, but it
+// also includes some additional fields that only matter to the commands.
 type updateConfig struct {
-	c                 *config.Config
+	opts              update.Options
 	emit              emitFunc
 	outDir, outSuffix string
-	repos             []repos.Repo
+
+	// staleFiles is the number of build files found to be out of date in
+	// check mode.
+	staleFiles int
+
+	// reportPath is the name of a file where a JSON report of changes should
+	// be written. No report is written if this is empty.
+	reportPath string
+
+	// cachePath is the absolute path to the file where opts.Cache is saved.
+	// It is empty unless -cache was given.
+	cachePath string
+
+	// writesFiles is true if build files are written in place. In this case,
+	// update.Update writes files itself, and emit is not used for build files.
+	writesFiles bool
+
+	// diagFormat is the format diagnostics are printed in. See diag.Write.
+	diagFormat string
+
+	// strict is true if warnings should cause the command to fail.
+	strict bool
 }
 
-type emitFunc func(*config.Config, *bzl.File, string) error
+// diagnosticsOutput is where diagnostics are printed.
+var diagnosticsOutput io.Writer = os.Stderr
+
+type emitFunc func(repoRoot string, content []byte, path string) error
 
 var modeFromName = map[string]emitFunc{
 	"print": printFile,
 	"fix":   fixFile,
 	"diff":  diffFile,
+	"check": checkFile,
 }
 
-// visitRecord stores information about about a directory visited with
-// packages.Walk.
-type visitRecord struct {
-	// pkgRel is the slash-separated path to the visited directory, relative to
-	// the repository root. "" for the repository root itself.
-	pkgRel string
-
-	// rules is a list of generated Go rules.
-	rules []*rule.Rule
-
-	// empty is a list of empty Go rules that may be deleted.
-	empty []*rule.Rule
-
-	// file is the build file being processed.
-	file *rule.File
+// emitFile emits a build file using the configured output mode. Files that
+// are out of date in check mode are counted, not reported as errors.
+func (uc *updateConfig) emitFile(content []byte, path string) error {
+	err := uc.emit(uc.opts.RepoRoot, content, path)
+	if err == errStaleBuildFile {
+		uc.staleFiles++
+		return nil
+	}
+	return err
 }
 
-type byPkgRel []visitRecord
-
-func (vs byPkgRel) Len() int           { return len(vs) }
-func (vs byPkgRel) Less(i, j int) bool { return vs[i].pkgRel < vs[j].pkgRel }
-func (vs byPkgRel) Swap(i, j int)      { vs[i], vs[j] = vs[j], vs[i] }
-
 func runFixUpdate(cmd command, args []string) error {
 	uc, err := newFixUpdateConfiguration(cmd, args)
 	if err != nil {
@@ -87,95 +103,83 @@ func runFixUpdate(cmd command, args []string) error {
 		// Only check the version when "fix" is run. Generated build files
 		// frequently work with older version of rules_go, and we don't want to
 		// nag too much since there's no way to disable this warning.
-		checkRulesGoVersion(uc.c.RepoRoot)
+		checkRulesGoVersion(uc.opts.RepoRoot)
 	}
+	result, err := updateBuildFiles(uc)
+	if err != nil {
+		return err
+	}
+	return checkDiagnostics(uc, result.Diagnostics)
+}
 
-	l := label.NewLabeler(uc.c)
-	ruleIndex := resolve.NewRuleIndex()
-
-	var visits []visitRecord
-
-	// Visit all directories in the repository.
-	packages.Walk(uc.c, uc.c.RepoRoot, func(dir, rel string, c *config.Config, pkg *packages.Package, file *rule.File, isUpdateDir bool) {
-		// If this file is ignored or if Gazelle was not asked to update this
-		// directory, just index the build file and move on.
-		if !isUpdateDir {
-			if file != nil {
-				ruleIndex.AddRulesFromFile(c, file)
-			}
-			return
-		}
+// checkDiagnostics returns an error if any diagnostics are errors, or if
+// any are warnings and -strict was given.
+func checkDiagnostics(uc *updateConfig, diags []diag.Diagnostic) error {
+	numErrors := diag.Count(diags, diag.Error)
+	numWarnings := diag.Count(diags, diag.Warning)
+	if uc.strict && numErrors+numWarnings > 0 {
+		return fmt.Errorf("found %d errors and %d warnings (-strict is set)", numErrors, numWarnings)
+	}
+	if numErrors > 0 {
+		return fmt.Errorf("found %d errors", numErrors)
+	}
+	return nil
+}
 
-		// Fix any problems in the file.
-		if file != nil {
-			merger.FixFile(c, file)
+func isDiagnosticsFormat(format string) bool {
+	for _, f := range diag.Formats {
+		if f == format {
+			return true
 		}
+	}
+	return false
+}
 
-		// If the file exists, but no Go code is present, create an empty package.
-		// This lets us delete existing rules.
-		if pkg == nil && file != nil {
-			pkg = packages.EmptyPackage(c, dir, rel)
-		}
+// updateBuildFiles generates build files with update.Update and emits them
+// with uc.emit. Files are written directly by update.Update when
+// uc.writesFiles is set. Diagnostics are printed to stderr. The cache is
+// saved and the report is written afterward, if requested.
+func updateBuildFiles(uc *updateConfig) (update.Result, error) {
+	opts := uc.opts
+	opts.Write = uc.writesFiles
+	result, err := update.Update(context.Background(), opts)
+	if err != nil {
+		return result, err
+	}
 
-		// Generate new rules and merge them into the existing file (if present).
-		if pkg != nil {
-			g := generator.NewGenerator(c, l, file)
-			rules, empty := g.GenerateRules(pkg)
-			if file == nil {
-				file = rule.EmptyFile(filepath.Join(c.RepoRoot, filepath.FromSlash(rel), c.DefaultBuildFileName()))
-				for _, r := range rules {
-					r.Insert(file)
-				}
-			} else {
-				rules = merger.MergeFile(file, empty, rules, merger.PreResolveAttrs)
+	if !uc.writesFiles {
+		for _, f := range result.Files {
+			path := filepath.Join(uc.opts.RepoRoot, filepath.FromSlash(f.Path))
+			if uc.outDir != "" {
+				stem := filepath.Base(path) + uc.outSuffix
+				path = filepath.Join(uc.outDir, f.Pkg, stem)
+			}
+			if err := uc.emitFile(f.Content, path); err != nil {
+				log.Print(err)
 			}
-			visits = append(visits, visitRecord{
-				pkgRel: rel,
-				rules:  rules,
-				empty:  empty,
-				file:   file,
-			})
-		}
-
-		// Add library rules to the dependency resolution table.
-		if file != nil {
-			ruleIndex.AddRulesFromFile(c, file)
-		}
-	})
-
-	// Finish building the index for dependency resolution.
-	ruleIndex.Finish()
-
-	// Resolve dependencies.
-	rc := repos.NewRemoteCache(uc.repos)
-	resolver := resolve.NewResolver(uc.c, l, ruleIndex, rc)
-	for _, v := range visits {
-		for _, r := range v.rules {
-			resolver.ResolveRule(r, v.pkgRel)
 		}
-		merger.MergeFile(v.file, v.empty, v.rules, merger.PostResolveAttrs)
 	}
-
-	// Emit merged files.
-	for _, v := range visits {
-		merger.FixLoads(v.file)
-		v.file.Sync()
-		bzl.Rewrite(v.file.File, nil) // have buildifier 'format' our rules.
-
-		path := v.file.Path
-		if uc.outDir != "" {
-			stem := filepath.Base(v.file.Path) + uc.outSuffix
-			path = filepath.Join(uc.outDir, v.pkgRel, stem)
-		}
-		if err := uc.emit(uc.c, v.file.File, path); err != nil {
+	if err := diag.Write(diagnosticsOutput, result.Diagnostics, uc.diagFormat); err != nil {
+		log.Print(err)
+	}
+	if opts.Cache != nil {
+		if err := opts.Cache.Save(); err != nil {
 			log.Print(err)
 		}
 	}
-	return nil
+	if uc.reportPath != "" {
+		if err := writeReport(uc.reportPath, result.Files); err != nil {
+			return result, err
+		}
+	}
+	if uc.staleFiles > 0 {
+		return result, fmt.Errorf("%d build files are not up to date", uc.staleFiles)
+	}
+	return result, nil
 }
 
 func newFixUpdateConfiguration(cmd command, args []string) (*updateConfig, error) {
-	uc := &updateConfig{c: &config.Config{}}
+	uc := &updateConfig{}
 	var err error
 
 	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
@@ -191,11 +195,16 @@ func newFixUpdateConfiguration(cmd command, args []string) (*updateConfig, error
 	fs.Var(&goPrefix, "go_prefix", "prefix of import paths in the current workspace")
 	repoRoot := fs.String("repo_root", "", "path to a directory which corresponds to go_prefix, otherwise gazelle searches for it.")
 	fs.Var(&knownImports, "known_import", "import path for which external resolution is skipped (can specify multiple times)")
-	mode := fs.String("mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff")
+	mode := fs.String("mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff\n\tcheck: lists build files that would change and fails if there are any")
 	outDir := fs.String("experimental_out_dir", "", "write build files to an alternate directory tree")
 	outSuffix := fs.String("experimental_out_suffix", "", "extra suffix appended to build file names. Only used if -experimental_out_dir is also set.")
+	cachePath := fs.String("cache", "", "file where information about source files and directories is cached\n\tbetween runs. Directories where nothing changed are not updated. A relative\n\tpath is interpreted relative to the repository root.")
+	reportPath := fs.String("report", "", "file where a JSON report of created, updated and deleted rules and\n\tunresolved imports is written")
+	goProxy := fs.String("go_proxy", "", "URL of a Go module proxy (GOPROXY protocol) used to find the repositories\n\tthat provide external imports. May be a file:// URL or a local directory.")
+	strict := fs.Bool("strict", false, "fail if any warnings are reported, in addition to errors")
+	diagFormat := fs.String("diagnostics_format", "text", "format of diagnostics printed to stderr:\n\ttext: one diagnostic per line, as path:line:column: severity: message [code]\n\tjson: one JSON object per line")
 	var proto explicitFlag
-	fs.Var(&proto, "proto", "default: generates new proto rules\n\tdisable: does not touch proto rules\n\tlegacy (deprecated): generates old proto rules")
+	fs.Var(&proto, "proto", "default: generates new proto rules\n\tdisable: does not touch proto rules\n\tlegacy (deprecated): generates old proto rules\n\tfile: generates a proto rule for each .proto file\n\tpackage: generates a proto rule for each proto package")
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
 			fixUpdateUsage(fs)
@@ -205,74 +214,78 @@ func newFixUpdateConfiguration(cmd command, args []string) (*updateConfig, error
 		log.Fatal("Try -help for more information.")
 	}
 
-	uc.c.Dirs = fs.Args()
-	if len(uc.c.Dirs) == 0 {
-		uc.c.Dirs = []string{"."}
+	uc.opts.Dirs = fs.Args()
+	if len(uc.opts.Dirs) == 0 {
+		uc.opts.Dirs = []string{"."}
 	}
-	for i := range uc.c.Dirs {
-		uc.c.Dirs[i], err = filepath.Abs(uc.c.Dirs[i])
+	for i := range uc.opts.Dirs {
+		uc.opts.Dirs[i], err = filepath.Abs(uc.opts.Dirs[i])
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	if *repoRoot != "" {
-		uc.c.RepoRoot = *repoRoot
-	} else if len(uc.c.Dirs) == 1 {
-		uc.c.RepoRoot, err = wspace.Find(uc.c.Dirs[0])
+		uc.opts.RepoRoot = *repoRoot
+	} else if len(uc.opts.Dirs) == 1 {
+		uc.opts.RepoRoot, err = wspace.Find(uc.opts.Dirs[0])
 		if err != nil {
 			return nil, fmt.Errorf("-repo_root not specified, and WORKSPACE cannot be found: %v", err)
 		}
 	} else {
-		uc.c.RepoRoot, err = wspace.Find(".")
+		uc.opts.RepoRoot, err = wspace.Find(".")
 		if err != nil {
 			return nil, fmt.Errorf("-repo_root not specified, and WORKSPACE cannot be found: %v", err)
 		}
 	}
-	uc.c.RepoRoot, err = filepath.EvalSymlinks(uc.c.RepoRoot)
+	uc.opts.RepoRoot, err = filepath.EvalSymlinks(uc.opts.RepoRoot)
 	if err != nil {
 		return nil, fmt.Errorf("failed to evaluate symlinks for repo root: %v", err)
 	}
 
-	for _, dir := range uc.c.Dirs {
-		if !isDescendingDir(dir, uc.c.RepoRoot) {
-			return nil, fmt.Errorf("dir %q is not a subdirectory of repo root %q", dir, uc.c.RepoRoot)
+	for _, dir := range uc.opts.Dirs {
+		if !isDescendingDir(dir, uc.opts.RepoRoot) {
+			return nil, fmt.Errorf("dir %q is not a subdirectory of repo root %q", dir, uc.opts.RepoRoot)
 		}
 	}
 
-	uc.c.ValidBuildFileNames = strings.Split(*buildFileName, ",")
-	if len(uc.c.ValidBuildFileNames) == 0 {
+	uc.opts.BuildFileNames = strings.Split(*buildFileName, ",")
+	if len(uc.opts.BuildFileNames) == 0 {
 		return nil, fmt.Errorf("no valid build file names specified")
 	}
 
-	uc.c.SetBuildTags(*buildTags)
-	uc.c.PreprocessTags()
+	if *buildTags != "" {
+		uc.opts.BuildTags = strings.Split(*buildTags, ",")
+	}
 
 	if goPrefix.set {
-		uc.c.GoPrefix = goPrefix.value
+		uc.opts.GoPrefix = goPrefix.value
 	} else {
-		uc.c.GoPrefix, err = loadGoPrefix(uc.c)
+		uc.opts.GoPrefix, err = loadGoPrefix(&config.Config{
+			RepoRoot:            uc.opts.RepoRoot,
+			ValidBuildFileNames: uc.opts.BuildFileNames,
+		})
 		if err != nil {
 			return nil, err
 		}
 	}
-	if err := config.CheckPrefix(uc.c.GoPrefix); err != nil {
+	if err := config.CheckPrefix(uc.opts.GoPrefix); err != nil {
 		return nil, err
 	}
 
-	uc.c.ShouldFix = cmd == fixCmd
+	uc.opts.Fix = cmd == fixCmd
 
-	uc.c.DepMode, err = config.DependencyModeFromString(*external)
+	uc.opts.DepMode, err = config.DependencyModeFromString(*external)
 	if err != nil {
 		return nil, err
 	}
 
 	if proto.set {
-		uc.c.ProtoMode, err = config.ProtoModeFromString(proto.value)
+		uc.opts.ProtoMode, err = config.ProtoModeFromString(proto.value)
 		if err != nil {
 			return nil, err
 		}
-		uc.c.ProtoModeExplicit = true
+		uc.opts.ProtoModeExplicit = true
 	}
 
 	emit, ok := modeFromName[*mode]
@@ -283,9 +296,25 @@ func newFixUpdateConfiguration(cmd command, args []string) (*updateConfig, error
 
 	uc.outDir = *outDir
 	uc.outSuffix = *outSuffix
+	uc.reportPath = *reportPath
+	uc.writesFiles = *mode == "fix" && uc.outDir == ""
+	uc.strict = *strict
+	uc.diagFormat = *diagFormat
+	if !isDiagnosticsFormat(uc.diagFormat) {
+		return nil, fmt.Errorf("unrecognized diagnostics format: %q", uc.diagFormat)
+	}
+	uc.opts.KnownImports = knownImports
+	uc.opts.Languages = languages
+
+	if *goProxy != "" {
+		uc.opts.Proxy, err = repos.NewModuleProxy(*goProxy)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	workspacePath := filepath.Join(uc.c.RepoRoot, "WORKSPACE")
-	if workspace, err := rule.LoadFile(workspacePath); err != nil {
+	workspacePath := filepath.Join(uc.opts.RepoRoot, "WORKSPACE")
+	if workspace, err := rule.LoadFile(workspacePath, ""); err != nil {
 		if !os.IsNotExist(err) {
 			return nil, err
 		}
@@ -293,33 +322,31 @@ func newFixUpdateConfiguration(cmd command, args []string) (*updateConfig, error
 		if err := fixWorkspace(uc, workspace); err != nil {
 			return nil, err
 		}
-		uc.c.RepoName = findWorkspaceName(workspace)
-		uc.repos = repos.ListRepositories(workspace)
-	}
-	repoPrefixes := make(map[string]bool)
-	for _, r := range uc.repos {
-		repoPrefixes[r.GoPrefix] = true
 	}
-	for _, imp := range knownImports {
-		if repoPrefixes[imp] {
-			continue
+	if *cachePath != "" {
+		uc.cachePath = *cachePath
+		if !filepath.IsAbs(uc.cachePath) {
+			uc.cachePath = filepath.Join(uc.opts.RepoRoot, uc.cachePath)
 		}
-		repo := repos.Repo{
-			Name:     label.ImportPathToBazelRepoName(imp),
-			GoPrefix: imp,
+		uc.opts.Cache, err = packages.LoadCache(uc.cachePath, cacheSeed(cmd, args, workspacePath))
+		if err != nil {
+			return nil, err
 		}
-		uc.repos = append(uc.repos, repo)
 	}
 
 	return uc, nil
 }
 
 func fixUpdateUsage(fs *flag.FlagSet) {
-	fmt.Fprint(os.Stderr, `usage: gazelle [fix|update] [flags...] [package-dirs...]
+	fmt.Fprint(os.Stderr, `usage: gazelle [fix|update|watch] [flags...] [package-dirs...]
 
 The update command creates new build files and update existing BUILD files
 when needed.
 
+The watch command updates build files, then keeps running and updates them
+again when files change. Only directories with changed files and packages
+that depend on them are updated.
+
 The fix command also creates and updates build files, and in addition, it may
 make potentially breaking updates to usage of rules. For example, it may
 delete obsolete rules or rename existing rules.
@@ -330,6 +357,9 @@ output mode determines what Gazelle does with updated BUILD files.
   fix (default) - write updated BUILD files back to disk.
   print - print updated BUILD files to stdout.
   diff - diff updated BUILD files against existing files in unified format.
+      The output is a single patch that can be applied with "patch -p1".
+  check - print paths of BUILD files that would be changed, without writing
+      anything. Gazelle exits with a non-zero status if any would change.
 
 Gazelle accepts a list of paths to Go package directories to process (defaults
 to the working directory if none are given). It recursively traverses
@@ -405,12 +435,12 @@ func loadGoPrefix(c *config.Config) (string, error) {
 }
 
 func fixWorkspace(uc *updateConfig, workspace *rule.File) error {
-	if !uc.c.ShouldFix {
+	if !uc.opts.Fix {
 		return nil
 	}
 	shouldFix := false
-	for _, d := range uc.c.Dirs {
-		if d == uc.c.RepoRoot {
+	for _, d := range uc.opts.Dirs {
+		if d == uc.opts.RepoRoot {
 			shouldFix = true
 		}
 	}
@@ -418,22 +448,35 @@ func fixWorkspace(uc *updateConfig, workspace *rule.File) error {
 		return nil
 	}
 
+	var loads []rule.LoadInfo
+	for _, lang := range languages {
+		loads = append(loads, lang.Loads()...)
+	}
 	merger.FixWorkspace(workspace)
-	merger.FixLoads(workspace)
+	merger.FixLoads(workspace, loads)
 	if err := merger.CheckGazelleLoaded(workspace); err != nil {
 		return err
 	}
 	workspace.Sync()
-	return uc.emit(uc.c, workspace.File, workspace.Path)
+	return uc.emitFile(bzl.Format(workspace.File), workspace.Path)
 }
 
-func findWorkspaceName(f *rule.File) string {
-	for _, r := range f.Rules {
-		if r.Kind() == "workspace" {
-			return r.Name()
+// cacheSeed returns a string that identifies everything other than source
+// and build files that affects generated rules: the command and its
+// arguments, the WORKSPACE file, and the Gazelle binary. Directories are
+// regenerated when the seed changes.
+func cacheSeed(cmd command, args []string, workspacePath string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d %q\n", cmd, args)
+	if data, err := ioutil.ReadFile(workspacePath); err == nil {
+		h.Write(data)
+	}
+	if exe, err := os.Executable(); err == nil {
+		if st, err := os.Stat(exe); err == nil {
+			fmt.Fprintf(h, "\n%s %d %d\n", exe, st.Size(), st.ModTime().UnixNano())
 		}
 	}
-	return ""
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 func isDescendingDir(dir, root string) bool {
@@ -19,16 +19,13 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
-	bzl "github.com/bazelbuild/buildtools/build"
 )
 
-func fixFile(c *config.Config, file *bzl.File, path string) error {
+func fixFile(_ string, content []byte, path string) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(path, bzl.Format(file), 0666); err != nil {
+	if err := ioutil.WriteFile(path, content, 0666); err != nil {
 		return err
 	}
 	return nil
@@ -22,7 +22,6 @@ import (
 	"path/filepath"
 	"testing"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
 	bzl "github.com/bazelbuild/buildtools/build"
 )
 
@@ -63,9 +62,7 @@ func TestFixFile(t *testing.T) {
 			},
 		},
 	}
-	c := &config.Config{}
-
-	if err := fixFile(c, stubFile, stubFile.Path); err != nil {
+	if err := fixFile(dir, bzl.Format(stubFile), stubFile.Path); err != nil {
 		t.Errorf("fixFile(%#v) failed with %v; want success", stubFile, err)
 		return
 	}
@@ -29,6 +29,7 @@ const (
 	updateCmd command = iota
 	fixCmd
 	updateReposCmd
+	watchCmd
 	helpCmd
 )
 
@@ -37,6 +38,7 @@ var commandFromName = map[string]command{
 	"help":         helpCmd,
 	"update":       updateCmd,
 	"update-repos": updateReposCmd,
+	"watch":        watchCmd,
 }
 
 func main() {
@@ -67,6 +69,8 @@ func run(args []string) error {
 		help()
 	case updateReposCmd:
 		return updateRepos(args)
+	case watchCmd:
+		return runWatch(args)
 	default:
 		log.Panicf("unknown command: %v", cmd)
 	}
@@ -92,6 +96,8 @@ Gazelle defaults to "update".
       existing rules.
   update-repos - updates repository rules in the WORKSPACE file. Run with
       -h for details.
+  watch - like update, but after updating, Gazelle keeps running and updates
+      build files again when source files change.
   help - show this message.
 
 For usage information for a specific command, run the command with the -h flag.
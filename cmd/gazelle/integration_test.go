@@ -20,13 +20,17 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
+	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/internal/wspace"
 )
 
@@ -768,7 +772,7 @@ go_library(
 
 option go_package = "example.com/repo";
 
-service {}
+service TestService {}
 `,
 		},
 	}
@@ -1258,6 +1262,192 @@ go_repository(
 		}})
 }
 
+func TestImportReposToMacro(t *testing.T) {
+	files := []fileSpec{
+		{
+			path: "WORKSPACE",
+			content: `
+http_archive(
+    name = "io_bazel_rules_go",
+    url = "https://github.com/bazelbuild/rules_go/releases/download/0.10.1/rules_go-0.10.1.tar.gz",
+    sha256 = "4b14d8dd31c6dbaf3ff871adcd03f28c3274e42abc855cb8fb4d01233c0154dc",
+)
+
+http_archive(
+    name = "bazel_gazelle",
+    url = "https://github.com/bazelbuild/bazel-gazelle/releases/download/0.10.0/bazel-gazelle-0.10.0.tar.gz",
+    sha256 = "6228d9618ab9536892aa69082c063207c91e777e51bd3c5544c9c060cafe1bd8",
+)
+
+load("@io_bazel_rules_go//go:def.bzl", "go_register_toolchains", "go_rules_dependencies")
+
+go_rules_dependencies()
+
+go_register_toolchains()
+
+load("@bazel_gazelle//:deps.bzl", "gazelle_dependencies")
+
+gazelle_dependencies()
+`,
+		}, {
+			path: "third_party/BUILD.bazel",
+		}, {
+			path: "third_party/go_deps.bzl",
+			content: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+def go_dependencies():
+    go_repository(
+        name = "org_golang_x_net",
+        importpath = "golang.org/x/net",
+        tag = "1.2",
+    )
+`,
+		}, {
+			path: "Gopkg.lock",
+			content: `
+[[projects]]
+  name = "github.com/pkg/errors"
+  packages = ["."]
+  revision = "645ef00459ed84a119197bfb8d8205042c6df63d"
+  version = "v0.8.0"
+
+[[projects]]
+  branch = "master"
+  name = "golang.org/x/net"
+  packages = ["context"]
+  revision = "66aacef3dd8a676686c7ae3716979581e8b03c47"
+`,
+		},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Run twice to check that the macro is only loaded and called once.
+	args := []string{"update-repos", "-from_file", "Gopkg.lock", "-to_macro", "third_party/go_deps.bzl%go_dependencies"}
+	for i := 0; i < 2; i++ {
+		if err := runGazelle(dir, args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkFiles(t, dir, []fileSpec{
+		{
+			path: "WORKSPACE",
+			content: `
+http_archive(
+    name = "io_bazel_rules_go",
+    url = "https://github.com/bazelbuild/rules_go/releases/download/0.10.1/rules_go-0.10.1.tar.gz",
+    sha256 = "4b14d8dd31c6dbaf3ff871adcd03f28c3274e42abc855cb8fb4d01233c0154dc",
+)
+
+http_archive(
+    name = "bazel_gazelle",
+    url = "https://github.com/bazelbuild/bazel-gazelle/releases/download/0.10.0/bazel-gazelle-0.10.0.tar.gz",
+    sha256 = "6228d9618ab9536892aa69082c063207c91e777e51bd3c5544c9c060cafe1bd8",
+)
+
+load("@io_bazel_rules_go//go:def.bzl", "go_register_toolchains", "go_rules_dependencies")
+
+go_rules_dependencies()
+
+go_register_toolchains()
+
+load("@bazel_gazelle//:deps.bzl", "gazelle_dependencies")
+
+gazelle_dependencies()
+
+load("//third_party:go_deps.bzl", "go_dependencies")
+
+go_dependencies()
+`,
+		}, {
+			path: "third_party/go_deps.bzl",
+			content: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+def go_dependencies():
+  go_repository(
+      name = "org_golang_x_net",
+      commit = "66aacef3dd8a676686c7ae3716979581e8b03c47",
+      importpath = "golang.org/x/net",
+  )
+  go_repository(
+      name = "com_github_pkg_errors",
+      commit = "645ef00459ed84a119197bfb8d8205042c6df63d",
+      importpath = "github.com/pkg/errors",
+  )
+`,
+		},
+	})
+}
+
+func TestImportReposToNewMacro(t *testing.T) {
+	files := []fileSpec{
+		{
+			path: "WORKSPACE",
+			content: `
+http_archive(
+    name = "bazel_gazelle",
+    url = "https://github.com/bazelbuild/bazel-gazelle/releases/download/0.10.0/bazel-gazelle-0.10.0.tar.gz",
+    sha256 = "6228d9618ab9536892aa69082c063207c91e777e51bd3c5544c9c060cafe1bd8",
+)
+`,
+		}, {
+			path: "Gopkg.lock",
+			content: `
+[[projects]]
+  name = "github.com/pkg/errors"
+  packages = ["."]
+  revision = "645ef00459ed84a119197bfb8d8205042c6df63d"
+  version = "v0.8.0"
+`,
+		},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"update-repos", "-from_file", "Gopkg.lock", "-to_macro", "go_deps.bzl%go_dependencies"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFiles(t, dir, []fileSpec{
+		{
+			path: "WORKSPACE",
+			content: `
+http_archive(
+    name = "bazel_gazelle",
+    url = "https://github.com/bazelbuild/bazel-gazelle/releases/download/0.10.0/bazel-gazelle-0.10.0.tar.gz",
+    sha256 = "6228d9618ab9536892aa69082c063207c91e777e51bd3c5544c9c060cafe1bd8",
+)
+
+load("//:go_deps.bzl", "go_dependencies")
+
+go_dependencies()
+`,
+		}, {
+			path: "go_deps.bzl",
+			content: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+def go_dependencies():
+  go_repository(
+      name = "com_github_pkg_errors",
+      commit = "645ef00459ed84a119197bfb8d8205042c6df63d",
+      importpath = "github.com/pkg/errors",
+  )
+`,
+		},
+	})
+}
+
 func TestDeleteRulesInEmptyDir(t *testing.T) {
 	files := []fileSpec{
 		{path: "WORKSPACE"},
@@ -1331,6 +1521,455 @@ go_repository(
 	}
 }
 
+// TestResolveDirective checks that resolve directives override labels found
+// in the index and labels that would otherwise be guessed, and that they only
+// apply in the directory where they are set and in subdirectories.
+func TestResolveDirective(t *testing.T) {
+	files := []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `
+# gazelle:prefix example.com/repo
+# gazelle:resolve go example.com/fork //third_party/fork:lib
+`,
+		}, {
+			path: "a/BUILD.bazel",
+			content: `
+# gazelle:resolve go example.com/repo/b //b:custom
+`,
+		}, {
+			path: "a/a.go",
+			content: `
+package a
+
+import (
+	_ "example.com/fork"
+	_ "example.com/repo/b"
+)
+`,
+		}, {
+			path: "c/c.go",
+			content: `
+package c
+
+import _ "example.com/repo/b"
+`,
+		}, {
+			path:    "b/b.go",
+			content: "package b",
+		},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFiles(t, dir, []fileSpec{
+		{
+			path: "a/BUILD.bazel",
+			content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:resolve go example.com/repo/b //b:custom
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//b:custom",
+        "//third_party/fork:lib",
+    ],
+)
+`,
+		}, {
+			path: "c/BUILD.bazel",
+			content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["c.go"],
+    importpath = "example.com/repo/c",
+    visibility = ["//visibility:public"],
+    deps = ["//b:go_default_library"],
+)
+`,
+		},
+	})
+}
+
+// TestCheckMode checks that -mode=check fails without writing anything when
+// build files are out of date and succeeds once they are updated.
+func TestCheckMode(t *testing.T) {
+	files := []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path:    "BUILD.bazel",
+			content: "# gazelle:prefix example.com/repo\n",
+		}, {
+			path:    "foo/foo.go",
+			content: "package foo\n",
+		},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := runGazelle(dir, []string{"-mode=check"}); err == nil {
+		t.Fatal("got success; want error")
+	} else if want := "1 build files are not up to date"; !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v; want error containing %q", err, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo", "BUILD.bazel")); !os.IsNotExist(err) {
+		t.Errorf("foo/BUILD.bazel was written in check mode: %v", err)
+	}
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, []string{"-mode=check"}); err != nil {
+		t.Errorf("after update: %v", err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	files := []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+# gazelle:prefix example.com/repo
+
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    importpath = "example.com/repo",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["old_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+		}, {
+			path:    "lib.go",
+			content: "package lib\n",
+		}, {
+			path:    "bin/main.go",
+			content: "package main\n\nimport _ \"../../outside\"\n",
+		},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reportPath := filepath.Join(dir, "report.json")
+	if err := runGazelle(dir, []string{"-report=" + reportPath}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := report{Files: []fileReport{
+		{
+			Path: "BUILD.bazel",
+			Updated: []ruleReport{{
+				Kind: "go_library",
+				Name: "go_default_library",
+				Attrs: []attrReport{{
+					Name: "srcs",
+					Old:  `["old.go"]`,
+					New:  `["lib.go"]`,
+				}},
+			}},
+			Deleted: []ruleReport{{Kind: "go_test", Name: "go_default_test"}},
+		}, {
+			Path: "bin/BUILD.bazel",
+			Created: []ruleReport{
+				{Kind: "go_library", Name: "go_default_library"},
+				{Kind: "go_binary", Name: "bin"},
+			},
+			Unresolved: []unresolvedReport{{
+				Rule:   "go_default_library",
+				Import: "../../outside",
+				Error:  `relative import path "../../outside" from "bin" points outside of repository`,
+			}},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %s\nwant %#v", data, want)
+	}
+}
+
+func TestCache(t *testing.T) {
+	files := []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path:    "BUILD.bazel",
+			content: "# gazelle:prefix example.com/repo\n",
+		}, {
+			path:    "a/a.go",
+			content: "package a\n",
+		}, {
+			path:    "b/b.go",
+			content: "package b\n",
+		},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"-cache=.gazelle-cache"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".gazelle-cache")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set the modification times of the build files to the past. Files that
+	// are rewritten will get a new modification time.
+	past := time.Now().Add(-time.Hour)
+	for _, rel := range []string{"a/BUILD.bazel", "b/BUILD.bazel"} {
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(rel)), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b", "b_test.go"), []byte("package b\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		rel       string
+		rewritten bool
+	}{
+		{rel: "a/BUILD.bazel", rewritten: false},
+		{rel: "b/BUILD.bazel", rewritten: true},
+	} {
+		st, err := os.Stat(filepath.Join(dir, filepath.FromSlash(tc.rel)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rewritten := st.ModTime().After(past.Add(time.Minute)); rewritten != tc.rewritten {
+			t.Errorf("%s: rewritten = %v; want %v", tc.rel, rewritten, tc.rewritten)
+		}
+	}
+	checkFiles(t, dir, []fileSpec{{
+		path: "b/BUILD.bazel",
+		content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["b.go"],
+    importpath = "example.com/repo/b",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["b_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+	}})
+}
+
+func TestWatch(t *testing.T) {
+	for _, tc := range []struct {
+		desc       string
+		newWatcher func() (fileWatcher, error)
+	}{
+		{desc: "native", newWatcher: newNativeWatcher},
+		{desc: "poll", newWatcher: nil},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if tc.desc == "native" && tc.newWatcher == nil {
+				t.Skip("no native file watcher on this platform")
+			}
+			oldNewWatcher, oldInterval := newNativeWatcher, pollInterval
+			newNativeWatcher, pollInterval = tc.newWatcher, 10*time.Millisecond
+			defer func() { newNativeWatcher, pollInterval = oldNewWatcher, oldInterval }()
+			testWatch(t)
+		})
+	}
+}
+
+func testWatch(t *testing.T) {
+	files := []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path:    "BUILD.bazel",
+			content: "# gazelle:prefix example.com/repo\n",
+		}, {
+			path:    "foo/foo.proto",
+			content: "syntax = \"proto3\";\n\npackage foo;\n",
+		}, {
+			path:    "bar/bar.proto",
+			content: "syntax = \"proto3\";\n\npackage bar;\n\nimport \"foo/foo.proto\";\n",
+		}, {
+			path:    "baz/baz.go",
+			content: "package baz\n\nimport _ \"github.com/other/foo\"\n",
+		},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	uc, err := newFixUpdateConfiguration(watchCmd, []string{"-repo_root", dir, dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	errc := make(chan error)
+	go func() { errc <- watchRepo(uc, stop) }()
+	defer func() {
+		if stop != nil {
+			close(stop)
+			<-errc
+		}
+	}()
+
+	// waitForFile waits until a file contains a string.
+	waitForFile := func(rel, want string) {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			data, _ := ioutil.ReadFile(p)
+			if strings.Contains(string(data), want) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s to contain %q; content:\n%s", rel, want, data)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitForFile("bar/BUILD.bazel", `"//foo:foo_proto"`)
+	waitForFile("baz/BUILD.bazel", `"@com_github_other_foo//:go_default_library"`)
+
+	// Add a file in a new directory.
+	if err := os.MkdirAll(filepath.Join(dir, "new"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "new", "new.go"), []byte("package new\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	waitForFile("new/BUILD.bazel", `importpath = "example.com/repo/new"`)
+
+	// Change the import path of a package. baz, which imports the new path,
+	// should be updated, even though nothing in baz changed.
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo", "foo.proto"), []byte("syntax = \"proto3\";\n\npackage foo;\n\noption go_package = \"github.com/other/foo\";\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	waitForFile("foo/BUILD.bazel", `importpath = "github.com/other/foo"`)
+	waitForFile("baz/BUILD.bazel", `"//foo:go_default_library"`)
+
+	close(stop)
+	stop = nil
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+}
+
 // TODO(jayconrod): more tests
 //   run in fix mode in testdata directories to create new files
 //   run in diff mode in testdata directories to update existing files (no change)
+
+func TestDiagnostics(t *testing.T) {
+	files := []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+# gazelle:no_such_directive
+`,
+		}, {
+			path:    "a/x.go",
+			content: "package x\n",
+		}, {
+			path:    "a/y.go",
+			content: "package y\n",
+		},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldOutput := diagnosticsOutput
+	defer func() { diagnosticsOutput = oldOutput }()
+	buf := &bytes.Buffer{}
+	diagnosticsOutput = buf
+
+	// Errors cause the command to fail.
+	if err := runGazelle(dir, []string{"-diagnostics_format=json"}); err == nil {
+		t.Error("got success; want error")
+	} else if want := "found 1 errors"; !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v; want error containing %q", err, want)
+	}
+	type jsonDiagnostic struct {
+		Code, Severity, Path string
+	}
+	var got []jsonDiagnostic
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var d jsonDiagnostic
+		if err := dec.Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d)
+	}
+	want := []jsonDiagnostic{
+		{Code: "unknown-directive", Severity: "warning", Path: "BUILD.bazel"},
+		{Code: "multiple-packages", Severity: "error", Path: "a"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got diagnostics %#v; want %#v", got, want)
+	}
+
+	// Warnings are printed, but they don't cause failure unless -strict is set.
+	if err := os.Remove(filepath.Join(dir, "a", "y.go")); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := runGazelle(dir, nil); err != nil {
+		t.Error(err)
+	}
+	if got, want := buf.String(), "BUILD.bazel: warning: unknown directive: gazelle:no_such_directive [unknown-directive]\n"; got != want {
+		t.Errorf("got output %q; want %q", got, want)
+	}
+	if err := runGazelle(dir, []string{"-strict"}); err == nil {
+		t.Error("with -strict: got success; want error")
+	} else if want := "found 0 errors and 1 warnings"; !strings.Contains(err.Error(), want) {
+		t.Errorf("with -strict: got error %v; want error containing %q", err, want)
+	}
+}
@@ -13,11 +13,18 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package config
+package main
 
-// MergableAttrs is the set of attribute names for each kind of rule that
-// may be merged. When an attribute is mergeable, a generated value may
-// replace or augment an existing value. If an attribute is not mergeable,
-// existing values are preserved. Generated non-mergeable attributes may
-// still be added to a rule if there is no corresponding existing attribute.
-type MergeableAttrs map[string]map[string]bool
+import (
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/language/go"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
+)
+
+// languages is the list of language extensions used by fix and update.
+// The order matters: proto must come before go, since the Go extension
+// generates go_proto_library rules from proto_library rules.
+var languages = []language.Language{
+	proto.New(),
+	golang.New(),
+}
@@ -0,0 +1,130 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/bazelbuild/bazel-gazelle/update"
+)
+
+// report describes the changes Gazelle made to build files. It is written
+// as JSON to the file named by the -report flag.
+type report struct {
+	Files []fileReport `json:"files"`
+}
+
+// fileReport describes changes to a single build file. Only files with
+// changes or unresolved imports are reported.
+type fileReport struct {
+	// Path is the slash-separated path to the build file, relative to the
+	// repository root.
+	Path string `json:"path"`
+
+	// Created lists rules that did not exist before Gazelle ran.
+	Created []ruleReport `json:"created,omitempty"`
+
+	// Updated lists existing rules with attributes that were changed.
+	Updated []ruleReport `json:"updated,omitempty"`
+
+	// Deleted lists rules that were removed, usually because they were empty.
+	Deleted []ruleReport `json:"deleted,omitempty"`
+
+	// Unresolved lists imports that could not be resolved to dependencies.
+	Unresolved []unresolvedReport `json:"unresolved,omitempty"`
+}
+
+type ruleReport struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+
+	// Attrs lists attributes that were changed. It is only set for updated
+	// rules.
+	Attrs []attrReport `json:"attrs,omitempty"`
+}
+
+// attrReport describes a change to an attribute. Old and New are the
+// formatted values of the attribute; each is empty if the attribute was
+// added or removed.
+type attrReport struct {
+	Name string `json:"name"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+type unresolvedReport struct {
+	Rule   string `json:"rule"`
+	Import string `json:"import"`
+	Error  string `json:"error"`
+}
+
+// newFileReport converts a file generated by update.Update into a report.
+func newFileReport(f update.File) fileReport {
+	return fileReport{
+		Path:       f.Path,
+		Created:    newRuleReports(f.Created),
+		Updated:    newRuleReports(f.Updated),
+		Deleted:    newRuleReports(f.Deleted),
+		Unresolved: newUnresolvedReports(f.Unresolved),
+	}
+}
+
+func newRuleReports(changes []update.RuleChange) []ruleReport {
+	if changes == nil {
+		return nil
+	}
+	rrs := make([]ruleReport, len(changes))
+	for i, c := range changes {
+		rrs[i] = ruleReport{Kind: c.Kind, Name: c.Name}
+		for _, a := range c.Attrs {
+			rrs[i].Attrs = append(rrs[i].Attrs, attrReport{Name: a.Name, Old: a.Old, New: a.New})
+		}
+	}
+	return rrs
+}
+
+func newUnresolvedReports(unresolved []update.Unresolved) []unresolvedReport {
+	if unresolved == nil {
+		return nil
+	}
+	urs := make([]unresolvedReport, len(unresolved))
+	for i, u := range unresolved {
+		urs[i] = unresolvedReport{Rule: u.Rule, Import: u.Import, Error: u.Err.Error()}
+	}
+	return urs
+}
+
+// isEmpty returns whether the report has nothing to say about the file.
+func (fr fileReport) isEmpty() bool {
+	return len(fr.Created) == 0 && len(fr.Updated) == 0 && len(fr.Deleted) == 0 && len(fr.Unresolved) == 0
+}
+
+// writeReport writes a report of changes to files as JSON to the named file.
+// Files without changes or unresolved imports are omitted.
+func writeReport(path string, files []update.File) error {
+	reports := []fileReport{}
+	for _, f := range files {
+		if fr := newFileReport(f); !fr.isEmpty() {
+			reports = append(reports, fr)
+		}
+	}
+	data, err := json.MarshalIndent(report{Files: reports}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0666)
+}
@@ -20,22 +20,34 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/bazelbuild/bazel-gazelle/internal/merger"
-	"github.com/bazelbuild/bazel-gazelle/internal/repos"
-	"github.com/bazelbuild/bazel-gazelle/internal/rule"
 	"github.com/bazelbuild/bazel-gazelle/internal/wspace"
+	"github.com/bazelbuild/bazel-gazelle/repos"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
-type updateReposFn func(c *updateReposConfiguration, oldFile *rule.File) error
+// updateReposFn generates repository rules and merges them into dest.
+// workspace is the WORKSPACE file. dest is the same file unless -to_macro
+// was given, in which case dest is the .bzl file containing the macro.
+type updateReposFn func(c *updateReposConfiguration, workspace, dest *rule.File, kinds map[string]rule.KindInfo) error
 
 type updateReposConfiguration struct {
 	fn           updateReposFn
 	repoRoot     string
 	lockFilename string
 	importPaths  []string
+	proxy        *repos.ModuleProxy
+
+	// macroPath is the slash-separated path to a .bzl file, relative to
+	// repoRoot, where repository rules are written instead of WORKSPACE.
+	// macroDefName is the name of the function in that file that declares
+	// the rules. Both are "" unless -to_macro is given.
+	macroPath, macroDefName string
 }
 
 func updateRepos(args []string) error {
@@ -45,16 +57,44 @@ func updateRepos(args []string) error {
 	}
 
 	workspacePath := filepath.Join(c.repoRoot, "WORKSPACE")
-	f, err := rule.LoadFile(workspacePath)
+	f, err := rule.LoadFile(workspacePath, "")
 	if err != nil {
 		return fmt.Errorf("error loading %q: %v", workspacePath, err)
 	}
 	merger.FixWorkspace(f)
 
-	if err := c.fn(c, f); err != nil {
+	kinds := make(map[string]rule.KindInfo)
+	var loads []rule.LoadInfo
+	for _, lang := range languages {
+		for kind, info := range lang.Kinds() {
+			kinds[kind] = info
+		}
+		loads = append(loads, lang.Loads()...)
+	}
+
+	dest := f
+	if c.macroPath != "" {
+		macroPath := filepath.Join(c.repoRoot, filepath.FromSlash(c.macroPath))
+		dest, err = rule.LoadMacroFile(macroPath, "", c.macroDefName)
+		if os.IsNotExist(err) {
+			dest, err = rule.EmptyMacroFile(macroPath, "", c.macroDefName), nil
+		}
+		if err != nil {
+			return fmt.Errorf("error loading %q: %v", macroPath, err)
+		}
+	}
+
+	if err := c.fn(c, f, dest, kinds); err != nil {
 		return err
 	}
-	merger.FixLoads(f)
+	if dest != f {
+		merger.FixLoads(dest, loads)
+		if err := dest.Save(); err != nil {
+			return fmt.Errorf("error writing %q: %v", dest.Path, err)
+		}
+		addMacroCall(f, c.macroPath, c.macroDefName)
+	}
+	merger.FixLoads(f, loads)
 	if err := merger.CheckGazelleLoaded(f); err != nil {
 		return err
 	}
@@ -64,6 +104,42 @@ func updateRepos(args []string) error {
 	return nil
 }
 
+// addMacroCall adds a load statement for the macro defName in the .bzl file
+// at macroPath (relative to the repository root) to the end of the
+// WORKSPACE file f, followed by a call to the macro. Nothing is added if
+// the macro is already called.
+func addMacroCall(f *rule.File, macroPath, defName string) {
+	for _, r := range f.Rules {
+		if r.Kind() == defName {
+			return
+		}
+	}
+
+	pkg := path.Dir(macroPath)
+	if pkg == "." {
+		pkg = ""
+	}
+	label := fmt.Sprintf("//%s:%s", pkg, path.Base(macroPath))
+	index := len(f.File.Stmt)
+	loaded := false
+	for _, l := range f.Loads {
+		if l.Name() == label {
+			l.Add(defName)
+			loaded = true
+			break
+		}
+	}
+	if !loaded {
+		l := rule.NewLoad(label)
+		l.Add(defName)
+		l.Insert(f, index)
+	}
+
+	call := rule.NewRule(defName, "")
+	call.DelAttr("name")
+	call.Insert(f)
+}
+
 func newUpdateReposConfiguration(args []string) (*updateReposConfiguration, error) {
 	c := new(updateReposConfiguration)
 	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
@@ -71,8 +147,10 @@ func newUpdateReposConfiguration(args []string) (*updateReposConfiguration, erro
 	// -h or -help were passed explicitly.
 	fs.Usage = func() {}
 
-	fromFileFlag := fs.String("from_file", "", "Gazelle will translate repositories listed in this file into repository rules in WORKSPACE. Currently only dep's Gopkg.lock is supported.")
+	fromFileFlag := fs.String("from_file", "", "Gazelle will translate repositories listed in this file into repository rules in WORKSPACE. Currently dep's Gopkg.lock, go.mod, glide.lock, vendor.json, and Godeps.json are supported.")
 	repoRootFlag := fs.String("repo_root", "", "path to the root directory of the repository. If unspecified, this is assumed to be the directory containing WORKSPACE.")
+	toMacroFlag := fs.String("to_macro", "", "Tells Gazelle to write repository rules into a .bzl macro function rather than the WORKSPACE file.\n\tThe expected format is: macroFile%defName. macroFile is relative to the repository root.")
+	goProxyFlag := fs.String("go_proxy", "", "URL of a Go module proxy (GOPROXY protocol) used to find repositories and their latest versions instead of accessing version control directly. May be a file:// URL or a local directory.")
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
 			updateReposUsage(fs)
@@ -92,6 +170,27 @@ func newUpdateReposConfiguration(args []string) (*updateReposConfiguration, erro
 		}
 	}
 
+	if *toMacroFlag != "" {
+		parts := strings.Split(*toMacroFlag, "%")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || !strings.HasSuffix(parts[0], ".bzl") {
+			return nil, fmt.Errorf("-to_macro: got %q; want a value like macroFile.bzl%%defName", *toMacroFlag)
+		}
+		macroPath := path.Clean(filepath.ToSlash(parts[0]))
+		if path.IsAbs(macroPath) || macroPath == ".." || strings.HasPrefix(macroPath, "../") {
+			return nil, fmt.Errorf("-to_macro: %q must be a relative path within the repository", parts[0])
+		}
+		c.macroPath = macroPath
+		c.macroDefName = parts[1]
+	}
+
+	if *goProxyFlag != "" {
+		proxy, err := repos.NewModuleProxy(*goProxyFlag)
+		if err != nil {
+			return nil, err
+		}
+		c.proxy = proxy
+	}
+
 	// Handle flags specific to each subcommand.
 	switch {
 	case *fromFileFlag != "":
@@ -124,16 +223,24 @@ gazelle update-repos -from_file=file
 The update-repos command updates repository rules in the WORKSPACE file.
 update-repos can add or update repositories explicitly by import path.
 update-repos can also import repository rules from a vendoring tool's lock
-file (currently only deps' Gopkg.lock is supported).
+file (dep's Gopkg.lock, Glide's glide.lock, govendor's vendor.json, or
+godep's Godeps.json) or a Go module file (go.mod).
+
+With -to_macro, rules are written into the body of a function in a .bzl file
+instead of WORKSPACE, and WORKSPACE is updated to load and call the function.
 
 FLAGS:
 
 `)
 }
 
-func updateImportPaths(c *updateReposConfiguration, f *rule.File) error {
-	rs := repos.ListRepositories(f)
+func updateImportPaths(c *updateReposConfiguration, workspace, dest *rule.File, kinds map[string]rule.KindInfo) error {
+	rs := repos.ListRepositories(workspace)
+	if dest != workspace {
+		rs = append(rs, repos.ListRepositories(dest)...)
+	}
 	rc := repos.NewRemoteCache(rs)
+	rc.Proxy = c.proxy
 
 	genRules := make([]*rule.Rule, len(c.importPaths))
 	errs := make([]error, len(c.importPaths))
@@ -160,16 +267,16 @@ func updateImportPaths(c *updateReposConfiguration, f *rule.File) error {
 			return err
 		}
 	}
-	merger.MergeFile(f, nil, genRules, merger.RepoAttrs)
+	merger.MergeFile(dest, nil, genRules, merger.PreResolve, kinds, nil)
 	return nil
 }
 
-func importFromLockFile(c *updateReposConfiguration, f *rule.File) error {
-	genRules, err := repos.ImportRepoRules(c.lockFilename)
+func importFromLockFile(c *updateReposConfiguration, workspace, dest *rule.File, kinds map[string]rule.KindInfo) error {
+	genRules, err := repos.ImportRepoRules(c.lockFilename, c.repoRoot)
 	if err != nil {
 		return err
 	}
 
-	merger.MergeFile(f, nil, genRules, merger.RepoAttrs)
+	merger.MergeFile(dest, nil, genRules, merger.PreResolve, kinds, nil)
 	return nil
 }
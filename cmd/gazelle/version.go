@@ -21,9 +21,9 @@ import (
 	"path/filepath"
 	"regexp"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
-	"github.com/bazelbuild/bazel-gazelle/internal/repos"
+	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/internal/version"
+	"github.com/bazelbuild/bazel-gazelle/repos"
 )
 
 var minimumRulesGoVersion = version.Version{0, 11, 0}
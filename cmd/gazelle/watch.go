@@ -0,0 +1,510 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/bazel-gazelle/packages"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/update"
+)
+
+// runWatch implements the watch command. It updates build files, then
+// updates them again whenever files in the repository change, until
+// Gazelle is interrupted.
+func runWatch(args []string) error {
+	uc, err := newFixUpdateConfiguration(watchCmd, args)
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		close(stop)
+	}()
+	return watchRepo(uc, stop)
+}
+
+// watchDelay is how long watchRepo waits after a change for more changes
+// before updating build files.
+var watchDelay = 100 * time.Millisecond
+
+// watchRepo updates build files, then updates them again when files change
+// until stop is closed. Only directories with changed files and packages
+// that depend on them are updated.
+func watchRepo(uc *updateConfig, stop <-chan struct{}) error {
+	if !uc.writesFiles {
+		return errors.New("watch may only be used with -mode=fix")
+	}
+	if uc.opts.Cache == nil {
+		var err error
+		uc.opts.Cache, err = packages.LoadCache("", "")
+		if err != nil {
+			return err
+		}
+	}
+	// Update everything once, so we know which packages have unresolved
+	// imports.
+	uc.opts.Cache.InvalidateAll()
+
+	w := &watchState{
+		uc:      uc,
+		fw:      newFileWatcher(),
+		watched: make(map[string]bool),
+		written: make(map[string][]byte),
+		imports: make(map[string]map[string]bool),
+		ignored: make(map[string]bool),
+	}
+	defer func() { w.fw.Close() }()
+	for _, p := range []string{uc.cachePath, uc.reportPath} {
+		if p != "" {
+			if abs, err := filepath.Abs(p); err == nil {
+				w.ignored[abs] = true
+			}
+		}
+	}
+
+	if err := w.update(true); err != nil {
+		return err
+	}
+	log.Print("watching for changes")
+	for {
+		var changes []string
+		select {
+		case <-stop:
+			return nil
+		case p, ok := <-w.fw.Changes():
+			if !ok {
+				return errors.New("file watcher stopped unexpectedly")
+			}
+			changes = w.collectChanges(p)
+		}
+		if !w.isRelevant(changes) {
+			continue
+		}
+		if err := w.update(false); err != nil {
+			return err
+		}
+	}
+}
+
+// watchState holds information the watch command keeps between updates.
+type watchState struct {
+	uc *updateConfig
+	fw fileWatcher
+
+	// watched is the set of directories being watched.
+	watched map[string]bool
+
+	// dirs is the list of directories visited in the last update, relative
+	// to the repository root.
+	dirs []string
+
+	// written maps paths of build files written by Gazelle to their content.
+	// Changes to these files are ignored if the content is the same.
+	written map[string][]byte
+
+	// ruleIndex is the index built during the last update.
+	ruleIndex *resolve.RuleIndex
+
+	// imports maps packages (slash-separated paths relative to the
+	// repository root) to the set of strings imported by their rules. When
+	// a package changes, packages that import what it provides are updated,
+	// since their imports may resolve differently.
+	imports map[string]map[string]bool
+
+	// ignored is a set of paths of files written by Gazelle that should not
+	// trigger updates.
+	ignored map[string]bool
+}
+
+// update generates build files in directories that have changed. If any
+// build files change, packages that depend on them are updated, too.
+// initial should be true for the first update, which updates everything.
+func (w *watchState) update(initial bool) error {
+	for {
+		prevDirs, prevIndex := w.dirs, w.ruleIndex
+		result, err := updateBuildFiles(w.uc)
+		if err != nil {
+			return err
+		}
+		w.dirs, w.ruleIndex = result.Dirs, result.Index
+		for _, f := range result.Files {
+			if summary := summarizeChanges(f); summary != "" {
+				log.Printf("%s: %s", f.Path, summary)
+			}
+			if f.Changed() {
+				w.written[filepath.Join(w.uc.opts.RepoRoot, filepath.FromSlash(f.Path))] = f.Content
+			}
+			imports := make(map[string]bool)
+			for _, imp := range f.Imports {
+				imports[imp] = true
+			}
+			w.imports[f.Pkg] = imports
+		}
+
+		var dependents []string
+		if !initial {
+			dependents = w.findDependents(result, prevDirs, prevIndex)
+			for _, rel := range dependents {
+				w.uc.opts.Cache.Invalidate(rel)
+			}
+		}
+		initial = false
+
+		// Directories may have been created while they weren't watched, so
+		// update again after watching new directories.
+		if !w.watchDirs(result.Dirs) && len(dependents) == 0 {
+			return nil
+		}
+	}
+}
+
+// findDependents returns a list of packages that should be updated because
+// packages they may depend on changed. Packages with changed build files
+// and packages that were removed since the last update are considered.
+// Dependents are packages with rules that depend on a changed package and
+// packages that import something a changed package provides, either before
+// or after the change.
+func (w *watchState) findDependents(result update.Result, prevDirs []string, prevIndex *resolve.RuleIndex) []string {
+	var changed []string
+	for _, f := range result.Files {
+		if len(f.Created) > 0 || len(f.Updated) > 0 || len(f.Deleted) > 0 {
+			changed = append(changed, f.Pkg)
+		}
+	}
+	dirSet := make(map[string]bool)
+	for _, rel := range result.Dirs {
+		dirSet[rel] = true
+	}
+	for _, rel := range prevDirs {
+		if !dirSet[rel] {
+			changed = append(changed, rel)
+			delete(w.imports, rel)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	deps := make(map[string]bool)
+	provided := make(map[string]bool)
+	for _, pkg := range changed {
+		for _, dep := range result.Index.FindDependents(pkg) {
+			deps[dep] = true
+		}
+		for _, ix := range []*resolve.RuleIndex{prevIndex, result.Index} {
+			if ix == nil {
+				continue
+			}
+			for _, imp := range ix.ProvidedImports(pkg) {
+				provided[imp.Imp] = true
+			}
+		}
+	}
+	for pkg, imports := range w.imports {
+		for imp := range imports {
+			if provided[imp] {
+				deps[pkg] = true
+				break
+			}
+		}
+	}
+	for _, pkg := range changed {
+		delete(deps, pkg)
+	}
+	var dependents []string
+	for dep := range deps {
+		dependents = append(dependents, dep)
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// watchDirs starts watching directories that aren't already watched. dirs
+// are relative to the repository root. It returns whether any new
+// directories were watched. If a directory can't be watched with the file
+// system's notification mechanism, watchDirs falls back to polling.
+func (w *watchState) watchDirs(rels []string) bool {
+	dirs := make([]string, len(rels))
+	for i, rel := range rels {
+		dirs[i] = filepath.Join(w.uc.opts.RepoRoot, filepath.FromSlash(rel))
+	}
+
+	// Forget directories that no longer exist, so they are watched again if
+	// they are created again.
+	dirSet := make(map[string]bool)
+	for _, dir := range dirs {
+		dirSet[dir] = true
+	}
+	for dir := range w.watched {
+		if !dirSet[dir] {
+			delete(w.watched, dir)
+		}
+	}
+
+	added := false
+	for _, dir := range dirs {
+		if w.watched[dir] {
+			continue
+		}
+		if err := w.fw.Add(dir); err != nil {
+			if _, ok := w.fw.(*pollWatcher); ok {
+				log.Print(err)
+				continue
+			}
+			log.Printf("%v; polling for changes instead", err)
+			w.fw.Close()
+			w.fw = newPollWatcher(pollInterval)
+			for watched := range w.watched {
+				w.fw.Add(watched)
+			}
+			if err := w.fw.Add(dir); err != nil {
+				log.Print(err)
+				continue
+			}
+		}
+		w.watched[dir] = true
+		added = true
+	}
+	return added
+}
+
+// collectChanges returns first and any other changes that occur until no
+// changes have been reported for watchDelay.
+func (w *watchState) collectChanges(first string) []string {
+	changes := []string{first}
+	timer := time.NewTimer(watchDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case p, ok := <-w.fw.Changes():
+			if !ok {
+				return changes
+			}
+			changes = append(changes, p)
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(watchDelay)
+		case <-timer.C:
+			return changes
+		}
+	}
+}
+
+// isRelevant returns whether any of the changed paths may affect build
+// files. Hidden files are ignored, as are files written by Gazelle itself.
+func (w *watchState) isRelevant(changes []string) bool {
+	for _, p := range changes {
+		base := filepath.Base(p)
+		if strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") || w.ignored[p] {
+			continue
+		}
+		if content, ok := w.written[p]; ok {
+			if data, err := ioutil.ReadFile(p); err == nil && bytes.Equal(data, content) {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// summarizeChanges returns a short, human-readable description of the rules
+// that changed in a file. An empty string is returned if no rules changed.
+func summarizeChanges(f update.File) string {
+	var parts []string
+	for _, r := range f.Created {
+		parts = append(parts, fmt.Sprintf("created %s %s", r.Kind, r.Name))
+	}
+	for _, r := range f.Updated {
+		attrs := make([]string, len(r.Attrs))
+		for i, a := range r.Attrs {
+			attrs[i] = a.Name
+		}
+		parts = append(parts, fmt.Sprintf("updated %s %s (%s)", r.Kind, r.Name, strings.Join(attrs, ", ")))
+	}
+	for _, r := range f.Deleted {
+		parts = append(parts, fmt.Sprintf("deleted %s %s", r.Kind, r.Name))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// fileWatcher reports changes to files in a set of directories.
+type fileWatcher interface {
+	// Add starts watching files in a directory. Subdirectories are not
+	// watched unless they are added separately.
+	Add(dir string) error
+
+	// Changes returns a channel that receives paths of files and directories
+	// that were created, modified, or removed. The channel is closed when the
+	// watcher is closed.
+	Changes() <-chan string
+
+	Close() error
+}
+
+// newNativeWatcher creates a fileWatcher that uses the operating system's
+// file notification mechanism. It is nil on platforms where none is
+// supported.
+var newNativeWatcher func() (fileWatcher, error)
+
+// pollInterval is how often a pollWatcher checks for changes.
+var pollInterval = time.Second
+
+// newFileWatcher creates a native fileWatcher if possible. Otherwise, it
+// creates a watcher that polls for changes.
+func newFileWatcher() fileWatcher {
+	if newNativeWatcher != nil {
+		fw, err := newNativeWatcher()
+		if err == nil {
+			return fw
+		}
+		log.Printf("%v; polling for changes instead", err)
+	}
+	return newPollWatcher(pollInterval)
+}
+
+// pollWatcher is a fileWatcher that periodically lists watched directories
+// and compares the sizes and modification times of their files.
+type pollWatcher struct {
+	interval time.Duration
+	changes  chan string
+	done     chan struct{}
+	stopped  chan struct{}
+
+	mu   sync.Mutex
+	dirs map[string]map[string]fileStamp
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		interval: interval,
+		changes:  make(chan string),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		dirs:     make(map[string]map[string]fileStamp),
+	}
+	go w.poll()
+	return w
+}
+
+func (w *pollWatcher) Add(dir string) error {
+	stamps, err := readStamps(dir)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.dirs[dir]; !ok {
+		w.dirs[dir] = stamps
+	}
+	return nil
+}
+
+func (w *pollWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	<-w.stopped
+	return nil
+}
+
+func (w *pollWatcher) poll() {
+	defer close(w.stopped)
+	defer close(w.changes)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		for _, p := range w.scan() {
+			select {
+			case w.changes <- p:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// scan lists watched directories and returns paths of files that changed
+// since the last scan. Directories that can no longer be read are reported
+// as changed and are no longer watched.
+func (w *pollWatcher) scan() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var changes []string
+	for dir, old := range w.dirs {
+		stamps, err := readStamps(dir)
+		if err != nil {
+			delete(w.dirs, dir)
+			changes = append(changes, dir)
+			continue
+		}
+		for name, s := range stamps {
+			if o, ok := old[name]; !ok || o != s {
+				changes = append(changes, filepath.Join(dir, name))
+			}
+		}
+		for name := range old {
+			if _, ok := stamps[name]; !ok {
+				changes = append(changes, filepath.Join(dir, name))
+			}
+		}
+		w.dirs[dir] = stamps
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func readStamps(dir string) (map[string]fileStamp, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	stamps := make(map[string]fileStamp)
+	for _, f := range files {
+		stamps[f.Name()] = fileStamp{size: f.Size(), modTime: f.ModTime(), isDir: f.IsDir()}
+	}
+	return stamps, nil
+}
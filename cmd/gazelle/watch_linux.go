@@ -0,0 +1,120 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+func init() {
+	newNativeWatcher = newInotifyWatcher
+}
+
+// inotifyWatcher is a fileWatcher that uses inotify(7).
+type inotifyWatcher struct {
+	fd      int
+	f       *os.File
+	changes chan string
+	done    chan struct{}
+
+	mu sync.Mutex
+	// dirs maps watch descriptors to watched directories.
+	dirs map[int32]string
+}
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+func newInotifyWatcher() (fileWatcher, error) {
+	// The descriptor is non-blocking, so reads are handled by the runtime's
+	// poller, and Close interrupts a pending read.
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		fd:      fd,
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		changes: make(chan string),
+		done:    make(chan struct{}),
+		dirs:    make(map[int32]string),
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	w.mu.Lock()
+	w.dirs[int32(wd)] = dir
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *inotifyWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *inotifyWatcher) Close() error {
+	close(w.done)
+	return w.f.Close()
+}
+
+func (w *inotifyWatcher) read() {
+	defer close(w.changes)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + syscall.SizeofInotifyEvent
+			off = nameStart + int(ev.Len)
+			name := strings.TrimRight(string(buf[nameStart:off]), "\x00")
+
+			w.mu.Lock()
+			dir, ok := w.dirs[ev.Wd]
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				// The directory was removed or is no longer watched.
+				delete(w.dirs, ev.Wd)
+			}
+			w.mu.Unlock()
+			if !ok || ev.Mask&syscall.IN_IGNORED != 0 {
+				continue
+			}
+
+			p := dir
+			if name != "" {
+				p = filepath.Join(dir, name)
+			}
+			select {
+			case w.changes <- p:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
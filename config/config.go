@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"go/build"
 	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/diag"
 )
 
 // Config holds information about how Gazelle should run. This is mostly
@@ -73,6 +75,29 @@ type Config struct {
 
 	// ProtoModeExplicit indicates whether the proto mode was set explicitly.
 	ProtoModeExplicit bool
+
+	// Diagnostics collects problems found while generating build files. It
+	// is shared by all copies of the configuration. If it is nil,
+	// diagnostics are logged.
+	Diagnostics *diag.Collector
+
+	// Exts is a set of configurable extensions. Generally, each language
+	// has its own set of extensions, but other modules may provide their own
+	// extensions as well. Values in here may be populated by command line
+	// arguments, directives in build files, or other mechanisms.
+	Exts map[string]interface{}
+}
+
+// Clone returns a copy of the configuration. The Exts map is copied, but
+// its values are not; extensions that store mutable values in Exts must
+// replace them rather than modify them in place.
+func (c *Config) Clone() *Config {
+	cc := *c
+	cc.Exts = make(map[string]interface{})
+	for k, v := range c.Exts {
+		cc.Exts[k] = v
+	}
+	return &cc
 }
 
 var DefaultValidBuildFileNames = []string{"BUILD.bazel", "BUILD"}
@@ -172,6 +197,16 @@ const (
 	// LegacyProtoMode generates filegroups for .proto files if .pb.go files
 	// are present in the same directory.
 	LegacyProtoMode
+
+	// FileProtoMode generates a proto_library rule for each .proto file.
+	// go_proto_library rules are generated for each Go package the files
+	// belong to.
+	FileProtoMode
+
+	// PackageProtoMode generates a proto_library rule for each proto package
+	// declared by .proto files in a directory. go_proto_library rules are
+	// generated for each Go package the files belong to.
+	PackageProtoMode
 )
 
 func ProtoModeFromString(s string) (ProtoMode, error) {
@@ -182,7 +217,24 @@ func ProtoModeFromString(s string) (ProtoMode, error) {
 		return DisableProtoMode, nil
 	case "legacy":
 		return LegacyProtoMode, nil
+	case "file":
+		return FileProtoMode, nil
+	case "package":
+		return PackageProtoMode, nil
 	default:
 		return 0, fmt.Errorf("unrecognized proto mode: %q", s)
 	}
 }
+
+// ShouldGenerateRules returns whether proto_library rules are generated in
+// this mode.
+func (m ProtoMode) ShouldGenerateRules() bool {
+	return m == DefaultProtoMode || m == FileProtoMode || m == PackageProtoMode
+}
+
+// GroupsProtoFiles returns whether .proto files in a directory are grouped
+// into rules by file or by proto package in this mode, rather than by the Go
+// package they belong to.
+func (m ProtoMode) GroupsProtoFiles() bool {
+	return m == FileProtoMode || m == PackageProtoMode
+}
@@ -0,0 +1,132 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/diag"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// Directive is a key-value pair extracted from a top-level comment in
+// a build file. Directives have the following format:
+//
+//     # gazelle:key value
+//
+// Keys may not contain spaces. Values may be empty and may contain spaces,
+// but surrounding space is trimmed.
+type Directive struct {
+	Key, Value string
+}
+
+// TODO(jayconrod): annotation directives will apply to an individual rule.
+// They must appear in the block of comments above that rule.
+
+// ParseDirectives scans f for Gazelle directives. The full list of directives
+// is returned. Directives are not checked here; unrecognized directives are
+// reported by CheckDirectives once the set of known directives is available.
+func ParseDirectives(f *bzl.File) []Directive {
+	var directives []Directive
+	parseComment := func(com bzl.Comment) {
+		match := directiveRe.FindStringSubmatch(com.Token)
+		if match == nil {
+			return
+		}
+		key, value := match[1], match[2]
+		directives = append(directives, Directive{key, value})
+	}
+
+	for _, s := range f.Stmt {
+		coms := s.Comment()
+		for _, com := range coms.Before {
+			parseComment(com)
+		}
+		for _, com := range coms.After {
+			parseComment(com)
+		}
+	}
+	return directives
+}
+
+var directiveRe = regexp.MustCompile(`^#\s*gazelle:(\w+)\s*(.*?)\s*$`)
+
+// CheckDirectives reports directives in a build file at path whose keys
+// are not in known.
+func CheckDirectives(c *Config, path string, directives []Directive, known map[string]bool) {
+	for _, d := range directives {
+		if !known[d.Key] {
+			c.Diagnostics.Warnf(diag.UnknownDirective, diag.Position{Path: path}, "unknown directive: gazelle:%s", d.Key)
+		}
+	}
+}
+
+// ReportInvalidDirective reports that the directive d in the build file f
+// was ignored because of err. f may be nil.
+func ReportInvalidDirective(c *Config, f *bzl.File, d Directive, err error) {
+	var pos diag.Position
+	if f != nil {
+		pos.Path = f.Path
+	}
+	c.Diagnostics.Errorf(diag.InvalidDirective, pos, "gazelle:%s %s: %v", d.Key, d.Value, err)
+}
+
+// Configurer is the interface for extensions that read configuration
+// from directives in build files. Configurers are called by packages.Walk
+// in each visited directory.
+type Configurer interface {
+	// KnownDirectives returns a list of directive keys that this Configurer
+	// can interpret. Gazelle prints errors for directives that are not
+	// recognized by any Configurer.
+	KnownDirectives() []string
+
+	// Configure modifies the configuration using directives and other
+	// information extracted from a build file. Configure is called in each
+	// directory.
+	//
+	// c is the configuration for the current directory. It starts out as a
+	// copy of the configuration for the parent directory and may be modified
+	// in place.
+	//
+	// rel is the slash-separated relative path from the repository root to
+	// the current directory. It is "" for the root directory itself.
+	//
+	// f is the syntax tree of the build file in the current directory. It is
+	// nil if there is no build file. directives are the directives parsed
+	// from f (nil if f is nil).
+	Configure(c *Config, rel string, f *bzl.File, directives []Directive)
+}
+
+// CommonConfigurer handles directives that are not specific to any
+// language: build_file_name, exclude, ignore, repo and repository. exclude
+// and ignore are interpreted by packages.Walk, repo by the fix command, and
+// repository by repos.ListRepositories; build_file_name modifies the
+// configuration.
+type CommonConfigurer struct{}
+
+func (cc *CommonConfigurer) KnownDirectives() []string {
+	return []string{"build_file_name", "exclude", "ignore", "repo", "repository"}
+}
+
+func (cc *CommonConfigurer) Configure(c *Config, rel string, f *bzl.File, directives []Directive) {
+	for _, d := range directives {
+		switch d.Key {
+		case "build_file_name":
+			c.ValidBuildFileNames = strings.Split(d.Value, ",")
+		}
+	}
+}
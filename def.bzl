@@ -66,7 +66,7 @@ _gazelle_runner = rule(
             default = "update",
         ),
         "mode": attr.string(
-            values = ["print", "fix", "diff"],
+            values = ["print", "fix", "diff", "check"],
             default="fix"
         ),
         "external": attr.string(
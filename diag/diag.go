@@ -0,0 +1,270 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diag provides structured diagnostics for problems Gazelle finds
+// while generating build files. Each diagnostic has a stable code, a
+// severity, and a position in a build file or source file.
+//
+// Diagnostics are reported to a Collector, which is shared by all
+// configurations in a run through config.Config.Diagnostics. A nil Collector
+// logs diagnostics instead of collecting them.
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Severity indicates how serious a problem is.
+type Severity int
+
+const (
+	// Warning indicates that Gazelle worked around a problem, for example,
+	// by skipping a rule or an import. Build files may be incomplete.
+	Warning Severity = iota
+
+	// Error indicates that Gazelle could not process a file or directory.
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Code identifies a kind of problem. Codes are stable and may be used by
+// tools to filter or suppress diagnostics.
+type Code string
+
+const (
+	// BuildFileError is reported when a build file can't be read or parsed.
+	BuildFileError Code = "build-file-error"
+
+	// MultipleBuildFiles is reported when a directory contains more than one
+	// file with a valid build file name.
+	MultipleBuildFiles Code = "multiple-build-files"
+
+	// UnknownDirective is reported for directives no extension recognizes.
+	UnknownDirective Code = "unknown-directive"
+
+	// InvalidDirective is reported for directives with invalid values.
+	InvalidDirective Code = "invalid-directive"
+
+	// SourceError is reported when a source file can't be read or parsed.
+	SourceError Code = "source-error"
+
+	// UnsupportedSource is reported for source files Gazelle doesn't know how
+	// to build.
+	UnsupportedSource Code = "unsupported-source"
+
+	// MultiplePackages is reported when a directory contains sources for more
+	// than one package, and none of them can be chosen by default.
+	MultiplePackages Code = "multiple-packages"
+
+	// ImportPathError is reported when the import path for a package can't
+	// be determined.
+	ImportPathError Code = "import-path-error"
+
+	// DuplicateLabel is reported when more than one rule has the same label.
+	// Only the first rule is indexed for dependency resolution.
+	DuplicateLabel Code = "duplicate-label"
+
+	// MergeConflict is reported when a generated rule can't be merged with
+	// existing rules, or when an attribute can't be merged with an existing
+	// attribute. The generated rule or attribute is skipped.
+	MergeConflict Code = "merge-conflict"
+
+	// NamingConvention is reported when an existing rule is not named
+	// according to the naming convention, and it was not renamed.
+	NamingConvention Code = "naming-convention"
+
+	// UnresolvedImport is reported when an import can't be resolved to a
+	// dependency.
+	UnresolvedImport Code = "unresolved-import"
+)
+
+// Position identifies a location in a file.
+type Position struct {
+	// Path is the path to the file. Paths of files in the repository are
+	// slash-separated and relative to the repository root when reported to
+	// a Collector.
+	Path string
+
+	// Line and Column are 1-based. They are 0 if unknown.
+	Line, Column int
+}
+
+func (p Position) String() string {
+	switch {
+	case p.Line == 0:
+		return p.Path
+	case p.Column == 0:
+		return fmt.Sprintf("%s:%d", p.Path, p.Line)
+	default:
+		return fmt.Sprintf("%s:%d:%d", p.Path, p.Line, p.Column)
+	}
+}
+
+// Diagnostic describes a problem found while generating build files.
+type Diagnostic struct {
+	Code     Code
+	Severity Severity
+	Pos      Position
+	Message  string
+}
+
+// String formats the diagnostic the way compilers do, so editors can
+// navigate to it, for example:
+//
+//	foo/BUILD.bazel:12:1: warning: could not resolve import "x" [unresolved-import]
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s [%s]", d.Pos, d.Severity, d.Message, d.Code)
+}
+
+// Collector gathers diagnostics reported during a run. It is safe for
+// concurrent use. A nil *Collector is valid; it logs diagnostics with
+// log.Print instead of collecting them.
+type Collector struct {
+	root  string
+	mu    sync.Mutex
+	diags []Diagnostic
+}
+
+// NewCollector returns an empty collector. Paths of reported diagnostics
+// within repoRoot are made relative to it.
+func NewCollector(repoRoot string) *Collector {
+	return &Collector{root: repoRoot}
+}
+
+// Report records a diagnostic.
+func (c *Collector) Report(d Diagnostic) {
+	if c == nil {
+		log.Print(d)
+		return
+	}
+	if c.root != "" && filepath.IsAbs(d.Pos.Path) {
+		if rel, err := filepath.Rel(c.root, d.Pos.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			d.Pos.Path = filepath.ToSlash(rel)
+		}
+	}
+	c.mu.Lock()
+	c.diags = append(c.diags, d)
+	c.mu.Unlock()
+}
+
+// Warnf reports a diagnostic with Warning severity.
+func (c *Collector) Warnf(code Code, pos Position, format string, args ...interface{}) {
+	c.Report(Diagnostic{Code: code, Severity: Warning, Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+// Errorf reports a diagnostic with Error severity.
+func (c *Collector) Errorf(code Code, pos Position, format string, args ...interface{}) {
+	c.Report(Diagnostic{Code: code, Severity: Error, Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+// Diagnostics returns the diagnostics reported so far, sorted by position.
+// Diagnostics at the same position are kept in the order they were
+// reported.
+func (c *Collector) Diagnostics() []Diagnostic {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	diags := append([]Diagnostic(nil), c.diags...)
+	c.mu.Unlock()
+	sort.SliceStable(diags, func(i, j int) bool {
+		pi, pj := diags[i].Pos, diags[j].Pos
+		if pi.Path != pj.Path {
+			return pi.Path < pj.Path
+		}
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Column < pj.Column
+	})
+	return diags
+}
+
+// Count returns the number of diagnostics in diags with severity sev.
+func Count(diags []Diagnostic, sev Severity) int {
+	n := 0
+	for _, d := range diags {
+		if d.Severity == sev {
+			n++
+		}
+	}
+	return n
+}
+
+// Formats lists the names of formats accepted by Write.
+var Formats = []string{"text", "json"}
+
+// jsonDiagnostic is the form of a diagnostic written in the "json" format.
+type jsonDiagnostic struct {
+	Code     Code   `json:"code"`
+	Severity string `json:"severity"`
+	Path     string `json:"path"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Write writes diagnostics to w in the named format. In the "text" format,
+// each diagnostic is written on its own line as formatted by
+// Diagnostic.String. In the "json" format, each diagnostic is written as
+// a JSON object on its own line.
+func Write(w io.Writer, diags []Diagnostic, format string) error {
+	switch format {
+	case "text":
+		for _, d := range diags {
+			if _, err := fmt.Fprintln(w, d); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "json":
+		enc := json.NewEncoder(w)
+		for _, d := range diags {
+			jd := jsonDiagnostic{
+				Code:     d.Code,
+				Severity: d.Severity.String(),
+				Path:     d.Pos.Path,
+				Line:     d.Pos.Line,
+				Column:   d.Pos.Column,
+				Message:  d.Message,
+			}
+			if err := enc.Encode(jd); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown diagnostics format %q; valid formats are %s", format, strings.Join(Formats, ", "))
+	}
+}
@@ -0,0 +1,65 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diag
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollector(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	c := NewCollector(root)
+	c.Warnf(UnresolvedImport, Position{Path: filepath.Join(root, "b", "BUILD.bazel"), Line: 3, Column: 1}, "could not resolve import %q", "x")
+	c.Errorf(MultiplePackages, Position{Path: filepath.Join(root, "a")}, "found packages x and y")
+	c.Warnf(InvalidDirective, Position{Path: "/elsewhere/BUILD", Line: 1}, "bad")
+
+	var buf bytes.Buffer
+	if err := Write(&buf, c.Diagnostics(), "text"); err != nil {
+		t.Fatal(err)
+	}
+	want := `/elsewhere/BUILD:1: warning: bad [invalid-directive]
+a: error: found packages x and y [multiple-packages]
+b/BUILD.bazel:3:1: warning: could not resolve import "x" [unresolved-import]
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := Count(c.Diagnostics(), Warning); got != 2 {
+		t.Errorf("got %d warnings; want 2", got)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	diags := []Diagnostic{
+		{Code: UnknownDirective, Severity: Warning, Pos: Position{Path: "BUILD.bazel", Line: 2, Column: 1}, Message: "unknown directive: gazelle:foo"},
+		{Code: MultiplePackages, Severity: Error, Pos: Position{Path: "a"}, Message: "found packages x and y"},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, diags, "json"); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"code":"unknown-directive","severity":"warning","path":"BUILD.bazel","line":2,"column":1,"message":"unknown directive: gazelle:foo"}
+{"code":"multiple-packages","severity":"error","path":"a","message":"found packages x and y"}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if err := Write(&buf, diags, "xml"); err == nil {
+		t.Error("got success writing unknown format; want error")
+	}
+}
@@ -18,7 +18,44 @@ load("@io_bazel_rules_go//go/private:common.bzl", "env_execute", "executable_ext
 _GO_REPOSITORY_TIMEOUT = 86400
 
 def _go_repository_impl(ctx):
-  if ctx.attr.urls:
+  fetch_repo_env = {
+      "PATH": ctx.os.environ["PATH"],  # to find git
+  }
+  if "SSH_AUTH_SOCK" in ctx.os.environ:
+    fetch_repo_env["SSH_AUTH_SOCK"] = ctx.os.environ["SSH_AUTH_SOCK"]
+  if "HTTP_PROXY" in ctx.os.environ:
+    fetch_repo_env["HTTP_PROXY"] = ctx.os.environ["HTTP_PROXY"]
+  if "HTTPS_PROXY" in ctx.os.environ:
+    fetch_repo_env["HTTPS_PROXY"] = ctx.os.environ["HTTPS_PROXY"]
+  if "GOPROXY" in ctx.os.environ:
+    fetch_repo_env["GOPROXY"] = ctx.os.environ["GOPROXY"]
+  _fetch_repo = "@bazel_gazelle_go_repository_tools//:bin/fetch_repo{}".format(executable_extension(ctx))
+
+  if ctx.attr.version:
+    # download a module zip from a module proxy
+    for key in ("commit", "tag", "vcs", "remote", "urls", "strip_prefix", "type", "sha256"):
+      if getattr(ctx.attr, key):
+        fail("cannot specify both version and %s" % key, key)
+    # a replacement module is downloaded by its own path
+    module = ctx.attr.replace or ctx.attr.importpath
+    args = [
+        ctx.path(Label(_fetch_repo)),
+        '--dest', ctx.path(''),
+        '--importpath', module,
+        '--version', ctx.attr.version,
+    ]
+    if ctx.attr.sum:
+      args.extend(['--sum', ctx.attr.sum])
+    result = env_execute(ctx, args, environment = fetch_repo_env, timeout = _GO_REPOSITORY_TIMEOUT)
+    if result.return_code:
+      fail("failed to fetch %s: %s" % (ctx.name, result.stderr))
+    if result.stderr:
+      print("fetch_repo: " + result.stderr)
+  elif ctx.attr.sum:
+    fail("sum may only be used with version", "sum")
+  elif ctx.attr.replace:
+    fail("replace may only be used with version", "replace")
+  elif ctx.attr.urls:
     # download from explicit source url
     for key in ("commit", "tag", "vcs", "remote"):
       if getattr(ctx.attr, key):
@@ -49,17 +86,6 @@ def _go_repository_impl(ctx):
     if ctx.attr.vcs and not ctx.attr.remote:
       fail("if vcs is specified, remote must also be")
 
-    fetch_repo_env = {
-        "PATH": ctx.os.environ["PATH"],  # to find git
-    }
-    if "SSH_AUTH_SOCK" in ctx.os.environ:
-      fetch_repo_env["SSH_AUTH_SOCK"] = ctx.os.environ["SSH_AUTH_SOCK"]
-    if "HTTP_PROXY" in ctx.os.environ:
-      fetch_repo_env["HTTP_PROXY"] = ctx.os.environ["HTTP_PROXY"]
-    if "HTTPS_PROXY" in ctx.os.environ:
-      fetch_repo_env["HTTPS_PROXY"] = ctx.os.environ["HTTPS_PROXY"]
-
-    _fetch_repo = "@bazel_gazelle_go_repository_tools//:bin/fetch_repo{}".format(executable_extension(ctx))
     args = [
         ctx.path(Label(_fetch_repo)), 
         '--dest', ctx.path(''),
@@ -126,6 +152,11 @@ go_repository = repository_rule(
         ),
         "remote": attr.string(),
 
+        # Attributes for a repository that should be downloaded from a module proxy
+        "version": attr.string(),
+        "sum": attr.string(),
+        "replace": attr.string(),
+
         # Attributes for a repository that comes from a source blob not a vcs
         "urls": attr.string_list(),
         "strip_prefix": attr.string(),
@@ -160,6 +191,9 @@ go_repository = repository_rule(
         ),
         "build_extra_args": attr.string_list(),
     },
+    # Modules are downloaded from the proxies listed in GOPROXY, so they
+    # are fetched again when it changes.
+    environ = ["GOPROXY"],
 )
 """See repository.rst#go-repository for full documentation."""
 
@@ -20,190 +20,49 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
-	"github.com/bazelbuild/bazel-gazelle/internal/rule"
+	"github.com/bazelbuild/bazel-gazelle/diag"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
-var (
-	// PreResolveAttrs is the set of attributes that should be merged before
-	// dependency resolution, i.e., everything except deps.
-	PreResolveAttrs config.MergeableAttrs
-
-	// PostResolveAttrs is the set of attributes that should be merged after
-	// dependency resolution, i.e., deps.
-	PostResolveAttrs config.MergeableAttrs
-
-	// BuildAttrs is the union of PreResolveAttrs and PostResolveAttrs.
-	BuildAttrs config.MergeableAttrs
-
-	// RepoAttrs is the set of attributes that should be merged in repository
-	// rules in WORKSPACE.
-	RepoAttrs config.MergeableAttrs
+// Phase indicates which attributes should be merged in matching rules.
+//
+// The pre-resolve merge is performed before rules are indexed for dependency
+// resolution. All attributes not related to dependencies are merged. This
+// merge must be performed before indexing because attributes related to indexing
+// (e.g., srcs, importpath) will be affected.
+//
+// The post-resolve merge is performed after rules are indexed. All attributes
+// related to dependencies are merged.
+type Phase int
 
-	// NonEmptyAttrs is the set of attributes that disqualify a rule from being
-	// deleted after merge.
-	NonEmptyAttrs config.MergeableAttrs
+const (
+	PreResolve Phase = iota
+	PostResolve
 )
 
-func init() {
-	PreResolveAttrs = make(config.MergeableAttrs)
-	PostResolveAttrs = make(config.MergeableAttrs)
-	RepoAttrs = make(config.MergeableAttrs)
-	NonEmptyAttrs = make(config.MergeableAttrs)
-	for _, set := range []struct {
-		mergeableAttrs config.MergeableAttrs
-		kinds, attrs   []string
-	}{
-		{
-			mergeableAttrs: PreResolveAttrs,
-			kinds: []string{
-				"go_library",
-				"go_binary",
-				"go_test",
-				"go_proto_library",
-				"proto_library",
-				"filegroup",
-			},
-			attrs: []string{
-				"srcs",
-			},
-		}, {
-			mergeableAttrs: PreResolveAttrs,
-			kinds: []string{
-				"go_library",
-				"go_proto_library",
-			},
-			attrs: []string{
-				"importpath",
-				"importmap",
-			},
-		}, {
-			mergeableAttrs: PreResolveAttrs,
-			kinds: []string{
-				"go_library",
-				"go_binary",
-				"go_test",
-				"go_proto_library",
-			},
-			attrs: []string{
-				"cgo",
-				"clinkopts",
-				"copts",
-				"embed",
-			},
-		}, {
-			mergeableAttrs: PreResolveAttrs,
-			kinds: []string{
-				"go_proto_library",
-			},
-			attrs: []string{
-				"proto",
-			},
-		}, {
-			mergeableAttrs: PostResolveAttrs,
-			kinds: []string{
-				"go_library",
-				"go_binary",
-				"go_test",
-				"go_proto_library",
-				"proto_library",
-			},
-			attrs: []string{
-				"deps",
-				config.GazelleImportsKey,
-			},
-		}, {
-			mergeableAttrs: RepoAttrs,
-			kinds: []string{
-				"go_repository",
-			},
-			attrs: []string{
-				"commit",
-				"importpath",
-				"remote",
-				"sha256",
-				"strip_prefix",
-				"tag",
-				"type",
-				"urls",
-				"vcs",
-			},
-		}, {
-			mergeableAttrs: NonEmptyAttrs,
-			kinds: []string{
-				"go_binary",
-				"go_library",
-				"go_test",
-				"proto_library",
-				"filegroup",
-			},
-			attrs: []string{
-				"srcs",
-			},
-		}, {
-			mergeableAttrs: NonEmptyAttrs,
-			kinds: []string{
-				"go_binary",
-				"go_library",
-				"go_test",
-				"proto_library",
-			},
-			attrs: []string{
-				"deps",
-			},
-		}, {
-			mergeableAttrs: NonEmptyAttrs,
-			kinds: []string{
-				"go_binary",
-				"go_library",
-				"go_test",
-			},
-			attrs: []string{
-				"embed",
-			},
-		}, {
-			mergeableAttrs: NonEmptyAttrs,
-			kinds: []string{
-				"go_proto_library",
-			},
-			attrs: []string{
-				"proto",
-			},
-		},
-	} {
-		for _, kind := range set.kinds {
-			if set.mergeableAttrs[kind] == nil {
-				set.mergeableAttrs[kind] = make(map[string]bool)
-			}
-			for _, attr := range set.attrs {
-				set.mergeableAttrs[kind][attr] = true
-			}
-		}
-	}
-	BuildAttrs = make(config.MergeableAttrs)
-	for _, mattrs := range []config.MergeableAttrs{PreResolveAttrs, PostResolveAttrs} {
-		for kind, attrs := range mattrs {
-			if BuildAttrs[kind] == nil {
-				BuildAttrs[kind] = make(map[string]bool)
-			}
-			for attr := range attrs {
-				BuildAttrs[kind][attr] = true
-			}
-		}
-	}
-}
-
 // MergeFile merges the rules in genRules with matching rules in f and
 // adds unmatched rules to the end of the merged file. MergeFile also merges
 // rules in empty with matching rules in f and deletes rules that
-// are empty after merging. attrs is the set of attributes to merge. Attributes
-// not in this set will be left alone if they already exist.
-func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, attrs config.MergeableAttrs) (mergedRules []*rule.Rule) {
+// are empty after merging. phase determines which attributes are merged;
+// attributes not merged in that phase will be left alone if they already
+// exist. kinds describes how to match and merge rules of each kind; rules of
+// kinds not in this map are matched by name only and are never merged.
+// Generated rules that conflict with existing rules and attributes that
+// can't be merged are skipped and reported to diags, which may be nil.
+func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phase, kinds map[string]rule.KindInfo, diags *diag.Collector) (mergedRules []*rule.Rule) {
+	getMergeAttrs := func(r *rule.Rule) map[string]bool {
+		if phase == PreResolve {
+			return kinds[r.Kind()].MergeableAttrs
+		} else {
+			return kinds[r.Kind()].ResolveAttrs
+		}
+	}
+
 	// Merge empty rules into the file and delete any rules which become empty.
 	for _, emptyRule := range emptyRules {
-		if oldRule, _ := match(oldFile.Rules, emptyRule); oldRule != nil {
-			rule.MergeRules(emptyRule, oldRule, attrs, oldFile.Path)
-			if oldRule.IsEmpty(NonEmptyAttrs) {
+		if oldRule, _ := match(oldFile.Rules, emptyRule, kinds[emptyRule.Kind()]); oldRule != nil {
+			rule.MergeRules(emptyRule, oldRule, getMergeAttrs(oldRule), oldFile.Path, diags)
+			if oldRule.IsEmpty(kinds[oldRule.Kind()]) {
 				oldRule.Delete()
 			}
 		}
@@ -216,11 +75,19 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, attrs conf
 	matchErrors := make([]error, len(genRules))
 	substitutions := make(map[string]string)
 	for i, genRule := range genRules {
-		oldRule, err := match(oldFile.Rules, genRule)
+		oldRule, err := match(oldFile.Rules, genRule, kinds[genRule.Kind()])
 		if err != nil {
-			// TODO(jayconrod): add a verbose mode and log errors. They are too chatty
-			// to print by default.
 			matchErrors[i] = err
+			if phase == PreResolve {
+				// Rules that fail to match are dropped before the post-resolve
+				// merge, so conflicts are only reported once.
+				pos := diag.Position{Path: oldFile.Path}
+				if merr, ok := err.(*matchError); ok {
+					start := merr.rule.Pos()
+					pos.Line, pos.Column = start.Line, start.LineRune
+				}
+				diags.Warnf(diag.MergeConflict, pos, "%v", err)
+			}
 			continue
 		}
 		matchRules[i] = oldRule
@@ -234,7 +101,7 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, attrs conf
 	// Rename labels in generated rules that refer to other generated rules.
 	if len(substitutions) > 0 {
 		for _, genRule := range genRules {
-			substituteRule(genRule, substitutions)
+			substituteRule(genRule, substitutions, kinds[genRule.Kind()])
 		}
 	}
 
@@ -247,7 +114,7 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, attrs conf
 			genRule.Insert(oldFile)
 			mergedRules = append(mergedRules, genRule)
 		} else {
-			rule.MergeRules(genRule, matchRules[i], attrs, oldFile.Path)
+			rule.MergeRules(genRule, matchRules[i], getMergeAttrs(genRule), oldFile.Path, diags)
 			mergedRules = append(mergedRules, matchRules[i])
 		}
 	}
@@ -255,23 +122,13 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, attrs conf
 	return mergedRules
 }
 
-// substituteAttrs contains a list of attributes for each kind that should be
-// processed by substituteRule and substituteExpr. Note that "name" does not
-// need to be substituted since it's not mergeable.
-var substituteAttrs = map[string][]string{
-	"go_binary":        {"embed"},
-	"go_library":       {"embed"},
-	"go_test":          {"embed"},
-	"go_proto_library": {"proto"},
-}
-
 // substituteRule replaces local labels (those beginning with ":", referring to
 // targets in the same package) according to a substitution map. This is used
 // to update generated rules before merging when the corresponding existing
 // rules have different names. If substituteRule replaces a string, it returns
 // a new expression; it will not modify the original expression.
-func substituteRule(r *rule.Rule, substitutions map[string]string) {
-	for _, attr := range substituteAttrs[r.Kind()] {
+func substituteRule(r *rule.Rule, substitutions map[string]string, info rule.KindInfo) {
+	for attr := range info.SubstituteAttrs {
 		if expr := r.Attr(attr); expr != nil {
 			expr = rule.MapExprStrings(expr, func(s string) string {
 				if rename, ok := substitutions[strings.TrimPrefix(s, ":")]; ok {
@@ -285,28 +142,14 @@ func substituteRule(r *rule.Rule, substitutions map[string]string) {
 	}
 }
 
-// matchAttrs contains lists of attributes for each kind that are used in
-// matching. For example, importpath attributes can be used to match go_library
-// rules, even when the names are different.
-var matchAttrs = map[string][]string{
-	"go_library":       {"importpath"},
-	"go_proto_library": {"importpath"},
-	"go_repository":    {"importpath"},
-}
-
-// matchAny is a set of kinds which may be matched regardless of attributes.
-// For example, if there is only one go_binary in a package, any go_binary
-// rule will match.
-var matchAny = map[string]bool{"go_binary": true}
-
 // match searches for a rule that can be merged with x in rules.
 //
 // A rule is considered a match if its kind is equal to x's kind AND either its
-// name is equal OR at least one of the attributes in matchAttrs is equal.
+// name is equal OR at least one of the attributes in info.MatchAttrs is equal.
 //
 // If there are no matches, nil and nil are returned.
 //
-// If a rule has the same name but a different kind, nill and an error
+// If a rule has the same name but a different kind, nil and an error
 // are returned.
 //
 // If there is exactly one match, the rule and nil are returned.
@@ -315,7 +158,9 @@ var matchAny = map[string]bool{"go_binary": true}
 // the quality of the match (name match is best, then attribute match in the
 // order that attributes are listed). If disambiguation is successful,
 // the rule and nil are returned. Otherwise, nil and an error are returned.
-func match(rules []*rule.Rule, x *rule.Rule) (*rule.Rule, error) {
+//
+// Errors are of type *matchError and identify one of the conflicting rules.
+func match(rules []*rule.Rule, x *rule.Rule, info rule.KindInfo) (*rule.Rule, error) {
 	xname := x.Name()
 	xkind := x.Kind()
 	var nameMatches []*rule.Rule
@@ -332,16 +177,15 @@ func match(rules []*rule.Rule, x *rule.Rule) (*rule.Rule, error) {
 	if len(nameMatches) == 1 {
 		y := nameMatches[0]
 		if xkind != y.Kind() {
-			return nil, fmt.Errorf("could not merge %s(%s): a rule of the same name has kind %s", xkind, xname, y.Kind())
+			return nil, &matchError{y, fmt.Sprintf("could not merge %s(%s): a rule of the same name has kind %s", xkind, xname, y.Kind())}
 		}
 		return y, nil
 	}
 	if len(nameMatches) > 1 {
-		return nil, fmt.Errorf("could not merge %s(%s): multiple rules have the same name", xkind, xname)
+		return nil, &matchError{nameMatches[1], fmt.Sprintf("could not merge %s(%s): multiple rules have the same name", xkind, xname)}
 	}
 
-	attrs := matchAttrs[xkind]
-	for _, key := range attrs {
+	for _, key := range info.MatchAttrs {
 		var attrMatches []*rule.Rule
 		xvalue := x.AttrString(key)
 		if xvalue == "" {
@@ -355,17 +199,28 @@ func match(rules []*rule.Rule, x *rule.Rule) (*rule.Rule, error) {
 		if len(attrMatches) == 1 {
 			return attrMatches[0], nil
 		} else if len(attrMatches) > 1 {
-			return nil, fmt.Errorf("could not merge %s(%s): multiple rules have the same attribute %s = %q", xkind, xname, key, xvalue)
+			return nil, &matchError{attrMatches[1], fmt.Sprintf("could not merge %s(%s): multiple rules have the same attribute %s = %q", xkind, xname, key, xvalue)}
 		}
 	}
 
-	if matchAny[xkind] {
+	if info.MatchAny {
 		if len(kindMatches) == 1 {
 			return kindMatches[0], nil
 		} else if len(kindMatches) > 1 {
-			return nil, fmt.Errorf("could not merge %s(%s): multiple rules have the same kind but different names", xkind, xname)
+			return nil, &matchError{kindMatches[1], fmt.Sprintf("could not merge %s(%s): multiple rules have the same kind but different names", xkind, xname)}
 		}
 	}
 
 	return nil, nil
 }
+
+// matchError is returned by match when a rule can't be matched
+// unambiguously. rule is one of the existing rules involved in the conflict.
+type matchError struct {
+	rule *rule.Rule
+	msg  string
+}
+
+func (e *matchError) Error() string {
+	return e.msg
+}
@@ -18,7 +18,12 @@ package merger
 import (
 	"testing"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/rule"
+	"github.com/bazelbuild/bazel-gazelle/diag"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/language/go"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
 )
 
 // should fix
@@ -864,23 +869,43 @@ go_proto_library(
 	},
 }
 
+var testLangs = []language.Language{proto.New(), golang.New()}
+
+func testKinds() map[string]rule.KindInfo {
+	kinds := make(map[string]rule.KindInfo)
+	for _, lang := range testLangs {
+		for kind, info := range lang.Kinds() {
+			kinds[kind] = info
+		}
+	}
+	return kinds
+}
+
+func testLoads() []rule.LoadInfo {
+	var loads []rule.LoadInfo
+	for _, lang := range testLangs {
+		loads = append(loads, lang.Loads()...)
+	}
+	return loads
+}
+
 func TestMergeFile(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.desc, func(t *testing.T) {
-			genFile, err := rule.LoadData("current", []byte(tc.current))
+			genFile, err := rule.LoadData("current", "", []byte(tc.current))
 			if err != nil {
 				t.Fatalf("%s: %v", tc.desc, err)
 			}
-			f, err := rule.LoadData("previous", []byte(tc.previous))
+			f, err := rule.LoadData("previous", "", []byte(tc.previous))
 			if err != nil {
 				t.Fatalf("%s: %v", tc.desc, err)
 			}
-			emptyFile, err := rule.LoadData("empty", []byte(tc.empty))
+			emptyFile, err := rule.LoadData("empty", "", []byte(tc.empty))
 			if err != nil {
 				t.Fatalf("%s: %v", tc.desc, err)
 			}
-			MergeFile(f, emptyFile.Rules, genFile.Rules, PreResolveAttrs)
-			FixLoads(f)
+			MergeFile(f, emptyFile.Rules, genFile.Rules, PreResolve, testKinds(), nil)
+			FixLoads(f, testLoads())
 
 			want := tc.expected
 			if len(want) > 0 && want[0] == '\n' {
@@ -894,6 +919,33 @@ func TestMergeFile(t *testing.T) {
 	}
 }
 
+func TestMergeFileConflict(t *testing.T) {
+	genFile, err := rule.LoadData("current", "", []byte(`go_library(name = "go_default_library", srcs = ["lib.go"])`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := rule.LoadData("previous", "", []byte(`
+go_library(
+    name = "go_default_library",
+    srcs = glob(["*.go"]),
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diags := diag.NewCollector("")
+	MergeFile(f, nil, genFile.Rules, PreResolve, testKinds(), diags)
+
+	got := diags.Diagnostics()
+	wantPos := diag.Position{Path: "previous", Line: 4, Column: 12}
+	if len(got) != 1 || got[0].Code != diag.MergeConflict || got[0].Pos != wantPos {
+		t.Errorf("got diagnostics %v; want merge conflict at %v", got, wantPos)
+	}
+	if srcs := f.Rules[0].Attr("srcs"); srcs == nil || bzl.FormatString(srcs) != `glob(["*.go"])` {
+		t.Errorf("srcs was modified: got %s", bzl.FormatString(srcs))
+	}
+}
+
 func TestMatch(t *testing.T) {
 	for _, tc := range []struct {
 		desc, gen, old string
@@ -951,22 +1003,24 @@ go_binary(name = "z")
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			genFile, err := rule.LoadData("gen", []byte(tc.gen))
+			genFile, err := rule.LoadData("gen", "", []byte(tc.gen))
 			if err != nil {
 				t.Fatal(err)
 			}
-			oldFile, err := rule.LoadData("old", []byte(tc.old))
+			oldFile, err := rule.LoadData("old", "", []byte(tc.old))
 			if err != nil {
 				t.Fatal(err)
 			}
-			if got, gotErr := match(oldFile.Rules, genFile.Rules[0]); gotErr != nil {
+			r := genFile.Rules[0]
+			info := testKinds()[r.Kind()]
+			if got, gotErr := match(oldFile.Rules, r, info); gotErr != nil {
 				if !tc.wantError {
 					t.Errorf("unexpected error: %v", gotErr)
 				}
 			} else if tc.wantError {
 				t.Error("unexpected success")
 			} else if got == nil && tc.wantIndex >= 0 {
-				t.Error("got nil; want index %d", tc.wantIndex)
+				t.Errorf("got nil; want index %d", tc.wantIndex)
 			} else if got != nil && got.Index() != tc.wantIndex {
 				t.Fatalf("got index %d ; want %d", got.Index(), tc.wantIndex)
 			}
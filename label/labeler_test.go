@@ -18,7 +18,7 @@ package label
 import (
 	"testing"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
+	"github.com/bazelbuild/bazel-gazelle/config"
 )
 
 func TestLabelerGo(t *testing.T) {
@@ -0,0 +1,210 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// goConfig contains configuration values related to Go rules. It is stored
+// in Config.Exts under goName. Values are shared with subdirectories, so
+// Configure replaces the goConfig instead of modifying it.
+type goConfig struct {
+	// namingConvention determines how go_library and go_test rules are
+	// named. Set with the go_naming_convention directive.
+	namingConvention namingConvention
+
+	// testMode determines whether one go_test rule is generated for each
+	// package or for each test file. Set with the go_test directive.
+	testMode testMode
+
+	// protoCompilers and grpcCompilers are the compilers used by
+	// go_proto_library rules for protos without and with services. When
+	// they are nil, the rules_go defaults are used. Set with the
+	// go_proto_compilers and go_grpc_compilers directives.
+	protoCompilers, grpcCompilers []protoCompiler
+}
+
+// getGoConfig returns the Go configuration for the directory c applies to.
+// The returned value must not be modified.
+func getGoConfig(c *config.Config) *goConfig {
+	if gc, ok := c.Exts[goName].(*goConfig); ok {
+		return gc
+	}
+	return &goConfig{}
+}
+
+// testMode determines how go_test rules are generated.
+type testMode int
+
+const (
+	// defaultTestMode generates one go_test rule for all the test files in
+	// a package.
+	defaultTestMode testMode = iota
+
+	// fileTestMode generates a go_test rule for each test file, named after
+	// the file. Each rule embeds the package's library, and its dependencies
+	// are resolved from the imports of its file.
+	fileTestMode
+)
+
+func testModeFromString(s string) (testMode, error) {
+	switch s {
+	case "default":
+		return defaultTestMode, nil
+	case "file":
+		return fileTestMode, nil
+	default:
+		return 0, fmt.Errorf("unrecognized go_test mode: %q", s)
+	}
+}
+
+func (m testMode) String() string {
+	switch m {
+	case defaultTestMode:
+		return "default"
+	case fileTestMode:
+		return "file"
+	default:
+		return fmt.Sprintf("testMode(%d)", int(m))
+	}
+}
+
+// protoCompiler is a go_proto_compiler rule used to generate code in
+// go_proto_library rules.
+type protoCompiler struct {
+	// label is the label of the go_proto_compiler rule.
+	label string
+
+	// imports lists Go packages imported by the generated code that are not
+	// provided by the compiler itself. They are resolved and added to the
+	// deps of go_proto_library rules that use the compiler.
+	imports []string
+}
+
+func (gl *goLang) KnownDirectives() []string {
+	return []string{
+		"build_tags",
+		"go_grpc_compilers",
+		"go_naming_convention",
+		"go_proto_compilers",
+		"go_test",
+		"importmap_prefix",
+		"prefix",
+	}
+}
+
+func (gl *goLang) Configure(c *config.Config, rel string, f *bzl.File, directives []config.Directive) {
+	// If this is a vendor directory, set an empty prefix. Libraries in vendor
+	// are imported by their paths relative to vendor.
+	if path.Base(rel) == "vendor" {
+		c.GoPrefix = ""
+		c.GoPrefixRel = rel
+		c.GoImportMapPrefix = path.Join(c.RepoName, rel)
+		c.GoImportMapPrefixRel = rel
+	}
+
+	gc := *getGoConfig(c)
+	for _, d := range directives {
+		switch d.Key {
+		case "build_tags":
+			oldTags := c.GenericTags
+			if err := c.SetBuildTags(d.Value); err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				c.GenericTags = oldTags
+				continue
+			}
+			c.PreprocessTags()
+		case "go_grpc_compilers", "go_proto_compilers":
+			compilers, err := parseGoProtoCompilers(d.Value)
+			if err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				continue
+			}
+			if d.Key == "go_grpc_compilers" {
+				gc.grpcCompilers = compilers
+			} else {
+				gc.protoCompilers = compilers
+			}
+		case "go_naming_convention":
+			nc, err := namingConventionFromString(d.Value)
+			if err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				continue
+			}
+			gc.namingConvention = nc
+		case "go_test":
+			mode, err := testModeFromString(d.Value)
+			if err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				continue
+			}
+			gc.testMode = mode
+		case "importmap_prefix":
+			if err := config.CheckPrefix(d.Value); err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				continue
+			}
+			c.GoImportMapPrefix = d.Value
+			c.GoImportMapPrefixRel = rel
+		case "prefix":
+			if err := config.CheckPrefix(d.Value); err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				continue
+			}
+			c.GoPrefix = d.Value
+			c.GoPrefixRel = rel
+		}
+	}
+	if c.Exts == nil {
+		c.Exts = make(map[string]interface{})
+	}
+	c.Exts[goName] = &gc
+}
+
+// parseGoProtoCompilers parses the value of a go_proto_compilers or
+// go_grpc_compilers directive. Compilers are separated by spaces. Each
+// compiler is a label, optionally followed by "=" and a comma-separated list
+// of Go import paths that code generated by the compiler needs. An empty
+// value returns nil, which selects the default compilers.
+func parseGoProtoCompilers(value string) ([]protoCompiler, error) {
+	var compilers []protoCompiler
+	for _, field := range strings.Fields(value) {
+		var compiler protoCompiler
+		if i := strings.IndexByte(field, '='); i >= 0 {
+			compiler.label = field[:i]
+			for _, imp := range strings.Split(field[i+1:], ",") {
+				if imp == "" {
+					return nil, fmt.Errorf("compiler %s: empty import path", compiler.label)
+				}
+				compiler.imports = append(compiler.imports, imp)
+			}
+		} else {
+			compiler.label = field
+		}
+		if _, err := label.Parse(compiler.label); err != nil {
+			return nil, err
+		}
+		compilers = append(compilers, compiler)
+	}
+	return compilers, nil
+}
@@ -0,0 +1,104 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+func TestConfigure(t *testing.T) {
+	for _, tc := range []struct {
+		desc       string
+		directives []config.Directive
+		rel        string
+		want       config.Config
+		wantGo     goConfig
+	}{
+		{
+			desc:       "empty build_tags",
+			directives: []config.Directive{{Key: "build_tags", Value: ""}},
+			want:       config.Config{},
+		}, {
+			desc:       "build_tags",
+			directives: []config.Directive{{Key: "build_tags", Value: "foo,bar"}},
+			want:       config.Config{GenericTags: config.BuildTags{"foo": true, "bar": true}},
+		}, {
+			desc:       "prefix",
+			directives: []config.Directive{{Key: "prefix", Value: "example.com/repo"}},
+			rel:        "sub",
+			want:       config.Config{GoPrefix: "example.com/repo", GoPrefixRel: "sub"},
+		}, {
+			desc:       "go_naming_convention",
+			directives: []config.Directive{{Key: "go_naming_convention", Value: "import"}},
+			wantGo:     goConfig{namingConvention: importNamingConvention},
+		}, {
+			desc:       "go_test",
+			directives: []config.Directive{{Key: "go_test", Value: "file"}},
+			wantGo:     goConfig{testMode: fileTestMode},
+		}, {
+			desc: "go_proto_compilers",
+			directives: []config.Directive{
+				{Key: "go_proto_compilers", Value: "@io_bazel_rules_go//proto:go_proto //:validate=example.com/validate,example.com/other"},
+			},
+			wantGo: goConfig{protoCompilers: []protoCompiler{
+				{label: "@io_bazel_rules_go//proto:go_proto"},
+				{label: "//:validate", imports: []string{"example.com/validate", "example.com/other"}},
+			}},
+		}, {
+			desc: "go_grpc_compilers_reset",
+			directives: []config.Directive{
+				{Key: "go_grpc_compilers", Value: "//:gateway"},
+				{Key: "go_grpc_compilers", Value: ""},
+			},
+			want: config.Config{},
+		}, {
+			desc:       "go_grpc_compilers_invalid",
+			directives: []config.Directive{{Key: "go_grpc_compilers", Value: "//:gateway="}},
+			want:       config.Config{},
+		}, {
+			desc:       "importmap_prefix",
+			directives: []config.Directive{{Key: "importmap_prefix", Value: "example.com/repo"}},
+			rel:        "sub",
+			want:       config.Config{GoImportMapPrefix: "example.com/repo", GoImportMapPrefixRel: "sub"},
+		}, {
+			desc: "vendor",
+			rel:  "sub/vendor",
+			want: config.Config{
+				GoPrefixRel:          "sub/vendor",
+				GoImportMapPrefix:    "sub/vendor",
+				GoImportMapPrefixRel: "sub/vendor",
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := &config.Config{}
+			c.PreprocessTags()
+			New().Configure(c, tc.rel, nil, tc.directives)
+			gc := getGoConfig(c)
+			c.Exts = nil
+			tc.want.PreprocessTags()
+			if !reflect.DeepEqual(*c, tc.want) {
+				t.Errorf("got %#v ; want %#v", *c, tc.want)
+			}
+			if !reflect.DeepEqual(*gc, tc.wantGo) {
+				t.Errorf("got Go config %#v ; want %#v", *gc, tc.wantGo)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"log"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// Fix updates rules in f that were generated by an older version of
+// Gazelle to a newer form that can be merged with freshly generated rules.
+//
+// If c.ShouldFix is true, Fix may perform potentially destructive
+// transformations, such as squashing or deleting rules (e.g., cgo_library).
+// If not, Fix will perform a set of low-risk transformations (e.g., removing
+// unused attributes) and will print a message about transformations it
+// would have performed.
+//
+// merger.FixLoads should be called after this, since it will fix load
+// statements that may be broken by transformations applied by this function.
+func (gl *goLang) Fix(c *config.Config, f *rule.File) {
+	migrateLibraryEmbed(c, f)
+	migrateGrpcCompilers(c, f)
+	flattenSrcs(c, f)
+	squashCgoLibrary(c, f)
+	squashXtest(c, f)
+	removeLegacyProto(c, f)
+	migrateNamingConvention(c, f)
+}
+
+// migrateLibraryEmbed converts "library" attributes to "embed" attributes,
+// preserving comments. This only applies to Go rules, and only if there is
+// no keep comment on "library" and no existing "embed" attribute.
+func migrateLibraryEmbed(c *config.Config, f *rule.File) {
+	for _, r := range f.Rules {
+		libExpr := r.Attr("library")
+		if libExpr == nil || rule.ShouldKeep(libExpr) || r.Attr("embed") != nil {
+			continue
+		}
+		r.DelAttr("library")
+		r.SetAttr("embed", &bzl.ListExpr{List: []bzl.Expr{libExpr}})
+	}
+}
+
+// migrateGrpcCompilers converts "go_grpc_library" rules into "go_proto_library"
+// rules with a "compilers" attribute.
+func migrateGrpcCompilers(c *config.Config, f *rule.File) {
+	for _, r := range f.Rules {
+		if r.Kind() != "go_grpc_library" || r.ShouldKeep() || r.Attr("compilers") != nil {
+			continue
+		}
+		r.SetKind("go_proto_library")
+		r.SetAttr("compilers", []string{config.GrpcCompilerLabel})
+	}
+}
+
+// squashCgoLibrary removes cgo_library rules with the default name and
+// merges their attributes with go_library with the default name. If no
+// go_library rule exists, a new one will be created.
+//
+// Note that the library attribute is disregarded, so cgo_library and
+// go_library attributes will be squashed even if the cgo_library was unlinked.
+// MergeFile will remove unused values and attributes later.
+func squashCgoLibrary(c *config.Config, f *rule.File) {
+	// Find the default cgo_library and go_library rules.
+	var cgoLibrary, goLibrary *rule.Rule
+	for _, r := range f.Rules {
+		if r.Kind() == "cgo_library" && r.Name() == config.DefaultCgoLibName && !r.ShouldKeep() {
+			if cgoLibrary != nil {
+				log.Printf("%s: when fixing existing file, multiple cgo_library rules with default name found", f.Path)
+				continue
+			}
+			cgoLibrary = r
+			continue
+		}
+		if r.Kind() == "go_library" && r.Name() == config.DefaultLibName {
+			if goLibrary != nil {
+				log.Printf("%s: when fixing existing file, multiple go_library rules with default name referencing cgo_library found", f.Path)
+			}
+			goLibrary = r
+			continue
+		}
+	}
+
+	if cgoLibrary == nil {
+		return
+	}
+	if !c.ShouldFix {
+		log.Printf("%s: cgo_library is deprecated. Run 'gazelle fix' to squash with go_library.", f.Path)
+		return
+	}
+
+	if goLibrary == nil {
+		cgoLibrary.SetKind("go_library")
+		cgoLibrary.SetName(config.DefaultLibName)
+		cgoLibrary.SetAttr("cgo", true)
+		return
+	}
+
+	if err := rule.SquashRules(cgoLibrary, goLibrary, f.Path); err != nil {
+		log.Print(err)
+		return
+	}
+	goLibrary.DelAttr("embed")
+	goLibrary.SetAttr("cgo", true)
+	cgoLibrary.Delete()
+}
+
+// squashXtest removes go_test rules with the default external name and merges
+// their attributes with a go_test rule with the default internal name. If
+// no internal go_test rule exists, a new one will be created (effectively
+// renaming the old rule).
+func squashXtest(c *config.Config, f *rule.File) {
+	// Search for internal and external tests.
+	var itest, xtest *rule.Rule
+	for _, r := range f.Rules {
+		if r.Kind() != "go_test" {
+			continue
+		}
+		if r.Name() == config.DefaultTestName {
+			itest = r
+		} else if r.Name() == config.DefaultXTestName {
+			xtest = r
+		}
+	}
+
+	if xtest == nil || xtest.ShouldKeep() || (itest != nil && itest.ShouldKeep()) {
+		return
+	}
+	if !c.ShouldFix {
+		if itest == nil {
+			log.Printf("%s: go_default_xtest is no longer necessary. Run 'gazelle fix' to rename to go_default_test.", f.Path)
+		} else {
+			log.Printf("%s: go_default_xtest is no longer necessary. Run 'gazelle fix' to squash with go_default_test.", f.Path)
+		}
+		return
+	}
+
+	// If there was no internal test, we can just rename the external test.
+	if itest == nil {
+		xtest.SetName(config.DefaultTestName)
+		return
+	}
+
+	// Attempt to squash.
+	if err := rule.SquashRules(xtest, itest, f.Path); err != nil {
+		log.Print(err)
+		return
+	}
+	xtest.Delete()
+}
+
+// removeLegacyProto removes uses of the old proto rules. It deletes loads
+// from go_proto_library.bzl. It deletes proto filegroups. It removes
+// go_proto_library attributes which are no longer recognized. New rules
+// are generated in place of the deleted rules, but attributes and comments
+// are not migrated.
+func removeLegacyProto(c *config.Config, f *rule.File) {
+	// Don't fix if proto rules aren't generated in this mode.
+	if !c.ProtoMode.ShouldGenerateRules() {
+		return
+	}
+
+	// Scan for definitions to delete.
+	var protoLoads []*rule.Load
+	for _, l := range f.Loads {
+		if l.Name() == "@io_bazel_rules_go//proto:go_proto_library.bzl" {
+			protoLoads = append(protoLoads, l)
+		}
+	}
+	var protoFilegroups, protoRules []*rule.Rule
+	for _, r := range f.Rules {
+		if r.Kind() == "filegroup" && r.Name() == config.DefaultProtosName {
+			protoFilegroups = append(protoFilegroups, r)
+		}
+		if r.Kind() == "go_proto_library" {
+			protoRules = append(protoRules, r)
+		}
+	}
+	if len(protoLoads)+len(protoFilegroups) == 0 {
+		return
+	}
+	if !c.ShouldFix {
+		log.Printf("%s: go_proto_library.bzl is deprecated. Run 'gazelle fix' to replace old rules.", f.Path)
+		return
+	}
+
+	// Delete legacy proto loads and filegroups. Only delete go_proto_library
+	// rules if we deleted a load.
+	for _, l := range protoLoads {
+		l.Delete()
+	}
+	for _, r := range protoFilegroups {
+		r.Delete()
+	}
+	if len(protoLoads) > 0 {
+		for _, r := range protoRules {
+			r.Delete()
+		}
+	}
+}
+
+// migrateNamingConvention renames go_library and go_test rules with default
+// names (go_default_library and go_default_test) according to the naming
+// convention set with the go_naming_convention directive. Rules are not
+// renamed if a rule with the new name already exists. References to renamed
+// rules, including references within f, are not updated here; Gazelle
+// updates them in all build files after Fix is called.
+func migrateNamingConvention(c *config.Config, f *rule.File) {
+	nc := getGoConfig(c).namingConvention
+	if nc == goDefaultLibraryNamingConvention {
+		return
+	}
+
+	// Libraries embedded in binaries are named differently, so check whether
+	// the default library is embedded before renaming anything.
+	l := labeler(c)
+	defaultLib := label.New("", f.Pkg, config.DefaultLibName)
+	isCommand := false
+	names := make(map[string]bool)
+	for _, r := range f.Rules {
+		names[r.Name()] = true
+		if r.Kind() != "go_binary" {
+			continue
+		}
+		for _, s := range r.AttrStrings("embed") {
+			if e, err := label.Parse(s); err == nil && e.Abs("", f.Pkg).Equal(defaultLib) {
+				isCommand = true
+			}
+		}
+	}
+
+	for _, r := range f.Rules {
+		if r.ShouldKeep() {
+			continue
+		}
+		var name string
+		switch {
+		case r.Kind() == "go_library" && r.Name() == config.DefaultLibName:
+			if isCommand {
+				name = l.CommandLibraryLabel(f.Pkg).Name
+			} else {
+				name = l.LibraryLabel(f.Pkg).Name
+			}
+		case r.Kind() == "go_test" && r.Name() == config.DefaultTestName:
+			name = l.TestLabel(f.Pkg).Name
+		default:
+			continue
+		}
+		if names[name] {
+			c.Diagnostics.Warnf(diag.NamingConvention, rulePosition(f, r), "can't rename %s to %s for the %s naming convention: a rule with that name already exists", r.Name(), name, nc)
+			continue
+		}
+		if !c.ShouldFix {
+			c.Diagnostics.Warnf(diag.NamingConvention, rulePosition(f, r), "%s is not named according to the %s naming convention. Run 'gazelle fix' to rename it to %s.", r.Name(), nc, name)
+			continue
+		}
+		names[name] = true
+		r.SetName(name)
+	}
+}
+
+func rulePosition(f *rule.File, r *rule.Rule) diag.Position {
+	start := r.Pos()
+	return diag.Position{Path: f.Path, Line: start.Line, Column: start.LineRune}
+}
+
+// flattenSrcs transforms srcs attributes structured as concatenations of
+// lists and selects (generated from PlatformStrings; see
+// extractPlatformStringsExprs for matching details) into a sorted,
+// de-duplicated list. Comments are accumulated and de-duplicated across
+// duplicate expressions.
+func flattenSrcs(c *config.Config, f *rule.File) {
+	for _, r := range f.Rules {
+		if !isGoRule(r.Kind()) {
+			continue
+		}
+		oldSrcs := r.Attr("srcs")
+		if oldSrcs == nil {
+			continue
+		}
+		flatSrcs := rule.FlattenExpr(oldSrcs)
+		if flatSrcs != oldSrcs {
+			r.SetAttr("srcs", flatSrcs)
+		}
+	}
+}
+
+func isGoRule(kind string) bool {
+	return kind == "go_library" ||
+		kind == "go_binary" ||
+		kind == "go_test" ||
+		kind == "go_proto_library" ||
+		kind == "go_grpc_library"
+}
@@ -69,7 +69,7 @@ limitations under the License.
 // Generated by gen_std_package_list.go
 // DO NOT EDIT
 
-package resolve
+package golang
 
 var stdPackages = map[string]bool{
 {{range . -}}
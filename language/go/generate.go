@@ -0,0 +1,569 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/internal/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
+	"github.com/bazelbuild/bazel-gazelle/packages"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func (gl *goLang) GenerateRules(args language.GenerateArgs) (gen, empty []*rule.Rule) {
+	g := &generator{
+		c:                   args.Config,
+		l:                   labeler(args.Config),
+		file:                args.File,
+		shouldSetVisibility: args.File == nil || !args.File.HasDefaultVisibility(),
+	}
+	pkg := args.Package
+
+	var rs []*rule.Rule
+	protoLibName, protoRules := g.generateProto(pkg, args.OtherGen, args.OtherEmpty)
+	rs = append(rs, protoRules...)
+
+	libName, libRule := g.generateLib(pkg, protoLibName)
+	rs = append(rs, libRule)
+
+	rs = append(rs, g.generateBin(pkg, libName))
+	rs = append(rs, g.generateTests(pkg, libName)...)
+
+	for _, r := range rs {
+		if r.IsEmpty(goKinds[r.Kind()]) {
+			empty = append(empty, r)
+		} else {
+			gen = append(gen, r)
+		}
+	}
+	return gen, empty
+}
+
+// generator generates Bazel build rules for Go build targets in a single
+// directory.
+type generator struct {
+	c                   *config.Config
+	l                   *goLabeler
+	file                *rule.File
+	shouldSetVisibility bool
+}
+
+// ruleName returns the name of a generated rule of the given kind in the
+// directory rel. labelFn returns the label for the rule chosen by a
+// goLabeler. When a naming convention other than go_default_library is used
+// and the existing build file has a rule of the same kind with the default
+// name, the default name is returned instead. This keeps rules from being
+// duplicated in directories that have not been migrated to the new
+// convention yet; "gazelle fix" renames these rules.
+func (g *generator) ruleName(kind, rel string, labelFn func(*goLabeler, string) label.Label) string {
+	name := labelFn(g.l, rel).Name
+	if getGoConfig(g.c).namingConvention == goDefaultLibraryNamingConvention || g.file == nil || findRule(g.file, kind, name) != nil {
+		return name
+	}
+	defaultName := labelFn(g.l.withNamingConvention(goDefaultLibraryNamingConvention), rel).Name
+	if findRule(g.file, kind, defaultName) != nil {
+		return defaultName
+	}
+	return name
+}
+
+// findRule returns the rule in f with the given kind and name, or nil if
+// there is no such rule.
+func findRule(f *rule.File, kind, name string) *rule.Rule {
+	for _, r := range f.Rules {
+		if r.Kind() == kind && r.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// generateProto generates a go_proto_library for the proto_library generated
+// by the proto extension in the same directory, if there is one. In legacy
+// mode, it generates a filegroup of .proto sources instead.
+func (g *generator) generateProto(pkg *packages.Package, otherGen, otherEmpty []*rule.Rule) (string, []*rule.Rule) {
+	if g.c.ProtoMode == config.DisableProtoMode {
+		// Don't create or delete proto rules in this mode. Any existing rules
+		// are likely hand-written.
+		return "", nil
+	}
+
+	filegroupName := config.DefaultProtosName
+	if g.c.ProtoMode == config.LegacyProtoMode {
+		filegroup := rule.NewRule("filegroup", filegroupName)
+		if !pkg.Proto.HasProto() {
+			return "", []*rule.Rule{filegroup}
+		}
+		filegroup.SetAttr("srcs", pkg.Proto.Sources)
+		if g.shouldSetVisibility {
+			filegroup.SetAttr("visibility", checkInternalVisibility(pkg.Rel, defaultVisibility(g.c)))
+		}
+		return "", []*rule.Rule{filegroup}
+	}
+	if !proto.HasLang(g.c, "go") {
+		// Go rules aren't wanted for protos in this directory. Treat it as if
+		// there were no proto_library rules, so go_proto_library rules with
+		// default names are deleted.
+		otherGen = nil
+	}
+	if g.c.ProtoMode.GroupsProtoFiles() {
+		return g.generateProtoGroups(pkg, otherGen, otherEmpty)
+	}
+
+	var protoLibrary *rule.Rule
+	for _, r := range otherGen {
+		if r.Kind() == "proto_library" {
+			protoLibrary = r
+			break
+		}
+	}
+	if protoLibrary == nil {
+		return "", []*rule.Rule{
+			rule.NewRule("filegroup", filegroupName),
+			rule.NewRule("go_proto_library", g.l.GoProtoLabel(pkg.Rel, pkg.Name).Name),
+		}
+	}
+
+	protoName := protoLibrary.Name()
+	goProtoName := strings.TrimSuffix(protoName, "_proto") + "_go_proto"
+	goProtoLibrary := rule.NewRule("go_proto_library", goProtoName)
+	goProtoLibrary.SetAttr("proto", ":"+protoName)
+	g.setImportAttrs(goProtoLibrary, pkg)
+	target, _ := protoLibrary.PrivateAttr(proto.PackageKey).(packages.ProtoTarget)
+	if g.shouldSetVisibility {
+		goProtoLibrary.SetAttr("visibility", checkInternalVisibility(pkg.Rel, defaultVisibility(g.c)))
+	}
+	g.setCompilersAndImports(goProtoLibrary, target.HasServices, pkg.Proto.Imports)
+	return goProtoName, []*rule.Rule{goProtoLibrary}
+}
+
+// generateProtoGroups generates a go_proto_library for each Go package that
+// proto_library rules in the same directory belong to. This is used in proto
+// modes that group .proto files by file or by proto package, where files in
+// one directory may have different go_package options. The name of the
+// go_proto_library for the Go package in the directory is returned, so the
+// go_library can embed it.
+func (g *generator) generateProtoGroups(pkg *packages.Package, otherGen, otherEmpty []*rule.Rule) (string, []*rule.Rule) {
+	var importPaths []string
+	protosByImportPath := make(map[string][]*rule.Rule)
+	goNames := make(map[string]string)
+	for _, r := range otherGen {
+		if r.Kind() != "proto_library" {
+			continue
+		}
+		target, _ := r.PrivateAttr(proto.PackageKey).(packages.ProtoTarget)
+		importPath, goName := pkg.ImportPath, pkg.Name
+		for _, f := range target.Files {
+			if f.GoImportPath != "" {
+				importPath, goName = f.GoImportPath, f.GoPackageName
+				break
+			}
+		}
+		if _, ok := protosByImportPath[importPath]; !ok {
+			importPaths = append(importPaths, importPath)
+			goNames[importPath] = goName
+		}
+		protosByImportPath[importPath] = append(protosByImportPath[importPath], r)
+	}
+	sort.Strings(importPaths)
+
+	var libName string
+	var rs []*rule.Rule
+	generated := make(map[string]bool)
+	for _, importPath := range importPaths {
+		protos := protosByImportPath[importPath]
+		var goProtoName string
+		if len(protos) == 1 {
+			goProtoName = strings.TrimSuffix(protos[0].Name(), "_proto") + "_go_proto"
+		} else {
+			goProtoName = g.l.GoProtoLabel(pkg.Rel, goNames[importPath]).Name
+		}
+		goProtoLibrary := rule.NewRule("go_proto_library", goProtoName)
+		if len(protos) == 1 {
+			goProtoLibrary.SetAttr("proto", ":"+protos[0].Name())
+		} else {
+			protoLabels := make([]string, len(protos))
+			for i, r := range protos {
+				protoLabels[i] = ":" + r.Name()
+			}
+			goProtoLibrary.SetAttr("protos", protoLabels)
+		}
+		if importPath == pkg.ImportPath {
+			g.setImportAttrs(goProtoLibrary, pkg)
+			libName = goProtoName
+		} else {
+			goProtoLibrary.SetAttr("importpath", importPath)
+		}
+
+		hasServices := false
+		importSet := make(map[string]bool)
+		for _, r := range protos {
+			target, _ := r.PrivateAttr(proto.PackageKey).(packages.ProtoTarget)
+			hasServices = hasServices || target.HasServices
+			for _, imp := range target.Imports.Generic {
+				importSet[imp] = true
+			}
+		}
+		if g.shouldSetVisibility {
+			goProtoLibrary.SetAttr("visibility", checkInternalVisibility(pkg.Rel, defaultVisibility(g.c)))
+		}
+		var imports rule.PlatformStrings
+		for imp := range importSet {
+			imports.Generic = append(imports.Generic, imp)
+		}
+		sort.Strings(imports.Generic)
+		g.setCompilersAndImports(goProtoLibrary, hasServices, imports)
+		rs = append(rs, goProtoLibrary)
+		generated[goProtoName] = true
+	}
+
+	// Delete go_proto_library rules for proto_library rules that were deleted.
+	emptyNames := []string{g.l.GoProtoLabel(pkg.Rel, pkg.Name).Name}
+	for _, r := range otherEmpty {
+		if r.Kind() == "proto_library" {
+			emptyNames = append(emptyNames, strings.TrimSuffix(r.Name(), "_proto")+"_go_proto")
+		}
+	}
+	for _, name := range emptyNames {
+		if !generated[name] {
+			rs = append(rs, rule.NewRule("go_proto_library", name))
+			generated[name] = true
+		}
+	}
+	if len(importPaths) == 0 {
+		rs = append(rs, rule.NewRule("filegroup", config.DefaultProtosName))
+	}
+	return libName, rs
+}
+
+// setCompilersAndImports sets the compilers attribute of a go_proto_library
+// to the compilers configured for protos with or without services. The
+// compilers attribute is not set when the rules_go default is used. The
+// imports of the .proto files and the Go imports needed by the compilers
+// are stored in the "_gazelle_imports" attribute to be resolved later.
+func (g *generator) setCompilersAndImports(r *rule.Rule, hasServices bool, protoImports rule.PlatformStrings) {
+	gc := getGoConfig(g.c)
+	compilers := gc.protoCompilers
+	if hasServices {
+		compilers = gc.grpcCompilers
+		if compilers == nil {
+			compilers = []protoCompiler{{label: config.GrpcCompilerLabel}}
+		}
+	}
+	if len(compilers) > 0 {
+		labels := make([]string, len(compilers))
+		for i, compiler := range compilers {
+			labels[i] = compiler.label
+		}
+		r.SetAttr("compilers", labels)
+	}
+
+	imports := protoImports
+	for _, compiler := range compilers {
+		if len(compiler.imports) > 0 {
+			imports.Generic = append(append([]string(nil), imports.Generic...), compiler.imports...)
+		}
+	}
+	if !imports.IsEmpty() {
+		r.SetAttr(config.GazelleImportsKey, imports)
+	}
+}
+
+func (g *generator) generateBin(pkg *packages.Package, library string) *rule.Rule {
+	name := g.l.BinaryLabel(pkg.Rel).Name
+	goBinary := rule.NewRule("go_binary", name)
+	if !pkg.IsCommand() || pkg.Binary.Sources.IsEmpty() && library == "" {
+		return goBinary // empty
+	}
+	visibility := checkInternalVisibility(pkg.Rel, defaultVisibility(g.c))
+	g.setCommonAttrs(goBinary, pkg.Rel, visibility, pkg.Binary)
+	if library != "" {
+		goBinary.SetAttr("embed", []string{":" + library})
+	}
+	return goBinary
+}
+
+func (g *generator) generateLib(pkg *packages.Package, goProtoName string) (string, *rule.Rule) {
+	labelFn := (*goLabeler).LibraryLabel
+	if pkg.IsCommand() {
+		labelFn = (*goLabeler).CommandLibraryLabel
+	}
+	name := g.ruleName("go_library", pkg.Rel, labelFn)
+	goLibrary := rule.NewRule("go_library", name)
+	if !pkg.Library.HasGo() && goProtoName == "" {
+		return "", goLibrary // empty
+	}
+	var visibility []string
+	if pkg.IsCommand() {
+		// Libraries made for a go_binary should not be exposed to the public.
+		visibility = []string{"//visibility:private"}
+	} else {
+		visibility = checkInternalVisibility(pkg.Rel, defaultVisibility(g.c))
+	}
+
+	g.setCommonAttrs(goLibrary, pkg.Rel, visibility, pkg.Library)
+	g.setImportAttrs(goLibrary, pkg)
+	if goProtoName != "" {
+		goLibrary.SetAttr("embed", []string{":" + goProtoName})
+	}
+	return name, goLibrary
+}
+
+// defaultVisibility returns the visibility of generated rules that may be
+// used by other packages: the visibility set with the default_visibility
+// directive, or public.
+func defaultVisibility(c *config.Config) []string {
+	if visibility := language.DefaultVisibility(c); len(visibility) > 0 {
+		return visibility
+	}
+	return []string{"//visibility:public"}
+}
+
+// checkInternalVisibility overrides the given visibility if the package is
+// internal.
+func checkInternalVisibility(rel string, visibility []string) []string {
+	if i := strings.LastIndex(rel, "/internal/"); i >= 0 {
+		visibility = []string{fmt.Sprintf("//%s:__subpackages__", rel[:i])}
+	} else if strings.HasPrefix(rel, "internal/") {
+		visibility = []string{"//:__subpackages__"}
+	}
+	return visibility
+}
+
+// generateTests generates go_test rules for the package. Normally, one rule
+// is generated for all test files. When go_test rules are generated for each
+// file, each rule is named after its file without the .go extension, and the
+// rule for the whole package is returned empty, so an existing one is
+// deleted. Test files without tests, like helpers and the file with
+// TestMain, are included in each rule instead of getting their own.
+//
+// In both modes, existing rules for individual test files that are not
+// generated are returned empty, so they are deleted when their files are
+// deleted or when go_test rules are no longer generated for each file.
+func (g *generator) generateTests(pkg *packages.Package, library string) []*rule.Rule {
+	name := g.ruleName("go_test", pkg.Rel, (*goLabeler).TestLabel)
+	var tests []*rule.Rule
+	if getGoConfig(g.c).testMode != fileTestMode {
+		tests = append(tests, g.generateTest(name, pkg, pkg.Test, library))
+	} else {
+		files := make([]string, 0, len(pkg.TestFiles))
+		for file := range pkg.TestFiles {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		hasPackageTest := false
+		for _, file := range files {
+			fileTestName := strings.TrimSuffix(file, ".go")
+			hasPackageTest = hasPackageTest || fileTestName == name
+			tests = append(tests, g.generateTest(fileTestName, pkg, pkg.TestFiles[file], library))
+		}
+		if !hasPackageTest {
+			tests = append(tests, rule.NewRule("go_test", name))
+		}
+	}
+
+	// Delete go_test rules generated for single test files that are no
+	// longer needed, either because the go_test mode changed or because the
+	// file was removed.
+	if g.file != nil {
+		generated := make(map[string]bool)
+		for _, t := range tests {
+			generated[t.Name()] = true
+		}
+		testSrcs := make(map[string]bool)
+		for _, src := range pkg.Test.Sources.Flat() {
+			testSrcs[src] = true
+		}
+		for _, r := range g.file.Rules {
+			if r.Kind() != "go_test" || generated[r.Name()] || !isFileTest(r) {
+				continue
+			}
+			file := r.Name() + ".go"
+			if testSrcs[file] || getGoConfig(g.c).testMode == fileTestMode && !isExcludedFile(g.file, file) {
+				tests = append(tests, rule.NewRule("go_test", r.Name()))
+			}
+		}
+	}
+	return tests
+}
+
+// isFileTest returns whether r looks like a go_test rule generated for a
+// single test file: its name is the file's name without the .go extension,
+// it only has test files in this directory in srcs, and it has no
+// attributes that Gazelle doesn't set. Rules with other attributes, like
+// tags or size, were written by hand and are never deleted.
+func isFileTest(r *rule.Rule) bool {
+	info := goKinds["go_test"]
+	for _, key := range r.AttrKeys() {
+		if key != "name" && key != "data" && !info.NonEmptyAttrs[key] && !info.MergeableAttrs[key] && !info.ResolveAttrs[key] {
+			return false
+		}
+	}
+	srcs := r.AttrStrings("srcs")
+	hasOwnFile := false
+	for _, src := range srcs {
+		l, err := label.Parse(src)
+		if err != nil || !l.Relative || strings.Contains(l.Name, "/") || !strings.HasSuffix(l.Name, "_test.go") {
+			return false
+		}
+		hasOwnFile = hasOwnFile || l.Name == r.Name()+".go"
+	}
+	return hasOwnFile
+}
+
+// isExcludedFile returns whether name is excluded with an exclude directive
+// in f.
+func isExcludedFile(f *rule.File, name string) bool {
+	for _, d := range f.Directives {
+		if d.Key == "exclude" && d.Value == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) generateTest(name string, pkg *packages.Package, target packages.GoTarget, library string) *rule.Rule {
+	goTest := rule.NewRule("go_test", name)
+	if !target.HasGo() {
+		return goTest // empty
+	}
+	g.setCommonAttrs(goTest, pkg.Rel, nil, target)
+	if library != "" {
+		goTest.SetAttr("embed", []string{":" + library})
+	}
+	if pkg.HasTestdata {
+		goTest.SetAttr("data", rule.GlobValue{Patterns: []string{"testdata/**"}})
+	}
+	return goTest
+}
+
+func (g *generator) setCommonAttrs(r *rule.Rule, pkgRel string, visibility []string, target packages.GoTarget) {
+	if !target.Sources.IsEmpty() {
+		r.SetAttr("srcs", target.Sources.Flat())
+	}
+	if target.Cgo {
+		r.SetAttr("cgo", true)
+	}
+	if !target.CLinkOpts.IsEmpty() {
+		r.SetAttr("clinkopts", g.options(target.CLinkOpts, pkgRel))
+	}
+	if !target.COpts.IsEmpty() {
+		r.SetAttr("copts", g.options(target.COpts, pkgRel))
+	}
+	if g.shouldSetVisibility && len(visibility) > 0 {
+		r.SetAttr("visibility", visibility)
+	}
+	imports := target.Imports
+	if !imports.IsEmpty() {
+		r.SetAttr(config.GazelleImportsKey, imports)
+	}
+}
+
+func (g *generator) setImportAttrs(r *rule.Rule, pkg *packages.Package) {
+	r.SetAttr("importpath", pkg.ImportPath)
+	if g.c.GoImportMapPrefix != "" {
+		fromPrefixRel := pathtools.TrimPrefix(pkg.Rel, g.c.GoImportMapPrefixRel)
+		importMap := path.Join(g.c.GoImportMapPrefix, fromPrefixRel)
+		if importMap != pkg.ImportPath {
+			r.SetAttr("importmap", importMap)
+		}
+	}
+}
+
+var (
+	// shortOptPrefixes are strings that come at the beginning of an option
+	// argument that includes a path, e.g., -Ifoo/bar.
+	shortOptPrefixes = []string{"-I", "-L", "-F"}
+
+	// longOptPrefixes are separate arguments that come before a path argument,
+	// e.g., -iquote foo/bar.
+	longOptPrefixes = []string{"-I", "-L", "-F", "-iquote", "-isystem"}
+)
+
+// options transforms package-relative paths in cgo options into repository-
+// root-relative paths that Bazel can understand. For example, if a cgo file
+// in //foo declares an include flag in its copts: "-Ibar", this method
+// will transform that flag into "-Ifoo/bar".
+func (g *generator) options(opts rule.PlatformStrings, pkgRel string) rule.PlatformStrings {
+	fixPath := func(opt string) string {
+		if strings.HasPrefix(opt, "/") {
+			return opt
+		}
+		return path.Clean(path.Join(pkgRel, opt))
+	}
+
+	fixGroups := func(groups []string) ([]string, error) {
+		fixedGroups := make([]string, len(groups))
+		for i, group := range groups {
+			opts := strings.Split(group, packages.OptSeparator)
+			fixedOpts := make([]string, len(opts))
+			isPath := false
+			for j, opt := range opts {
+				if isPath {
+					opt = fixPath(opt)
+					isPath = false
+					goto next
+				}
+
+				for _, short := range shortOptPrefixes {
+					if strings.HasPrefix(opt, short) && len(opt) > len(short) {
+						opt = short + fixPath(opt[len(short):])
+						goto next
+					}
+				}
+
+				for _, long := range longOptPrefixes {
+					if opt == long {
+						isPath = true
+						goto next
+					}
+				}
+
+			next:
+				fixedOpts[j] = escapeOption(opt)
+			}
+			fixedGroups[i] = strings.Join(fixedOpts, " ")
+		}
+
+		return fixedGroups, nil
+	}
+
+	opts, errs := opts.MapSlice(fixGroups)
+	if errs != nil {
+		log.Panicf("unexpected error when transforming options with pkg %q: %v", pkgRel, errs)
+	}
+	return opts
+}
+
+func escapeOption(opt string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		`'`, `\'`,
+		`"`, `\"`,
+		` `, `\ `,
+		"\t", "\\\t",
+		"\n", "\\\n",
+		"\r", "\\\r",
+	).Replace(opt)
+}
@@ -0,0 +1,289 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/internal/merger"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/language/go"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
+	"github.com/bazelbuild/bazel-gazelle/packages"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func testConfig(repoRoot, goPrefix string) *config.Config {
+	c := &config.Config{
+		RepoRoot:            repoRoot,
+		Dirs:                []string{repoRoot},
+		GoPrefix:            goPrefix,
+		GenericTags:         config.BuildTags{},
+		ValidBuildFileNames: []string{"BUILD.old"},
+	}
+	c.PreprocessTags()
+	return c
+}
+
+func testLangs() []language.Language {
+	return []language.Language{proto.New(), golang.New()}
+}
+
+// generateRules runs each language's GenerateRules in order, the way the
+// fix and update commands do.
+func generateRules(langs []language.Language, args language.GenerateArgs) (gen, empty []*rule.Rule) {
+	for _, lang := range langs {
+		args.OtherGen = gen
+		args.OtherEmpty = empty
+		res, resEmpty := lang.GenerateRules(args)
+		gen = append(gen, res...)
+		empty = append(empty, resEmpty...)
+	}
+	return gen, empty
+}
+
+func packageFromDir(langs []language.Language, conf *config.Config, dir string) language.GenerateArgs {
+	cexts := []config.Configurer{&config.CommonConfigurer{}}
+	for _, lang := range langs {
+		cexts = append(cexts, lang)
+	}
+	var args language.GenerateArgs
+	packages.Walk(conf, cexts, dir, func(dir, rel string, c *config.Config, p *packages.Package, f *rule.File, subdirs, regularFiles, genFiles []string, _ bool) {
+		if p != nil && p.Dir == dir {
+			args = language.GenerateArgs{
+				Config:       c,
+				Dir:          dir,
+				Rel:          rel,
+				File:         f,
+				Subdirs:      subdirs,
+				RegularFiles: regularFiles,
+				GenFiles:     genFiles,
+				Package:      p,
+			}
+		}
+	})
+	return args
+}
+
+func TestGenerator(t *testing.T) {
+	repoRoot := filepath.FromSlash("testdata/repo")
+	goPrefix := "example.com/repo"
+	c := testConfig(repoRoot, goPrefix)
+	langs := testLangs()
+	var loads []rule.LoadInfo
+	for _, lang := range langs {
+		loads = append(loads, lang.Loads()...)
+	}
+
+	var dirs []string
+	err := filepath.Walk(repoRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if filepath.Base(path) == "BUILD.want" {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range dirs {
+		rel, _ := filepath.Rel(repoRoot, dir)
+		t.Run(rel, func(t *testing.T) {
+			args := packageFromDir(langs, c, dir)
+			pkg := args.Package
+			rs, _ := generateRules(langs, args)
+			f := rule.EmptyFile("test", "")
+			for _, r := range rs {
+				r.Insert(f)
+			}
+			merger.FixLoads(f, loads)
+			f.SyncIncludingHiddenAttrs()
+			got := string(bzl.Format(f.File))
+
+			wantPath := filepath.Join(pkg.Dir, "BUILD.want")
+			wantBytes, err := ioutil.ReadFile(wantPath)
+			if err != nil {
+				t.Fatalf("error reading %s: %v", wantPath, err)
+			}
+			want := string(wantBytes)
+
+			if got != want {
+				t.Errorf("g.Generate(%q, %#v) = %s; want %s", rel, pkg, got, want)
+			}
+		})
+	}
+}
+
+func TestGeneratorEmpty(t *testing.T) {
+	c := testConfig("", "example.com/repo")
+
+	pkg := packages.Package{Name: "foo"}
+	want := `proto_library(name = "foo_proto")
+
+filegroup(name = "go_default_library_protos")
+
+go_proto_library(name = "foo_go_proto")
+
+go_library(name = "go_default_library")
+
+go_binary(name = "repo")
+
+go_test(name = "go_default_test")
+`
+	_, empty := generateRules(testLangs(), language.GenerateArgs{Config: c, Package: &pkg})
+	f := rule.EmptyFile("test", "")
+	for _, e := range empty {
+		e.Insert(f)
+	}
+	f.Sync()
+	got := string(bzl.Format(f.File))
+	if got != want {
+		t.Errorf("got '%s' ;\nwant %s", got, want)
+	}
+}
+
+func TestGeneratorEmptyLegacyProto(t *testing.T) {
+	c := testConfig("", "example.com/repo")
+	c.ProtoMode = config.LegacyProtoMode
+
+	pkg := packages.Package{Name: "foo"}
+	_, empty := generateRules(testLangs(), language.GenerateArgs{Config: c, Package: &pkg})
+	for _, e := range empty {
+		if kind := e.Kind(); kind == "proto_library" || kind == "go_proto_library" || kind == "go_grpc_library" {
+			t.Errorf("deleted rule %s ; should not delete in legacy proto mode", kind)
+		}
+	}
+}
+
+func TestGeneratorNamingConvention(t *testing.T) {
+	repoRoot := filepath.FromSlash("testdata/repo")
+	c := testConfig(repoRoot, "example.com/repo")
+	golang.New().Configure(c, "", nil, []config.Directive{{Key: "go_naming_convention", Value: "import"}})
+	langs := testLangs()
+
+	for _, tc := range []struct {
+		desc, dir, old string
+		want           []string
+	}{
+		{
+			desc: "lib",
+			dir:  "lib",
+			want: []string{"go_library(lib)", "go_test(lib_test)"},
+		}, {
+			desc: "bin",
+			dir:  "bin_with_tests",
+			want: []string{"go_library(bin_with_tests_lib)", "go_binary(bin_with_tests)", "go_test(bin_with_tests_test)"},
+		}, {
+			desc: "not_migrated",
+			dir:  "bin_with_tests",
+			old: `
+go_library(name = "go_default_library")
+
+go_test(name = "go_default_test")
+`,
+			want: []string{"go_library(go_default_library)", "go_binary(bin_with_tests)", "go_test(go_default_test)"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			args := packageFromDir(langs, c, filepath.Join(repoRoot, tc.dir))
+			if tc.old != "" {
+				f, err := rule.LoadData("BUILD.old", tc.dir, []byte(tc.old))
+				if err != nil {
+					t.Fatal(err)
+				}
+				args.File = f
+			}
+			gen, _ := generateRules(langs, args)
+			var got []string
+			for _, r := range gen {
+				got = append(got, fmt.Sprintf("%s(%s)", r.Kind(), r.Name()))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGeneratorGoTestFile(t *testing.T) {
+	repoRoot := filepath.FromSlash("testdata/repo")
+	c := testConfig(repoRoot, "example.com/repo")
+	golang.New().Configure(c, "", nil, []config.Directive{{Key: "go_test", Value: "file"}})
+	langs := testLangs()
+
+	args := packageFromDir(langs, c, filepath.Join(repoRoot, "lib"))
+	gen, empty := generateRules(langs, args)
+
+	type testInfo struct {
+		name, embed string
+		srcs        []string
+		imports     []string
+	}
+	var got []testInfo
+	for _, r := range gen {
+		if r.Kind() != "go_test" {
+			continue
+		}
+		info := testInfo{
+			name:  r.Name(),
+			embed: strings.Join(r.AttrStrings("embed"), ","),
+			srcs:  r.AttrStrings("srcs"),
+		}
+		rule.MapExprStrings(r.Attr(config.GazelleImportsKey), func(imp string) string {
+			info.imports = append(info.imports, imp)
+			return imp
+		})
+		got = append(got, info)
+	}
+	want := []testInfo{
+		{
+			name:    "lib_external_test",
+			embed:   ":go_default_library",
+			srcs:    []string{"lib_external_test.go"},
+			imports: []string{"example.com/repo/lib", "testing"},
+		}, {
+			name:    "lib_test",
+			embed:   ":go_default_library",
+			srcs:    []string{"lib_test.go"},
+			imports: []string{"testing"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got tests %+v; want %+v", got, want)
+	}
+
+	var emptyTests []string
+	for _, r := range empty {
+		if r.Kind() == "go_test" {
+			emptyTests = append(emptyTests, r.Name())
+		}
+	}
+	if want := []string{"go_default_test"}; !reflect.DeepEqual(emptyTests, want) {
+		t.Errorf("got empty tests %q; want %q", emptyTests, want)
+	}
+}
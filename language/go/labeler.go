@@ -0,0 +1,118 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"fmt"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/internal/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// namingConvention determines how Go library and test rules are named.
+type namingConvention int
+
+const (
+	// goDefaultLibraryNamingConvention names libraries go_default_library and
+	// tests go_default_test.
+	goDefaultLibraryNamingConvention namingConvention = iota
+
+	// importNamingConvention names libraries and tests after the last
+	// component of the package's import path, for example, foo and foo_test.
+	// Libraries embedded in binaries are named like foo_lib, since the
+	// binary is named foo.
+	importNamingConvention
+)
+
+// namingConventionFromString converts a string from a directive to a
+// namingConvention. Valid strings are "go_default_library" and "import".
+func namingConventionFromString(s string) (namingConvention, error) {
+	switch s {
+	case "go_default_library":
+		return goDefaultLibraryNamingConvention, nil
+	case "import":
+		return importNamingConvention, nil
+	default:
+		return 0, fmt.Errorf("unrecognized naming convention: %q", s)
+	}
+}
+
+func (nc namingConvention) String() string {
+	switch nc {
+	case goDefaultLibraryNamingConvention:
+		return "go_default_library"
+	case importNamingConvention:
+		return "import"
+	default:
+		return fmt.Sprintf("namingConvention(%d)", int(nc))
+	}
+}
+
+// goLabeler generates labels for Go rules. Library and test rules are named
+// according to a naming convention; other labels come from the embedded
+// label.Labeler.
+type goLabeler struct {
+	*label.Labeler
+	c  *config.Config
+	nc namingConvention
+}
+
+// labeler returns a goLabeler that names rules according to the Go naming
+// convention configured in c.
+func labeler(c *config.Config) *goLabeler {
+	return &goLabeler{
+		Labeler: label.NewLabeler(c),
+		c:       c,
+		nc:      getGoConfig(c).namingConvention,
+	}
+}
+
+// withNamingConvention returns a goLabeler that names Go library and test
+// rules according to nc.
+func (l *goLabeler) withNamingConvention(nc namingConvention) *goLabeler {
+	return &goLabeler{Labeler: l.Labeler, c: l.c, nc: nc}
+}
+
+// LibraryLabel returns the label of the go_library rule in the directory
+// rel. Libraries embedded in binaries are named with CommandLibraryLabel
+// instead.
+func (l *goLabeler) LibraryLabel(rel string) label.Label {
+	if l.nc == importNamingConvention {
+		return label.New("", rel, l.baseName(rel))
+	}
+	return l.Labeler.LibraryLabel(rel)
+}
+
+// CommandLibraryLabel returns the label of the go_library rule embedded in
+// the go_binary in the directory rel.
+func (l *goLabeler) CommandLibraryLabel(rel string) label.Label {
+	if l.nc == importNamingConvention {
+		return label.New("", rel, l.baseName(rel)+"_lib")
+	}
+	return l.Labeler.LibraryLabel(rel)
+}
+
+func (l *goLabeler) TestLabel(rel string) label.Label {
+	if l.nc == importNamingConvention {
+		return label.New("", rel, l.baseName(rel)+"_test")
+	}
+	return l.Labeler.TestLabel(rel)
+}
+
+func (l *goLabeler) baseName(rel string) string {
+	return pathtools.RelBaseName(rel, l.c.GoPrefix, l.c.RepoRoot)
+}
@@ -0,0 +1,93 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+func TestLabeler(t *testing.T) {
+	for _, tc := range []struct {
+		name, rel                              string
+		nc                                     namingConvention
+		wantLib, wantCmdLib, wantBin, wantTest string
+	}{
+		{
+			name:       "root_hierarchical",
+			rel:        "",
+			wantLib:    "//:go_default_library",
+			wantCmdLib: "//:go_default_library",
+			wantBin:    "//:root",
+			wantTest:   "//:go_default_test",
+		}, {
+			name:       "sub_hierarchical",
+			rel:        "sub",
+			wantLib:    "//sub:go_default_library",
+			wantCmdLib: "//sub:go_default_library",
+			wantBin:    "//sub",
+			wantTest:   "//sub:go_default_test",
+		}, {
+			name:       "root_import",
+			rel:        "",
+			nc:         importNamingConvention,
+			wantLib:    "//:root",
+			wantCmdLib: "//:root_lib",
+			wantBin:    "//:root",
+			wantTest:   "//:root_test",
+		}, {
+			name:       "sub_import",
+			rel:        "sub",
+			nc:         importNamingConvention,
+			wantLib:    "//sub",
+			wantCmdLib: "//sub:sub_lib",
+			wantBin:    "//sub",
+			wantTest:   "//sub:sub_test",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &config.Config{}
+			l := labeler(c).withNamingConvention(tc.nc)
+
+			if got := l.LibraryLabel(tc.rel).String(); got != tc.wantLib {
+				t.Errorf("for library in %s: got %q ; want %q", tc.rel, got, tc.wantLib)
+			}
+			if got := l.CommandLibraryLabel(tc.rel).String(); got != tc.wantCmdLib {
+				t.Errorf("for command library in %s: got %q ; want %q", tc.rel, got, tc.wantCmdLib)
+			}
+			if got := l.BinaryLabel(tc.rel).String(); got != tc.wantBin {
+				t.Errorf("for binary in %s: got %q ; want %q", tc.rel, got, tc.wantBin)
+			}
+			if got := l.TestLabel(tc.rel).String(); got != tc.wantTest {
+				t.Errorf("for test in %s: got %q ; want %q", tc.rel, got, tc.wantTest)
+			}
+		})
+	}
+}
+
+func TestLabelerNamingConventionFromConfig(t *testing.T) {
+	c := &config.Config{}
+	New().Configure(c, "", nil, []config.Directive{{Key: "go_naming_convention", Value: "import"}})
+	l := labeler(c)
+	if got, want := l.LibraryLabel("sub").String(), "//sub"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	l = l.withNamingConvention(goDefaultLibraryNamingConvention)
+	if got, want := l.LibraryLabel("sub").String(), "//sub:go_default_library"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,209 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package golang provides support for Go and Go proto rules. It generates
+// go_library, go_binary, go_test, and go_proto_library rules.
+//
+// Configuration
+//
+// Go rules support the flags -build_tags, -go_prefix, and -external.
+// They also support the directives # gazelle:build_tags, # gazelle:prefix,
+// # gazelle:importmap_prefix, # gazelle:go_naming_convention, and
+// # gazelle:go_test. In vendor directories, the prefix is reset so that
+// libraries are imported by their paths relative to vendor.
+//
+// Rule generation
+//
+// Currently, Gazelle generates rules for one Go package per directory. In
+// general, we aim to support Go code which is compatible with "go build". If
+// there are no buildable packages, Gazelle will delete existing rules with
+// default names. If there are multiple packages, Gazelle will pick one that
+// matches the directory name or will print an error. Tests in a package are
+// built by one go_test rule, or by one go_test rule per file when
+// # gazelle:go_test file is set.
+//
+// go_proto_library rules are generated for proto_library rules produced by
+// the proto extension, which must run before this one. In the file and
+// package proto modes, one go_proto_library is generated for each Go package
+// named by go_package options, and the go_library embeds the one that
+// matches its import path.
+//
+// Dependency resolution
+//
+// Go libraries are indexed by their importpath attribute. Gazelle attempts to
+// resolve imports using this index. If there's no match, Gazelle will use
+// the -external mode to resolve the import to an external repository or
+// a vendored library.
+package golang
+
+import (
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const goName = "go"
+
+type goLang struct{}
+
+// New returns a new instance of the Go language extension.
+func New() language.Language {
+	return &goLang{}
+}
+
+func (gl *goLang) Name() string { return goName }
+
+var goKinds = map[string]rule.KindInfo{
+	"filegroup": {
+		NonEmptyAttrs:  map[string]bool{"srcs": true},
+		MergeableAttrs: map[string]bool{"srcs": true},
+	},
+	"go_binary": {
+		MatchAny: true,
+		NonEmptyAttrs: map[string]bool{
+			"deps":  true,
+			"embed": true,
+			"srcs":  true,
+		},
+		SubstituteAttrs: map[string]bool{"embed": true},
+		MergeableAttrs: map[string]bool{
+			"cgo":       true,
+			"clinkopts": true,
+			"copts":     true,
+			"embed":     true,
+			"srcs":      true,
+		},
+		ResolveAttrs: map[string]bool{
+			"deps":                   true,
+			config.GazelleImportsKey: true,
+		},
+	},
+	"go_library": {
+		MatchAttrs: []string{"importpath"},
+		NonEmptyAttrs: map[string]bool{
+			"deps":  true,
+			"embed": true,
+			"srcs":  true,
+		},
+		SubstituteAttrs: map[string]bool{"embed": true},
+		MergeableAttrs: map[string]bool{
+			"cgo":        true,
+			"clinkopts":  true,
+			"copts":      true,
+			"embed":      true,
+			"importmap":  true,
+			"importpath": true,
+			"srcs":       true,
+		},
+		ResolveAttrs: map[string]bool{
+			"deps":                   true,
+			config.GazelleImportsKey: true,
+		},
+	},
+	"go_proto_library": {
+		MatchAttrs:      []string{"importpath"},
+		NonEmptyAttrs:   map[string]bool{"proto": true, "protos": true},
+		SubstituteAttrs: map[string]bool{"proto": true, "protos": true},
+		MergeableAttrs: map[string]bool{
+			"srcs":       true,
+			"importpath": true,
+			"importmap":  true,
+			"cgo":        true,
+			"clinkopts":  true,
+			"compilers":  true,
+			"copts":      true,
+			"embed":      true,
+			"proto":      true,
+			"protos":     true,
+		},
+		ResolveAttrs: map[string]bool{
+			"deps":                   true,
+			config.GazelleImportsKey: true,
+		},
+	},
+	"go_repository": {
+		MatchAttrs: []string{"importpath"},
+		MergeableAttrs: map[string]bool{
+			"commit":       true,
+			"importpath":   true,
+			"remote":       true,
+			"replace":      true,
+			"sha256":       true,
+			"strip_prefix": true,
+			"sum":          true,
+			"tag":          true,
+			"type":         true,
+			"urls":         true,
+			"vcs":          true,
+			"version":      true,
+		},
+	},
+	"go_test": {
+		NonEmptyAttrs: map[string]bool{
+			"deps":  true,
+			"embed": true,
+			"srcs":  true,
+		},
+		SubstituteAttrs: map[string]bool{"embed": true},
+		MergeableAttrs: map[string]bool{
+			"cgo":       true,
+			"clinkopts": true,
+			"copts":     true,
+			"embed":     true,
+			"srcs":      true,
+		},
+		ResolveAttrs: map[string]bool{
+			"deps":                   true,
+			config.GazelleImportsKey: true,
+		},
+	},
+	"local_repository": {
+		MergeableAttrs: map[string]bool{"path": true},
+	},
+}
+
+func (gl *goLang) Kinds() map[string]rule.KindInfo { return goKinds }
+
+var goLoads = []rule.LoadInfo{
+	{
+		Name: "@io_bazel_rules_go//go:def.bzl",
+		Symbols: []string{
+			"cgo_library",
+			"go_binary",
+			"go_library",
+			"go_prefix",
+			"go_repository",
+			"go_test",
+		},
+	}, {
+		Name: "@io_bazel_rules_go//proto:def.bzl",
+		Symbols: []string{
+			"go_grpc_library",
+			"go_proto_library",
+		},
+	}, {
+		Name: "@bazel_gazelle//:deps.bzl",
+		Symbols: []string{
+			"go_repository",
+		},
+		After: []string{
+			"go_rules_dependencies",
+			"go_register_toolchains",
+			"gazelle_dependencies",
+		},
+	},
+}
+
+func (gl *goLang) Loads() []rule.LoadInfo { return goLoads }
@@ -0,0 +1,349 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"path"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/internal/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
+	"github.com/bazelbuild/bazel-gazelle/repos"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Imports returns the import paths by which a library rule may be imported.
+// Only go_library, go_proto_library, and go_grpc_library rules are indexed;
+// nil is returned for other kinds.
+func (gl *goLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	if !isGoLibrary(r.Kind()) {
+		return nil
+	}
+	importPath := r.AttrString("importpath")
+	if importPath == "" {
+		return []resolve.ImportSpec{}
+	}
+	return []resolve.ImportSpec{{Lang: goName, Imp: importPath}}
+}
+
+// Embeds returns labels of rules embedded by r. go_proto_library rules
+// embed the proto_library rules named by their "proto" and "protos"
+// attributes, so they inherit their imports in the index.
+func (gl *goLang) Embeds(r *rule.Rule, from label.Label) []label.Label {
+	embedStrings := r.AttrStrings("embed")
+	if isGoProtoLibrary(r.Kind()) {
+		embedStrings = append(embedStrings, r.AttrString("proto"))
+		embedStrings = append(embedStrings, r.AttrStrings("protos")...)
+	}
+	embedLabels := make([]label.Label, 0, len(embedStrings))
+	for _, s := range embedStrings {
+		l, err := label.Parse(s)
+		if err != nil {
+			continue
+		}
+		l = l.Abs(from.Repo, from.Pkg)
+		embedLabels = append(embedLabels, l)
+	}
+	return embedLabels
+}
+
+// Resolve replaces the import paths in the "_gazelle_imports" attribute of
+// a Go rule with labels in a "deps" attribute. Any existing "deps" attribute
+// is deleted, so it may be necessary to merge the result.
+func (gl *goLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repos.RemoteCache, r *rule.Rule, from label.Label) {
+	var resolveFn func(*config.Config, *resolve.RuleIndex, *repos.RemoteCache, string, label.Label) (label.Label, error)
+	switch r.Kind() {
+	case "go_library", "go_binary", "go_test":
+		resolveFn = resolveGo
+	case "go_proto_library", "go_grpc_library":
+		resolveFn = resolveGoProto
+	default:
+		return
+	}
+	embeds := gl.Embeds(r, from)
+
+	imports := r.Attr(config.GazelleImportsKey)
+	r.DelAttr(config.GazelleImportsKey)
+	r.DelAttr("deps")
+	deps := rule.MapExprStrings(imports, func(imp string) string {
+		l, err := resolveFn(c, ix, rc, imp, from)
+		if err == skipImportError {
+			return ""
+		} else if err != nil {
+			resolve.AddUnresolvedImport(r, imp, err)
+			return ""
+		}
+		for _, e := range embeds {
+			if l.Equal(e) {
+				return ""
+			}
+		}
+		l.Relative = l.Repo == "" && l.Pkg == from.Pkg
+		return l.String()
+	})
+	if deps != nil {
+		r.SetAttr("deps", deps)
+	}
+}
+
+var (
+	skipImportError = errors.New("std or self import")
+	notFoundError   = errors.New("rule not found")
+)
+
+// resolveGo resolves an import path from a Go source file to a label.
+// from.Pkg is the path to the Go package relative to the repository root; it
+// is used to resolve relative imports.
+func resolveGo(c *config.Config, ix *resolve.RuleIndex, rc *repos.RemoteCache, imp string, from label.Label) (label.Label, error) {
+	if build.IsLocalImport(imp) {
+		cleanRel := path.Clean(path.Join(from.Pkg, imp))
+		if build.IsLocalImport(cleanRel) {
+			return label.NoLabel, fmt.Errorf("relative import path %q from %q points outside of repository", imp, from.Pkg)
+		}
+		imp = path.Join(c.GoPrefix, cleanRel)
+	}
+
+	if l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Lang: goName, Imp: imp}, goName); ok {
+		return l, nil
+	}
+
+	if IsStandard(imp) {
+		return label.NoLabel, skipImportError
+	}
+
+	if l := resolveWellKnownGo(imp); !l.Equal(label.NoLabel) {
+		return l, nil
+	}
+
+	if l, err := resolveWithIndexGo(ix, imp, from); err == nil || err == skipImportError {
+		return l, err
+	} else if err != notFoundError {
+		return label.NoLabel, err
+	}
+
+	l := labeler(c)
+	if pathtools.HasPrefix(imp, c.GoPrefix) {
+		return l.LibraryLabel(pathtools.TrimPrefix(imp, c.GoPrefix)), nil
+	}
+
+	var external nonlocalResolver
+	switch c.DepMode {
+	case config.ExternalMode:
+		external = newExternalResolver(l, rc)
+	case config.VendorMode:
+		external = newVendoredResolver(l)
+	}
+	return external.resolve(imp)
+}
+
+// nonlocalResolver resolves import paths outside of the current repository's
+// prefix. Once we have smarter import path resolution, this shouldn't
+// be necessary, and we can remove this abstraction.
+type nonlocalResolver interface {
+	resolve(imp string) (label.Label, error)
+}
+
+// resolveWithIndexGo looks up a Go import path in the index. Vendoring logic
+// is applied: a library in a vendor directory is only visible in the parent
+// tree. Vendored libraries supercede non-vendored libraries, and libraries
+// closer to from.Pkg supercede those further up the tree.
+func resolveWithIndexGo(ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+	matches := ix.FindRulesByImport(resolve.ImportSpec{Lang: goName, Imp: imp}, goName)
+	var bestMatch resolve.FindResult
+	var bestMatchIsVendored bool
+	var bestMatchVendorRoot string
+	var matchError error
+
+	for _, m := range matches {
+		isVendored := false
+		vendorRoot := ""
+		parts := strings.Split(m.Label.Pkg, "/")
+		for i := len(parts) - 1; i >= 0; i-- {
+			if parts[i] == "vendor" {
+				isVendored = true
+				vendorRoot = strings.Join(parts[:i], "/")
+				break
+			}
+		}
+		if isVendored && !label.New(m.Label.Repo, vendorRoot, "").Contains(from) {
+			// vendor directory not visible
+			continue
+		}
+		if bestMatch.Rule == nil || isVendored && (!bestMatchIsVendored || len(vendorRoot) > len(bestMatchVendorRoot)) {
+			// Current match is better
+			bestMatch = m
+			bestMatchIsVendored = isVendored
+			bestMatchVendorRoot = vendorRoot
+			matchError = nil
+		} else if (!isVendored && bestMatchIsVendored) || (isVendored && len(vendorRoot) < len(bestMatchVendorRoot)) {
+			// Current match is worse
+		} else {
+			// Match is ambiguous
+			matchError = fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", bestMatch.Label, m.Label, imp, from)
+		}
+	}
+	if matchError != nil {
+		return label.NoLabel, matchError
+	}
+	if bestMatch.Rule == nil {
+		return label.NoLabel, notFoundError
+	}
+	if bestMatch.IsSelfImport(from) {
+		return label.NoLabel, skipImportError
+	}
+	return bestMatch.Label, nil
+}
+
+// resolveGoProto resolves an import of a go_proto_library. Imports of .proto
+// files come from the library's sources. Other imports are Go packages
+// needed by code that the library's compilers generate.
+func resolveGoProto(c *config.Config, ix *resolve.RuleIndex, rc *repos.RemoteCache, imp string, from label.Label) (label.Label, error) {
+	if strings.HasSuffix(imp, ".proto") {
+		return resolveProto(c, ix, rc, imp, from)
+	}
+	return resolveGo(c, ix, rc, imp, from)
+}
+
+// resolveProto resolves an import statement in a .proto file to a
+// label for a go_library rule that embeds the corresponding go_proto_library.
+func resolveProto(c *config.Config, ix *resolve.RuleIndex, rc *repos.RemoteCache, imp string, from label.Label) (label.Label, error) {
+	if !strings.HasSuffix(imp, ".proto") {
+		return label.NoLabel, fmt.Errorf("can't import non-proto: %q", imp)
+	}
+	stem := imp[:len(imp)-len(".proto")]
+
+	if l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Lang: "proto", Imp: imp}, goName); ok {
+		return l, nil
+	}
+
+	if isWellKnownProto(stem) {
+		return label.NoLabel, skipImportError
+	}
+
+	if l, err := resolveWithIndexProto(ix, imp, from); err == nil || err == skipImportError {
+		return l, err
+	} else if err != notFoundError {
+		return label.NoLabel, err
+	}
+
+	// Protos under a mapped import prefix are in another repository or
+	// package. Their go_proto_library rules are embedded in a go_library in
+	// every proto mode, so the library's label is used, as for other protos.
+	// Libraries in other repositories are named with the default naming
+	// convention, like libraries found by externalResolver.
+	if p, ok := proto.FindImportPrefix(c, imp); ok {
+		gl := labeler(c)
+		if p.Repo != "" {
+			gl = gl.withNamingConvention(goDefaultLibraryNamingConvention)
+		}
+		l := gl.LibraryLabel(p.Package(imp))
+		l.Repo = p.Repo
+		return l, nil
+	}
+
+	// As a fallback, guess the label based on the proto file name. We assume
+	// all proto files in a directory belong to the same package, and the
+	// package name matches the directory base name. We also assume that protos
+	// in the vendor directory must refer to something else in vendor.
+	rel := path.Dir(imp)
+	if rel == "." {
+		rel = ""
+	}
+	if from.Pkg == "vendor" || strings.HasPrefix(from.Pkg, "vendor/") {
+		rel = path.Join("vendor", rel)
+	}
+	return labeler(c).LibraryLabel(rel), nil
+}
+
+// resolveWithIndexProto looks up a proto import in the index, returning
+// the label of a Go library that embeds the proto_library providing it.
+func resolveWithIndexProto(ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+	matches := ix.FindRulesByImport(resolve.ImportSpec{Lang: "proto", Imp: imp}, goName)
+	if len(matches) == 0 {
+		return label.NoLabel, notFoundError
+	}
+	if len(matches) > 1 {
+		return label.NoLabel, fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", matches[0].Label, matches[1].Label, imp, from)
+	}
+	if matches[0].IsSelfImport(from) {
+		return label.NoLabel, skipImportError
+	}
+	return matches[0].Label, nil
+}
+
+func isGoLibrary(kind string) bool {
+	return kind == "go_library" || isGoProtoLibrary(kind)
+}
+
+func isGoProtoLibrary(kind string) bool {
+	return kind == "go_proto_library" || kind == "go_grpc_library"
+}
+
+// IsStandard returns whether a package is in the standard library.
+func IsStandard(imp string) bool {
+	return stdPackages[imp]
+}
+
+func isWellKnownProto(imp string) bool {
+	return pathtools.HasPrefix(imp, config.WellKnownTypesProtoPrefix) && pathtools.TrimPrefix(imp, config.WellKnownTypesProtoPrefix) == path.Base(imp)
+}
+
+func resolveWellKnownGo(imp string) label.Label {
+	// keep in sync with @io_bazel_rules_go//proto/wkt:well_known_types.bzl
+	// TODO(jayconrod): in well_known_types.bzl, write the import paths and
+	// targets in a public dict. Import it here, and use it to generate this code.
+	switch imp {
+	case "github.com/golang/protobuf/ptypes/any",
+		"github.com/golang/protobuf/ptypes/api",
+		"github.com/golang/protobuf/protoc-gen-go/descriptor",
+		"github.com/golang/protobuf/ptypes/duration",
+		"github.com/golang/protobuf/ptypes/empty",
+		"google.golang.org/genproto/protobuf/field_mask",
+		"google.golang.org/genproto/protobuf/source_context",
+		"github.com/golang/protobuf/ptypes/struct",
+		"github.com/golang/protobuf/ptypes/timestamp",
+		"github.com/golang/protobuf/ptypes/wrappers":
+		return label.Label{
+			Repo: config.RulesGoRepoName,
+			Pkg:  config.WellKnownTypesPkg,
+			Name: path.Base(imp) + "_go_proto",
+		}
+	case "github.com/golang/protobuf/protoc-gen-go/plugin":
+		return label.Label{
+			Repo: config.RulesGoRepoName,
+			Pkg:  config.WellKnownTypesPkg,
+			Name: "compiler_plugin_go_proto",
+		}
+	case "google.golang.org/genproto/protobuf/ptype":
+		return label.Label{
+			Repo: config.RulesGoRepoName,
+			Pkg:  config.WellKnownTypesPkg,
+			Name: "type_go_proto",
+		}
+	}
+	return label.NoLabel
+}
+
+func isWellKnownGo(imp string) bool {
+	prefix := config.WellKnownTypesGoPrefix + "/ptypes/"
+	return strings.HasPrefix(imp, prefix) && strings.TrimPrefix(imp, prefix) == path.Base(imp)
+}
@@ -13,12 +13,12 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package resolve
+package golang
 
 import (
-	"github.com/bazelbuild/bazel-gazelle/internal/label"
 	"github.com/bazelbuild/bazel-gazelle/internal/pathtools"
-	"github.com/bazelbuild/bazel-gazelle/internal/repos"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/repos"
 )
 
 // externalResolver resolves import paths to external repositories. It uses
@@ -28,13 +28,13 @@ import (
 // guidelines in http://bazel.io/docs/be/functions.html#workspace. The remaining
 // portion of the import path is treated as the package name.
 type externalResolver struct {
-	l  *label.Labeler
+	l  *goLabeler
 	rc *repos.RemoteCache
 }
 
 var _ nonlocalResolver = (*externalResolver)(nil)
 
-func newExternalResolver(l *label.Labeler, rc *repos.RemoteCache) *externalResolver {
+func newExternalResolver(l *goLabeler, rc *repos.RemoteCache) *externalResolver {
 	return &externalResolver{l: l, rc: rc}
 }
 
@@ -53,7 +53,9 @@ func (r *externalResolver) resolve(importPath string) (label.Label, error) {
 		pkg = pathtools.TrimPrefix(importPath, prefix)
 	}
 
-	l := r.l.LibraryLabel(pkg)
+	// Build files in external repositories are generated by go_repository,
+	// which names libraries according to the default convention.
+	l := r.l.withNamingConvention(goDefaultLibraryNamingConvention).LibraryLabel(pkg)
 	l.Repo = repo
 	return l, nil
 }
@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package resolve
+package golang
 
 import (
 	"fmt"
@@ -21,9 +21,9 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
-	"github.com/bazelbuild/bazel-gazelle/internal/label"
-	"github.com/bazelbuild/bazel-gazelle/internal/repos"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/repos"
 
 	"golang.org/x/tools/go/vcs"
 )
@@ -68,7 +68,7 @@ func TestExternalResolver(t *testing.T) {
 }
 
 func newStubExternalResolver(knownRepos []repos.Repo) *externalResolver {
-	l := label.NewLabeler(&config.Config{})
+	l := labeler(&config.Config{})
 	rc := newStubRemoteCache(knownRepos)
 	return newExternalResolver(l, rc)
 }
@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package resolve
+package golang
 
 import (
 	"path"
@@ -22,18 +22,34 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
-	"github.com/bazelbuild/bazel-gazelle/internal/label"
-	"github.com/bazelbuild/bazel-gazelle/internal/rule"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 	bzl "github.com/bazelbuild/buildtools/build"
 )
 
+func newTestIndex() *resolve.RuleIndex {
+	kindToResolver := make(map[string]resolve.Resolver)
+	for _, lang := range []interface {
+		resolve.Resolver
+		Kinds() map[string]rule.KindInfo
+	}{proto.New(), New()} {
+		for kind := range lang.Kinds() {
+			kindToResolver[kind] = lang
+		}
+	}
+	return resolve.NewRuleIndex(func(r *rule.Rule) resolve.Resolver {
+		return kindToResolver[r.Kind()]
+	})
+}
+
 func TestResolveGoIndex(t *testing.T) {
 	c := &config.Config{
 		GoPrefix: "example.com/repo",
 		DepMode:  config.VendorMode,
 	}
-	l := label.NewLabeler(c)
 
 	type fileSpec struct {
 		rel, content string
@@ -200,9 +216,9 @@ go_library(
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			ix := NewRuleIndex()
+			ix := newTestIndex()
 			for _, fs := range tc.buildFiles {
-				f, err := rule.LoadData(path.Join(fs.rel, "BUILD.bazel"), []byte(fs.content))
+				f, err := rule.LoadData(path.Join(fs.rel, "BUILD.bazel"), fs.rel, []byte(fs.content))
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -211,8 +227,7 @@ go_library(
 
 			ix.Finish()
 
-			r := NewResolver(c, l, ix, nil)
-			got, err := r.resolveGo(tc.imp, tc.from)
+			got, err := resolveGo(c, ix, nil, tc.imp, tc.from)
 			if err != nil {
 				if tc.wantErr == "" {
 					t.Fatal(err)
@@ -237,7 +252,6 @@ func TestResolveProtoIndex(t *testing.T) {
 		GoPrefix: "example.com/repo",
 		DepMode:  config.VendorMode,
 	}
-	l := label.NewLabeler(c)
 
 	buildContent := []byte(`
 proto_library(
@@ -257,36 +271,24 @@ go_library(
     importpath = "example.com/foo",
 )
 `)
-	f, err := rule.LoadData(filepath.Join("sub", "BUILD.bazel"), buildContent)
+	f, err := rule.LoadData(filepath.Join("sub", "BUILD.bazel"), "sub", buildContent)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ix := NewRuleIndex()
+	ix := newTestIndex()
 	ix.AddRulesFromFile(c, f)
 	ix.Finish()
-	r := NewResolver(c, l, ix, nil)
-
-	wantProto := label.New("", "sub", "foo_proto")
-	if got, err := r.resolveProto("sub/bar.proto", label.New("", "baz", "baz")); err != nil {
-		t.Error(err)
-	} else if !reflect.DeepEqual(got, wantProto) {
-		t.Errorf("resolveProto: got %s ; want %s", got, wantProto)
-	}
-	_, err = r.resolveProto("sub/bar.proto", label.New("", "sub", "foo_proto"))
-	if _, ok := err.(selfImportError); !ok {
-		t.Errorf("resolveProto: got %v ; want selfImportError", err)
-	}
 
 	wantGoProto := label.New("", "sub", "embed")
-	if got, err := r.resolveGoProto("sub/bar.proto", label.New("", "baz", "baz")); err != nil {
+	if got, err := resolveProto(c, ix, nil, "sub/bar.proto", label.New("", "baz", "baz")); err != nil {
 		t.Error(err)
 	} else if !reflect.DeepEqual(got, wantGoProto) {
-		t.Errorf("resolveGoProto: got %s ; want %s", got, wantGoProto)
+		t.Errorf("resolveProto: got %s ; want %s", got, wantGoProto)
 	}
-	_, err = r.resolveGoProto("sub/bar.proto", label.New("", "sub", "foo_go_proto"))
-	if _, ok := err.(selfImportError); !ok {
-		t.Errorf("resolveGoProto: got %v ; want selfImportError", err)
+	_, err = resolveProto(c, ix, nil, "sub/bar.proto", label.New("", "sub", "foo_go_proto"))
+	if err != skipImportError {
+		t.Errorf("resolveProto: got %v ; want skipImportError", err)
 	}
 }
 
@@ -320,26 +322,76 @@ func TestResolveGoLocal(t *testing.T) {
 		},
 	} {
 		c := &config.Config{GoPrefix: "example.com/repo"}
-		l := label.NewLabeler(c)
-		ix := NewRuleIndex()
-		r := NewResolver(c, l, ix, nil)
-		label, err := r.resolveGo(spec.importpath, spec.from)
+		ix := newTestIndex()
+		label, err := resolveGo(c, ix, nil, spec.importpath, spec.from)
 		if err != nil {
-			t.Errorf("r.resolveGo(%q) failed with %v; want success", spec.importpath, err)
+			t.Errorf("resolveGo(%q) failed with %v; want success", spec.importpath, err)
 			continue
 		}
 		if got, want := label, spec.want; !reflect.DeepEqual(got, want) {
-			t.Errorf("r.resolveGo(%q) = %s; want %s", spec.importpath, got, want)
+			t.Errorf("resolveGo(%q) = %s; want %s", spec.importpath, got, want)
+		}
+	}
+}
+
+func TestResolveGoNamingConvention(t *testing.T) {
+	c := &config.Config{
+		GoPrefix: "example.com/repo",
+		Exts: map[string]interface{}{
+			goName: &goConfig{namingConvention: importNamingConvention},
+		},
+	}
+	ix := newTestIndex()
+	for _, fs := range []struct{ rel, content string }{
+		{
+			rel: "old",
+			content: `
+go_library(
+    name = "go_default_library",
+    importpath = "example.com/repo/old",
+)
+`,
+		}, {
+			rel: "new",
+			content: `
+go_library(
+    name = "new",
+    importpath = "example.com/repo/new",
+)
+`,
+		},
+	} {
+		f, err := rule.LoadData(path.Join(fs.rel, "BUILD.bazel"), fs.rel, []byte(fs.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ix.AddRulesFromFile(c, f)
+	}
+	ix.Finish()
+	rc := newStubRemoteCache(nil)
+
+	for _, tc := range []struct {
+		imp  string
+		want label.Label
+	}{
+		{imp: "example.com/repo/old", want: label.New("", "old", "go_default_library")},
+		{imp: "example.com/repo/new", want: label.New("", "new", "new")},
+		{imp: "example.com/repo/missing", want: label.New("", "missing", "missing")},
+		{imp: "example.com/ext", want: label.New("com_example", "ext", "go_default_library")},
+	} {
+		got, err := resolveGo(c, ix, rc, tc.imp, label.New("", "from", "from"))
+		if err != nil {
+			t.Errorf("resolveGo(%q): %v", tc.imp, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("resolveGo(%q) = %s; want %s", tc.imp, got, tc.want)
 		}
 	}
 }
 
 func TestResolveGoLocalError(t *testing.T) {
 	c := &config.Config{GoPrefix: "example.com/repo"}
-	l := label.NewLabeler(c)
-	ix := NewRuleIndex()
+	ix := newTestIndex()
 	rc := newStubRemoteCache(nil)
-	r := NewResolver(c, l, ix, rc)
 
 	for _, importpath := range []string{
 		"fmt",
@@ -347,83 +399,98 @@ func TestResolveGoLocalError(t *testing.T) {
 		"unknown.com/another/sub",
 		"unknown.com/repo_suffix",
 	} {
-		if l, err := r.resolveGo(importpath, label.NoLabel); err == nil {
-			t.Errorf("r.resolveGo(%q) = %s; want error", importpath, l)
+		if l, err := resolveGo(c, ix, rc, importpath, label.NoLabel); err == nil {
+			t.Errorf("resolveGo(%q) = %s; want error", importpath, l)
 		}
 	}
 
-	if l, err := r.resolveGo("..", label.NoLabel); err == nil {
-		t.Errorf("r.resolveGo(%q) = %s; want error", "..", l)
+	if l, err := resolveGo(c, ix, rc, "..", label.NoLabel); err == nil {
+		t.Errorf("resolveGo(%q) = %s; want error", "..", l)
 	}
 }
 
 func TestResolveGoEmptyPrefix(t *testing.T) {
 	c := &config.Config{}
-	l := label.NewLabeler(c)
-	ix := NewRuleIndex()
-	r := NewResolver(c, l, ix, nil)
+	ix := newTestIndex()
 
 	imp := "foo"
 	want := label.New("", "foo", config.DefaultLibName)
-	if got, err := r.resolveGo(imp, label.NoLabel); err != nil {
-		t.Errorf("r.resolveGo(%q) failed with %v; want success", imp, err)
+	if got, err := resolveGo(c, ix, nil, imp, label.NoLabel); err != nil {
+		t.Errorf("resolveGo(%q) failed with %v; want success", imp, err)
 	} else if !reflect.DeepEqual(got, want) {
-		t.Errorf("r.resolveGo(%q) = %s; want %s", imp, got, want)
+		t.Errorf("resolveGo(%q) = %s; want %s", imp, got, want)
 	}
 
 	imp = "fmt"
-	if _, err := r.resolveGo(imp, label.NoLabel); err == nil {
-		t.Errorf("r.resolveGo(%q) succeeded; want failure", imp)
+	if _, err := resolveGo(c, ix, nil, imp, label.NoLabel); err == nil {
+		t.Errorf("resolveGo(%q) succeeded; want failure", imp)
 	}
 }
 
 func TestResolveProto(t *testing.T) {
 	prefix := "example.com/repo"
 	for _, tc := range []struct {
-		desc, imp              string
-		from                   label.Label
-		depMode                config.DependencyMode
-		wantProto, wantGoProto label.Label
+		desc, imp string
+		from      label.Label
+		depMode   config.DependencyMode
+		prefixMap []string
+		nc        namingConvention
+		want      label.Label
 	}{
 		{
-			desc:        "root",
-			imp:         "foo.proto",
-			wantProto:   label.New("", "", "repo_proto"),
-			wantGoProto: label.New("", "", config.DefaultLibName),
+			desc: "root",
+			imp:  "foo.proto",
+			want: label.New("", "", config.DefaultLibName),
+		}, {
+			desc:      "import_prefix",
+			imp:       "google/api/expr/v1/syntax.proto",
+			prefixMap: []string{"google/api @go_googleapis//google/api"},
+			want:      label.New("go_googleapis", "google/api/expr/v1", config.DefaultLibName),
+		}, {
+			desc:      "import_prefix_file_mode",
+			imp:       "google/api/annotations.proto",
+			prefixMap: []string{"google/api @go_googleapis//google/api file"},
+			want:      label.New("go_googleapis", "google/api", config.DefaultLibName),
 		}, {
-			desc:        "sub",
-			imp:         "foo/bar/bar.proto",
-			wantProto:   label.New("", "foo/bar", "bar_proto"),
-			wantGoProto: label.New("", "foo/bar", config.DefaultLibName),
+			desc:      "import_prefix_naming_convention",
+			imp:       "google/api/annotations.proto",
+			prefixMap: []string{"google/api @go_googleapis//google/api file"},
+			nc:        importNamingConvention,
+			want:      label.New("go_googleapis", "google/api", config.DefaultLibName),
 		}, {
-			desc:        "vendor",
-			depMode:     config.VendorMode,
-			imp:         "foo/bar/bar.proto",
-			from:        label.New("", "vendor", ""),
-			wantProto:   label.New("", "foo/bar", "bar_proto"),
-			wantGoProto: label.New("", "vendor/foo/bar", config.DefaultLibName),
+			desc:      "import_prefix_local_naming_convention",
+			imp:       "google/api/annotations.proto",
+			prefixMap: []string{"google/api //third_party/googleapis"},
+			nc:        importNamingConvention,
+			want:      label.New("", "third_party/googleapis", "googleapis"),
 		}, {
-			desc:        "well known",
-			imp:         "google/protobuf/any.proto",
-			wantProto:   label.New("com_google_protobuf", "", "any_proto"),
-			wantGoProto: label.NoLabel,
+			desc: "sub",
+			imp:  "foo/bar/bar.proto",
+			want: label.New("", "foo/bar", config.DefaultLibName),
 		}, {
-			desc:        "well known vendor",
-			depMode:     config.VendorMode,
-			imp:         "google/protobuf/any.proto",
-			wantProto:   label.New("com_google_protobuf", "", "any_proto"),
-			wantGoProto: label.NoLabel,
+			desc:    "vendor",
+			depMode: config.VendorMode,
+			imp:     "foo/bar/bar.proto",
+			from:    label.New("", "vendor", ""),
+			want:    label.New("", "vendor/foo/bar", config.DefaultLibName),
 		}, {
-			desc:        "descriptor",
-			imp:         "google/protobuf/descriptor.proto",
-			wantProto:   label.New("com_google_protobuf", "", "descriptor_proto"),
-			wantGoProto: label.NoLabel,
+			desc: "well known",
+			imp:  "google/protobuf/any.proto",
+			want: label.NoLabel,
 		}, {
-			desc:        "descriptor vendor",
-			depMode:     config.VendorMode,
-			imp:         "google/protobuf/descriptor.proto",
-			wantProto:   label.New("com_google_protobuf", "", "descriptor_proto"),
-			wantGoProto: label.NoLabel,
+			desc:    "well known vendor",
+			depMode: config.VendorMode,
+			imp:     "google/protobuf/any.proto",
+			want:    label.NoLabel,
+		}, {
+			desc: "descriptor",
+			imp:  "google/protobuf/descriptor.proto",
+			want: label.NoLabel,
+		}, {
+			desc:    "descriptor vendor",
+			depMode: config.VendorMode,
+			imp:     "google/protobuf/descriptor.proto",
+			want:    label.NoLabel,
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
@@ -431,28 +498,24 @@ func TestResolveProto(t *testing.T) {
 				GoPrefix: prefix,
 				DepMode:  tc.depMode,
 			}
-			l := label.NewLabeler(c)
-			ix := NewRuleIndex()
-			r := NewResolver(c, l, ix, nil)
-
-			got, err := r.resolveProto(tc.imp, tc.from)
-			if err != nil {
-				t.Errorf("resolveProto: got error %v; want success", err)
-			}
-			if !reflect.DeepEqual(got, tc.wantProto) {
-				t.Errorf("resolveProto: got %s; want %s", got, tc.wantProto)
+			var directives []config.Directive
+			for _, value := range tc.prefixMap {
+				directives = append(directives, config.Directive{Key: "proto_import_prefix_map", Value: value})
 			}
+			proto.New().Configure(c, "", nil, directives)
+			c.Exts[goName] = &goConfig{namingConvention: tc.nc}
+			ix := newTestIndex()
 
-			got, err = r.resolveGoProto(tc.imp, tc.from)
+			got, err := resolveProto(c, ix, nil, tc.imp, tc.from)
 			if err != nil {
-				if tc.wantGoProto != label.NoLabel {
-					t.Errorf("resolveGoProto: got error %v; want %s", got, tc.wantGoProto)
-				} else if _, ok := err.(standardImportError); !ok {
-					t.Errorf("resolveGoProto: got error %v; want standardImportError", err)
+				if tc.want != label.NoLabel {
+					t.Errorf("resolveProto: got error %v; want %s", err, tc.want)
+				} else if err != skipImportError {
+					t.Errorf("resolveProto: got error %v; want skipImportError", err)
 				}
 			}
-			if !got.Equal(tc.wantGoProto) {
-				t.Errorf("resolveGoProto: got %s; want %s", got, tc.wantGoProto)
+			if !got.Equal(tc.want) {
+				t.Errorf("resolveProto: got %s; want %s", got, tc.want)
 			}
 		})
 	}
@@ -460,9 +523,7 @@ func TestResolveProto(t *testing.T) {
 
 func TestResolveGoWKT(t *testing.T) {
 	c := &config.Config{}
-	l := label.NewLabeler(c)
-	ix := NewRuleIndex()
-	r := NewResolver(c, l, ix, nil)
+	ix := newTestIndex()
 
 	for _, tc := range []struct {
 		imp, want string
@@ -486,7 +547,7 @@ func TestResolveGoWKT(t *testing.T) {
 				Pkg:  config.WellKnownTypesPkg,
 				Name: tc.want,
 			}
-			if got, err := r.resolveGo(tc.imp, label.NoLabel); err != nil {
+			if got, err := resolveGo(c, ix, nil, tc.imp, label.NoLabel); err != nil {
 				t.Error(err)
 			} else if !got.Equal(want) {
 				t.Errorf("got %s; want %s", got, want)
@@ -497,11 +558,9 @@ func TestResolveGoWKT(t *testing.T) {
 
 func TestResolveGoSkipEmbeds(t *testing.T) {
 	c := &config.Config{}
-	l := label.NewLabeler(c)
-	ix := NewRuleIndex()
-	r := NewResolver(c, l, ix, nil)
+	ix := newTestIndex()
 
-	f, err := rule.LoadData("(test)", []byte(`
+	f, err := rule.LoadData("(test)", "", []byte(`
 load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
 
 go_library(
@@ -524,7 +583,7 @@ go_test(
 	ix.AddRulesFromFile(c, f)
 	ix.Finish()
 	testRule := f.Rules[len(f.Rules)-1]
-	r.ResolveRule(testRule, "")
+	New().Resolve(c, ix, nil, testRule, label.New("", "", testRule.Name()))
 	testDeps := testRule.Attr("deps")
 	if testDeps != nil {
 		t.Errorf("got deps = %s; want nil", bzl.FormatString(testDeps))
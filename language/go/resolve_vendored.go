@@ -13,20 +13,20 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package resolve
+package golang
 
 import (
-	"github.com/bazelbuild/bazel-gazelle/internal/label"
+	"github.com/bazelbuild/bazel-gazelle/label"
 )
 
 // vendoredResolver resolves external packages as packages in vendor/.
 type vendoredResolver struct {
-	l *label.Labeler
+	l *goLabeler
 }
 
 var _ nonlocalResolver = (*vendoredResolver)(nil)
 
-func newVendoredResolver(l *label.Labeler) *vendoredResolver {
+func newVendoredResolver(l *goLabeler) *vendoredResolver {
 	return &vendoredResolver{l}
 }
 
@@ -0,0 +1,288 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/internal/pathtools"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// protoConfig contains configuration values related to proto rules. It is
+// stored in Config.Exts under protoName. Values are shared with
+// subdirectories, so Configure replaces the protoConfig instead of
+// modifying it.
+type protoConfig struct {
+	// langs lists the languages of rules generated for each proto_library,
+	// for example, "go" and "java". When it is nil, only Go rules are
+	// generated. Set with the proto_langs directive.
+	langs []string
+
+	// importPrefixes map prefixes of proto import paths to packages in other
+	// repositories. They are used to resolve imports of .proto files that
+	// aren't in this repository. Set with the proto_import_prefix_map
+	// directive.
+	importPrefixes []ImportPrefix
+}
+
+// getProtoConfig returns the proto configuration for the directory c
+// applies to. The returned value must not be modified.
+func getProtoConfig(c *config.Config) *protoConfig {
+	if pc, ok := c.Exts[protoName].(*protoConfig); ok {
+		return pc
+	}
+	return &protoConfig{}
+}
+
+// HasLang returns whether rules for the language lang should be generated
+// for proto_library rules in the directory c applies to.
+func HasLang(c *config.Config, lang string) bool {
+	langs := getProtoConfig(c).langs
+	if langs == nil {
+		return lang == "go"
+	}
+	for _, l := range langs {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportPrefix maps .proto files imported with paths that start with Prefix
+// to rules in a package of another repository.
+type ImportPrefix struct {
+	// Prefix is a slash-separated prefix of import paths, for example,
+	// "google/api".
+	Prefix string
+
+	// Repo and Pkg name the package that contains .proto files imported
+	// with Prefix, for example, "go_googleapis" and "google/api". Files
+	// imported from subdirectories of Prefix are in subdirectories of Pkg.
+	Repo, Pkg string
+
+	// Mode is the proto mode the package's rules were generated with.
+	// Either DefaultProtoMode (one rule per directory) or FileProtoMode (one
+	// rule per file).
+	Mode config.ProtoMode
+}
+
+// Package returns the package in the repository mapped by p that contains
+// the .proto file imported as imp. imp must start with p.Prefix.
+func (p ImportPrefix) Package(imp string) string {
+	rel := path.Dir(imp)
+	if rel == "." {
+		rel = ""
+	}
+	return path.Join(p.Pkg, pathtools.TrimPrefix(rel, p.Prefix))
+}
+
+// FindImportPrefix returns the mapping set with a proto_import_prefix_map
+// directive with the longest prefix that matches the proto import path imp.
+// If several mappings have the same prefix, the one set last (in the
+// deepest directory) is returned.
+func FindImportPrefix(c *config.Config, imp string) (ImportPrefix, bool) {
+	var best ImportPrefix
+	found := false
+	for _, p := range getProtoConfig(c).importPrefixes {
+		if pathtools.HasPrefix(imp, p.Prefix) && (!found || len(p.Prefix) >= len(best.Prefix)) {
+			best = p
+			found = true
+		}
+	}
+	return best, found
+}
+
+func (pl *protoLang) KnownDirectives() []string {
+	return []string{"proto", "proto_import_prefix_map", "proto_langs"}
+}
+
+func (pl *protoLang) Configure(c *config.Config, rel string, f *bzl.File, directives []config.Directive) {
+	// The Go prefix is read here (but not applied) since it determines whether
+	// this is the Well Known Types repository. The Go extension may be
+	// configured after this one.
+	goPrefix := c.GoPrefix
+	pc := *getProtoConfig(c)
+	var importPrefixes []ImportPrefix
+	for _, d := range directives {
+		switch d.Key {
+		case "proto":
+			protoMode, err := config.ProtoModeFromString(d.Value)
+			if err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				continue
+			}
+			c.ProtoMode = protoMode
+			c.ProtoModeExplicit = true
+		case "proto_import_prefix_map":
+			p, err := parseProtoImportPrefix(d.Value)
+			if err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				continue
+			}
+			importPrefixes = append(importPrefixes, p)
+		case "proto_langs":
+			langs, err := parseProtoLangs(d.Value)
+			if err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				continue
+			}
+			pc.langs = langs
+		case "prefix":
+			goPrefix = d.Value
+		}
+	}
+	if len(importPrefixes) > 0 {
+		// The inherited slice is shared with parent directories, so copy it.
+		all := make([]ImportPrefix, 0, len(pc.importPrefixes)+len(importPrefixes))
+		all = append(all, pc.importPrefixes...)
+		pc.importPrefixes = append(all, importPrefixes...)
+	}
+	if c.Exts == nil {
+		c.Exts = make(map[string]interface{})
+	}
+	c.Exts[protoName] = &pc
+	inferProtoMode(c, rel, f, goPrefix)
+}
+
+// parseProtoLangs parses the comma-separated list of languages in a
+// proto_langs directive. Spaces around languages are ignored. An empty value
+// returns nil, which selects the default languages.
+func parseProtoLangs(value string) ([]string, error) {
+	langs := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(langs) == 0 {
+		return nil, nil
+	}
+	for _, lang := range langs {
+		if lang != "go" && langKinds[lang] == "" {
+			return nil, fmt.Errorf("unknown proto language %q; valid languages are go, %s", lang, strings.Join(otherLangs, ", "))
+		}
+	}
+	return langs, nil
+}
+
+// parseProtoImportPrefix parses the value of a proto_import_prefix_map
+// directive: an import path prefix, the label of a package in another
+// repository, and optionally the proto mode the package's rules were
+// generated with ("default" or "file").
+func parseProtoImportPrefix(value string) (ImportPrefix, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 || len(fields) > 3 {
+		return ImportPrefix{}, fmt.Errorf("expected an import prefix, a package label, and an optional proto mode")
+	}
+	p := ImportPrefix{Prefix: strings.Trim(fields[0], "/")}
+	pkgLabel := fields[1]
+	if strings.HasPrefix(pkgLabel, "@") {
+		i := strings.Index(pkgLabel, "//")
+		if i < 0 {
+			return ImportPrefix{}, fmt.Errorf("%s: repository does not end with '//'", fields[1])
+		}
+		p.Repo, pkgLabel = pkgLabel[len("@"):i], pkgLabel[i:]
+	}
+	if !strings.HasPrefix(pkgLabel, "//") {
+		return ImportPrefix{}, fmt.Errorf("%s: package label must be absolute", fields[1])
+	}
+	if strings.Contains(pkgLabel, ":") {
+		return ImportPrefix{}, fmt.Errorf("%s: package label may not have a target name", fields[1])
+	}
+	p.Pkg = strings.TrimSuffix(pkgLabel[len("//"):], "/")
+	if len(fields) == 3 {
+		mode, err := config.ProtoModeFromString(fields[2])
+		if err != nil {
+			return ImportPrefix{}, err
+		}
+		if mode != config.DefaultProtoMode && mode != config.FileProtoMode {
+			return ImportPrefix{}, fmt.Errorf("proto mode must be default or file: %q", fields[2])
+		}
+		p.Mode = mode
+	}
+	return p, nil
+}
+
+// inferProtoMode sets Config.ProtoMode, based on the contents of f.  If the
+// proto mode is already set to something other than the default, or if the mode
+// is set explicitly in directives, this function does not change it. If the
+// legacy go_proto_library.bzl is loaded, or if this is the Well Known Types
+// repository, legacy mode is used. If go_proto_library is loaded from another
+// file, proto rule generation is disabled.
+func inferProtoMode(c *config.Config, rel string, f *bzl.File, goPrefix string) {
+	if c.ProtoMode != config.DefaultProtoMode || c.ProtoModeExplicit {
+		return
+	}
+	if goPrefix == config.WellKnownTypesGoPrefix {
+		// Use legacy mode in this repo. We don't need proto_library or
+		// go_proto_library, since we get that from @com_google_protobuf.
+		// Legacy rules still refer to .proto files in here, which need are
+		// exposed by filegroup. go_library rules from .pb.go files will be
+		// generated, which are depended upon by the new rules.
+		c.ProtoMode = config.LegacyProtoMode
+		return
+	}
+	if path.Base(rel) == "vendor" {
+		c.ProtoMode = config.DisableProtoMode
+		return
+	}
+	if f == nil {
+		return
+	}
+	mode := config.DefaultProtoMode
+	for _, stmt := range f.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		x, ok := call.X.(*bzl.LiteralExpr)
+		if !ok || x.Token != "load" || len(call.List) == 0 {
+			continue
+		}
+		name, ok := call.List[0].(*bzl.StringExpr)
+		if !ok {
+			continue
+		}
+		if name.Value == "@io_bazel_rules_go//proto:def.bzl" {
+			break
+		}
+		if name.Value == "@io_bazel_rules_go//proto:go_proto_library.bzl" {
+			mode = config.LegacyProtoMode
+			break
+		}
+		for _, arg := range call.List[1:] {
+			if sym, ok := arg.(*bzl.StringExpr); ok && sym.Value == "go_proto_library" {
+				mode = config.DisableProtoMode
+				break
+			}
+			kwarg, ok := arg.(*bzl.BinaryExpr)
+			if !ok || kwarg.Op != "=" {
+				continue
+			}
+			if key, ok := kwarg.X.(*bzl.LiteralExpr); ok && key.Token == "go_proto_library" {
+				mode = config.DisableProtoMode
+				break
+			}
+		}
+	}
+	if mode == config.DefaultProtoMode || c.ProtoMode == mode || c.ShouldFix && mode == config.LegacyProtoMode {
+		return
+	}
+	c.ProtoMode = mode
+}
@@ -0,0 +1,167 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func TestConfigureProtoMode(t *testing.T) {
+	for _, tc := range []struct {
+		desc, content string
+		c             config.Config
+		rel           string
+		want          config.ProtoMode
+	}{
+		{
+			desc: "default",
+		}, {
+			desc: "previous",
+			c:    config.Config{ProtoMode: config.LegacyProtoMode},
+			want: config.LegacyProtoMode,
+		}, {
+			desc: "explicit",
+			content: `# gazelle:proto default
+
+load("@io_bazel_rules_go//proto:go_proto_library.bzl", "go_proto_library")
+`,
+			want: config.DefaultProtoMode,
+		}, {
+			desc:    "explicit_file",
+			content: `# gazelle:proto file`,
+			want:    config.FileProtoMode,
+		}, {
+			desc:    "explicit_package",
+			content: `# gazelle:proto package`,
+			want:    config.PackageProtoMode,
+		}, {
+			desc:    "explicit_no_override",
+			content: `load("@io_bazel_rules_go//proto:go_proto_library.bzl", "go_proto_library")`,
+			c: config.Config{
+				ProtoMode:         config.DefaultProtoMode,
+				ProtoModeExplicit: true,
+			},
+			want: config.DefaultProtoMode,
+		}, {
+			desc: "vendor",
+			rel:  "vendor",
+			want: config.DisableProtoMode,
+		}, {
+			desc:    "legacy",
+			content: `load("@io_bazel_rules_go//proto:go_proto_library.bzl", "go_proto_library")`,
+			want:    config.LegacyProtoMode,
+		}, {
+			desc:    "disable",
+			content: `load("@com_example_repo//proto:go_proto_library.bzl", go_proto_library = "x")`,
+			want:    config.DisableProtoMode,
+		}, {
+			desc:    "fix legacy",
+			content: `load("@io_bazel_rules_go//proto:go_proto_library.bzl", "go_proto_library")`,
+			c:       config.Config{ShouldFix: true},
+		}, {
+			desc:    "fix disabled",
+			content: `load("@com_example_repo//proto:go_proto_library.bzl", go_proto_library = "x")`,
+			c:       config.Config{ShouldFix: true},
+			want:    config.DisableProtoMode,
+		}, {
+			desc: "well known types",
+			c:    config.Config{GoPrefix: "github.com/golang/protobuf"},
+			want: config.LegacyProtoMode,
+		}, {
+			desc:    "well known types directive",
+			content: `# gazelle:prefix github.com/golang/protobuf`,
+			want:    config.LegacyProtoMode,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var f *bzl.File
+			var directives []config.Directive
+			if tc.content != "" {
+				var err error
+				f, err = bzl.Parse("BUILD.bazel", []byte(tc.content))
+				if err != nil {
+					t.Fatalf("error parsing build file: %v", err)
+				}
+				directives = config.ParseDirectives(f)
+			}
+
+			c := tc.c
+			New().Configure(&c, tc.rel, f, directives)
+			if c.ProtoMode != tc.want {
+				t.Errorf("got proto mode %v ; want %v", c.ProtoMode, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigureProtoLangs(t *testing.T) {
+	for _, tc := range []struct {
+		desc, value string
+		want        []string
+	}{
+		{
+			desc:  "empty",
+			value: "",
+		}, {
+			desc:  "langs",
+			value: "go,java,cc,py",
+			want:  []string{"go", "java", "cc", "py"},
+		}, {
+			desc:  "spaces",
+			value: " go, java ,,cc ",
+			want:  []string{"go", "java", "cc"},
+		}, {
+			desc:  "unknown",
+			value: "go,rust",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := &config.Config{}
+			New().Configure(c, "", nil, []config.Directive{{Key: "proto_langs", Value: tc.value}})
+			if langs := getProtoConfig(c).langs; !reflect.DeepEqual(langs, tc.want) {
+				t.Errorf("got %#v; want %#v", langs, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigureProtoImportPrefixMap(t *testing.T) {
+	parent := &config.Config{}
+	New().Configure(parent, "", nil, []config.Directive{
+		{Key: "proto_import_prefix_map", Value: "google/api @go_googleapis//google/api file"},
+	})
+	child := parent.Clone()
+	New().Configure(child, "sub", nil, []config.Directive{
+		{Key: "proto_import_prefix_map", Value: "/example/ @com_example_protos//"},
+		{Key: "proto_import_prefix_map", Value: "bad //:target"},
+		{Key: "proto_import_prefix_map", Value: "bad @repo//pkg legacy"},
+	})
+
+	wantParent := []ImportPrefix{
+		{Prefix: "google/api", Repo: "go_googleapis", Pkg: "google/api", Mode: config.FileProtoMode},
+	}
+	if got := getProtoConfig(parent).importPrefixes; !reflect.DeepEqual(got, wantParent) {
+		t.Errorf("parent: got %#v; want %#v", got, wantParent)
+	}
+	wantChild := append(wantParent, ImportPrefix{Prefix: "example", Repo: "com_example_protos"})
+	if got := getProtoConfig(child).importPrefixes; !reflect.DeepEqual(got, wantChild) {
+		t.Errorf("child: got %#v; want %#v", got, wantChild)
+	}
+}
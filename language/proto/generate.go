@@ -0,0 +1,203 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/packages"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// PackageKey is the name of a private attribute set on generated
+// proto_library rules. This attribute contains a packages.ProtoTarget
+// describing the sources of the rule. Other languages (e.g., Go) use it to
+// generate their own proto rules.
+const PackageKey = "_proto_package"
+
+func (pl *protoLang) GenerateRules(args language.GenerateArgs) (gen, empty []*rule.Rule) {
+	c := args.Config
+	if !c.ProtoMode.ShouldGenerateRules() {
+		// Don't create or delete proto rules in other modes. Any existing rules
+		// are likely hand-written. In legacy mode, filegroups are generated by
+		// the Go extension.
+		return nil, nil
+	}
+
+	pkg := args.Package
+	l := label.NewLabeler(c)
+	if c.ProtoMode.GroupsProtoFiles() {
+		gen, empty = generateGroups(c, args)
+	} else if r := rule.NewRule("proto_library", l.ProtoLabel(pkg.Rel, pkg.Name).Name); !pkg.Proto.HasProto() {
+		empty = []*rule.Rule{r}
+	} else {
+		gen = []*rule.Rule{generateProto(c, args.File, pkg.Rel, r.Name(), pkg.Proto)}
+	}
+	return generateLangRules(c, args.File, pkg.Rel, gen, empty)
+}
+
+// generateLangRules generates rules for other languages for each generated
+// proto_library, for the languages listed with the proto_langs directive.
+// For each empty proto_library, empty rules are returned for the same
+// languages, so rules for deleted protos are deleted too. Rules for other
+// languages are not changed.
+func generateLangRules(c *config.Config, f *rule.File, rel string, protoGen, protoEmpty []*rule.Rule) (gen, empty []*rule.Rule) {
+	gen = protoGen
+	empty = protoEmpty
+	for _, lang := range otherLangs {
+		if !HasLang(c, lang) {
+			continue
+		}
+		for _, r := range protoGen {
+			langRule := rule.NewRule(langKinds[lang], langRuleName(r.Name(), lang))
+			langRule.SetAttr("deps", []string{":" + r.Name()})
+			if f == nil || !f.HasDefaultVisibility() {
+				langRule.SetAttr("visibility", checkInternalVisibility(rel, defaultVisibility(c)))
+			}
+			gen = append(gen, langRule)
+		}
+		for _, r := range protoEmpty {
+			empty = append(empty, rule.NewRule(langKinds[lang], langRuleName(r.Name(), lang)))
+		}
+	}
+	return gen, empty
+}
+
+// langRuleName returns the name of the rule for lang generated for the
+// proto_library named protoName, for example, "foo_java_proto" for
+// "foo_proto".
+func langRuleName(protoName, lang string) string {
+	return strings.TrimSuffix(protoName, "_proto") + "_" + lang + "_proto"
+}
+
+// generateGroups generates a proto_library rule for each .proto file or for
+// each proto package declared in the directory, depending on the proto mode.
+// Files in the package mode without a package statement are grouped into a
+// rule with the default name. Existing proto_library rules that are not
+// generated and only contain .proto files from this directory are returned
+// as empty rules, so they are deleted when their files move to other rules.
+func generateGroups(c *config.Config, args language.GenerateArgs) (gen, empty []*rule.Rule) {
+	pkg := args.Package
+	defaultName := label.NewLabeler(c).ProtoLabel(pkg.Rel, pkg.Name).Name
+	var names []string
+	groups := make(map[string][]packages.ProtoFile)
+	for _, f := range pkg.Proto.Files {
+		var name string
+		switch {
+		case c.ProtoMode == config.FileProtoMode:
+			name = strings.TrimSuffix(f.Name, ".proto") + "_proto"
+		case f.PackageName != "":
+			name = strings.Replace(f.PackageName, ".", "_", -1) + "_proto"
+		default:
+			name = defaultName
+		}
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], f)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		gen = append(gen, generateProto(c, args.File, pkg.Rel, name, groupTarget(groups[name])))
+	}
+
+	if _, ok := groups[defaultName]; !ok {
+		empty = append(empty, rule.NewRule("proto_library", defaultName))
+	}
+	if args.File != nil {
+		for _, r := range args.File.Rules {
+			if _, ok := groups[r.Name()]; ok || r.Kind() != "proto_library" || r.Name() == defaultName || !hasOnlyLocalProtos(r) {
+				continue
+			}
+			empty = append(empty, rule.NewRule("proto_library", r.Name()))
+		}
+	}
+	return gen, empty
+}
+
+// generateProto generates a proto_library rule with the given name for the
+// sources in target.
+func generateProto(c *config.Config, f *rule.File, rel, name string, target packages.ProtoTarget) *rule.Rule {
+	r := rule.NewRule("proto_library", name)
+	r.SetAttr("srcs", target.Sources)
+	if f == nil || !f.HasDefaultVisibility() {
+		r.SetAttr("visibility", checkInternalVisibility(rel, defaultVisibility(c)))
+	}
+	if imports := target.Imports; !imports.IsEmpty() {
+		r.SetAttr(config.GazelleImportsKey, imports)
+	}
+	r.SetPrivateAttr(PackageKey, target)
+	return r
+}
+
+// groupTarget returns a ProtoTarget containing the given files.
+func groupTarget(files []packages.ProtoFile) packages.ProtoTarget {
+	target := packages.ProtoTarget{Files: files}
+	importSet := make(map[string]bool)
+	for _, f := range files {
+		target.Sources.Generic = append(target.Sources.Generic, f.Name)
+		for _, imp := range f.Imports {
+			importSet[imp] = true
+		}
+		target.HasServices = target.HasServices || f.HasServices
+	}
+	for imp := range importSet {
+		target.Imports.Generic = append(target.Imports.Generic, imp)
+	}
+	sort.Strings(target.Imports.Generic)
+	return target
+}
+
+// hasOnlyLocalProtos returns whether r has sources, and all of them are
+// .proto files in the same directory.
+func hasOnlyLocalProtos(r *rule.Rule) bool {
+	srcs := r.AttrStrings("srcs")
+	if len(srcs) == 0 {
+		return false
+	}
+	for _, src := range srcs {
+		l, err := label.Parse(src)
+		if err != nil || !l.Relative || strings.Contains(l.Name, "/") || !strings.HasSuffix(l.Name, ".proto") {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultVisibility returns the visibility of generated rules: the
+// visibility set with the default_visibility directive, or public.
+func defaultVisibility(c *config.Config) []string {
+	if visibility := language.DefaultVisibility(c); len(visibility) > 0 {
+		return visibility
+	}
+	return []string{"//visibility:public"}
+}
+
+// checkInternalVisibility overrides the given visibility if the package is
+// internal.
+func checkInternalVisibility(rel string, visibility []string) []string {
+	if i := strings.LastIndex(rel, "/internal/"); i >= 0 {
+		visibility = []string{fmt.Sprintf("//%s:__subpackages__", rel[:i])}
+	} else if strings.HasPrefix(rel, "internal/") {
+		visibility = []string{"//:__subpackages__"}
+	}
+	return visibility
+}
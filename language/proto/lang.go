@@ -0,0 +1,114 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package proto provides support for protocol buffer rules.
+// It generates proto_library rules only (not go_proto_library or any other
+// language-specific implementations).
+//
+// Configuration
+//
+// Configuration is largely controlled by Config.ProtoMode. In disable mode,
+// proto rules are left alone (neither generated nor deleted). In legacy mode,
+// filegroups are emitted containing protos (by the Go extension). In default
+// mode, proto_library rules are emitted. In file and package modes,
+// proto_library rules are emitted for each .proto file or for each proto
+// package. The proto mode may be set with the -proto command line flag or the "# gazelle:proto" directive. If the mode
+// is not set explicitly, it is inferred from load statements in build files.
+//
+// Rule generation
+//
+// In default mode, Gazelle generates at most one proto_library per directory.
+// Protos in the same package are grouped together into a proto_library. If
+// there are sources for multiple packages, the package name that matches the
+// directory name will be chosen; if there is no such package, an error will
+// be printed. In file mode, each .proto file gets its own proto_library. In
+// package mode, .proto files are grouped by their package statements, so
+// files for different packages may be in the same directory.
+//
+// The proto_langs directive lists languages of rules generated for each
+// proto_library. cc_proto_library, java_proto_library, and py_proto_library
+// rules are generated here. go_proto_library rules are generated by the Go
+// extension.
+//
+// Dependency resolution
+//
+// proto_library rules are indexed by their srcs attribute. Gazelle attempts
+// to resolve proto imports using this index. Imports of Well Known Types are
+// resolved to rules in @com_google_protobuf. Imports that can't be resolved
+// are mapped to a guessed label in the directory of the imported file. In
+// file mode, the guessed label is named after the imported file.
+package proto
+
+import (
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const protoName = "proto"
+
+type protoLang struct{}
+
+// New returns a new instance of the proto language extension.
+func New() language.Language {
+	return &protoLang{}
+}
+
+func (pl *protoLang) Name() string { return protoName }
+
+var protoKinds = map[string]rule.KindInfo{
+	"proto_library": {
+		NonEmptyAttrs:  map[string]bool{"srcs": true, "deps": true},
+		MergeableAttrs: map[string]bool{"srcs": true},
+		ResolveAttrs:   map[string]bool{"deps": true, config.GazelleImportsKey: true},
+	},
+	"cc_proto_library": {
+		NonEmptyAttrs:  map[string]bool{"deps": true},
+		MergeableAttrs: map[string]bool{"deps": true},
+	},
+	"java_proto_library": {
+		NonEmptyAttrs:  map[string]bool{"deps": true},
+		MergeableAttrs: map[string]bool{"deps": true},
+	},
+	"py_proto_library": {
+		NonEmptyAttrs:  map[string]bool{"deps": true},
+		MergeableAttrs: map[string]bool{"deps": true},
+	},
+}
+
+// otherLangs lists languages other than Go that rules may be generated for
+// with the proto_langs directive. Go rules are generated by the Go extension.
+var otherLangs = []string{"cc", "java", "py"}
+
+// langKinds maps each language in otherLangs to the kind of rule generated
+// for it.
+var langKinds = map[string]string{
+	"cc":   "cc_proto_library",
+	"java": "java_proto_library",
+	"py":   "py_proto_library",
+}
+
+func (pl *protoLang) Kinds() map[string]rule.KindInfo { return protoKinds }
+
+// Loads returns the file py_proto_library is loaded from. The other kinds
+// are native rules.
+func (pl *protoLang) Loads() []rule.LoadInfo {
+	return []rule.LoadInfo{{
+		Name:    "@com_google_protobuf//bazel:py_proto_library.bzl",
+		Symbols: []string{"py_proto_library"},
+	}}
+}
+
+func (pl *protoLang) Fix(c *config.Config, f *rule.File) {}
@@ -0,0 +1,154 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/internal/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/repos"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Imports returns the .proto files in the srcs of a proto_library, relative
+// to the repository root. Every proto_library is indexed, even if it has
+// no sources, since it may be embedded. Other kinds are not indexed.
+func (pl *protoLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	if r.Kind() != "proto_library" {
+		return nil
+	}
+	srcs := r.AttrStrings("srcs")
+	imports := make([]resolve.ImportSpec, 0, len(srcs))
+	for _, src := range srcs {
+		l, err := label.Parse(src)
+		if err != nil || !l.Relative || !strings.HasSuffix(l.Name, ".proto") {
+			continue
+		}
+		imports = append(imports, resolve.ImportSpec{Lang: protoName, Imp: path.Join(f.Pkg, l.Name)})
+	}
+	return imports
+}
+
+func (pl *protoLang) Embeds(r *rule.Rule, from label.Label) []label.Label {
+	return nil
+}
+
+// Resolve replaces the proto imports in the "_gazelle_imports" attribute of
+// a proto_library with labels in a "deps" attribute. Any existing "deps"
+// attribute is deleted, so it may be necessary to merge the result.
+func (pl *protoLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repos.RemoteCache, r *rule.Rule, from label.Label) {
+	if r.Kind() != "proto_library" {
+		// Rules for other languages only depend on the proto_library in the
+		// same directory, which is set when they are generated.
+		return
+	}
+	imports := r.Attr(config.GazelleImportsKey)
+	r.DelAttr(config.GazelleImportsKey)
+	r.DelAttr("deps")
+	deps := rule.MapExprStrings(imports, func(imp string) string {
+		l, err := resolveProto(c, ix, imp, from)
+		if err == skipImportError {
+			return ""
+		} else if err != nil {
+			resolve.AddUnresolvedImport(r, imp, err)
+			return ""
+		}
+		l.Relative = l.Repo == "" && l.Pkg == from.Pkg
+		return l.String()
+	})
+	if deps != nil {
+		r.SetAttr("deps", deps)
+	}
+}
+
+var (
+	skipImportError = errors.New("self import")
+	notFoundError   = errors.New("not found")
+)
+
+// resolveProto resolves an import statement in a .proto file to a label
+// for a proto_library rule.
+func resolveProto(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+	if !strings.HasSuffix(imp, ".proto") {
+		return label.NoLabel, fmt.Errorf("can't import non-proto: %q", imp)
+	}
+	if l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Lang: protoName, Imp: imp}, protoName); ok {
+		return l, nil
+	}
+	if isWellKnownProto(imp) {
+		name := path.Base(imp[:len(imp)-len(".proto")]) + "_proto"
+		return label.New(config.WellKnownTypesProtoRepo, "", name), nil
+	}
+
+	if l, err := resolveWithIndex(ix, imp, from); err == nil || err == skipImportError {
+		return l, err
+	} else if err != notFoundError {
+		return label.NoLabel, err
+	}
+
+	if p, ok := FindImportPrefix(c, imp); ok {
+		return resolveImportPrefix(p, imp), nil
+	}
+
+	rel := path.Dir(imp)
+	if rel == "." {
+		rel = ""
+	}
+	name := pathtools.RelBaseName(rel, c.GoPrefix, c.RepoRoot)
+	if c.ProtoMode == config.FileProtoMode {
+		name = path.Base(imp[:len(imp)-len(".proto")])
+	}
+	return label.NewLabeler(c).ProtoLabel(rel, name), nil
+}
+
+// resolveImportPrefix returns the label of the proto_library that provides
+// imp in the package mapped by p, which was set with a
+// proto_import_prefix_map directive.
+func resolveImportPrefix(p ImportPrefix, imp string) label.Label {
+	pkg := p.Package(imp)
+	if p.Mode == config.FileProtoMode {
+		return label.New(p.Repo, pkg, path.Base(imp[:len(imp)-len(".proto")])+"_proto")
+	}
+	name := path.Base(pkg)
+	if pkg == "" {
+		name = p.Repo
+	}
+	return label.New(p.Repo, pkg, name+"_proto")
+}
+
+func resolveWithIndex(ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+	matches := ix.FindRulesByImport(resolve.ImportSpec{Lang: protoName, Imp: imp}, protoName)
+	if len(matches) == 0 {
+		return label.NoLabel, notFoundError
+	}
+	if len(matches) > 1 {
+		return label.NoLabel, fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", matches[0].Label, matches[1].Label, imp, from)
+	}
+	if matches[0].IsSelfImport(from) {
+		return label.NoLabel, skipImportError
+	}
+	return matches[0].Label, nil
+}
+
+func isWellKnownProto(imp string) bool {
+	return pathtools.HasPrefix(imp, config.WellKnownTypesProtoPrefix) && pathtools.TrimPrefix(imp, config.WellKnownTypesProtoPrefix) == path.Base(imp)
+}
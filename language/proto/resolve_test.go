@@ -0,0 +1,143 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestResolveProtoIndex(t *testing.T) {
+	c := &config.Config{GoPrefix: "example.com/repo"}
+
+	buildContent := []byte(`
+proto_library(
+    name = "foo_proto",
+    srcs = ["bar.proto"],
+)
+`)
+	f, err := rule.LoadData(filepath.Join("sub", "BUILD.bazel"), "sub", buildContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pl := New()
+	ix := resolve.NewRuleIndex(func(r *rule.Rule) resolve.Resolver {
+		if r.Kind() == "proto_library" {
+			return pl
+		}
+		return nil
+	})
+	ix.AddRulesFromFile(c, f)
+	ix.Finish()
+
+	want := label.New("", "sub", "foo_proto")
+	if got, err := resolveProto(c, ix, "sub/bar.proto", label.New("", "baz", "baz")); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveProto: got %s ; want %s", got, want)
+	}
+	_, err = resolveProto(c, ix, "sub/bar.proto", label.New("", "sub", "foo_proto"))
+	if err != skipImportError {
+		t.Errorf("resolveProto: got %v ; want skipImportError", err)
+	}
+}
+
+func TestResolveProto(t *testing.T) {
+	for _, tc := range []struct {
+		desc, imp string
+		mode      config.ProtoMode
+		prefixes  []ImportPrefix
+		from      label.Label
+		want      label.Label
+	}{
+		{
+			desc: "root",
+			imp:  "foo.proto",
+			want: label.New("", "", "repo_proto"),
+		}, {
+			desc: "sub",
+			imp:  "foo/bar/bar.proto",
+			want: label.New("", "foo/bar", "bar_proto"),
+		}, {
+			desc: "vendor",
+			imp:  "foo/bar/bar.proto",
+			from: label.New("", "vendor", ""),
+			want: label.New("", "foo/bar", "bar_proto"),
+		}, {
+			desc: "file_mode",
+			imp:  "foo/bar/baz.proto",
+			mode: config.FileProtoMode,
+			want: label.New("", "foo/bar", "baz_proto"),
+		}, {
+			desc: "import_prefix",
+			imp:  "google/api/expr/v1/syntax.proto",
+			prefixes: []ImportPrefix{
+				{Prefix: "google", Repo: "other", Pkg: "google"},
+				{Prefix: "google/api", Repo: "go_googleapis", Pkg: "google/api"},
+			},
+			want: label.New("go_googleapis", "google/api/expr/v1", "v1_proto"),
+		}, {
+			desc: "import_prefix_file_mode",
+			imp:  "google/api/annotations.proto",
+			prefixes: []ImportPrefix{
+				{Prefix: "google/api", Repo: "go_googleapis", Pkg: "google/api", Mode: config.FileProtoMode},
+			},
+			want: label.New("go_googleapis", "google/api", "annotations_proto"),
+		}, {
+			desc: "import_prefix_root",
+			imp:  "api.proto",
+			prefixes: []ImportPrefix{
+				{Repo: "com_example_api"},
+			},
+			want: label.New("com_example_api", "", "com_example_api_proto"),
+		}, {
+			desc: "well known",
+			imp:  "google/protobuf/any.proto",
+			want: label.New("com_google_protobuf", "", "any_proto"),
+		}, {
+			desc: "descriptor",
+			imp:  "google/protobuf/descriptor.proto",
+			want: label.New("com_google_protobuf", "", "descriptor_proto"),
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := &config.Config{
+				GoPrefix:  "example.com/repo",
+				ProtoMode: tc.mode,
+				Exts: map[string]interface{}{
+					protoName: &protoConfig{importPrefixes: tc.prefixes},
+				},
+			}
+			ix := resolve.NewRuleIndex(func(r *rule.Rule) resolve.Resolver { return nil })
+			ix.Finish()
+
+			got, err := resolveProto(c, ix, tc.imp, tc.from)
+			if err != nil {
+				t.Errorf("resolveProto: got error %v; want success", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("resolveProto: got %s; want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package language
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+const visibilityName = "visibility"
+
+// visibilityConfig holds the visibility settings for a directory. It is
+// stored in Config.Exts.
+type visibilityConfig struct {
+	// defaultVisibility is the visibility of generated rules that may be
+	// used by other packages. If it is empty, these rules are public.
+	defaultVisibility []string
+
+	// mode determines whether the visibility of generated rules is narrowed
+	// to the packages that depend on them.
+	mode VisibilityMode
+}
+
+func getVisibilityConfig(c *config.Config) *visibilityConfig {
+	vc, _ := c.Exts[visibilityName].(*visibilityConfig)
+	if vc == nil {
+		return &visibilityConfig{}
+	}
+	return vc
+}
+
+// DefaultVisibility returns the visibility language extensions should give
+// generated rules that may be used by other packages. If it is empty, these
+// rules should be public.
+func DefaultVisibility(c *config.Config) []string {
+	return getVisibilityConfig(c).defaultVisibility
+}
+
+// GetVisibilityMode returns the visibility mode for the directory c
+// applies to.
+func GetVisibilityMode(c *config.Config) VisibilityMode {
+	return getVisibilityConfig(c).mode
+}
+
+// VisibilityMode determines how the visibility of generated rules is chosen.
+type VisibilityMode int
+
+const (
+	// DefaultVisibilityMode sets the visibility of new rules to
+	// DefaultVisibility or public. The visibility of existing rules is not
+	// changed.
+	DefaultVisibilityMode VisibilityMode = iota
+
+	// MinimalVisibilityMode sets the visibility of indexed rules to the
+	// narrowest visibility that includes the packages with rules that depend
+	// on them. Rules no other package depends on are private. The visibility
+	// of existing rules is updated, too.
+	MinimalVisibilityMode
+)
+
+func VisibilityModeFromString(s string) (VisibilityMode, error) {
+	switch s {
+	case "default":
+		return DefaultVisibilityMode, nil
+	case "minimal":
+		return MinimalVisibilityMode, nil
+	default:
+		return 0, fmt.Errorf("unrecognized visibility mode: %q", s)
+	}
+}
+
+func (vm VisibilityMode) String() string {
+	switch vm {
+	case DefaultVisibilityMode:
+		return "default"
+	case MinimalVisibilityMode:
+		return "minimal"
+	default:
+		return fmt.Sprintf("VisibilityMode(%d)", int(vm))
+	}
+}
+
+// VisibilityConfigurer handles the default_visibility and visibility_mode
+// directives, which apply to rules generated by all languages.
+type VisibilityConfigurer struct{}
+
+func (*VisibilityConfigurer) KnownDirectives() []string {
+	return []string{"default_visibility", "visibility_mode"}
+}
+
+func (*VisibilityConfigurer) Configure(c *config.Config, rel string, f *bzl.File, directives []config.Directive) {
+	vc := *getVisibilityConfig(c)
+	for _, d := range directives {
+		switch d.Key {
+		case "default_visibility":
+			visibility, err := parseVisibility(d.Value)
+			if err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				continue
+			}
+			vc.defaultVisibility = visibility
+		case "visibility_mode":
+			vm, err := VisibilityModeFromString(d.Value)
+			if err != nil {
+				config.ReportInvalidDirective(c, f, d, err)
+				continue
+			}
+			vc.mode = vm
+		}
+	}
+	if c.Exts == nil {
+		c.Exts = make(map[string]interface{})
+	}
+	c.Exts[visibilityName] = &vc
+}
+
+// parseVisibility parses the value of a default_visibility directive, which
+// is a comma-separated list of labels. An empty value means generated rules
+// are public.
+func parseVisibility(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	visibility := strings.Split(value, ",")
+	for _, v := range visibility {
+		if !strings.HasPrefix(v, "//") && !strings.HasPrefix(v, "@") {
+			return nil, fmt.Errorf("%q is not an absolute label", v)
+		}
+	}
+	return visibility, nil
+}
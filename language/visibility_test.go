@@ -0,0 +1,57 @@
+/*
+	Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package language
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+func TestVisibilityConfigurer(t *testing.T) {
+	for _, tc := range []struct {
+		desc       string
+		directives []config.Directive
+		want       visibilityConfig
+	}{
+		{
+			desc: "default_visibility",
+			directives: []config.Directive{
+				{Key: "default_visibility", Value: "//foo:__subpackages__,//bar:__pkg__"},
+				{Key: "default_visibility", Value: "foo"},
+			},
+			want: visibilityConfig{defaultVisibility: []string{"//foo:__subpackages__", "//bar:__pkg__"}},
+		}, {
+			desc:       "visibility_mode",
+			directives: []config.Directive{{Key: "visibility_mode", Value: "minimal"}, {Key: "visibility_mode", Value: "narrow"}},
+			want:       visibilityConfig{mode: MinimalVisibilityMode},
+		}, {
+			desc:       "other",
+			directives: []config.Directive{{Key: "ignore", Value: ""}},
+			want:       visibilityConfig{},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := &config.Config{}
+			vc := &VisibilityConfigurer{}
+			vc.Configure(c, "", nil, tc.directives)
+			if got := getVisibilityConfig(c); !reflect.DeepEqual(*got, tc.want) {
+				t.Errorf("got %#v ; want %#v", *got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,558 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
+)
+
+// cacheVersion is written at the beginning of cache files. It should be
+// changed whenever the format of the cache or the information stored in
+// fileInfo changes.
+const cacheVersion = "gazelle-cache-7"
+
+// Cache stores information parsed from source files and a fingerprint of
+// each directory where build files were generated. It lets WalkWithCache
+// skip parsing files that haven't changed and skip generating rules in
+// directories that haven't changed since their build files were last
+// written.
+//
+// Files are identified by their size, modification time and a hash of their
+// content. Directories are identified by a hash of the cache's seed, the
+// directives in build files of parent directories, the directory's build
+// file, and the names and hashes of files in the directory.
+type Cache struct {
+	path string
+	seed string
+
+	mu sync.Mutex
+
+	// oldFiles and oldDirs are loaded from the cache file.
+	oldFiles map[string]cachedFile
+	oldDirs  map[string]cachedDir
+
+	// files and dirs are written to the cache file by Save. Entries are
+	// copied from oldFiles and oldDirs when they are used.
+	files map[string]cachedFile
+	dirs  map[string]cachedDir
+
+	// pendingDirs contains fingerprints of directories that were
+	// regenerated. They are moved to dirs by MarkUpToDate.
+	pendingDirs map[string]cachedDir
+
+	// skipped lists directories that were not updated during the last walk,
+	// in the order they were visited.
+	skipped []string
+}
+
+type cachedFile struct {
+	Size    int64
+	ModTime int64
+	Hash    string
+	Info    cachedFileInfo
+
+	// Diagnostics were reported when the file was parsed. They are reported
+	// again each time the entry is used, so problems in a file are not
+	// hidden by the cache.
+	Diagnostics []cachedDiagnostic
+}
+
+// cachedFileInfo contains the parts of fileInfo that are read from a
+// file's content. Other fields are derived from the file name.
+type cachedFileInfo struct {
+	PackageName, ImportPath string
+	ProtoPackageName        string
+	IsCgo, HasServices      bool
+	HasTests                bool
+	Imports                 []string
+	Tags                    [][][]string
+	Copts, Clinkopts        []cachedOpts
+	ProtoOptions            []cachedProtoOption
+	ProtoImports            []cachedProtoImport
+	ProtoMessages           []string
+	ProtoServices           []string
+}
+
+type cachedOpts struct {
+	Tags [][]string
+	Opts string
+}
+
+type cachedProtoOption struct {
+	Name, Value string
+}
+
+type cachedProtoImport struct {
+	Path         string
+	Public, Weak bool
+	Line, Col    int
+}
+
+// cachedDiagnostic is a diagnostic reported while parsing a file. Its
+// position is always in the file itself, so the path is not stored.
+type cachedDiagnostic struct {
+	Code         diag.Code
+	Severity     diag.Severity
+	Line, Column int
+	Message      string
+}
+
+type cachedDir struct {
+	// Context is a hash of everything that affects the directory's rules
+	// except its own build file and whether it has a testdata directory.
+	Context string
+
+	// BuildFile is a hash of the build file's content, or "" if there was no
+	// build file.
+	BuildFile string
+
+	HasTestdata bool
+
+	// Imports lists the strings imported by rules generated in the
+	// directory. Resolved identifies the rules they resolved to. Both are set
+	// by MarkUpToDate. A directory is only skipped if nothing in it changed,
+	// but the caller should also check its imports still resolve the same way.
+	Imports  []string
+	Resolved string
+}
+
+type cacheData struct {
+	Version string
+	Seed    string
+	Files   map[string]cachedFile
+	Dirs    map[string]cachedDir
+}
+
+// LoadCache reads a cache file. If the file does not exist, is corrupt, or
+// was written with a different seed, an empty cache is returned. seed should
+// identify everything other than build files and sources that affects
+// generated rules, for example, command line flags. If path is empty, the
+// cache is only kept in memory.
+func LoadCache(path, seed string) (*Cache, error) {
+	cache := &Cache{
+		path:        path,
+		seed:        seed,
+		oldFiles:    make(map[string]cachedFile),
+		oldDirs:     make(map[string]cachedDir),
+		files:       make(map[string]cachedFile),
+		dirs:        make(map[string]cachedDir),
+		pendingDirs: make(map[string]cachedDir),
+	}
+	if path == "" {
+		return cache, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cd cacheData
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cd); err != nil || cd.Version != cacheVersion {
+		// Ignore caches written by other versions of Gazelle.
+		return cache, nil
+	}
+	if cd.Files != nil {
+		cache.oldFiles = cd.Files
+	}
+	if cd.Dirs != nil && cd.Seed == seed {
+		cache.oldDirs = cd.Dirs
+	}
+	return cache, nil
+}
+
+// Save writes the cache to disk. Only files and directories seen since the
+// cache was loaded or last saved are written. The cache may be used for
+// another walk after it is saved.
+func (cache *Cache) Save() error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	files, dirs := cache.files, cache.dirs
+	cache.oldFiles, cache.oldDirs = files, dirs
+	cache.files = make(map[string]cachedFile)
+	cache.dirs = make(map[string]cachedDir)
+	cache.pendingDirs = make(map[string]cachedDir)
+	if cache.path == "" {
+		return nil
+	}
+
+	cd := cacheData{
+		Version: cacheVersion,
+		Seed:    cache.seed,
+		Files:   files,
+		Dirs:    dirs,
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cd); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cache.path), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cache.path, buf.Bytes(), 0666)
+}
+
+// MarkUpToDate records that the build file in the directory rel (relative
+// to the repository root) was regenerated, and its content is now content.
+// content should be nil if there is no build file. imports lists strings
+// imported by the generated rules, and resolved identifies the rules they
+// resolved to; see SkippedImports. Directories that are not marked are
+// regenerated the next time the cache is used.
+func (cache *Cache) MarkUpToDate(rel string, content []byte, imports []string, resolved string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	d, ok := cache.pendingDirs[rel]
+	if !ok {
+		return
+	}
+	delete(cache.pendingDirs, rel)
+	d.BuildFile = hashBytes(content)
+	d.Imports = imports
+	d.Resolved = resolved
+	cache.dirs[rel] = d
+}
+
+// SkippedDirs returns the directories that were not updated during the last
+// walk because nothing in them changed.
+func (cache *Cache) SkippedDirs() []string {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return append([]string(nil), cache.skipped...)
+}
+
+// SkippedImports returns the imports and resolution recorded by
+// MarkUpToDate for a directory that was skipped. If the imports would now
+// resolve to different rules, the caller should call Invalidate and update
+// the directory again.
+func (cache *Cache) SkippedImports(rel string) (imports []string, resolved string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	d := cache.dirs[rel]
+	return d.Imports, d.Resolved
+}
+
+// Invalidate forgets the fingerprint of the directory rel, so it is updated
+// the next time the cache is used, even if nothing in it has changed.
+// Information about files is kept.
+func (cache *Cache) Invalidate(rel string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	delete(cache.oldDirs, rel)
+	delete(cache.dirs, rel)
+}
+
+// InvalidateAll forgets the fingerprints of all directories, so every
+// directory is updated the next time the cache is used. Information about
+// files is kept.
+func (cache *Cache) InvalidateAll() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.oldDirs = make(map[string]cachedDir)
+	cache.dirs = make(map[string]cachedDir)
+}
+
+// fileInfo returns information about a file from the cache if the file has
+// not changed. Otherwise, it calls parse and stores the result. The file's
+// content hash is also returned. If cache is nil, parse is called directly,
+// and the hash is empty.
+//
+// Diagnostics reported by parse are stored with the result and reported to
+// c again when the stored result is used.
+func (cache *Cache) fileInfo(c *config.Config, dir, rel, name string, parse func(c *config.Config) fileInfo) (fileInfo, string) {
+	if cache == nil {
+		return parse(c), ""
+	}
+	key := path.Join(rel, name)
+	filePath := filepath.Join(dir, name)
+	st, err := os.Stat(filePath)
+	if err != nil {
+		return parse(c), ""
+	}
+
+	cache.mu.Lock()
+	cf, ok := cache.files[key]
+	if !ok {
+		cf, ok = cache.oldFiles[key]
+	}
+	cache.mu.Unlock()
+	if ok && cf.Size == st.Size() && cf.ModTime == st.ModTime().UnixNano() {
+		cache.storeFile(key, cf)
+		reportCachedDiagnostics(c, filePath, cf.Diagnostics)
+		return cf.Info.toFileInfo(dir, rel, name), cf.Hash
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return parse(c), ""
+	}
+	hash := hashBytes(content)
+	if ok && cf.Hash == hash {
+		cf.Size, cf.ModTime = st.Size(), st.ModTime().UnixNano()
+		cache.storeFile(key, cf)
+		reportCachedDiagnostics(c, filePath, cf.Diagnostics)
+		return cf.Info.toFileInfo(dir, rel, name), hash
+	}
+
+	// Collect diagnostics from parse separately, so they can be stored.
+	pc := *c
+	pc.Diagnostics = diag.NewCollector("")
+	info := parse(&pc)
+	diags := pc.Diagnostics.Diagnostics()
+	for _, d := range diags {
+		c.Diagnostics.Report(d)
+	}
+	cache.storeFile(key, cachedFile{
+		Size:        st.Size(),
+		ModTime:     st.ModTime().UnixNano(),
+		Hash:        hash,
+		Info:        newCachedFileInfo(info),
+		Diagnostics: newCachedDiagnostics(diags),
+	})
+	return info, hash
+}
+
+func (cache *Cache) storeFile(key string, cf cachedFile) {
+	cache.mu.Lock()
+	cache.files[key] = cf
+	cache.mu.Unlock()
+}
+
+// checkDir compares the fingerprint of a directory with the fingerprint
+// stored in the cache. If they match, the stored entry is kept, and true is
+// returned. Otherwise, the fingerprint is recorded so it may be stored
+// later with MarkUpToDate.
+func (cache *Cache) checkDir(rel string, d cachedDir) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if old, ok := cache.oldDirs[rel]; ok && old.Context == d.Context && old.BuildFile == d.BuildFile && old.HasTestdata == d.HasTestdata {
+		cache.dirs[rel] = old
+		cache.skipped = append(cache.skipped, rel)
+		return true
+	}
+	cache.pendingDirs[rel] = d
+	return false
+}
+
+// dirContext computes the Context of a directory's fingerprint.
+// directivesHash is the hash of directives in the directory and its parents
+// returned by hashDirectives. entries lists the directory's contents, and
+// hashes contains the content hashes of files that were read.
+func dirContext(seed, directivesHash string, entries []walkEntry, hashes map[string]string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q\n", seed, directivesHash)
+	for _, e := range entries {
+		fmt.Fprintf(h, "%q %d %q\n", e.name, e.kind, hashes[e.name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashDirectives hashes the directives in a build file together with the
+// hash of directives in parent directories. Directives are inherited, so
+// a change in a parent directory affects all subdirectories.
+func hashDirectives(parent string, directives []config.Directive) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\n", parent)
+	for _, d := range directives {
+		fmt.Fprintf(h, "%q %q\n", d.Key, d.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashBytes(data []byte) string {
+	if data == nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func newCachedDiagnostics(diags []diag.Diagnostic) []cachedDiagnostic {
+	if diags == nil {
+		return nil
+	}
+	cds := make([]cachedDiagnostic, len(diags))
+	for i, d := range diags {
+		cds[i] = cachedDiagnostic{
+			Code:     d.Code,
+			Severity: d.Severity,
+			Line:     d.Pos.Line,
+			Column:   d.Pos.Column,
+			Message:  d.Message,
+		}
+	}
+	return cds
+}
+
+func reportCachedDiagnostics(c *config.Config, filePath string, cds []cachedDiagnostic) {
+	for _, cd := range cds {
+		c.Diagnostics.Report(diag.Diagnostic{
+			Code:     cd.Code,
+			Severity: cd.Severity,
+			Pos:      diag.Position{Path: filePath, Line: cd.Line, Column: cd.Column},
+			Message:  cd.Message,
+		})
+	}
+}
+
+func newCachedFileInfo(info fileInfo) cachedFileInfo {
+	return cachedFileInfo{
+		PackageName:      info.packageName,
+		ImportPath:       info.importPath,
+		ProtoPackageName: info.protoPackageName,
+		IsCgo:            info.isCgo,
+		HasServices:      info.hasServices,
+		HasTests:         info.hasTests,
+		Imports:          info.imports,
+		Tags:             tagLinesToStrings(info.tags),
+		Copts:            newCachedOpts(info.copts),
+		Clinkopts:        newCachedOpts(info.clinkopts),
+		ProtoOptions:     newCachedProtoOptions(info.protoOptions),
+		ProtoImports:     newCachedProtoImports(info.protoImports),
+		ProtoMessages:    info.protoMessages,
+		ProtoServices:    info.protoServices,
+	}
+}
+
+func (ci cachedFileInfo) toFileInfo(dir, rel, name string) fileInfo {
+	info := fileNameInfo(dir, rel, name)
+	info.packageName = ci.PackageName
+	info.importPath = ci.ImportPath
+	info.protoPackageName = ci.ProtoPackageName
+	info.isCgo = ci.IsCgo
+	info.hasServices = ci.HasServices
+	info.hasTests = ci.HasTests
+	info.imports = ci.Imports
+	info.tags = stringsToTagLines(ci.Tags)
+	info.copts = toTaggedOpts(ci.Copts)
+	info.clinkopts = toTaggedOpts(ci.Clinkopts)
+	info.protoOptions = toProtoOptions(ci.ProtoOptions)
+	info.protoImports = toProtoImports(ci.ProtoImports)
+	info.protoMessages = ci.ProtoMessages
+	info.protoServices = ci.ProtoServices
+	return info
+}
+
+func newCachedOpts(opts []taggedOpts) []cachedOpts {
+	if opts == nil {
+		return nil
+	}
+	cos := make([]cachedOpts, len(opts))
+	for i, o := range opts {
+		cos[i] = cachedOpts{Tags: tagLinesToStrings([]tagLine{o.tags})[0], Opts: o.opts}
+	}
+	return cos
+}
+
+func toTaggedOpts(cos []cachedOpts) []taggedOpts {
+	if cos == nil {
+		return nil
+	}
+	opts := make([]taggedOpts, len(cos))
+	for i, co := range cos {
+		opts[i] = taggedOpts{tags: stringsToTagLines([][][]string{co.Tags})[0], opts: co.Opts}
+	}
+	return opts
+}
+
+func newCachedProtoOptions(opts []protoOption) []cachedProtoOption {
+	if opts == nil {
+		return nil
+	}
+	cos := make([]cachedProtoOption, len(opts))
+	for i, o := range opts {
+		cos[i] = cachedProtoOption{Name: o.name, Value: o.value}
+	}
+	return cos
+}
+
+func toProtoOptions(cos []cachedProtoOption) []protoOption {
+	if cos == nil {
+		return nil
+	}
+	opts := make([]protoOption, len(cos))
+	for i, co := range cos {
+		opts[i] = protoOption{name: co.Name, value: co.Value}
+	}
+	return opts
+}
+
+func newCachedProtoImports(imps []protoImport) []cachedProtoImport {
+	if imps == nil {
+		return nil
+	}
+	cis := make([]cachedProtoImport, len(imps))
+	for i, imp := range imps {
+		cis[i] = cachedProtoImport{Path: imp.path, Public: imp.public, Weak: imp.weak, Line: imp.pos.line, Col: imp.pos.col}
+	}
+	return cis
+}
+
+func toProtoImports(cis []cachedProtoImport) []protoImport {
+	if cis == nil {
+		return nil
+	}
+	imps := make([]protoImport, len(cis))
+	for i, ci := range cis {
+		imps[i] = protoImport{path: ci.Path, public: ci.Public, weak: ci.Weak, pos: protoPos{line: ci.Line, col: ci.Col}}
+	}
+	return imps
+}
+
+func tagLinesToStrings(lines []tagLine) [][][]string {
+	if lines == nil {
+		return nil
+	}
+	ss := make([][][]string, len(lines))
+	for i, l := range lines {
+		if l == nil {
+			continue
+		}
+		ss[i] = make([][]string, len(l))
+		for j, g := range l {
+			ss[i][j] = []string(g)
+		}
+	}
+	return ss
+}
+
+func stringsToTagLines(ss [][][]string) []tagLine {
+	if ss == nil {
+		return nil
+	}
+	lines := make([]tagLine, len(ss))
+	for i, s := range ss {
+		if s == nil {
+			continue
+		}
+		lines[i] = make(tagLine, len(s))
+		for j, g := range s {
+			lines[i][j] = tagGroup(g)
+		}
+	}
+	return lines
+}
@@ -17,13 +17,13 @@ package packages
 
 import (
 	"bufio"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
 )
 
 // fileInfo holds information used to decide how to build a file. This
@@ -49,6 +49,11 @@ type fileInfo struct {
 	// ends with "_test.go". This is never true for non-Go files.
 	isTest bool
 
+	// hasTests is true for test files that declare Test, Benchmark or
+	// Example functions run by "go test". TestMain is not counted, so files
+	// with only helpers or TestMain are false.
+	hasTests bool
+
 	// imports is a list of packages imported by a file. It does not include
 	// "C" or anything from the standard library.
 	imports []string
@@ -70,6 +75,24 @@ type fileInfo struct {
 
 	// hasServices indicates whether a .proto file has service definitions.
 	hasServices bool
+
+	// protoPackageName is the name in the package statement of a .proto
+	// file, for example, "foo.bar". It is empty for other files and for
+	// .proto files without a package statement.
+	protoPackageName string
+
+	// protoOptions contains the file-level options declared in a .proto
+	// file, in the order they were declared.
+	protoOptions []protoOption
+
+	// protoImports describes the import statements in a .proto file, in the
+	// order they were declared. imports contains the same paths, sorted.
+	protoImports []protoImport
+
+	// protoMessages and protoServices contain the names of messages and
+	// services declared in a .proto file. Nested messages are qualified with
+	// the names of messages that contain them, for example, "Outer.Inner".
+	protoMessages, protoServices []string
 }
 
 // tagLine represents the space-separated disjunction of build tag groups
@@ -244,20 +267,20 @@ func fileNameInfo(dir, rel, name string) fileInfo {
 
 // otherFileInfo returns information about a non-.go file. It will parse
 // part of the file to determine build tags. If the file can't be read, an
-// error will be logged, and partial information will be returned.
-func otherFileInfo(dir, rel, name string) fileInfo {
+// error will be reported, and partial information will be returned.
+func otherFileInfo(c *config.Config, dir, rel, name string) fileInfo {
 	info := fileNameInfo(dir, rel, name)
 	if info.category == ignoredExt {
 		return info
 	}
 	if info.category == unsupportedExt {
-		log.Printf("%s: warning: file extension not yet supported", info.path)
+		c.Diagnostics.Warnf(diag.UnsupportedSource, diag.Position{Path: info.path}, "file extension not yet supported")
 		return info
 	}
 
 	tags, err := readTags(info.path)
 	if err != nil {
-		log.Printf("%s: error reading file: %v", info.path, err)
+		c.Diagnostics.Errorf(diag.SourceError, diag.Position{Path: info.path}, "error reading file: %v", err)
 		return info
 	}
 	info.tags = tags
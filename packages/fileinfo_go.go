@@ -21,29 +21,36 @@ import (
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
-	"log"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
 )
 
 // goFileInfo returns information about a .go file. It will parse part of the
 // file to determine the package name, imports, and build constraints.
-// If the file can't be read, an error will be logged, and partial information
-// will be returned.
+// If the file can't be read, an error will be reported, and partial
+// information will be returned.
 // This function is intended to match go/build.Context.Import.
 // TODD(#53): extract canonical import path
 func goFileInfo(c *config.Config, dir, rel, name string) fileInfo {
 	info := fileNameInfo(dir, rel, name)
 	fset := token.NewFileSet()
-	pf, err := parser.ParseFile(fset, info.path, nil, parser.ImportsOnly|parser.ParseComments)
+	// Test files are parsed completely, since their function declarations
+	// tell whether they contain tests.
+	mode := parser.ImportsOnly | parser.ParseComments
+	if info.isTest {
+		mode = parser.ParseComments
+	}
+	pf, err := parser.ParseFile(fset, info.path, nil, mode)
 	if err != nil {
-		log.Printf("%s: error reading go file: %v", info.path, err)
+		reportGoError(c, info.path, err)
 		return info
 	}
 
@@ -53,6 +60,10 @@ func goFileInfo(c *config.Config, dir, rel, name string) fileInfo {
 	}
 
 	for _, decl := range pf.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok {
+			info.hasTests = info.hasTests || info.isTest && isTestFunc(fd)
+			continue
+		}
 		d, ok := decl.(*ast.GenDecl)
 		if !ok {
 			continue
@@ -65,14 +76,11 @@ func goFileInfo(c *config.Config, dir, rel, name string) fileInfo {
 			quoted := spec.Path.Value
 			path, err := strconv.Unquote(quoted)
 			if err != nil {
-				log.Printf("%s: error reading go file: %v", info.path, err)
+				c.Diagnostics.Errorf(diag.SourceError, goPosition(fset, spec.Path.Pos()), "error reading go file: %v", err)
 				continue
 			}
 
 			if path == "C" {
-				if info.isTest {
-					log.Printf("%s: warning: use of cgo in test not supported", info.path)
-				}
 				info.isCgo = true
 				cg := spec.Doc
 				if cg == nil && len(d.Specs) == 1 {
@@ -80,7 +88,7 @@ func goFileInfo(c *config.Config, dir, rel, name string) fileInfo {
 				}
 				if cg != nil {
 					if err := saveCgo(&info, cg); err != nil {
-						log.Printf("%s: error reading go file: %v", info.path, err)
+						c.Diagnostics.Errorf(diag.SourceError, goPosition(fset, cg.Pos()), "error reading go file: %v", err)
 					}
 				}
 				continue
@@ -91,7 +99,7 @@ func goFileInfo(c *config.Config, dir, rel, name string) fileInfo {
 
 	tags, err := readTags(info.path)
 	if err != nil {
-		log.Printf("%s: error reading go file: %v", info.path, err)
+		c.Diagnostics.Errorf(diag.SourceError, diag.Position{Path: info.path}, "error reading go file: %v", err)
 		return info
 	}
 	info.tags = tags
@@ -99,6 +107,43 @@ func goFileInfo(c *config.Config, dir, rel, name string) fileInfo {
 	return info
 }
 
+// isTestFunc returns whether fd declares a function run by "go test": a
+// top-level Test, Benchmark or Example function. The name must not continue
+// with a lower-case letter after the prefix, as in go/build and "go test".
+func isTestFunc(fd *ast.FuncDecl) bool {
+	if fd.Recv != nil || fd.Name.Name == "TestMain" {
+		return false
+	}
+	for _, prefix := range []string{"Test", "Benchmark", "Example"} {
+		if !strings.HasPrefix(fd.Name.Name, prefix) {
+			continue
+		}
+		rest := fd.Name.Name[len(prefix):]
+		if rest == "" {
+			return true
+		}
+		r, _ := utf8.DecodeRuneInString(rest)
+		return !unicode.IsLower(r)
+	}
+	return false
+}
+
+// reportGoError reports an error returned by the Go parser. The position of
+// the first syntax error is used if there is one.
+func reportGoError(c *config.Config, path string, err error) {
+	if errs, ok := err.(scanner.ErrorList); ok && len(errs) > 0 {
+		pos := diag.Position{Path: errs[0].Pos.Filename, Line: errs[0].Pos.Line, Column: errs[0].Pos.Column}
+		c.Diagnostics.Errorf(diag.SourceError, pos, "error reading go file: %s", errs[0].Msg)
+		return
+	}
+	c.Diagnostics.Errorf(diag.SourceError, diag.Position{Path: path}, "error reading go file: %v", err)
+}
+
+func goPosition(fset *token.FileSet, p token.Pos) diag.Position {
+	pos := fset.Position(p)
+	return diag.Position{Path: pos.Filename, Line: pos.Line, Column: pos.Column}
+}
+
 // saveCgo extracts CFLAGS, CPPFLAGS, CXXFLAGS, and LDFLAGS directives
 // from a comment above a "C" import. This is intended to match logic in
 // go/build.Context.saveCgo.
@@ -23,8 +23,8 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
-	"github.com/bazelbuild/bazel-gazelle/internal/rule"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
 func TestGoFileInfo(t *testing.T) {
@@ -52,6 +52,47 @@ func TestGoFileInfo(t *testing.T) {
 				isTest:      true,
 			},
 		},
+		{
+			"test functions",
+			"foo_test.go",
+			`package foo
+
+import "testing"
+
+func TestMain(m *testing.M) {}
+
+func Testable() {}
+
+func ExampleFoo() {}
+`,
+			fileInfo{
+				packageName: "foo",
+				isTest:      true,
+				hasTests:    true,
+				imports:     []string{"testing"},
+			},
+		},
+		{
+			"test helpers",
+			"foo_test.go",
+			`package foo
+
+import "testing"
+
+func TestMain(m *testing.M) {}
+
+func Testable() {}
+
+type T struct{}
+
+func (T) TestFoo(t *testing.T) {}
+`,
+			fileInfo{
+				packageName: "foo",
+				isTest:      true,
+				imports:     []string{"testing"},
+			},
+		},
 		{
 			"xtest suffix on non-test",
 			"foo_xtest.go",
@@ -154,6 +195,7 @@ package route
 		got = fileInfo{
 			packageName: got.packageName,
 			isTest:      got.isTest,
+			hasTests:    got.hasTests,
 			imports:     got.imports,
 			isCgo:       got.isCgo,
 			tags:        got.tags,
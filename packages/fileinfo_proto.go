@@ -0,0 +1,97 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"errors"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
+)
+
+// protoFileInfo returns information about a .proto file. The file is
+// parsed to find its package, imports, options, and services. If the file
+// can't be read or has a syntax error, an error will be reported, and
+// partial information will be returned.
+func protoFileInfo(c *config.Config, dir, rel, name string) fileInfo {
+	info := fileNameInfo(dir, rel, name)
+	content, err := ioutil.ReadFile(info.path)
+	if err != nil {
+		c.Diagnostics.Errorf(diag.SourceError, diag.Position{Path: info.path}, "error reading proto file: %v", err)
+		return info
+	}
+
+	pf, err := parseProto(content)
+	if err != nil {
+		pos := diag.Position{Path: info.path}
+		if perr, ok := err.(*protoParseError); ok {
+			pos.Line, pos.Column = perr.pos.line, perr.pos.col
+			err = errors.New(perr.msg)
+		}
+		c.Diagnostics.Errorf(diag.SourceError, pos, "error reading proto file: %v", err)
+	}
+
+	info.protoImports = pf.imports
+	for _, imp := range pf.imports {
+		info.imports = append(info.imports, imp.path)
+	}
+	sort.Strings(info.imports)
+
+	if pf.pkg != "" {
+		info.protoPackageName = pf.pkg
+		info.packageName = strings.Replace(pf.pkg, ".", "_", -1)
+	}
+
+	info.protoOptions = pf.options
+	for _, opt := range pf.options {
+		if opt.name != "go_package" {
+			continue
+		}
+		gopkg := opt.value
+		// If there's no / in the package option, then it's just a
+		// simple package name, not a full import path.
+		if strings.LastIndexByte(gopkg, '/') == -1 {
+			info.packageName = gopkg
+		} else {
+			if i := strings.LastIndexByte(gopkg, ';'); i != -1 {
+				info.importPath = gopkg[:i]
+				info.packageName = gopkg[i+1:]
+			} else {
+				info.importPath = gopkg
+				info.packageName = path.Base(gopkg)
+			}
+		}
+	}
+
+	info.protoMessages = pf.messages
+	info.protoServices = pf.services
+	info.hasServices = len(pf.services) > 0
+
+	if info.packageName == "" {
+		stem := strings.TrimSuffix(name, ".proto")
+		fs := strings.FieldsFunc(stem, func(r rune) bool {
+			return !(unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_')
+		})
+		info.packageName = strings.Join(fs, "_")
+	}
+
+	return info
+}
@@ -18,30 +18,14 @@ package packages
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
 )
 
-func TestProtoRegexpGroupNames(t *testing.T) {
-	names := protoRe.SubexpNames()
-	nameMap := map[string]int{
-		"import":     importSubexpIndex,
-		"package":    packageSubexpIndex,
-		"go_package": goPackageSubexpIndex,
-		"service":    serviceSubexpIndex,
-	}
-	for name, index := range nameMap {
-		if names[index] != name {
-			t.Errorf("proto regexp subexp %d is %s ; want %s", index, names[index], name)
-		}
-	}
-	if len(names)-1 != len(nameMap) {
-		t.Errorf("proto regexp has %d groups ; want %d", len(names), len(nameMap))
-	}
-}
-
 func TestProtoFileInfo(t *testing.T) {
 	c := &config.Config{}
 	dir := "."
@@ -62,14 +46,16 @@ func TestProtoFileInfo(t *testing.T) {
 			name:  "package.proto",
 			proto: "package foo;",
 			want: fileInfo{
-				packageName: "foo",
+				packageName:      "foo",
+				protoPackageName: "foo",
 			},
 		}, {
 			desc:  "full package",
 			name:  "full.proto",
 			proto: "package foo.bar.baz;",
 			want: fileInfo{
-				packageName: "foo_bar_baz",
+				packageName:      "foo_bar_baz",
+				protoPackageName: "foo.bar.baz",
 			},
 		}, {
 			desc: "import simple",
@@ -113,6 +99,9 @@ import "second.proto";`,
 			want: fileInfo{
 				packageName: "projectpb",
 				importPath:  "github.com/example/project",
+				protoOptions: []protoOption{
+					{name: "go_package", value: "github.com/example/project;projectpb"},
+				},
 			},
 		}, {
 			desc:  "go_package_simple",
@@ -121,6 +110,9 @@ import "second.proto";`,
 			want: fileInfo{
 				packageName: "bar",
 				importPath:  "",
+				protoOptions: []protoOption{
+					{name: "go_package", value: "bar"},
+				},
 			},
 		}, {
 			desc:  "service",
@@ -130,6 +122,51 @@ import "second.proto";`,
 				packageName: "service",
 				hasServices: true,
 			},
+		}, {
+			desc: "service_in_comment",
+			name: "comment.proto",
+			proto: `// service Foo {}
+/* import "foo.proto";
+   package foo; */
+message Service { string service = 1; }`,
+			want: fileInfo{
+				packageName: "comment",
+			},
+		}, {
+			desc: "import_public_weak",
+			name: "public.proto",
+			proto: `import public "a.proto";
+import weak "b.proto";`,
+			want: fileInfo{
+				packageName: "public",
+				imports:     []string{"a.proto", "b.proto"},
+			},
+		}, {
+			desc: "options",
+			name: "options.proto",
+			proto: `option (my.opt) = { a: { b: 1 } c: "}" };
+option java_package = "com.example";
+option go_package = "example.com/foo";`,
+			want: fileInfo{
+				packageName: "foo",
+				importPath:  "example.com/foo",
+				protoOptions: []protoOption{
+					{name: "(my.opt)", value: `{ a: { b: 1 } c: "}" }`},
+					{name: "java_package", value: "com.example"},
+					{name: "go_package", value: "example.com/foo"},
+				},
+			},
+		}, {
+			desc: "syntax_error",
+			name: "error.proto",
+			proto: `package foo;
+import "a.proto";
+import`,
+			want: fileInfo{
+				packageName:      "foo",
+				protoPackageName: "foo",
+				imports:          []string{"a.proto"},
+			},
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
@@ -142,10 +179,12 @@ import "second.proto";`,
 
 			// Clear fields we don't care about for testing.
 			got = fileInfo{
-				packageName: got.packageName,
-				imports:     got.imports,
-				importPath:  got.importPath,
-				hasServices: got.hasServices,
+				packageName:      got.packageName,
+				imports:          got.imports,
+				importPath:       got.importPath,
+				hasServices:      got.hasServices,
+				protoPackageName: got.protoPackageName,
+				protoOptions:     got.protoOptions,
 			}
 			if !reflect.DeepEqual(got, tc.want) {
 				t.Errorf("got %#v; want %#v", got, tc.want)
@@ -153,3 +192,27 @@ import "second.proto";`,
 		})
 	}
 }
+
+func TestProtoFileInfoError(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "TestProtoFileInfoError")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := `syntax = "proto3";
+
+message Foo {
+  string bar = 1;
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo.proto"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &config.Config{Diagnostics: diag.NewCollector(dir)}
+	protoFileInfo(c, dir, "", "foo.proto")
+	diags := c.Diagnostics.Diagnostics()
+	wantPos := diag.Position{Path: "foo.proto", Line: 3, Column: 13}
+	if len(diags) != 1 || diags[0].Code != diag.SourceError || diags[0].Pos != wantPos {
+		t.Errorf("got diagnostics %v; want one source error at %v", diags, wantPos)
+	}
+}
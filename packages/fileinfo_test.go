@@ -22,7 +22,7 @@ import (
 	"reflect"
 	"testing"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
+	"github.com/bazelbuild/bazel-gazelle/config"
 )
 
 func TestOtherFileInfo(t *testing.T) {
@@ -54,7 +54,7 @@ func TestOtherFileInfo(t *testing.T) {
 			}
 			defer os.Remove(tc.name)
 
-			got := otherFileInfo(dir, rel, tc.name)
+			got := otherFileInfo(&config.Config{}, dir, rel, tc.name)
 
 			// Only check that we can extract tags. Everything else is covered
 			// by other tests.
@@ -22,9 +22,10 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
 	"github.com/bazelbuild/bazel-gazelle/internal/pathtools"
-	"github.com/bazelbuild/bazel-gazelle/internal/rule"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
 // Package contains metadata about a Go package extracted from a directory.
@@ -49,6 +50,12 @@ type Package struct {
 	Library, Binary, Test GoTarget
 	Proto                 ProtoTarget
 
+	// TestFiles contains a target for each test file with Test, Benchmark
+	// or Example functions, keyed by file name. Each target also includes
+	// the test files without such functions, like helpers and the file with
+	// TestMain. It is used when go_test rules are generated for each file.
+	TestFiles map[string]GoTarget
+
 	HasTestdata bool
 }
 
@@ -67,6 +74,57 @@ type ProtoTarget struct {
 	// HasPbGo indicates whether unexcluded .pb.go files are present in the
 	// same package. They will not be in this target's sources.
 	HasPbGo bool
+
+	// Files describes each .proto file in the target, sorted by name. It is
+	// only set in proto modes that group files by file or by proto package.
+	Files []ProtoFile
+}
+
+// ProtoFile contains metadata about a single .proto file.
+type ProtoFile struct {
+	// Name is the base name of the file.
+	Name string
+
+	// PackageName is the name in the file's package statement, for example,
+	// "foo.bar". It is empty if there is no package statement.
+	PackageName string
+
+	// GoImportPath and GoPackageName are read from the go_package option.
+	// Both are empty if the option is not set or does not include an import
+	// path.
+	GoImportPath, GoPackageName string
+
+	// Imports lists the .proto files imported by this file, sorted.
+	Imports []string
+
+	// ImportStatements describes each import statement in the file, in the
+	// order they were declared.
+	ImportStatements []ProtoImport
+
+	// Options maps the names of file-level options to their values, for
+	// example, "java_package" to "com.example.foo". Gazelle only reads
+	// go_package itself; other options are provided for language extensions.
+	Options map[string]string
+
+	// Messages and Services contain the names of messages and services
+	// declared in the file. Nested messages are qualified with the names of
+	// messages that contain them, for example, "Outer.Inner".
+	Messages, Services []string
+
+	HasServices bool
+}
+
+// ProtoImport describes an import statement in a .proto file.
+type ProtoImport struct {
+	// Path is the imported file, for example, "google/protobuf/any.proto".
+	Path string
+
+	// Public and Weak are true for "import public" and "import weak"
+	// statements.
+	Public, Weak bool
+
+	// Line and Column are the 1-based position of the import statement.
+	Line, Column int
 }
 
 // IsCommand returns true if the package name is "main".
@@ -99,6 +157,8 @@ func (t *ProtoTarget) HasProto() bool {
 type packageBuilder struct {
 	name, dir, rel             string
 	library, binary, test      goTargetBuilder
+	testFiles                  map[string]*goTargetBuilder
+	sharedTestFiles            []fileInfo
 	proto                      protoTargetBuilder
 	hasTestdata                bool
 	importPath, importPathFile string
@@ -112,6 +172,7 @@ type goTargetBuilder struct {
 type protoTargetBuilder struct {
 	sources, imports     platformStringsBuilder
 	hasServices, hasPbGo bool
+	files                []ProtoFile
 }
 
 type platformStringsBuilder struct {
@@ -140,20 +201,41 @@ const (
 // "cgo" tells whether any ".go" file in the package contains cgo code. This
 // affects whether C files are added to targets.
 //
-// An error is returned if a file is buildable but invalid (for example, a
-// test .go file containing cgo code). Files that are not buildable will not
+// A diagnostic is reported if a file is buildable but invalid (for example,
+// a test .go file containing cgo code). Files that are not buildable will not
 // be added to any target (for example, .txt files).
-func (pb *packageBuilder) addFile(c *config.Config, info fileInfo, cgo bool) error {
+func (pb *packageBuilder) addFile(c *config.Config, info fileInfo, cgo bool) {
 	switch {
 	case info.category == ignoredExt || info.category == unsupportedExt ||
 		!cgo && (info.category == cExt || info.category == csExt) ||
 		c.ProtoMode == config.DisableProtoMode && info.category == protoExt:
-		return nil
+		return
 	case info.isTest:
 		if info.isCgo {
-			return fmt.Errorf("%s: use of cgo in test not supported", info.path)
+			c.Diagnostics.Warnf(diag.UnsupportedSource, diag.Position{Path: info.path}, "use of cgo in test not supported")
+			return
 		}
 		pb.test.addFile(c, info)
+		if !info.hasTests {
+			// Shared test files, like helpers and TestMain, are added to the
+			// target of every test file, including test files added later.
+			pb.sharedTestFiles = append(pb.sharedTestFiles, info)
+			for _, tb := range pb.testFiles {
+				tb.addFile(c, info)
+			}
+			break
+		}
+		if pb.testFiles == nil {
+			pb.testFiles = make(map[string]*goTargetBuilder)
+		}
+		if pb.testFiles[info.name] == nil {
+			tb := &goTargetBuilder{}
+			for _, shared := range pb.sharedTestFiles {
+				tb.addFile(c, shared)
+			}
+			pb.testFiles[info.name] = tb
+		}
+		pb.testFiles[info.name].addFile(c, info)
 	case info.category == protoExt:
 		pb.proto.addFile(c, info)
 	default:
@@ -163,16 +245,17 @@ func (pb *packageBuilder) addFile(c *config.Config, info fileInfo, cgo bool) err
 		pb.proto.hasPbGo = true
 	}
 
-	if info.importPath != "" {
+	// In proto modes that group files by file or by proto package, .proto
+	// files may belong to different Go packages, so their go_package options
+	// don't determine the import path of the Go package in the directory.
+	if info.importPath != "" && !(info.category == protoExt && c.ProtoMode.GroupsProtoFiles()) {
 		if pb.importPath == "" {
 			pb.importPath = info.importPath
 			pb.importPathFile = info.path
 		} else if pb.importPath != info.importPath {
-			return fmt.Errorf("found import comments %q (%s) and %q (%s)", pb.importPath, pb.importPathFile, info.importPath, info.path)
+			c.Diagnostics.Warnf(diag.ImportPathError, diag.Position{Path: info.path}, "found import comments %q (%s) and %q (%s)", pb.importPath, pb.importPathFile, info.importPath, info.path)
 		}
 	}
-
-	return nil
 }
 
 // isBuildable returns true if anything in the package is buildable.
@@ -180,7 +263,7 @@ func (pb *packageBuilder) addFile(c *config.Config, info fileInfo, cgo bool) err
 // on any platform or has proto files not in legacy mode.
 func (pb *packageBuilder) isBuildable(c *config.Config) bool {
 	return pb.firstGoFile() != "" ||
-		len(pb.proto.sources.strs) > 0 && c.ProtoMode == config.DefaultProtoMode
+		len(pb.proto.sources.strs) > 0 && c.ProtoMode.ShouldGenerateRules()
 }
 
 // firstGoFile returns the name of a .go file if the package contains at least
@@ -220,6 +303,13 @@ func (pb *packageBuilder) inferImportPath(c *config.Config) error {
 }
 
 func (pb *packageBuilder) build() *Package {
+	var testFiles map[string]GoTarget
+	if pb.testFiles != nil {
+		testFiles = make(map[string]GoTarget)
+		for name, tb := range pb.testFiles {
+			testFiles[name] = tb.build()
+		}
+	}
 	return &Package{
 		Name:        pb.name,
 		Dir:         pb.dir,
@@ -228,6 +318,7 @@ func (pb *packageBuilder) build() *Package {
 		Library:     pb.library.build(),
 		Binary:      pb.binary.build(),
 		Test:        pb.test.build(),
+		TestFiles:   testFiles,
 		Proto:       pb.proto.build(),
 		HasTestdata: pb.hasTestdata,
 	}
@@ -269,6 +360,36 @@ func (tb *protoTargetBuilder) addFile(c *config.Config, info fileInfo) {
 	add(&tb.sources, info.name)
 	add(&tb.imports, info.imports...)
 	tb.hasServices = tb.hasServices || info.hasServices
+	if c.ProtoMode.GroupsProtoFiles() {
+		file := ProtoFile{
+			Name:         info.name,
+			PackageName:  info.protoPackageName,
+			GoImportPath: info.importPath,
+			Imports:      info.imports,
+			Messages:     info.protoMessages,
+			Services:     info.protoServices,
+			HasServices:  info.hasServices,
+		}
+		if info.importPath != "" {
+			file.GoPackageName = info.packageName
+		}
+		for _, imp := range info.protoImports {
+			file.ImportStatements = append(file.ImportStatements, ProtoImport{
+				Path:   imp.path,
+				Public: imp.public,
+				Weak:   imp.weak,
+				Line:   imp.pos.line,
+				Column: imp.pos.col,
+			})
+		}
+		if len(info.protoOptions) > 0 {
+			file.Options = make(map[string]string)
+			for _, opt := range info.protoOptions {
+				file.Options[opt.name] = opt.value
+			}
+		}
+		tb.files = append(tb.files, file)
+	}
 }
 
 func (tb *protoTargetBuilder) build() ProtoTarget {
@@ -277,9 +398,15 @@ func (tb *protoTargetBuilder) build() ProtoTarget {
 		Imports:     tb.imports.build(),
 		HasServices: tb.hasServices,
 		HasPbGo:     tb.hasPbGo,
+		Files:       tb.sortedFiles(),
 	}
 }
 
+func (tb *protoTargetBuilder) sortedFiles() []ProtoFile {
+	sort.Slice(tb.files, func(i, j int) bool { return tb.files[i].Name < tb.files[j].Name })
+	return tb.files
+}
+
 // getPlatformStringsAddFunction returns a function used to add strings to
 // a *platformStringsBuilder under the same set of constraints. This is a
 // performance optimization to avoid evaluating constraints repeatedly.
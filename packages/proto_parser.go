@@ -0,0 +1,603 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// protoFile contains declarations read from a .proto file. Only the parts
+// of the file Gazelle needs are recorded. Bodies of messages, enums, and
+// services are skipped, except for nested message declarations.
+type protoFile struct {
+	// syntax is the value of the syntax statement, for example, "proto3".
+	// It is empty if there is no syntax statement.
+	syntax string
+
+	// pkg is the name in the package statement, for example, "foo.bar".
+	pkg string
+
+	imports []protoImport
+
+	// options contains file-level options in the order they were declared.
+	options []protoOption
+
+	// messages contains the names of messages declared in the file. Nested
+	// messages are qualified with the names of messages that contain them,
+	// for example, "Outer.Inner".
+	messages []string
+
+	services []string
+}
+
+type protoImport struct {
+	path         string
+	public, weak bool
+	pos          protoPos
+}
+
+// protoOption is a file-level option. name is the option name as written,
+// for example, "go_package" or "(foo.bar).baz". value is the unquoted
+// string for string constants and the source text for other constants.
+type protoOption struct {
+	name, value string
+}
+
+// protoPos is a 1-based line and column in a .proto file.
+type protoPos struct {
+	line, col int
+}
+
+type protoParseError struct {
+	pos protoPos
+	msg string
+}
+
+func (e *protoParseError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.pos.line, e.pos.col, e.msg)
+}
+
+// parseProto parses the content of a .proto file. Both proto2 and proto3
+// syntax are accepted. If there is a syntax error, a *protoParseError is
+// returned together with the declarations read before the error.
+func parseProto(data []byte) (f *protoFile, err error) {
+	p := &protoParser{lx: protoLexer{data: data, pos: protoPos{line: 1, col: 1}}}
+	defer func() {
+		if r := recover(); r != nil {
+			perr, ok := r.(*protoParseError)
+			if !ok {
+				panic(r)
+			}
+			f, err = &p.file, perr
+		}
+	}()
+	p.next()
+	for p.tok.kind != protoEOF {
+		p.parseTopLevel()
+	}
+	return &p.file, nil
+}
+
+type protoTokenKind int
+
+const (
+	protoEOF protoTokenKind = iota
+	// protoIdent is an identifier or keyword. Dotted names are read as
+	// several tokens.
+	protoIdent
+	// protoString is a string literal. The token text is the unquoted value.
+	protoString
+	// protoNumber is an integer or floating point literal.
+	protoNumber
+	// protoPunct is any other single character.
+	protoPunct
+)
+
+type protoToken struct {
+	kind protoTokenKind
+	text string
+	pos  protoPos
+
+	// start and end are byte offsets of the token in the file.
+	start, end int
+}
+
+func (t protoToken) String() string {
+	switch t.kind {
+	case protoEOF:
+		return "end of file"
+	case protoString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+type protoLexer struct {
+	data []byte
+	off  int
+	pos  protoPos
+}
+
+func (lx *protoLexer) errorf(pos protoPos, format string, args ...interface{}) {
+	panic(&protoParseError{pos: pos, msg: fmt.Sprintf(format, args...)})
+}
+
+// peek returns the byte i bytes after the current offset, or 0 if that is
+// past the end of the file.
+func (lx *protoLexer) peek(i int) byte {
+	if lx.off+i >= len(lx.data) {
+		return 0
+	}
+	return lx.data[lx.off+i]
+}
+
+func (lx *protoLexer) advance() byte {
+	b := lx.data[lx.off]
+	lx.off++
+	if b == '\n' {
+		lx.pos.line++
+		lx.pos.col = 1
+	} else {
+		lx.pos.col++
+	}
+	return b
+}
+
+// skipSpace skips whitespace and comments.
+func (lx *protoLexer) skipSpace() {
+	for lx.off < len(lx.data) {
+		switch b := lx.peek(0); {
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f':
+			lx.advance()
+		case b == '/' && lx.peek(1) == '/':
+			for lx.off < len(lx.data) && lx.peek(0) != '\n' {
+				lx.advance()
+			}
+		case b == '/' && lx.peek(1) == '*':
+			start := lx.pos
+			lx.advance()
+			lx.advance()
+			for lx.off < len(lx.data) && !(lx.peek(0) == '*' && lx.peek(1) == '/') {
+				lx.advance()
+			}
+			if lx.off == len(lx.data) {
+				lx.errorf(start, "comment is not terminated")
+			}
+			lx.advance()
+			lx.advance()
+		default:
+			return
+		}
+	}
+}
+
+func (lx *protoLexer) next() protoToken {
+	lx.skipSpace()
+	tok := protoToken{pos: lx.pos, start: lx.off}
+	if lx.off == len(lx.data) {
+		tok.kind = protoEOF
+		tok.end = lx.off
+		return tok
+	}
+	switch b := lx.peek(0); {
+	case isProtoLetter(b):
+		tok.kind = protoIdent
+		for lx.off < len(lx.data) && (isProtoLetter(lx.peek(0)) || isProtoDigit(lx.peek(0))) {
+			lx.advance()
+		}
+		tok.text = string(lx.data[tok.start:lx.off])
+
+	case isProtoDigit(b) || b == '.' && isProtoDigit(lx.peek(1)):
+		tok.kind = protoNumber
+		for lx.off < len(lx.data) {
+			c := lx.peek(0)
+			if isProtoLetter(c) || isProtoDigit(c) || c == '.' {
+				lx.advance()
+			} else if (c == '+' || c == '-') && lx.off > tok.start && (lx.data[lx.off-1] == 'e' || lx.data[lx.off-1] == 'E') && !strings.HasPrefix(strings.ToLower(string(lx.data[tok.start:lx.off])), "0x") {
+				lx.advance()
+			} else {
+				break
+			}
+		}
+		tok.text = string(lx.data[tok.start:lx.off])
+
+	case b == '"' || b == '\'':
+		tok.kind = protoString
+		tok.text = lx.readString()
+
+	default:
+		tok.kind = protoPunct
+		lx.advance()
+		tok.text = string(b)
+	}
+	tok.end = lx.off
+	return tok
+}
+
+// readString reads a quoted string literal and returns its unquoted value.
+func (lx *protoLexer) readString() string {
+	start := lx.pos
+	quote := lx.advance()
+	var sb bytes.Buffer
+	for {
+		if lx.off == len(lx.data) || lx.peek(0) == '\n' {
+			lx.errorf(start, "string literal is not terminated")
+		}
+		escPos := lx.pos
+		b := lx.advance()
+		if b == quote {
+			return sb.String()
+		}
+		if b != '\\' {
+			sb.WriteByte(b)
+			continue
+		}
+		if lx.off == len(lx.data) {
+			lx.errorf(start, "string literal is not terminated")
+		}
+		switch e := lx.advance(); e {
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			sb.WriteByte(e)
+		case 'x', 'X':
+			v, n := 0, 0
+			for ; n < 2 && isProtoHexDigit(lx.peek(0)); n++ {
+				v = v*16 + protoHexValue(lx.advance())
+			}
+			if n == 0 {
+				lx.errorf(escPos, "invalid hex escape in string literal")
+			}
+			sb.WriteByte(byte(v))
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			v := int(e - '0')
+			for n := 1; n < 3 && lx.peek(0) >= '0' && lx.peek(0) <= '7'; n++ {
+				v = v*8 + int(lx.advance()-'0')
+			}
+			if v > 0xff {
+				lx.errorf(escPos, "octal escape in string literal is out of range")
+			}
+			sb.WriteByte(byte(v))
+		default:
+			lx.errorf(escPos, "invalid escape %q in string literal", "\\"+string(e))
+		}
+	}
+}
+
+func isProtoLetter(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || b == '_'
+}
+
+func isProtoDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+func isProtoHexDigit(b byte) bool {
+	return isProtoDigit(b) || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
+}
+
+func protoHexValue(b byte) int {
+	switch {
+	case isProtoDigit(b):
+		return int(b - '0')
+	case 'a' <= b && b <= 'f':
+		return int(b-'a') + 10
+	default:
+		return int(b-'A') + 10
+	}
+}
+
+type protoParser struct {
+	lx   protoLexer
+	tok  protoToken
+	file protoFile
+}
+
+func (p *protoParser) next() {
+	p.tok = p.lx.next()
+}
+
+func (p *protoParser) errorf(pos protoPos, format string, args ...interface{}) {
+	p.lx.errorf(pos, format, args...)
+}
+
+func (p *protoParser) isPunct(c string) bool {
+	return p.tok.kind == protoPunct && p.tok.text == c
+}
+
+func (p *protoParser) isIdent(name string) bool {
+	return p.tok.kind == protoIdent && p.tok.text == name
+}
+
+func (p *protoParser) expectPunct(c string) protoToken {
+	if !p.isPunct(c) {
+		p.errorf(p.tok.pos, "expected %q, found %s", c, p.tok)
+	}
+	tok := p.tok
+	p.next()
+	return tok
+}
+
+func (p *protoParser) expectIdent() protoToken {
+	if p.tok.kind != protoIdent {
+		p.errorf(p.tok.pos, "expected identifier, found %s", p.tok)
+	}
+	tok := p.tok
+	p.next()
+	return tok
+}
+
+// expectString parses one or more adjacent string literals and returns
+// their concatenated value.
+func (p *protoParser) expectString() string {
+	if p.tok.kind != protoString {
+		p.errorf(p.tok.pos, "expected string literal, found %s", p.tok)
+	}
+	var sb bytes.Buffer
+	for p.tok.kind == protoString {
+		sb.WriteString(p.tok.text)
+		p.next()
+	}
+	return sb.String()
+}
+
+func (p *protoParser) parseTopLevel() {
+	switch {
+	case p.isPunct(";"):
+		p.next()
+
+	case p.isIdent("syntax"):
+		p.next()
+		p.expectPunct("=")
+		p.file.syntax = p.expectString()
+		p.expectPunct(";")
+
+	case p.isIdent("package"):
+		pos := p.tok.pos
+		p.next()
+		if p.file.pkg != "" {
+			p.errorf(pos, "multiple package statements")
+		}
+		p.file.pkg = p.parseFullIdent()
+		p.expectPunct(";")
+
+	case p.isIdent("import"):
+		imp := protoImport{pos: p.tok.pos}
+		p.next()
+		if p.isIdent("public") {
+			imp.public = true
+			p.next()
+		} else if p.isIdent("weak") {
+			imp.weak = true
+			p.next()
+		}
+		imp.path = p.expectString()
+		p.expectPunct(";")
+		p.file.imports = append(p.file.imports, imp)
+
+	case p.isIdent("option"):
+		p.next()
+		var opt protoOption
+		opt.name = p.parseOptionName()
+		p.expectPunct("=")
+		opt.value = p.parseConstant()
+		p.expectPunct(";")
+		p.file.options = append(p.file.options, opt)
+
+	case p.isIdent("message"):
+		p.next()
+		p.parseMessage(p.expectIdent().text)
+
+	case p.isIdent("service"):
+		p.next()
+		p.file.services = append(p.file.services, p.expectIdent().text)
+		p.skipBlock()
+
+	case p.isIdent("enum"):
+		p.next()
+		p.expectIdent()
+		p.skipBlock()
+
+	case p.isIdent("extend"):
+		p.next()
+		p.parseFullIdent()
+		p.skipBlock()
+
+	default:
+		p.errorf(p.tok.pos, "unexpected %s", p.tok)
+	}
+}
+
+// parseFullIdent parses a dotted name, which may start with a dot.
+func (p *protoParser) parseFullIdent() string {
+	var sb bytes.Buffer
+	if p.isPunct(".") {
+		sb.WriteString(".")
+		p.next()
+	}
+	sb.WriteString(p.expectIdent().text)
+	for p.isPunct(".") {
+		p.next()
+		sb.WriteString(".")
+		sb.WriteString(p.expectIdent().text)
+	}
+	return sb.String()
+}
+
+// parseOptionName parses the name of an option, which may include
+// parenthesized extension names, for example, "(foo.bar).baz".
+func (p *protoParser) parseOptionName() string {
+	var sb bytes.Buffer
+	for {
+		if p.isPunct("(") {
+			p.next()
+			sb.WriteString("(" + p.parseFullIdent() + ")")
+			p.expectPunct(")")
+		} else {
+			sb.WriteString(p.expectIdent().text)
+		}
+		if !p.isPunct(".") {
+			return sb.String()
+		}
+		p.next()
+		sb.WriteString(".")
+	}
+}
+
+// parseConstant parses the value of an option. Aggregate values in braces are returned as source text.
+func (p *protoParser) parseConstant() string {
+	switch {
+	case p.tok.kind == protoString:
+		return p.expectString()
+
+	case p.isPunct("-") || p.isPunct("+"):
+		sign := p.tok.text
+		p.next()
+		if p.tok.kind != protoNumber && p.tok.kind != protoIdent {
+			p.errorf(p.tok.pos, "expected number, found %s", p.tok)
+		}
+		value := sign + p.tok.text
+		p.next()
+		return value
+
+	case p.tok.kind == protoNumber:
+		value := p.tok.text
+		p.next()
+		return value
+
+	case p.tok.kind == protoIdent:
+		return p.parseFullIdent()
+
+	case p.isPunct("{"):
+		start := p.tok.start
+		end := p.skipBlock()
+		return string(p.lx.data[start:end])
+
+	default:
+		p.errorf(p.tok.pos, "expected constant, found %s", p.tok)
+		return ""
+	}
+}
+
+// parseMessage parses the body of a message with the given name. Nested
+// messages are recorded. Other declarations in the body are skipped.
+func (p *protoParser) parseMessage(name string) {
+	open := p.expectPunct("{")
+	p.file.messages = append(p.file.messages, name)
+	for {
+		switch {
+		case p.tok.kind == protoEOF:
+			p.errorf(open.pos, "message %s is not terminated", name)
+		case p.isPunct("}"):
+			p.next()
+			return
+		case p.isPunct(";"):
+			p.next()
+		case p.isIdent("message"):
+			p.next()
+			p.parseMessage(name + "." + p.expectIdent().text)
+		default:
+			p.skipStatement()
+		}
+	}
+}
+
+// skipStatement skips a declaration inside a message body. The declaration
+// ends with a semicolon or with a block in braces. The closing brace of the
+// enclosing message is not consumed.
+func (p *protoParser) skipStatement() {
+	var open []protoToken
+	for {
+		switch {
+		case p.tok.kind == protoEOF:
+			if len(open) > 0 {
+				p.errorf(open[len(open)-1].pos, "%q is not closed", open[len(open)-1].text)
+			}
+			p.errorf(p.tok.pos, "unexpected end of file")
+		case p.isPunct("(") || p.isPunct("[") || p.isPunct("{"):
+			open = append(open, p.tok)
+		case p.isPunct(")") || p.isPunct("]") || p.isPunct("}"):
+			if len(open) == 0 {
+				if p.isPunct("}") {
+					return
+				}
+				p.errorf(p.tok.pos, "unexpected %s", p.tok)
+			}
+			last := open[len(open)-1]
+			if want := closingProtoPunct(last.text); p.tok.text != want {
+				p.errorf(p.tok.pos, "expected %q to close %q at %d:%d, found %s", want, last.text, last.pos.line, last.pos.col, p.tok)
+			}
+			open = open[:len(open)-1]
+			if len(open) == 0 && p.tok.text == "}" {
+				p.next()
+				return
+			}
+		case p.isPunct(";") && len(open) == 0:
+			p.next()
+			return
+		}
+		p.next()
+	}
+}
+
+// skipBlock skips a block in braces, including any nested blocks. It
+// returns the offset of the end of the closing brace.
+func (p *protoParser) skipBlock() int {
+	open := p.expectPunct("{")
+	depth := 1
+	for {
+		switch {
+		case p.tok.kind == protoEOF:
+			p.errorf(open.pos, "\"{\" is not closed")
+		case p.isPunct("{"):
+			depth++
+		case p.isPunct("}"):
+			depth--
+			if depth == 0 {
+				end := p.tok.end
+				p.next()
+				return end
+			}
+		}
+		p.next()
+	}
+}
+
+func closingProtoPunct(open string) string {
+	switch open {
+	case "(":
+		return ")"
+	case "[":
+		return "]"
+	default:
+		return "}"
+	}
+}
@@ -0,0 +1,158 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProto(t *testing.T) {
+	for _, tc := range []struct {
+		desc, proto string
+		want        protoFile
+	}{
+		{
+			desc: "empty",
+		}, {
+			desc: "proto2",
+			proto: `syntax = "proto2";
+package foo.bar;
+
+import "a.proto";
+import public 'b.proto';
+import weak "c" ".proto";
+
+option java_package = "com.example.foo";
+option optimize_for = SPEED;
+option (custom).field = -1.5e+3;
+
+message Outer {
+  optional group Group = 1 {
+    optional int32 x = 2;
+  }
+  message Inner {
+    enum Kind { A = 0; }
+    optional int32 message = 1 [(opt) = { a: 1 }, deprecated = true];
+  }
+  oneof choice {
+    string a = 3;
+  }
+  extensions 100 to max;
+  reserved "x", "y";
+}
+
+enum Top {
+  option allow_alias = true;
+  TOP = 0;
+}
+
+extend .google.protobuf.FileOptions {
+  optional string custom = 50000;
+}
+
+service Svc {
+  rpc Get(Outer) returns (Outer) {
+    option (http) = { get: "/v1/{name}" };
+  }
+}
+`,
+			want: protoFile{
+				syntax: "proto2",
+				pkg:    "foo.bar",
+				imports: []protoImport{
+					{path: "a.proto", pos: protoPos{line: 4, col: 1}},
+					{path: "b.proto", public: true, pos: protoPos{line: 5, col: 1}},
+					{path: "c.proto", weak: true, pos: protoPos{line: 6, col: 1}},
+				},
+				options: []protoOption{
+					{name: "java_package", value: "com.example.foo"},
+					{name: "optimize_for", value: "SPEED"},
+					{name: "(custom).field", value: "-1.5e+3"},
+				},
+				messages: []string{"Outer", "Outer.Inner"},
+				services: []string{"Svc"},
+			},
+		}, {
+			desc: "comments",
+			proto: `// package bad;
+/* service Bad {} */
+package /* inline */ good; // trailing
+`,
+			want: protoFile{pkg: "good"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseProto([]byte(tc.proto))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tc.want) {
+				t.Errorf("got %#v; want %#v", *got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseProtoErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc, proto, want string
+	}{
+		{
+			desc:  "missing_semicolon",
+			proto: "package foo\nimport \"a.proto\";",
+			want:  `2:1: expected ";", found "import"`,
+		}, {
+			desc:  "unterminated_string",
+			proto: "import \"a.proto;\n",
+			want:  "1:8: string literal is not terminated",
+		}, {
+			desc:  "unterminated_comment",
+			proto: "package foo;\n  /* comment",
+			want:  "2:3: comment is not terminated",
+		}, {
+			desc:  "unterminated_message",
+			proto: "message Foo {\n  int32 x = 1;\n",
+			want:  "1:13: message Foo is not terminated",
+		}, {
+			desc:  "unclosed_bracket",
+			proto: "message Foo {\n  int32 x = 1 [deprecated = true;\n",
+			want:  `2:15: "[" is not closed`,
+		}, {
+			desc:  "mismatched_brackets",
+			proto: "message Foo {\n  int32 x = 1 [deprecated = true);\n}",
+			want:  `2:33: expected "]" to close "[" at 2:15, found ")"`,
+		}, {
+			desc:  "invalid_escape",
+			proto: `import "\q.proto";`,
+			want:  `1:9: invalid escape "\\q" in string literal`,
+		}, {
+			desc:  "unexpected",
+			proto: "syntax = \"proto3\";\nfoo bar;",
+			want:  `2:1: unexpected "foo"`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := parseProto([]byte(tc.proto))
+			if err == nil {
+				t.Fatalf("got success; want error %q", tc.want)
+			}
+			if got := err.Error(); got != tc.want {
+				t.Errorf("got error %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
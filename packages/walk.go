@@ -0,0 +1,829 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
+	"github.com/bazelbuild/bazel-gazelle/internal/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// A WalkFunc is a callback called by Walk in each visited directory.
+//
+// dir is the absolute file system path to the directory being visited.
+//
+// rel is the relative slash-separated path to the directory from the
+// repository root. Will be "" for the repository root directory itself.
+//
+// c is the configuration for the current directory. This may have been
+// modified by directives in the directory's build file.
+//
+// pkg contains information about how to build source code in the directory.
+// Will be nil for directories that don't contain buildable code, directories
+// that Gazelle was not asked update, and directories where Walk
+// encountered errors.
+//
+// oldFile is the existing build file in the directory. Will be nil if there
+// was no file.
+//
+// subdirs is a list of base names of subdirectories within dir, not
+// including excluded files.
+//
+// regularFiles is a list of base names of regular files within dir, not
+// including excluded files.
+//
+// genFiles is a list of names of generated files, found by reading
+// "out" and "outs" attributes of rules in oldFile.
+//
+// isUpdateDir is true for directories that Gazelle was asked to update.
+type WalkFunc func(dir, rel string, c *config.Config, pkg *Package, oldFile *rule.File, subdirs, regularFiles, genFiles []string, isUpdateDir bool)
+
+// Walk traverses a directory tree. In each directory, Walk parses existing
+// build files. In directories that Gazelle was asked to update (c.Dirs), Walk
+// also parses source files and infers build information.
+//
+// c is the base configuration for the repository. c is copied in each
+// directory and may be modified by directives found in build files.
+//
+// cexts is a list of configuration extensions. In each directory, Walk
+// calls Configure on each extension in order, with directives found in
+// the build file. Directives not known to any extension are reported.
+// Configure may be called concurrently for different directories, but it
+// is always called for a directory after it has been called for the
+// directory's parent.
+//
+// root is an absolute file path to the directory to traverse.
+//
+// f is a function that will be called for each visited directory.
+// Directories are listed and source files are parsed concurrently, but f is
+// called sequentially, in the same order on every run: subdirectories are
+// visited in lexicographic order before their parents.
+func Walk(c *config.Config, cexts []config.Configurer, root string, f WalkFunc) {
+	WalkWithCache(c, cexts, root, nil, f)
+}
+
+// WalkWithCache is like Walk, but information about source files is read
+// from cache when the files have not changed. Directories that Gazelle was
+// asked to update are not updated if nothing in them has changed since
+// they were marked with Cache.MarkUpToDate; f is called for these
+// directories with a nil pkg, and isUpdateDir is false. If cache is nil,
+// WalkWithCache behaves like Walk.
+func WalkWithCache(c *config.Config, cexts []config.Configurer, root string, cache *Cache, f WalkFunc) {
+	w := &walker{
+		cexts:           cexts,
+		knownDirectives: make(map[string]bool),
+		cache:           cache,
+	}
+	for _, cext := range cexts {
+		for _, d := range cext.KnownDirectives() {
+			w.knownDirectives[d] = true
+		}
+	}
+	if cache != nil {
+		cache.mu.Lock()
+		cache.skipped = nil
+		cache.mu.Unlock()
+	}
+
+	// Determine relative paths for the directories to be updated.
+	for _, dir := range c.Dirs {
+		rel, err := filepath.Rel(c.RepoRoot, dir)
+		if err != nil {
+			// This should have been verified when c was built.
+			log.Panicf("%s: not a subdirectory of repository root %q", dir, c.RepoRoot)
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." || rel == "/" {
+			rel = ""
+		}
+		w.updateRels = append(w.updateRels, rel)
+	}
+	rootRel, err := filepath.Rel(c.RepoRoot, root)
+	if err != nil {
+		log.Panicf("%s: not a subdirectory of repository root %q", root, c.RepoRoot)
+	}
+	if rootRel == "." || rootRel == "/" {
+		rootRel = ""
+	}
+
+	// Load the tree in three passes. First, directories are listed, build
+	// files are read, and source files are parsed concurrently. Second,
+	// symbolic links are resolved sequentially, since whether a link is
+	// followed depends on which links were followed before it. Finally,
+	// packages are built and f is called sequentially in post-order.
+	top := newWalkNode(c, "", root, rootRel, false, nil)
+	w.loadTree(top)
+	symlinks := symlinkResolver{root: root, visited: []string{root}}
+	w.resolveSymlinks(top, &symlinks)
+	w.visit(top, f)
+}
+
+// walkConcurrency is the maximum number of directories that Walk loads
+// at the same time.
+var walkConcurrency = runtime.GOMAXPROCS(0)
+
+// walker holds state for a call to Walk.
+type walker struct {
+	cexts           []config.Configurer
+	knownDirectives map[string]bool
+	updateRels      []string
+	cache           *Cache
+}
+
+// walkNode holds information about a directory, gathered by walker.load.
+type walkNode struct {
+	dir, rel    string
+	c           *config.Config
+	isUpdateDir bool
+	excluded    []string
+
+	oldFile   *rule.File
+	haveError bool
+	ignore    bool
+
+	// directives is a hash of directives in this directory's build file and
+	// in build files of parent directories. It is only set when a cache
+	// is used.
+	directives string
+
+	// fingerprint identifies the directory's contents and configuration in
+	// the cache. It is only set in directories that may be skipped when
+	// nothing has changed.
+	fingerprint cachedDir
+
+	// listed is true if the directory's contents were read successfully.
+	listed bool
+
+	// entries lists the contents of the directory in lexicographic order,
+	// not including excluded and hidden files.
+	entries []walkEntry
+
+	// children maps base names of subdirectories to their nodes.
+	children map[string]*walkNode
+
+	// pkgInfos contains information parsed from .go and .proto files, and
+	// otherInfos contains information about other regular files, indexed by
+	// base name. They are only set in directories where a package may be
+	// built.
+	pkgInfos   []fileInfo
+	otherInfos map[string]fileInfo
+}
+
+type walkEntry struct {
+	name string
+	kind walkEntryKind
+}
+
+type walkEntryKind int
+
+const (
+	subdirEntry walkEntryKind = iota
+	pkgFileEntry
+	otherFileEntry
+
+	// symlinkEntry is a symbolic link that may point to a directory. It is
+	// replaced with subdirEntry or otherFileEntry by resolveSymlinks.
+	symlinkEntry
+)
+
+// newWalkNode creates a node for a directory that hasn't been loaded yet.
+// parent and parentDirectives are the configuration of the parent directory
+// and the hash of its directives.
+func newWalkNode(parent *config.Config, parentDirectives, dir, rel string, isUpdateDir bool, excluded []string) *walkNode {
+	return &walkNode{
+		dir:         dir,
+		rel:         rel,
+		c:           parent,
+		isUpdateDir: isUpdateDir,
+		excluded:    excluded,
+		directives:  parentDirectives,
+		children:    make(map[string]*walkNode),
+	}
+}
+
+// loadTree loads a directory and its subdirectories. Directories are loaded
+// by a fixed number of workers, so at most walkConcurrency directories are
+// read at the same time. Pending directories are loaded depth-first, which
+// keeps the queue small in wide trees.
+func (w *walker) loadTree(top *walkNode) {
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+	pending := []*walkNode{top}
+	active := 1 // directories queued or being loaded
+
+	worker := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for {
+			for len(pending) == 0 && active > 0 {
+				cond.Wait()
+			}
+			if active == 0 {
+				return
+			}
+			n := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			mu.Unlock()
+
+			w.loadDir(n)
+			var children []*walkNode
+			for _, e := range n.entries {
+				if e.kind == subdirEntry {
+					child := newWalkNode(n.c, n.directives, filepath.Join(n.dir, e.name), path.Join(n.rel, e.name), n.isUpdateDir, excludedForSubdir(n.excluded, e.name))
+					n.children[e.name] = child
+					children = append(children, child)
+				}
+			}
+
+			mu.Lock()
+			// Push children in reverse so they're loaded in order.
+			for i := len(children) - 1; i >= 0; i-- {
+				pending = append(pending, children[i])
+			}
+			active += len(children) - 1
+			cond.Broadcast()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < walkConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+	wg.Wait()
+}
+
+// loadDir reads the build file in a directory, applies its directives to
+// a copy of the parent configuration, lists the directory, and parses
+// .go and .proto files if a package may be built there.
+func (w *walker) loadDir(n *walkNode) {
+	// Check if this directory should be updated.
+	if !n.isUpdateDir {
+		for _, updateRel := range w.updateRels {
+			if pathtools.HasPrefix(n.rel, updateRel) {
+				n.isUpdateDir = true
+			}
+		}
+	}
+
+	// Look for an existing BUILD file.
+	for _, base := range n.c.ValidBuildFileNames {
+		oldPath := filepath.Join(n.dir, base)
+		st, err := os.Stat(oldPath)
+		if os.IsNotExist(err) || err == nil && st.IsDir() {
+			continue
+		}
+		if n.oldFile != nil {
+			n.c.Diagnostics.Errorf(diag.MultipleBuildFiles, diag.Position{Path: oldPath}, "multiple Bazel files are present in directory: %s, %s",
+				filepath.Base(n.oldFile.Path), base)
+			n.haveError = true
+			continue
+		}
+		n.oldFile, err = rule.LoadFile(oldPath, n.rel)
+		if err != nil {
+			reportBuildFileError(n.c, oldPath, err)
+			n.haveError = true
+			continue
+		}
+	}
+
+	// Process directives in the build file.
+	n.c = n.c.Clone()
+	var directives []config.Directive
+	var bzlFile *bzl.File
+	if n.oldFile != nil {
+		directives = n.oldFile.Directives
+		bzlFile = n.oldFile.File
+		config.CheckDirectives(n.c, n.oldFile.Path, directives, w.knownDirectives)
+	}
+	for _, cext := range w.cexts {
+		cext.Configure(n.c, n.rel, bzlFile, directives)
+	}
+	if w.cache != nil {
+		n.directives = hashDirectives(n.directives, directives)
+	}
+
+	excluded := append([]string(nil), n.excluded...)
+	for _, d := range directives {
+		switch d.Key {
+		case "exclude":
+			excluded = append(excluded, d.Value)
+		case "ignore":
+			n.ignore = true
+		}
+	}
+
+	// List files and subdirectories.
+	files, err := ioutil.ReadDir(n.dir)
+	if err != nil {
+		n.c.Diagnostics.Errorf(diag.SourceError, diag.Position{Path: n.dir}, "%v", err)
+		return
+	}
+	n.listed = true
+	if n.c.ProtoMode.ShouldGenerateRules() {
+		excluded = append(excluded, findPbGoFiles(files, excluded)...)
+	}
+	n.excluded = excluded
+
+	var pkgFiles, otherFiles []string
+	hasSymlinks := false
+	for _, f := range files {
+		base := f.Name()
+		switch {
+		case base == "" || base[0] == '.' || base[0] == '_' || isExcluded(excluded, base):
+			continue
+
+		case f.IsDir():
+			n.entries = append(n.entries, walkEntry{base, subdirEntry})
+
+		case strings.HasSuffix(base, ".go") ||
+			(n.c.ProtoMode != config.DisableProtoMode && strings.HasSuffix(base, ".proto")):
+			n.entries = append(n.entries, walkEntry{base, pkgFileEntry})
+			pkgFiles = append(pkgFiles, base)
+
+		case f.Mode()&os.ModeSymlink != 0:
+			n.entries = append(n.entries, walkEntry{base, symlinkEntry})
+			hasSymlinks = true
+
+		default:
+			n.entries = append(n.entries, walkEntry{base, otherFileEntry})
+			otherFiles = append(otherFiles, base)
+		}
+	}
+
+	if !n.isUpdateDir || n.haveError || n.ignore {
+		return
+	}
+	var hashes map[string]string
+	n.pkgInfos, n.otherInfos, hashes = readFiles(w.cache, n.c, n.dir, n.rel, pkgFiles, otherFiles)
+
+	// Symbolic links may be followed or not depending on other directories,
+	// so directories that contain them are always updated.
+	if w.cache != nil && !hasSymlinks {
+		// Build files are fingerprinted separately, since they are written
+		// after the directory is visited.
+		var entries []walkEntry
+		for _, e := range n.entries {
+			if !isBuildFileName(n.c, e.name) {
+				entries = append(entries, e)
+			}
+		}
+		n.fingerprint.Context = dirContext(w.cache.seed, n.directives, entries, hashes)
+		if n.oldFile != nil {
+			content, err := ioutil.ReadFile(n.oldFile.Path)
+			if err != nil {
+				n.c.Diagnostics.Errorf(diag.BuildFileError, diag.Position{Path: n.oldFile.Path}, "%v", err)
+				n.fingerprint.Context = ""
+			}
+			n.fingerprint.BuildFile = hashBytes(content)
+		}
+	}
+}
+
+// resolveSymlinks decides whether symbolic links in n and its subdirectories
+// should be followed, in the same pre-order that a sequential walk would
+// use. Subtrees of links that are followed are loaded.
+func (w *walker) resolveSymlinks(n *walkNode, symlinks *symlinkResolver) {
+	if !n.listed {
+		return
+	}
+	for i, e := range n.entries {
+		if e.kind != symlinkEntry {
+			continue
+		}
+		if symlinks.follow(n.dir, e.name) {
+			n.entries[i].kind = subdirEntry
+			child := newWalkNode(n.c, n.directives, filepath.Join(n.dir, e.name), path.Join(n.rel, e.name), n.isUpdateDir, excludedForSubdir(n.excluded, e.name))
+			n.children[e.name] = child
+			w.loadTree(child)
+		} else {
+			n.entries[i].kind = otherFileEntry
+		}
+	}
+	for _, e := range n.entries {
+		if e.kind == subdirEntry {
+			w.resolveSymlinks(n.children[e.name], symlinks)
+		}
+	}
+}
+
+// visit builds packages and calls f for n and its subdirectories in
+// post-order. It returns whether the given directory or any subdirectory
+// contained a build file or buildable source code. This affects whether
+// "testdata" directories are considered data dependencies.
+func (w *walker) visit(n *walkNode, f WalkFunc) bool {
+	if !n.listed {
+		return false
+	}
+
+	var otherFiles, subdirs, regularFiles []string
+	for _, e := range n.entries {
+		switch e.kind {
+		case subdirEntry:
+			subdirs = append(subdirs, e.name)
+		case pkgFileEntry:
+			regularFiles = append(regularFiles, e.name)
+		case otherFileEntry:
+			otherFiles = append(otherFiles, e.name)
+			regularFiles = append(regularFiles, e.name)
+		}
+	}
+
+	// Recurse into subdirectories.
+	hasTestdata := false
+	subdirHasPackage := false
+	for _, sub := range subdirs {
+		hasPackage := w.visit(n.children[sub], f)
+		// f has been called for the subdirectory and everything in it, so
+		// the subtree is no longer needed.
+		delete(n.children, sub)
+		if sub == "testdata" && !hasPackage {
+			hasTestdata = true
+		}
+		subdirHasPackage = subdirHasPackage || hasPackage
+	}
+
+	hasPackage := subdirHasPackage || n.oldFile != nil
+	var genFiles []string
+	if n.oldFile != nil {
+		genFiles = findGenFiles(n.oldFile, n.excluded)
+	}
+	if n.haveError || !n.isUpdateDir || n.ignore {
+		f(n.dir, n.rel, n.c, nil, n.oldFile, subdirs, regularFiles, genFiles, false)
+		return hasPackage
+	}
+
+	// Skip directories that haven't changed since they were last updated.
+	if n.fingerprint.Context != "" {
+		n.fingerprint.HasTestdata = hasTestdata
+		if w.cache.checkDir(n.rel, n.fingerprint) {
+			f(n.dir, n.rel, n.c, nil, n.oldFile, subdirs, regularFiles, genFiles, false)
+			return hasPackage
+		}
+	}
+
+	// Build a package from files in this directory. Symbolic links that
+	// weren't followed haven't been read yet.
+	otherInfos := make([]fileInfo, len(otherFiles))
+	for i, name := range otherFiles {
+		if info, ok := n.otherInfos[name]; ok {
+			otherInfos[i] = info
+		} else {
+			otherInfos[i] = otherFileInfo(n.c, n.dir, n.rel, name)
+		}
+	}
+	pkg := buildPackageFromInfos(n.c, n.dir, n.rel, n.pkgInfos, otherInfos, genFiles, hasTestdata)
+	f(n.dir, n.rel, n.c, pkg, n.oldFile, subdirs, regularFiles, genFiles, true)
+	return hasPackage || pkg != nil
+}
+
+// buildPackage reads source files in a given directory and returns a Package
+// containing information about those files and how to build them.
+//
+// If no buildable .go files are found in the directory, nil will be returned.
+// If the directory contains multiple buildable packages, the package whose
+// name matches the directory base name will be returned. If there is no such
+// package or if an error occurs, an error will be logged, and nil will be
+// returned.
+func buildPackage(c *config.Config, dir, rel string, pkgFiles, otherFiles, genFiles []string, hasTestdata bool) *Package {
+	pkgInfos, otherInfos, _ := readFiles(nil, c, dir, rel, pkgFiles, otherFiles)
+	infos := make([]fileInfo, len(otherFiles))
+	for i, name := range otherFiles {
+		infos[i] = otherInfos[name]
+	}
+	return buildPackageFromInfos(c, dir, rel, pkgInfos, infos, genFiles, hasTestdata)
+}
+
+// readFiles reads information about files in a directory. pkgFiles are .go
+// and .proto files, which determine the package name. otherFiles are other
+// regular files. Information about other files is indexed by base name.
+//
+// If cache is not nil, files that haven't changed are not parsed, and
+// hashes of files whose content may affect generated rules are returned.
+func readFiles(cache *Cache, c *config.Config, dir, rel string, pkgFiles, otherFiles []string) (pkgInfos []fileInfo, otherInfos map[string]fileInfo, hashes map[string]string) {
+	if cache != nil {
+		hashes = make(map[string]string)
+	}
+	pkgInfos = make([]fileInfo, 0, len(pkgFiles))
+	for _, f := range pkgFiles {
+		var parse func(c *config.Config) fileInfo
+		switch path.Ext(f) {
+		case ".go":
+			parse = func(c *config.Config) fileInfo { return goFileInfo(c, dir, rel, f) }
+		case ".proto":
+			parse = func(c *config.Config) fileInfo { return protoFileInfo(c, dir, rel, f) }
+		default:
+			log.Panicf("file cannot determine package name: %s", f)
+		}
+		info, hash := cache.fileInfo(c, dir, rel, f, parse)
+		pkgInfos = append(pkgInfos, info)
+		if hashes != nil {
+			hashes[f] = hash
+		}
+	}
+
+	otherInfos = make(map[string]fileInfo)
+	for _, f := range otherFiles {
+		if cat := fileNameInfo(dir, rel, f).category; cat == ignoredExt || cat == unsupportedExt {
+			// Only the names of these files matter, so they aren't read.
+			otherInfos[f] = otherFileInfo(c, dir, rel, f)
+			continue
+		}
+		info, hash := cache.fileInfo(c, dir, rel, f, func(c *config.Config) fileInfo { return otherFileInfo(c, dir, rel, f) })
+		otherInfos[f] = info
+		if hashes != nil {
+			hashes[f] = hash
+		}
+	}
+	return pkgInfos, otherInfos, hashes
+}
+
+// buildPackageFromInfos is like buildPackage, but files have already been
+// read with readFiles.
+func buildPackageFromInfos(c *config.Config, dir, rel string, pkgInfos, otherInfos []fileInfo, genFiles []string, hasTestdata bool) *Package {
+	packageMap := make(map[string]*packageBuilder)
+	cgo := false
+	var pkgFilesWithUnknownPackage, protoInfos []fileInfo
+	for _, info := range pkgInfos {
+		if info.category == protoExt && c.ProtoMode.GroupsProtoFiles() {
+			// .proto files don't need to belong to the same package in this
+			// mode, so they don't determine the package name.
+			protoInfos = append(protoInfos, info)
+			continue
+		}
+		if info.packageName == "" {
+			pkgFilesWithUnknownPackage = append(pkgFilesWithUnknownPackage, info)
+			continue
+		}
+		if info.packageName == "documentation" {
+			// go/build ignores this package
+			continue
+		}
+
+		cgo = cgo || info.isCgo
+
+		if _, ok := packageMap[info.packageName]; !ok {
+			packageMap[info.packageName] = &packageBuilder{
+				name:        info.packageName,
+				dir:         dir,
+				rel:         rel,
+				hasTestdata: hasTestdata,
+			}
+		}
+		packageMap[info.packageName].addFile(c, info, false)
+	}
+
+	// Select a package to generate rules for. If there is no Go package,
+	// .proto files that don't determine the package name still need one.
+	pkg, err := selectPackage(c, dir, packageMap)
+	if _, ok := err.(*build.NoGoError); ok && len(protoInfos) > 0 {
+		pkg = &packageBuilder{
+			name:        defaultPackageName(c, dir),
+			dir:         dir,
+			rel:         rel,
+			hasTestdata: hasTestdata,
+		}
+		err = nil
+	}
+	if err != nil {
+		if _, ok := err.(*build.NoGoError); !ok {
+			c.Diagnostics.Errorf(diag.MultiplePackages, diag.Position{Path: dir}, "%v", err)
+		}
+		return nil
+	}
+	for _, info := range protoInfos {
+		pkg.addFile(c, info, cgo)
+	}
+
+	// Add files with unknown packages. This happens when there are parse
+	// or I/O errors. We should keep the file in the srcs list and let the
+	// compiler deal with the error.
+	for _, info := range pkgFilesWithUnknownPackage {
+		pkg.addFile(c, info, cgo)
+	}
+
+	// Process the other static files.
+	for _, info := range otherInfos {
+		pkg.addFile(c, info, cgo)
+	}
+
+	// Process generated files. Note that generated files may have the same names
+	// as static files. Bazel will use the generated files, but we will look at
+	// the content of static files, assuming they will be the same.
+	staticFiles := make(map[string]bool)
+	for _, info := range pkgInfos {
+		staticFiles[info.name] = true
+	}
+	for _, info := range otherInfos {
+		staticFiles[info.name] = true
+	}
+	for _, f := range genFiles {
+		if staticFiles[f] {
+			continue
+		}
+		info := fileNameInfo(dir, rel, f)
+		pkg.addFile(c, info, cgo)
+	}
+
+	if pkg.importPath == "" {
+		if err := pkg.inferImportPath(c); err != nil {
+			c.Diagnostics.Errorf(diag.ImportPathError, diag.Position{Path: dir}, "%v", err)
+			return nil
+		}
+	}
+	return pkg.build()
+}
+
+// reportBuildFileError reports an error reading or parsing the build file at
+// path. Syntax errors begin with the position of the error, which is
+// reported separately from the message.
+func reportBuildFileError(c *config.Config, path string, err error) {
+	pos := diag.Position{Path: path}
+	msg := err.Error()
+	if rest := strings.TrimPrefix(msg, path+":"); rest != msg {
+		parts := strings.SplitN(rest, ":", 3)
+		if len(parts) == 3 {
+			line, lineErr := strconv.Atoi(parts[0])
+			col, colErr := strconv.Atoi(parts[1])
+			if lineErr == nil && colErr == nil {
+				pos.Line, pos.Column = line, col
+				msg = strings.TrimSpace(parts[2])
+			}
+		}
+	}
+	c.Diagnostics.Errorf(diag.BuildFileError, pos, "%s", msg)
+}
+
+func selectPackage(c *config.Config, dir string, packageMap map[string]*packageBuilder) (*packageBuilder, error) {
+	buildablePackages := make(map[string]*packageBuilder)
+	for name, pkg := range packageMap {
+		if pkg.isBuildable(c) {
+			buildablePackages[name] = pkg
+		}
+	}
+
+	if len(buildablePackages) == 0 {
+		return nil, &build.NoGoError{Dir: dir}
+	}
+
+	if len(buildablePackages) == 1 {
+		for _, pkg := range buildablePackages {
+			return pkg, nil
+		}
+	}
+
+	if pkg, ok := buildablePackages[defaultPackageName(c, dir)]; ok {
+		return pkg, nil
+	}
+
+	err := &build.MultiplePackageError{Dir: dir}
+	for name, pkg := range buildablePackages {
+		// Add the first file for each package for the error message.
+		// Error() method expects these lists to be the same length. File
+		// lists must be non-empty. These lists are only created by
+		// buildPackage for packages with .go files present.
+		err.Packages = append(err.Packages, name)
+		err.Files = append(err.Files, pkg.firstGoFile())
+	}
+	return nil, err
+}
+
+func defaultPackageName(c *config.Config, dir string) string {
+	if dir != c.RepoRoot {
+		return filepath.Base(dir)
+	}
+	name := path.Base(c.GoPrefix)
+	if name == "." || name == "/" {
+		// This can happen if go_prefix is empty or is all slashes.
+		return "unnamed"
+	}
+	return name
+}
+
+func findGenFiles(f *rule.File, excluded []string) []string {
+	var strs []string
+	for _, r := range f.Rules {
+		for _, key := range []string{"out", "outs"} {
+			if s := r.AttrString(key); s != "" {
+				strs = append(strs, s)
+			} else if ss := r.AttrStrings(key); len(ss) > 0 {
+				strs = append(strs, ss...)
+			}
+		}
+	}
+
+	var genFiles []string
+	for _, s := range strs {
+		if !isExcluded(excluded, s) {
+			genFiles = append(genFiles, s)
+		}
+	}
+	return genFiles
+}
+
+func findPbGoFiles(files []os.FileInfo, excluded []string) []string {
+	var pbGoFiles []string
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasSuffix(name, ".proto") && !isExcluded(excluded, name) {
+			pbGoFiles = append(pbGoFiles, name[:len(name)-len(".proto")]+".pb.go")
+		}
+	}
+	return pbGoFiles
+}
+
+func isBuildFileName(c *config.Config, base string) bool {
+	for _, name := range c.ValidBuildFileNames {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isExcluded(excluded []string, base string) bool {
+	for _, e := range excluded {
+		if base == e {
+			return true
+		}
+	}
+	return false
+}
+
+func excludedForSubdir(excluded []string, subdir string) []string {
+	var filtered []string
+	for _, e := range excluded {
+		i := strings.IndexByte(e, '/')
+		if i < 0 || i == len(e)-1 || e[:i] != subdir {
+			continue
+		}
+		filtered = append(filtered, e[i+1:])
+	}
+	return filtered
+}
+
+type symlinkResolver struct {
+	root    string
+	visited []string
+}
+
+// Decide if symlink dir/base should be followed.
+func (r *symlinkResolver) follow(dir, base string) bool {
+	if dir == r.root && strings.HasPrefix(base, "bazel-") {
+		// Links such as bazel-<workspace>, bazel-out, bazel-genfiles are created by
+		// Bazel to point to internal build directories.
+		return false
+	}
+	// See if the symlink points to a tree that has been already visited.
+	fullpath := filepath.Join(dir, base)
+	dest, err := filepath.EvalSymlinks(fullpath)
+	if err != nil {
+		return false
+	}
+	if !filepath.IsAbs(dest) {
+		dest, err = filepath.Abs(filepath.Join(dir, dest))
+		if err != nil {
+			return false
+		}
+	}
+	for _, p := range r.visited {
+		if pathtools.HasPrefix(dest, p) || pathtools.HasPrefix(p, dest) {
+			return false
+		}
+	}
+	r.visited = append(r.visited, dest)
+	stat, err := os.Stat(fullpath)
+	if err != nil {
+		return false
+	}
+	return stat.IsDir()
+}
@@ -24,9 +24,11 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
-	"github.com/bazelbuild/bazel-gazelle/internal/packages"
-	"github.com/bazelbuild/bazel-gazelle/internal/rule"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language/go"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
+	"github.com/bazelbuild/bazel-gazelle/packages"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
 func tempDir() (string, error) {
@@ -87,9 +89,13 @@ func createFiles(files []fileSpec) (string, error) {
 	return dir, nil
 }
 
+func testConfigurers() []config.Configurer {
+	return []config.Configurer{&config.CommonConfigurer{}, proto.New(), golang.New()}
+}
+
 func walkPackages(c *config.Config) []*packages.Package {
 	var pkgs []*packages.Package
-	packages.Walk(c, c.RepoRoot, func(_, _ string, _ *config.Config, pkg *packages.Package, _ *rule.File, _ bool) {
+	packages.Walk(c, testConfigurers(), c.RepoRoot, func(_, _ string, _ *config.Config, pkg *packages.Package, _ *rule.File, _, _, _ []string, _ bool) {
 		if pkg != nil {
 			pkgs = append(pkgs, pkg)
 		}
@@ -326,7 +332,7 @@ func TestVendorResetsPrefix(t *testing.T) {
 		ValidBuildFileNames: config.DefaultValidBuildFileNames,
 		GoPrefix:            basePrefix,
 	}
-	packages.Walk(c, c.RepoRoot, func(_, rel string, c *config.Config, _ *packages.Package, _ *rule.File, _ bool) {
+	packages.Walk(c, testConfigurers(), c.RepoRoot, func(_, rel string, c *config.Config, _ *packages.Package, _ *rule.File, _, _, _ []string, _ bool) {
 		if path.Base(rel) != "vendor" {
 			return
 		}
@@ -666,6 +672,65 @@ package exclude;
 	checkFiles(t, files, "example.com/repo", want)
 }
 
+func TestProtoFiles(t *testing.T) {
+	files := []fileSpec{
+		{
+			path:    "protos/BUILD",
+			content: "# gazelle:proto file\n",
+		},
+		{
+			path: "protos/foo.proto",
+			content: `syntax = "proto3";
+
+package example.foo;
+
+import "a.proto";
+import public "b.proto";
+import weak "c.proto";
+
+option java_package = "com.example.foo";
+
+message Foo {
+  message Bar {}
+}
+
+service FooService {}
+`,
+		},
+	}
+	want := []*packages.Package{
+		{
+			Name:       "protos",
+			Rel:        "protos",
+			ImportPath: "example.com/repo/protos",
+			Proto: packages.ProtoTarget{
+				Sources: rule.PlatformStrings{
+					Generic: []string{"foo.proto"},
+				},
+				Imports: rule.PlatformStrings{
+					Generic: []string{"a.proto", "b.proto", "c.proto"},
+				},
+				HasServices: true,
+				Files: []packages.ProtoFile{{
+					Name:        "foo.proto",
+					PackageName: "example.foo",
+					Imports:     []string{"a.proto", "b.proto", "c.proto"},
+					ImportStatements: []packages.ProtoImport{
+						{Path: "a.proto", Line: 5, Column: 1},
+						{Path: "b.proto", Public: true, Line: 6, Column: 1},
+						{Path: "c.proto", Weak: true, Line: 7, Column: 1},
+					},
+					Options:     map[string]string{"java_package": "com.example.foo"},
+					Messages:    []string{"Foo", "Foo.Bar"},
+					Services:    []string{"FooService"},
+					HasServices: true,
+				}},
+			},
+		},
+	}
+	checkFiles(t, files, "example.com/repo", want)
+}
+
 func TestLegacyProtos(t *testing.T) {
 	files := []fileSpec{
 		{
@@ -939,3 +1004,131 @@ func TestSymlinksDangling(t *testing.T) {
 	got := walkPackages(c)
 	checkPackages(t, got, want)
 }
+
+func TestWalkOrderAndConfig(t *testing.T) {
+	files := []fileSpec{
+		{path: "BUILD.bazel", content: "# gazelle:prefix example.com/repo"},
+		{path: "a/a.go", content: "package a"},
+		{path: "a/b/BUILD.bazel", content: "# gazelle:prefix example.com/b"},
+		{path: "a/b/c/c.go", content: "package c"},
+		{path: "a/b/d/d.go", content: "package d"},
+		{path: "a/e/e.go", content: "package e"},
+		{path: "f/g/h/h.go", content: "package h"},
+		{path: "f/i/i.go", content: "package i"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.Config{
+		RepoRoot:            dir,
+		Dirs:                []string{dir},
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+	}
+	want := []string{
+		"a/b/c example.com/b",
+		"a/b/d example.com/b",
+		"a/b example.com/b",
+		"a/e example.com/repo",
+		"a example.com/repo",
+		"f/g/h example.com/repo",
+		"f/g example.com/repo",
+		"f/i example.com/repo",
+		"f example.com/repo",
+		" example.com/repo",
+	}
+	for i := 0; i < 10; i++ {
+		var got []string
+		packages.Walk(c, testConfigurers(), dir, func(_, rel string, c *config.Config, _ *packages.Package, _ *rule.File, _, _, _ []string, _ bool) {
+			got = append(got, rel+" "+c.GoPrefix)
+		})
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d: got %q; want %q", i, got, want)
+		}
+	}
+}
+
+func TestWalkWithCache(t *testing.T) {
+	files := []fileSpec{
+		{path: "BUILD.bazel", content: "# gazelle:prefix example.com/repo"},
+		{path: "a/a.go", content: "package a"},
+		{path: "b/b.go", content: `package b; import _ "example.com/repo/a"`},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &config.Config{
+		RepoRoot:            dir,
+		Dirs:                []string{dir},
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+	}
+	cachePath := filepath.Join(dir, ".gazelle-cache")
+
+	// walk walks the tree with a freshly loaded cache and returns the
+	// directories that were updated. Updated directories are marked up to
+	// date, as if their build files had been written.
+	var cache *packages.Cache
+	walk := func(seed string) (updated []string, pkgs map[string]*packages.Package) {
+		var err error
+		cache, err = packages.LoadCache(cachePath, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkgs = make(map[string]*packages.Package)
+		packages.WalkWithCache(c, testConfigurers(), dir, cache, func(_, rel string, _ *config.Config, pkg *packages.Package, f *rule.File, _, _, _ []string, isUpdateDir bool) {
+			if !isUpdateDir {
+				return
+			}
+			updated = append(updated, rel)
+			pkgs[rel] = pkg
+			var content []byte
+			if f != nil {
+				if content, err = ioutil.ReadFile(f.Path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cache.MarkUpToDate(rel, content, nil, "")
+		})
+		if err := cache.Save(); err != nil {
+			t.Fatal(err)
+		}
+		return updated, pkgs
+	}
+
+	all := []string{"a", "b", ""}
+	if got, _ := walk("seed"); !reflect.DeepEqual(got, all) {
+		t.Errorf("first walk: got %q; want %q", got, all)
+	}
+	if got, _ := walk("seed"); len(got) != 0 {
+		t.Errorf("walk with no changes: got %q; want none", got)
+	}
+	if got := cache.SkippedDirs(); !reflect.DeepEqual(got, all) {
+		t.Errorf("walk with no changes: got skipped %q; want %q", got, all)
+	}
+
+	bPath := filepath.Join(dir, "b", "b.go")
+	if err := ioutil.WriteFile(bPath, []byte(`package b; import _ "example.com/repo/c/d"`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, pkgs := walk("seed")
+	if want := []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("walk after changing b: got %q; want %q", got, want)
+	} else if imports := pkgs["b"].Library.Imports.Generic; !reflect.DeepEqual(imports, []string{"example.com/repo/c/d"}) {
+		t.Errorf("walk after changing b: got imports %q; want %q", imports, "example.com/repo/c/d")
+	}
+
+	buildPath := filepath.Join(dir, "BUILD.bazel")
+	if err := ioutil.WriteFile(buildPath, []byte("# gazelle:prefix example.com/other"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := walk("seed"); !reflect.DeepEqual(got, all) {
+		t.Errorf("walk after changing directives: got %q; want %q", got, all)
+	}
+	if got, _ := walk("other seed"); !reflect.DeepEqual(got, all) {
+		t.Errorf("walk after changing seed: got %q; want %q", got, all)
+	}
+}
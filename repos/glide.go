@@ -0,0 +1,184 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repos
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+type glideProject struct {
+	name, version, repo, vcs string
+}
+
+func importRepoRulesGlide(filename string) ([]Repo, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	projects, err := parseGlideLock(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []Repo
+	rc := newLockFileRemoteCache()
+	for _, p := range projects {
+		repo := Repo{
+			Name:     label.ImportPathToBazelRepoName(p.name),
+			GoPrefix: p.name,
+			Commit:   p.version,
+			Remote:   p.repo,
+			VCS:      p.vcs,
+		}
+		if repo.Remote != "" && repo.VCS == "" {
+			// go_repository requires vcs with remote. Glide detects the version
+			// control system when it's not recorded, so we do the same.
+			repo.VCS, err = remoteVCS(rc, repo.Remote)
+			if err != nil {
+				log.Printf("%s: %s: could not determine version control system for %s; it will be fetched from its import path instead: %v", filename, p.name, repo.Remote, err)
+				repo.Remote = ""
+			}
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// newLockFileRemoteCache creates the RemoteCache used to find information
+// missing from lock files. Tests replace it to avoid network access.
+var newLockFileRemoteCache = func() *RemoteCache { return NewRemoteCache(nil) }
+
+var scpLikeURLRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+@[A-Za-z0-9.-]+:`)
+
+// remoteVCS determines the version control system used by the repository
+// at the given URL. Schemes and scp-like "user@host:path" URLs that are
+// specific to one system are recognized directly. Otherwise, the host and
+// path are looked up like an import path.
+func remoteVCS(rc *RemoteCache, remote string) (string, error) {
+	if scpLikeURLRe.MatchString(remote) {
+		return "git", nil
+	}
+	u, err := url.Parse(remote)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "git", "git+ssh":
+		return "git", nil
+	case "svn", "svn+ssh":
+		return "svn", nil
+	case "bzr", "bzr+ssh":
+		return "bzr", nil
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%q is not a URL", remote)
+	}
+	_, vcs, err := rc.Remote(path.Join(u.Host, u.Path))
+	return vcs, err
+}
+
+// parseGlideLock reads the projects listed in the "imports" and
+// "testImports" sections of a glide.lock file. glide.lock is YAML, but
+// Glide only writes a small, regular subset of it, so we parse it line
+// by line instead of depending on a YAML library.
+func parseGlideLock(data []byte) ([]glideProject, error) {
+	var projects []glideProject
+	var p *glideProject
+	inImports := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '-' {
+			// Top-level key.
+			key, _ := splitGlideKeyValue(trimmed)
+			inImports = key == "imports" || key == "testImports"
+			p = nil
+			continue
+		}
+		if !inImports {
+			continue
+		}
+
+		if strings.HasPrefix(line, "- ") {
+			// New project.
+			projects = append(projects, glideProject{})
+			p = &projects[len(projects)-1]
+			trimmed = strings.TrimSpace(line[len("- "):])
+		} else if !strings.HasPrefix(line, "  ") || strings.HasPrefix(line, "   ") || strings.HasPrefix(trimmed, "-") {
+			// Nested list, for example, subpackages.
+			continue
+		}
+		if p == nil {
+			return nil, fmt.Errorf("line %d: attribute outside of a project", lineNum)
+		}
+
+		key, value := splitGlideKeyValue(trimmed)
+		if uq, err := strconv.Unquote(value); err == nil {
+			value = uq
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		switch key {
+		case "name":
+			p.name = value
+		case "version":
+			p.version = value
+		case "repo":
+			p.repo = value
+		case "vcs":
+			p.vcs = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, p := range projects {
+		if p.name == "" {
+			return nil, fmt.Errorf("project with version %q has no name", p.version)
+		}
+	}
+	return projects, nil
+}
+
+func splitGlideKeyValue(s string) (key, value string) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return s, ""
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+}
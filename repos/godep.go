@@ -0,0 +1,50 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repos
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+type godepFile struct {
+	Deps []godepDependency `json:"Deps"`
+}
+
+type godepDependency struct {
+	ImportPath string `json:"ImportPath"`
+	Rev        string `json:"Rev"`
+}
+
+func importRepoRulesGodep(filename string) ([]Repo, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file godepFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	var pkgs []lockedPackage
+	for _, d := range file.Deps {
+		pkgs = append(pkgs, lockedPackage{
+			importPath: d.ImportPath,
+			revision:   d.Rev,
+		})
+	}
+	return reposFromPackages(pkgs), nil
+}
@@ -0,0 +1,504 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repos
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestImportDep(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestImportDep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lockFilename := filepath.Join(dir, "Gopkg.lock")
+	lockContent := []byte(`
+# This is an abbreviated version of dep's Gopkg.lock
+# Retrieved 2017-12-20
+
+[[projects]]
+  branch = "parse-constraints-with-dash-in-pre"
+  name = "github.com/Masterminds/semver"
+  packages = ["."]
+  revision = "a93e51b5a57ef416dac8bb02d11407b6f55d8929"
+  source = "https://github.com/carolynvs/semver.git"
+
+[[projects]]
+  name = "github.com/Masterminds/vcs"
+  packages = ["."]
+  revision = "3084677c2c188840777bff30054f2b553729d329"
+  version = "v1.11.1"
+
+[[projects]]
+  branch = "master"
+  name = "github.com/armon/go-radix"
+  packages = ["."]
+  revision = "4239b77079c7b5d1243b7b4736304ce8ddb6f0f2"
+
+[[projects]]
+  branch = "master"
+  name = "golang.org/x/net"
+  packages = ["context"]
+  revision = "66aacef3dd8a676686c7ae3716979581e8b03c47"
+
+[solve-meta]
+  analyzer-name = "dep"
+  analyzer-version = 1
+  inputs-digest = "05c1cd69be2c917c0cc4b32942830c2acfa044d8200fdc94716aae48a8083702"
+  solver-name = "gps-cdcl"
+  solver-version = 1
+`)
+	if err := ioutil.WriteFile(lockFilename, lockContent, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ImportRepoRules(lockFilename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := rule.EmptyFile("test", "")
+	for _, r := range rules {
+		r.Insert(f)
+	}
+	got := strings.TrimSpace(string(f.Format()))
+	want := strings.TrimSpace(`
+go_repository(
+    name = "com_github_armon_go_radix",
+    commit = "4239b77079c7b5d1243b7b4736304ce8ddb6f0f2",
+    importpath = "github.com/armon/go-radix",
+)
+
+go_repository(
+    name = "com_github_masterminds_semver",
+    commit = "a93e51b5a57ef416dac8bb02d11407b6f55d8929",
+    importpath = "github.com/Masterminds/semver",
+    remote = "https://github.com/carolynvs/semver.git",
+)
+
+go_repository(
+    name = "com_github_masterminds_vcs",
+    commit = "3084677c2c188840777bff30054f2b553729d329",
+    importpath = "github.com/Masterminds/vcs",
+)
+
+go_repository(
+    name = "org_golang_x_net",
+    commit = "66aacef3dd8a676686c7ae3716979581e8b03c47",
+    importpath = "golang.org/x/net",
+)
+`)
+	if got != want {
+		t.Errorf("got %s ; want %s", got, want)
+	}
+}
+
+func TestImportGlide(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestImportGlide")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lockFilename := filepath.Join(dir, "glide.lock")
+	lockContent := []byte(`
+hash: 1f3d0a8f4c1ae4d2d2e4b8e6e4b1c1cbd1a0e5c4b1c1cbd1a0e5c4b1c1cbd1a0
+updated: 2018-01-24T15:23:07.178468-05:00
+imports:
+- name: github.com/Masterminds/semver
+  version: a93e51b5a57ef416dac8bb02d11407b6f55d8929
+  repo: https://github.com/carolynvs/semver.git
+  vcs: git
+- name: example.com/inferred
+  version: 0123456789abcdef0123456789abcdef01234567
+  repo: https://example.com/repo
+- name: example.com/scp
+  version: 0123456789abcdef0123456789abcdef01234567
+  repo: git@example.com:scp/repo.git
+- name: example.com/unknown
+  version: 0123456789abcdef0123456789abcdef01234567
+  repo: https://unknown.example.org/repo
+- name: golang.org/x/net
+  version: 66aacef3dd8a676686c7ae3716979581e8b03c47
+  subpackages:
+  - context
+  - http2
+testImports:
+- name: github.com/stretchr/testify
+  version: "12b6f73e6084dad08a7c6e575284b177ecafbc71"
+  subpackages:
+  - assert
+`)
+	if err := ioutil.WriteFile(lockFilename, lockContent, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(old func() *RemoteCache) { newLockFileRemoteCache = old }(newLockFileRemoteCache)
+	newLockFileRemoteCache = func() *RemoteCache { return newStubRemoteCache(nil) }
+	rules, err := ImportRepoRules(lockFilename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := rule.EmptyFile("test", "")
+	for _, r := range rules {
+		r.Insert(f)
+	}
+	got := strings.TrimSpace(string(f.Format()))
+	want := strings.TrimSpace(`
+go_repository(
+    name = "com_example_inferred",
+    commit = "0123456789abcdef0123456789abcdef01234567",
+    importpath = "example.com/inferred",
+    remote = "https://example.com/repo",
+    vcs = "git",
+)
+
+go_repository(
+    name = "com_example_scp",
+    commit = "0123456789abcdef0123456789abcdef01234567",
+    importpath = "example.com/scp",
+    remote = "git@example.com:scp/repo.git",
+    vcs = "git",
+)
+
+go_repository(
+    name = "com_example_unknown",
+    commit = "0123456789abcdef0123456789abcdef01234567",
+    importpath = "example.com/unknown",
+)
+
+go_repository(
+    name = "com_github_masterminds_semver",
+    commit = "a93e51b5a57ef416dac8bb02d11407b6f55d8929",
+    importpath = "github.com/Masterminds/semver",
+    remote = "https://github.com/carolynvs/semver.git",
+    vcs = "git",
+)
+
+go_repository(
+    name = "com_github_stretchr_testify",
+    commit = "12b6f73e6084dad08a7c6e575284b177ecafbc71",
+    importpath = "github.com/stretchr/testify",
+)
+
+go_repository(
+    name = "org_golang_x_net",
+    commit = "66aacef3dd8a676686c7ae3716979581e8b03c47",
+    importpath = "golang.org/x/net",
+)
+`)
+	if got != want {
+		t.Errorf("got %s ; want %s", got, want)
+	}
+}
+
+func TestImportGovendor(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestImportGovendor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "vendor"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	lockFilename := filepath.Join(dir, "vendor", "vendor.json")
+	lockContent := []byte(`{
+	"comment": "",
+	"ignore": "test",
+	"package": [
+		{
+			"checksumSHA1": "abc=",
+			"origin": "github.com/carolynvs/semver",
+			"path": "github.com/Masterminds/semver",
+			"revision": "a93e51b5a57ef416dac8bb02d11407b6f55d8929",
+			"revisionTime": "2017-12-20T00:00:00Z"
+		},
+		{
+			"path": "example.com/custom/repo/a",
+			"revision": "0123456789abcdef0123456789abcdef01234567"
+		},
+		{
+			"path": "example.com/custom/repo/b/c",
+			"revision": "0123456789abcdef0123456789abcdef01234567"
+		},
+		{
+			"path": "golang.org/x/net/context",
+			"revision": "66aacef3dd8a676686c7ae3716979581e8b03c47"
+		},
+		{
+			"path": "golang.org/x/net/http2",
+			"revision": "66aacef3dd8a676686c7ae3716979581e8b03c47"
+		}
+	],
+	"rootPath": "example.com/project"
+}
+`)
+	if err := ioutil.WriteFile(lockFilename, lockContent, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ImportRepoRules(lockFilename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := rule.EmptyFile("test", "")
+	for _, r := range rules {
+		r.Insert(f)
+	}
+	got := strings.TrimSpace(string(f.Format()))
+	want := strings.TrimSpace(`
+go_repository(
+    name = "com_example_custom_repo",
+    commit = "0123456789abcdef0123456789abcdef01234567",
+    importpath = "example.com/custom/repo",
+)
+
+go_repository(
+    name = "com_github_masterminds_semver",
+    commit = "a93e51b5a57ef416dac8bb02d11407b6f55d8929",
+    importpath = "github.com/Masterminds/semver",
+    remote = "https://github.com/carolynvs/semver",
+    vcs = "git",
+)
+
+go_repository(
+    name = "org_golang_x_net",
+    commit = "66aacef3dd8a676686c7ae3716979581e8b03c47",
+    importpath = "golang.org/x/net",
+)
+`)
+	if got != want {
+		t.Errorf("got %s ; want %s", got, want)
+	}
+}
+
+func TestImportGodep(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestImportGodep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "Godeps"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	lockFilename := filepath.Join(dir, "Godeps", "Godeps.json")
+	lockContent := []byte(`{
+	"ImportPath": "example.com/project",
+	"GoVersion": "go1.9",
+	"GodepVersion": "v80",
+	"Deps": [
+		{
+			"ImportPath": "github.com/armon/go-radix",
+			"Rev": "4239b77079c7b5d1243b7b4736304ce8ddb6f0f2"
+		},
+		{
+			"ImportPath": "gopkg.in/yaml.v2",
+			"Comment": "v2.0.0",
+			"Rev": "eb3733d160e74a9c7e442f435eb3bea458e1d19f"
+		},
+		{
+			"ImportPath": "github.com/golang/protobuf/proto",
+			"Rev": "1e59b77b52bf8e4b449a57e6f79f21226d571845"
+		},
+		{
+			"ImportPath": "github.com/golang/protobuf/ptypes/any",
+			"Rev": "1e59b77b52bf8e4b449a57e6f79f21226d571845"
+		}
+	]
+}
+`)
+	if err := ioutil.WriteFile(lockFilename, lockContent, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ImportRepoRules(lockFilename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := rule.EmptyFile("test", "")
+	for _, r := range rules {
+		r.Insert(f)
+	}
+	got := strings.TrimSpace(string(f.Format()))
+	want := strings.TrimSpace(`
+go_repository(
+    name = "com_github_armon_go_radix",
+    commit = "4239b77079c7b5d1243b7b4736304ce8ddb6f0f2",
+    importpath = "github.com/armon/go-radix",
+)
+
+go_repository(
+    name = "com_github_golang_protobuf",
+    commit = "1e59b77b52bf8e4b449a57e6f79f21226d571845",
+    importpath = "github.com/golang/protobuf",
+)
+
+go_repository(
+    name = "in_gopkg_yaml_v2",
+    commit = "eb3733d160e74a9c7e442f435eb3bea458e1d19f",
+    importpath = "gopkg.in/yaml.v2",
+)
+`)
+	if got != want {
+		t.Errorf("got %s ; want %s", got, want)
+	}
+}
+
+func TestImportModules(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestImportModules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	modFilename := filepath.Join(dir, "mod", "go.mod")
+	if err := os.Mkdir(filepath.Join(dir, "mod"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	modContent := []byte(`
+module example.com/repo
+
+require (
+	github.com/pkg/errors v0.8.0
+	golang.org/x/net v0.0.0-20180406214816-61147c48b25b // indirect
+	github.com/old/fork v1.2.0
+	"github.com/local/dep" v1.0.0
+	github.com/outside/dep v1.0.0
+	github.com/excluded/dep v0.1.0
+	gopkg.in/yaml.v2 v2.1.0+incompatible
+)
+
+require github.com/single/line v1.0.1
+
+require github.com/no/sum v1.1.0
+
+exclude github.com/excluded/dep v0.1.0
+
+replace github.com/old/fork => github.com/new/fork v1.2.1-0.20180101000000-0123456789ab
+
+replace (
+	github.com/local/dep => ./dep
+	github.com/outside/dep => ../../outside
+)
+`)
+	if err := ioutil.WriteFile(modFilename, modContent, 0666); err != nil {
+		t.Fatal(err)
+	}
+	sumContent := []byte(`github.com/new/fork v1.2.1-0.20180101000000-0123456789ab h1:fork=
+github.com/pkg/errors v0.8.0 h1:WdK/asTD0HN+q6hsWO3/vpuAkAr+tw6aNJNDFFf0+qw=
+github.com/pkg/errors v0.8.0/go.mod h1:bwawxfHBFNV+L2hUp1rHADufV3IMtnDRdf1r5NINEl0=
+github.com/single/line v1.0.1 h1:single=
+golang.org/x/net v0.0.0-20180406214816-61147c48b25b h1:net=
+gopkg.in/yaml.v2 v2.1.0+incompatible h1:yaml=
+`)
+	if err := ioutil.WriteFile(filepath.Join(dir, "mod", "go.sum"), sumContent, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ImportRepoRules(modFilename, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := rule.EmptyFile("test", "")
+	for _, r := range rules {
+		r.Insert(f)
+	}
+	got := strings.TrimSpace(string(f.Format()))
+	outside := filepath.ToSlash(filepath.Join(filepath.Dir(dir), "outside"))
+	want := strings.TrimSpace(strings.Replace(`
+local_repository(
+    name = "com_github_local_dep",
+    path = "mod/dep",
+)
+
+go_repository(
+    name = "com_github_no_sum",
+    importpath = "github.com/no/sum",
+    tag = "v1.1.0",
+)
+
+go_repository(
+    name = "com_github_old_fork",
+    importpath = "github.com/old/fork",
+    replace = "github.com/new/fork",
+    sum = "h1:fork=",
+    version = "v1.2.1-0.20180101000000-0123456789ab",
+)
+
+local_repository(
+    name = "com_github_outside_dep",
+    path = "OUTSIDE",
+)
+
+go_repository(
+    name = "com_github_pkg_errors",
+    importpath = "github.com/pkg/errors",
+    sum = "h1:WdK/asTD0HN+q6hsWO3/vpuAkAr+tw6aNJNDFFf0+qw=",
+    version = "v0.8.0",
+)
+
+go_repository(
+    name = "com_github_single_line",
+    importpath = "github.com/single/line",
+    sum = "h1:single=",
+    version = "v1.0.1",
+)
+
+go_repository(
+    name = "in_gopkg_yaml_v2",
+    importpath = "gopkg.in/yaml.v2",
+    sum = "h1:yaml=",
+    version = "v2.1.0+incompatible",
+)
+
+go_repository(
+    name = "org_golang_x_net",
+    importpath = "golang.org/x/net",
+    sum = "h1:net=",
+    version = "v0.0.0-20180406214816-61147c48b25b",
+)
+`, "OUTSIDE", outside, 1))
+	if got != want {
+		t.Errorf("got %s ; want %s", got, want)
+	}
+}
+
+func TestParseGoModErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc, content string
+	}{
+		{
+			desc:    "require_no_version",
+			content: "require example.com/foo",
+		}, {
+			desc:    "replace_no_arrow",
+			content: "replace example.com/foo v1.0.0 example.com/bar v1.0.0",
+		}, {
+			desc:    "replace_module_no_version",
+			content: "replace example.com/foo => example.com/bar",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := parseGoMod("go.mod", []byte(tc.content)); err == nil {
+				t.Error("got success ; want error")
+			}
+		})
+	}
+}
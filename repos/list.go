@@ -0,0 +1,302 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repos
+
+import (
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// ListRepositories extracts metadata about repositories declared in a
+// WORKSPACE file.
+//
+// go_repository rules are read directly. For git_repository,
+// new_git_repository, http_archive, new_http_archive, and local_repository
+// rules, the Go prefix is read from an "importpath" attribute if there is one.
+// Otherwise, it's inferred from the "remote" or "urls" attributes for
+// well-known hosts like github.com. Repositories may also be described with
+// directives like:
+//
+//	# gazelle:repository go_repository name=org_golang_x_net importpath=golang.org/x/net
+//
+// Macros called from WORKSPACE that are loaded from .bzl files in the main
+// repository are followed statically, so repositories declared in them are
+// included. Repositories declared in macros from other repositories, or
+// declared in ways that require evaluating the file, are not.
+func ListRepositories(workspace *rule.File) []Repo {
+	l := &repoLister{
+		repoRoot: filepath.Dir(workspace.Path),
+		visited:  make(map[string]bool),
+		data:     make(map[string][]byte),
+		index:    make(map[string]int),
+	}
+	l.listFile(workspace, "")
+	return l.repos
+}
+
+// repoLister accumulates repositories declared in WORKSPACE and in macros
+// it calls.
+type repoLister struct {
+	repoRoot string
+
+	// visited is the set of macros that have been followed, as keys like
+	// "pkg/file.bzl%name". This prevents cycles.
+	visited map[string]bool
+
+	// data caches the contents of .bzl files, keyed by file system path.
+	data map[string][]byte
+
+	repos []Repo
+
+	// index maps repository names to indices in repos.
+	index map[string]int
+}
+
+// listFile adds repositories declared by rules in f, which is either
+// WORKSPACE or a macro in a .bzl file in the package pkg. Macros called from
+// f are followed.
+func (l *repoLister) listFile(f *rule.File, pkg string) {
+	// Map names of loaded symbols to the labels of the files defining them.
+	loaded := make(map[string]label.Label)
+	for _, ld := range f.Loads {
+		lbl, err := label.Parse(ld.Name())
+		if err != nil || lbl.Repo != "" {
+			continue
+		}
+		lbl = lbl.Abs("", pkg)
+		for _, sym := range ld.Symbols() {
+			loaded[sym] = lbl
+		}
+	}
+
+	// Macros may call other macros defined in the same file.
+	defined := make(map[string]bool)
+	if f.DefName != "" {
+		for _, stmt := range f.File.Stmt {
+			if def, ok := stmt.(*bzl.FuncDef); ok {
+				defined[def.Name] = true
+			}
+		}
+	}
+
+	for _, r := range f.Rules {
+		if repo, ok := repoFromRule(r); ok {
+			l.add(repo)
+			continue
+		}
+		if lbl, ok := loaded[r.Kind()]; ok {
+			l.followMacro(lbl.Pkg, lbl.Name, r.Kind())
+		} else if defined[r.Kind()] {
+			l.followMacro(pkg, filepath.Base(f.Path), r.Kind())
+		}
+	}
+
+	for _, d := range f.Directives {
+		if d.Key != "repository" {
+			continue
+		}
+		if repo, ok := repoFromDirective(d); ok {
+			l.add(repo)
+		} else {
+			log.Printf("%s: could not parse directive: gazelle:%s %s", f.Path, d.Key, d.Value)
+		}
+	}
+}
+
+// followMacro lists repositories declared in the function defName in the
+// file name in the package pkg.
+func (l *repoLister) followMacro(pkg, name, defName string) {
+	key := path.Join(pkg, name) + "%" + defName
+	if l.visited[key] {
+		return
+	}
+	l.visited[key] = true
+
+	bzlPath := filepath.Join(l.repoRoot, filepath.FromSlash(pkg), name)
+	data, ok := l.data[bzlPath]
+	if !ok {
+		var err error
+		data, err = ioutil.ReadFile(bzlPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Print(err)
+			}
+			data = nil
+		}
+		l.data[bzlPath] = data
+	}
+	if data == nil {
+		return
+	}
+	f, err := rule.LoadMacroData(bzlPath, pkg, defName, data)
+	if err != nil {
+		log.Printf("%s: %v", bzlPath, err)
+		return
+	}
+	l.listFile(f, pkg)
+}
+
+// add adds a repository to the list. If a repository with the same name
+// was already listed, it is replaced.
+func (l *repoLister) add(repo Repo) {
+	if i, ok := l.index[repo.Name]; ok {
+		l.repos[i] = repo
+		return
+	}
+	l.index[repo.Name] = len(l.repos)
+	l.repos = append(l.repos, repo)
+}
+
+// repoFromRule returns metadata about the repository declared by r, if r
+// is a repository rule with a known Go prefix.
+func repoFromRule(r *rule.Rule) (Repo, bool) {
+	name := r.Name()
+	if name == "" {
+		return Repo{}, false
+	}
+	switch r.Kind() {
+	case "go_repository":
+		// TODO(jayconrod): extract other fields needed by go_repository.
+		// Currently, we don't use the result of this function to produce new
+		// go_repository rules, so it doesn't matter.
+		goPrefix := r.AttrString("importpath")
+		if goPrefix == "" {
+			return Repo{}, false
+		}
+		return Repo{
+			Name:     name,
+			GoPrefix: goPrefix,
+			Commit:   r.AttrString("commit"),
+			Remote:   r.AttrString("remote"),
+			VCS:      r.AttrString("vcs"),
+		}, true
+
+	case "git_repository", "new_git_repository":
+		goPrefix := r.AttrString("importpath")
+		if goPrefix == "" {
+			goPrefix = importPathFromURL(r.AttrString("remote"))
+		}
+		if goPrefix == "" {
+			return Repo{}, false
+		}
+		return Repo{
+			Name:     name,
+			GoPrefix: goPrefix,
+			Commit:   r.AttrString("commit"),
+			Tag:      r.AttrString("tag"),
+			Remote:   r.AttrString("remote"),
+			VCS:      "git",
+		}, true
+
+	case "http_archive", "new_http_archive":
+		goPrefix := r.AttrString("importpath")
+		if goPrefix == "" {
+			urls := r.AttrStrings("urls")
+			if u := r.AttrString("url"); u != "" {
+				urls = append(urls, u)
+			}
+			for _, u := range urls {
+				if goPrefix = importPathFromURL(u); goPrefix != "" {
+					break
+				}
+			}
+		}
+		if goPrefix == "" {
+			return Repo{}, false
+		}
+		return Repo{Name: name, GoPrefix: goPrefix, VCS: "http"}, true
+
+	case "local_repository":
+		goPrefix := r.AttrString("importpath")
+		if goPrefix == "" {
+			return Repo{}, false
+		}
+		return Repo{
+			Name:      name,
+			GoPrefix:  goPrefix,
+			LocalPath: r.AttrString("path"),
+		}, true
+
+	default:
+		return Repo{}, false
+	}
+}
+
+// repoFromDirective parses a repository directive of the form
+// "kind name=value importpath=value ...". Other attributes are ignored.
+func repoFromDirective(d config.Directive) (Repo, bool) {
+	fields := strings.Fields(d.Value)
+	if len(fields) < 1 {
+		return Repo{}, false
+	}
+	attrs := make(map[string]string)
+	for _, f := range fields[1:] {
+		i := strings.IndexByte(f, '=')
+		if i < 0 {
+			return Repo{}, false
+		}
+		attrs[f[:i]] = f[i+1:]
+	}
+	if attrs["name"] == "" || attrs["importpath"] == "" {
+		return Repo{}, false
+	}
+	repo := Repo{
+		Name:     attrs["name"],
+		GoPrefix: attrs["importpath"],
+		Commit:   attrs["commit"],
+		Tag:      attrs["tag"],
+		Remote:   attrs["remote"],
+		VCS:      attrs["vcs"],
+	}
+	if fields[0] == "local_repository" {
+		repo.LocalPath = attrs["path"]
+	}
+	return repo, true
+}
+
+// importPathFromURL infers the Go import path of a repository from a URL
+// where it is hosted. Only well-known hosts with predictable URL structure
+// are recognized; "" is returned for other URLs.
+func importPathFromURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(u.Host)
+	if host == "codeload.github.com" {
+		host = "github.com"
+	}
+	switch host {
+	case "github.com", "gitlab.com", "bitbucket.org":
+	default:
+		return ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return ""
+	}
+	return host + "/" + parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")
+}
@@ -0,0 +1,241 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repos
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestListRepositories(t *testing.T) {
+	for _, tc := range []struct {
+		desc, workspace string
+		want            []Repo
+	}{
+		{
+			desc: "empty",
+			want: nil,
+		}, {
+			desc: "go_repository",
+			workspace: `
+go_repository(
+    name = "custom_repo",
+    commit = "123456",
+    remote = "https://example.com/repo",
+    importpath = "example.com/repo",
+)
+`,
+			want: []Repo{{
+				Name:     "custom_repo",
+				GoPrefix: "example.com/repo",
+				Remote:   "https://example.com/repo",
+				Commit:   "123456",
+			}},
+		}, {
+			desc: "git_repository",
+			workspace: `
+git_repository(
+    name = "com_github_foo_bar",
+    remote = "https://github.com/foo/bar.git",
+    tag = "v1.0.0",
+)
+
+git_repository(
+    name = "custom",
+    importpath = "example.com/custom",
+    remote = "https://example.com/custom.git",
+    commit = "123456",
+)
+
+git_repository(
+    name = "unknown",
+    remote = "https://example.com/unknown.git",
+)
+`,
+			want: []Repo{
+				{
+					Name:     "com_github_foo_bar",
+					GoPrefix: "github.com/foo/bar",
+					Remote:   "https://github.com/foo/bar.git",
+					Tag:      "v1.0.0",
+					VCS:      "git",
+				}, {
+					Name:     "custom",
+					GoPrefix: "example.com/custom",
+					Remote:   "https://example.com/custom.git",
+					Commit:   "123456",
+					VCS:      "git",
+				},
+			},
+		}, {
+			desc: "http_archive",
+			workspace: `
+http_archive(
+    name = "io_bazel_rules_go",
+    urls = ["https://github.com/bazelbuild/rules_go/releases/download/0.10.0/rules_go-0.10.0.tar.gz"],
+)
+
+http_archive(
+    name = "com_gitlab_foo_bar",
+    url = "https://gitlab.com/foo/bar/-/archive/v1/bar-v1.tar.gz",
+)
+`,
+			want: []Repo{
+				{
+					Name:     "io_bazel_rules_go",
+					GoPrefix: "github.com/bazelbuild/rules_go",
+					VCS:      "http",
+				}, {
+					Name:     "com_gitlab_foo_bar",
+					GoPrefix: "gitlab.com/foo/bar",
+					VCS:      "http",
+				},
+			},
+		}, {
+			desc: "local_repository",
+			workspace: `
+local_repository(
+    name = "local",
+    path = "../local",
+    importpath = "example.com/local",
+)
+
+local_repository(
+    name = "no_importpath",
+    path = "../other",
+)
+`,
+			want: []Repo{{
+				Name:      "local",
+				GoPrefix:  "example.com/local",
+				LocalPath: "../local",
+			}},
+		}, {
+			desc: "directive",
+			workspace: `
+# gazelle:repository go_repository name=org_golang_x_net importpath=golang.org/x/net
+# gazelle:repository git_repository name=custom importpath=example.com/custom vcs=git
+
+git_repository(
+    name = "custom",
+    remote = "https://example.com/custom.git",
+)
+`,
+			want: []Repo{
+				{
+					Name:     "org_golang_x_net",
+					GoPrefix: "golang.org/x/net",
+				}, {
+					Name:     "custom",
+					GoPrefix: "example.com/custom",
+					VCS:      "git",
+				},
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			workspace, err := rule.LoadData("WORKSPACE", "", []byte(tc.workspace))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ListRepositories(workspace); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v ; want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestListRepositoriesMacros(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestListRepositoriesMacros")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"WORKSPACE": `
+load("//third_party:deps.bzl", "go_deps")
+load("@io_bazel_rules_go//go:def.bzl", "go_rules_dependencies")
+
+go_rules_dependencies()
+
+go_deps()
+
+go_repository(
+    name = "org_golang_x_net",
+    importpath = "golang.org/x/net",
+)
+`,
+		"third_party/deps.bzl": `
+load(":more.bzl", "more_deps")
+
+def go_deps():
+    go_repository(
+        name = "org_golang_x_net",
+        importpath = "golang.org/x/net",
+        commit = "overridden",
+    )
+    more_deps()
+    local_deps()
+
+def local_deps():
+    go_repository(
+        name = "com_github_foo_bar",
+        importpath = "github.com/foo/bar",
+    )
+    go_deps()
+`,
+		"third_party/more.bzl": `
+def more_deps():
+    git_repository(
+        name = "com_github_more_deps",
+        remote = "https://github.com/more/deps",
+    )
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	workspace, err := rule.LoadFile(filepath.Join(dir, "WORKSPACE"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ListRepositories(workspace)
+	want := []Repo{
+		{Name: "org_golang_x_net", GoPrefix: "golang.org/x/net"},
+		{
+			Name:     "com_github_more_deps",
+			GoPrefix: "github.com/more/deps",
+			Remote:   "https://github.com/more/deps",
+			VCS:      "git",
+		},
+		{Name: "com_github_foo_bar", GoPrefix: "github.com/foo/bar"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v ; want %#v", got, want)
+	}
+}
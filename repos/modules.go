@@ -0,0 +1,234 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repos
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// goModFile contains the directives in a go.mod file that are relevant to
+// importing repositories.
+type goModFile struct {
+	requires []moduleVersion
+	replaces []moduleReplace
+	excludes map[moduleVersion]bool
+}
+
+// moduleVersion identifies a module at a specific version. Version may be
+// empty in the left side of a replace directive, which matches all versions.
+type moduleVersion struct {
+	path, version string
+}
+
+type moduleReplace struct {
+	old, new moduleVersion
+}
+
+func importRepoRulesModules(filename string) ([]Repo, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	mod, err := parseGoMod(filename, data)
+	if err != nil {
+		return nil, err
+	}
+
+	sumFilename := filepath.Join(filepath.Dir(filename), "go.sum")
+	sums, err := readGoSum(sumFilename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var repos []Repo
+	for _, req := range mod.requires {
+		if mod.excludes[req] {
+			log.Printf("%s: %s@%s is required but excluded; skipping", filename, req.path, req.version)
+			continue
+		}
+		target := req
+		for _, r := range mod.replaces {
+			if r.old.path == req.path && (r.old.version == "" || r.old.version == req.version) {
+				target = r.new
+			}
+		}
+
+		repo := Repo{
+			Name:     label.ImportPathToBazelRepoName(req.path),
+			GoPrefix: req.path,
+		}
+		if isLocalModulePath(target.path) {
+			// Local paths in go.mod are relative to the directory containing
+			// go.mod. ImportRepoRules makes them relative to the repository root.
+			repo.LocalPath = target.path
+			if !filepath.IsAbs(repo.LocalPath) {
+				repo.LocalPath = filepath.Join(filepath.Dir(filename), filepath.FromSlash(target.path))
+			}
+			repos = append(repos, repo)
+			continue
+		}
+
+		repo.Version = target.version
+		repo.Commit, repo.Tag = moduleVersionToRevision(target.version)
+		if target.path != req.path {
+			// The replacement is another module, most likely a fork. It is
+			// downloaded from the module proxy by its own path. Its location
+			// and version control system can't be derived from the path.
+			repo.Replace = target.path
+		}
+		if sums != nil {
+			if sum, ok := sums[target]; ok {
+				repo.Sum = sum
+			} else {
+				log.Printf("%s: no checksum for %s@%s in %s", filename, target.path, target.version, sumFilename)
+			}
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// parseGoMod extracts require, replace, and exclude directives from the
+// contents of a go.mod file. Both the single-line form and the
+// parenthesized block form of each directive are understood.
+func parseGoMod(filename string, data []byte) (*goModFile, error) {
+	mod := &goModFile{excludes: make(map[moduleVersion]bool)}
+	block := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.Trim(fields[i], "`\"")
+		}
+
+		var verb string
+		var args []string
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			verb, args = block, fields
+		} else {
+			verb, args = fields[0], fields[1:]
+			if len(args) == 1 && args[0] == "(" {
+				block = verb
+				continue
+			}
+		}
+
+		switch verb {
+		case "require", "exclude":
+			if len(args) != 2 {
+				return nil, fmt.Errorf("%s:%d: usage: %s module/path version", filename, lineNum, verb)
+			}
+			mv := moduleVersion{args[0], args[1]}
+			if verb == "require" {
+				mod.requires = append(mod.requires, mv)
+			} else {
+				mod.excludes[mv] = true
+			}
+		case "replace":
+			arrow := -1
+			for i, a := range args {
+				if a == "=>" {
+					arrow = i
+					break
+				}
+			}
+			if arrow < 1 || arrow > 2 || len(args)-arrow-1 < 1 || len(args)-arrow-1 > 2 {
+				return nil, fmt.Errorf("%s:%d: usage: replace module/path [version] => other/module/path [version]", filename, lineNum)
+			}
+			var r moduleReplace
+			r.old.path = args[0]
+			if arrow == 2 {
+				r.old.version = args[1]
+			}
+			r.new.path = args[arrow+1]
+			if len(args) == arrow+3 {
+				r.new.version = args[arrow+2]
+			} else if !isLocalModulePath(r.new.path) {
+				return nil, fmt.Errorf("%s:%d: replacement module %s must have a version", filename, lineNum, r.new.path)
+			}
+			mod.replaces = append(mod.replaces, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mod, nil
+}
+
+// readGoSum reads a go.sum file and returns a map from module versions to
+// hashes of their content. Hashes of go.mod files are not included.
+func readGoSum(filename string) (map[moduleVersion]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[moduleVersion]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: malformed line", filename, i+1)
+		}
+		if strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		sums[moduleVersion{fields[0], fields[1]}] = fields[2]
+	}
+	return sums, nil
+}
+
+// isLocalModulePath returns whether the target of a replace directive is
+// a directory on the local file system rather than a module path.
+func isLocalModulePath(p string) bool {
+	return strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") || filepath.IsAbs(p) || p == "." || p == ".."
+}
+
+var pseudoVersionRe = regexp.MustCompile(`[-.]\d{14}-([0-9a-f]{12})$`)
+
+// moduleVersionToRevision converts a module version into a commit or a tag
+// that can be checked out from a version control repository. Pseudo-versions
+// like v0.0.0-20180405011132-0123456789ab name a commit. Other versions
+// are assumed to correspond to tags.
+func moduleVersionToRevision(version string) (commit, tag string) {
+	version = strings.TrimSuffix(version, "+incompatible")
+	if m := pseudoVersionRe.FindStringSubmatch(version); m != nil {
+		return m[1], ""
+	}
+	return "", version
+}
@@ -0,0 +1,374 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repos
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModuleProxy retrieves information about Go modules from a module proxy.
+// A module proxy is a server that implements the GOPROXY protocol, which
+// serves these paths relative to its base URL:
+//
+//	<module>/@v/list             newline-separated list of known versions
+//	<module>/@latest             JSON ModuleInfo for the latest version
+//	<module>/@v/<version>.info   JSON ModuleInfo for a version
+//	<module>/@v/<version>.mod    go.mod file for a version
+//	<module>/@v/<version>.zip    zip file containing a version's files
+//
+// Module paths and versions are escaped in URLs by replacing each upper case
+// letter with '!' followed by the corresponding lower case letter.
+//
+// A proxy may also be a directory on the local file system with the same
+// layout (for example, a module download cache). This is useful for tests.
+type ModuleProxy struct {
+	// base is the URL of the proxy without a trailing slash. It is only set
+	// for HTTP proxies.
+	base string
+
+	// dir is the root directory of a file proxy. It is only set for file
+	// proxies.
+	dir string
+
+	// Client is used to send requests to HTTP proxies. It is
+	// http.DefaultClient by default.
+	Client *http.Client
+}
+
+// ModuleInfo describes a version of a module. It is the JSON object returned
+// by the .info and @latest proxy endpoints.
+type ModuleInfo struct {
+	Version string
+	Time    time.Time
+}
+
+// NewModuleProxy returns a ModuleProxy for the given URL. http and https
+// URLs refer to proxy servers. file URLs and plain paths refer to
+// directories on the local file system.
+func NewModuleProxy(proxyURL string) (*ModuleProxy, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid module proxy URL %q: %v", proxyURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &ModuleProxy{
+			base:   strings.TrimSuffix(proxyURL, "/"),
+			Client: http.DefaultClient,
+		}, nil
+	case "file":
+		return &ModuleProxy{dir: filepath.FromSlash(u.Path)}, nil
+	case "":
+		dir, err := filepath.Abs(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		return &ModuleProxy{dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("invalid module proxy URL %q: unsupported scheme %q", proxyURL, u.Scheme)
+	}
+}
+
+// List returns the versions of a module known to the proxy, in the order
+// they were listed. An empty list is returned if the proxy knows about the
+// module but has no tagged versions.
+func (p *ModuleProxy) List(modPath string) ([]string, error) {
+	data, err := p.readFile(modPath, "@v/list")
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if f := strings.Fields(line); len(f) > 0 {
+			versions = append(versions, f[0])
+		}
+	}
+	return versions, nil
+}
+
+// Latest returns the latest version of a module. This is the highest release
+// version in the proxy's list if there is one, then the highest pre-release
+// version. If the list is empty, the proxy's @latest endpoint is used.
+func (p *ModuleProxy) Latest(modPath string) (ModuleInfo, error) {
+	versions, err := p.List(modPath)
+	if err != nil && !IsModuleNotFound(err) {
+		return ModuleInfo{}, err
+	}
+	if latest := maxModuleVersion(versions); latest != "" {
+		return ModuleInfo{Version: latest}, nil
+	}
+	data, err := p.readFile(modPath, "@latest")
+	if err != nil {
+		return ModuleInfo{}, err
+	}
+	return parseModuleInfo(modPath, data)
+}
+
+// Info returns information about a version of a module.
+func (p *ModuleProxy) Info(modPath, version string) (ModuleInfo, error) {
+	ev, err := escapeModulePath(version)
+	if err != nil {
+		return ModuleInfo{}, err
+	}
+	data, err := p.readFile(modPath, "@v/"+ev+".info")
+	if err != nil {
+		return ModuleInfo{}, err
+	}
+	return parseModuleInfo(modPath, data)
+}
+
+// GoMod returns the content of the go.mod file for a version of a module.
+func (p *ModuleProxy) GoMod(modPath, version string) ([]byte, error) {
+	ev, err := escapeModulePath(version)
+	if err != nil {
+		return nil, err
+	}
+	return p.readFile(modPath, "@v/"+ev+".mod")
+}
+
+// Zip opens the zip file containing a version of a module. The caller
+// must close the returned reader.
+func (p *ModuleProxy) Zip(modPath, version string) (io.ReadCloser, error) {
+	ev, err := escapeModulePath(version)
+	if err != nil {
+		return nil, err
+	}
+	return p.open(modPath, "@v/"+ev+".zip")
+}
+
+// String returns the URL or directory of the proxy.
+func (p *ModuleProxy) String() string {
+	if p.dir != "" {
+		return p.dir
+	}
+	return p.base
+}
+
+// moduleNotFoundError is returned by ModuleProxy methods when the proxy
+// does not have the requested module or version.
+type moduleNotFoundError struct {
+	modPath, file string
+}
+
+func (e moduleNotFoundError) Error() string {
+	return fmt.Sprintf("module %s: %s not found in module proxy", e.modPath, e.file)
+}
+
+// IsModuleNotFound returns whether err was returned by a ModuleProxy because
+// the proxy does not know about a requested module or version.
+func IsModuleNotFound(err error) bool {
+	_, ok := err.(moduleNotFoundError)
+	return ok
+}
+
+func (p *ModuleProxy) readFile(modPath, file string) ([]byte, error) {
+	r, err := p.open(modPath, file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (p *ModuleProxy) open(modPath, file string) (io.ReadCloser, error) {
+	ep, err := escapeModulePath(modPath)
+	if err != nil {
+		return nil, err
+	}
+	rel := ep + "/" + file
+
+	if p.dir != "" {
+		f, err := os.Open(filepath.Join(p.dir, filepath.FromSlash(rel)))
+		if os.IsNotExist(err) {
+			return nil, moduleNotFoundError{modPath, file}
+		}
+		return f, err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(p.base + "/" + rel)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		resp.Body.Close()
+		return nil, moduleNotFoundError{modPath, file}
+	case resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+		return nil, fmt.Errorf("module %s: fetching %s from %s: %s", modPath, file, p.base, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func parseModuleInfo(modPath string, data []byte) (ModuleInfo, error) {
+	var info ModuleInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return ModuleInfo{}, fmt.Errorf("module %s: invalid version information: %v", modPath, err)
+	}
+	if info.Version == "" {
+		return ModuleInfo{}, fmt.Errorf("module %s: version information has no version", modPath)
+	}
+	return info, nil
+}
+
+// escapeModulePath escapes a module path or version for use in a proxy URL
+// or file name. Each upper case letter is replaced with '!' followed by
+// the corresponding lower case letter.
+func escapeModulePath(s string) (string, error) {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '!' || r >= 0x80:
+			return "", fmt.Errorf("invalid character %q in module path or version %q", r, s)
+		case 'A' <= r && r <= 'Z':
+			b.WriteByte('!')
+			b.WriteRune(r + 'a' - 'A')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), nil
+}
+
+// maxModuleVersion returns the highest semantic version in a list. Release
+// versions are preferred over pre-release versions. Strings that are not
+// valid semantic versions are ignored. "" is returned if there are no
+// valid versions.
+func maxModuleVersion(versions []string) string {
+	max := ""
+	var maxVersion semver
+	for _, v := range versions {
+		sv, ok := parseSemver(v)
+		if !ok {
+			continue
+		}
+		var better bool
+		switch {
+		case max == "":
+			better = true
+		case (sv.pre == "") != (maxVersion.pre == ""):
+			better = sv.pre == ""
+		default:
+			better = compareSemver(sv, maxVersion) > 0
+		}
+		if better {
+			max, maxVersion = v, sv
+		}
+	}
+	return max
+}
+
+type semver struct {
+	major, minor, patch int
+	pre                 string
+}
+
+func parseSemver(v string) (semver, bool) {
+	if !strings.HasPrefix(v, "v") {
+		return semver{}, false
+	}
+	v = v[1:]
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+	var sv semver
+	if i := strings.Index(v, "-"); i >= 0 {
+		v, sv.pre = v[:i], v[i+1:]
+		if sv.pre == "" {
+			return semver{}, false
+		}
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+	nums := []*int{&sv.major, &sv.minor, &sv.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (len(part) > 1 && part[0] == '0') {
+			return semver{}, false
+		}
+		*nums[i] = n
+	}
+	return sv, true
+}
+
+// compareSemver returns -1, 0, or 1 if a is less than, equal to, or greater
+// than b, following the precedence rules in semver.org section 11.
+func compareSemver(a, b semver) int {
+	for _, c := range [][2]int{{a.major, b.major}, {a.minor, b.minor}, {a.patch, b.patch}} {
+		if c[0] < c[1] {
+			return -1
+		} else if c[0] > c[1] {
+			return 1
+		}
+	}
+	switch {
+	case a.pre == b.pre:
+		return 0
+	case a.pre == "":
+		return 1
+	case b.pre == "":
+		return -1
+	}
+	ap := strings.Split(a.pre, ".")
+	bp := strings.Split(b.pre, ".")
+	for i := 0; i < len(ap) && i < len(bp); i++ {
+		if ap[i] == bp[i] {
+			continue
+		}
+		an, aerr := strconv.Atoi(ap[i])
+		bn, berr := strconv.Atoi(bp[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		case ap[i] < bp[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch {
+	case len(ap) < len(bp):
+		return -1
+	case len(ap) > len(bp):
+		return 1
+	default:
+		return 0
+	}
+}
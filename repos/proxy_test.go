@@ -0,0 +1,228 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repos
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeProxyDir creates a file proxy in a temporary directory. The caller
+// should remove the directory when done.
+func writeProxyDir(t *testing.T) string {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"github.com/!masterminds/semver/@v/list":        "v1.3.0\nv1.4.0\nv1.5.0-rc.1\n",
+		"github.com/!masterminds/semver/@v/v1.4.0.info": `{"Version":"v1.4.0","Time":"2017-10-10T00:00:00Z"}`,
+		"github.com/!masterminds/semver/@v/v1.4.0.mod":  "module github.com/Masterminds/semver\n",
+		"github.com/!masterminds/semver/@v/v1.4.0.zip":  "not really a zip",
+		"example.com/pseudo/@v/list":                    "",
+		"example.com/pseudo/@latest":                    `{"Version":"v0.0.0-20180101000000-0123456789ab"}`,
+		"example.com/prerelease/@v/list":                "v2.0.0-beta.2\nv2.0.0-beta.10\n",
+		"example.com/nested/@v/list":                    "v1.0.0\n",
+		"example.com/nested/sub/module/@v/list":         "v0.1.0\n",
+		"example.com/nested/sub/module/@v/v0.1.0.info":  `{"Version":"v0.1.0"}`,
+		"example.com/nested/sub/module/@v/v0.1.0.mod":   "module example.com/nested/sub/module\n",
+		"example.com/nested/sub/module/@v/v0.1.0.zip":   "zip",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestModuleProxy(t *testing.T) {
+	dir := writeProxyDir(t)
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	for _, proxyURL := range []string{dir, "file://" + filepath.ToSlash(dir), server.URL} {
+		t.Run(proxyURL, func(t *testing.T) {
+			p, err := NewModuleProxy(proxyURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := p.List("github.com/Masterminds/semver"); err != nil {
+				t.Error(err)
+			} else if want := []string{"v1.3.0", "v1.4.0", "v1.5.0-rc.1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("List: got %q; want %q", got, want)
+			}
+
+			for _, tc := range []struct {
+				modPath, want string
+			}{
+				{"github.com/Masterminds/semver", "v1.4.0"},
+				{"example.com/pseudo", "v0.0.0-20180101000000-0123456789ab"},
+				{"example.com/prerelease", "v2.0.0-beta.10"},
+			} {
+				if got, err := p.Latest(tc.modPath); err != nil {
+					t.Error(err)
+				} else if got.Version != tc.want {
+					t.Errorf("Latest(%q): got %q; want %q", tc.modPath, got.Version, tc.want)
+				}
+			}
+
+			if got, err := p.Info("github.com/Masterminds/semver", "v1.4.0"); err != nil {
+				t.Error(err)
+			} else if got.Version != "v1.4.0" || got.Time.Year() != 2017 {
+				t.Errorf("Info: got %#v", got)
+			}
+
+			if got, err := p.GoMod("github.com/Masterminds/semver", "v1.4.0"); err != nil {
+				t.Error(err)
+			} else if want := "module github.com/Masterminds/semver\n"; string(got) != want {
+				t.Errorf("GoMod: got %q; want %q", got, want)
+			}
+
+			if r, err := p.Zip("github.com/Masterminds/semver", "v1.4.0"); err != nil {
+				t.Error(err)
+			} else {
+				got, err := ioutil.ReadAll(r)
+				r.Close()
+				if err != nil {
+					t.Error(err)
+				} else if want := "not really a zip"; string(got) != want {
+					t.Errorf("Zip: got %q; want %q", got, want)
+				}
+			}
+
+			if _, err := p.Info("example.com/missing", "v1.0.0"); !IsModuleNotFound(err) {
+				t.Errorf("Info of missing module: got error %v; want not found error", err)
+			}
+		})
+	}
+}
+
+func TestRootProxy(t *testing.T) {
+	dir := writeProxyDir(t)
+	defer os.RemoveAll(dir)
+	p, err := NewModuleProxy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		in, wantRoot, wantName string
+		repos                  []Repo
+		wantError              bool
+	}{
+		{in: "github.com/Masterminds/semver", wantRoot: "github.com/Masterminds/semver", wantName: "com_github_masterminds_semver"},
+		{in: "example.com/nested/sub", wantRoot: "example.com/nested", wantName: "com_example_nested"},
+		{in: "example.com/nested/sub/module/pkg", wantRoot: "example.com/nested/sub/module", wantName: "com_example_nested_sub_module"},
+		{in: "golang.org/x/net/context", wantError: true},
+		{
+			in:       "golang.org/x/net/context",
+			repos:    []Repo{{Name: "custom_net", GoPrefix: "golang.org/x/net"}},
+			wantRoot: "golang.org/x/net",
+			wantName: "custom_net",
+		},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			rc := newStubRemoteCache(tc.repos)
+			rc.Proxy = p
+			if gotRoot, gotName, err := rc.Root(tc.in); err != nil {
+				if !tc.wantError {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if tc.wantError {
+				t.Errorf("unexpected success: %v", tc.in)
+			} else if gotRoot != tc.wantRoot || gotName != tc.wantName {
+				t.Errorf("got (%q, %q); want (%q, %q)", gotRoot, gotName, tc.wantRoot, tc.wantName)
+			}
+		})
+	}
+}
+
+func TestUpdateRepoProxy(t *testing.T) {
+	dir := writeProxyDir(t)
+	defer os.RemoveAll(dir)
+	p, err := NewModuleProxy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := newStubRemoteCache(nil)
+	rc.Proxy = p
+
+	for _, tc := range []struct {
+		importPath string
+		want       Repo
+	}{
+		{
+			importPath: "github.com/Masterminds/semver",
+			want: Repo{
+				Name:     "com_github_masterminds_semver",
+				GoPrefix: "github.com/Masterminds/semver",
+				Tag:      "v1.4.0",
+				Version:  "v1.4.0",
+			},
+		}, {
+			importPath: "example.com/pseudo",
+			want: Repo{
+				Name:     "com_example_pseudo",
+				GoPrefix: "example.com/pseudo",
+				Commit:   "0123456789ab",
+				Version:  "v0.0.0-20180101000000-0123456789ab",
+			},
+		},
+	} {
+		t.Run(tc.importPath, func(t *testing.T) {
+			got, err := UpdateRepo(rc, tc.importPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMaxModuleVersion(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		versions []string
+		want     string
+	}{
+		{desc: "empty", want: ""},
+		{desc: "invalid", versions: []string{"master", "1.0.0", "v1.0"}, want: ""},
+		{desc: "release", versions: []string{"v1.10.0", "v1.9.0", "v1.2.3"}, want: "v1.10.0"},
+		{desc: "release_over_prerelease", versions: []string{"v2.0.0-rc.1", "v1.0.0"}, want: "v1.0.0"},
+		{desc: "prerelease", versions: []string{"v1.0.0-alpha", "v1.0.0-alpha.1", "v1.0.0-beta"}, want: "v1.0.0-beta"},
+		{desc: "incompatible", versions: []string{"v2.0.0+incompatible", "v1.0.0"}, want: "v2.0.0+incompatible"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := maxModuleVersion(tc.versions); got != tc.want {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -24,8 +24,8 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/label"
 	"github.com/bazelbuild/bazel-gazelle/internal/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"golang.org/x/tools/go/vcs"
 )
 
@@ -41,6 +41,21 @@ func UpdateRepo(rc *RemoteCache, importPath string) (Repo, error) {
 	if err != nil {
 		return Repo{}, err
 	}
+	if rc.Proxy != nil {
+		version, err := rc.LatestVersion(root)
+		if err != nil {
+			return Repo{}, err
+		}
+		commit, tag := moduleVersionToRevision(version)
+		repo := Repo{
+			Name:     name,
+			GoPrefix: root,
+			Commit:   commit,
+			Tag:      tag,
+			Version:  version,
+		}
+		return repo, nil
+	}
 	remote, vcs, err := rc.Remote(root)
 	if err != nil {
 		return Repo{}, err
@@ -67,6 +82,9 @@ func UpdateRepo(rc *RemoteCache, importPath string) (Repo, error) {
 //
 // Public methods of RemoteCache may be slow in cases where a network fetch
 // is needed. Public methods may be called concurrently.
+//
+// If Proxy is set, repository roots and latest versions are retrieved from
+// a module proxy instead of version control servers.
 type RemoteCache struct {
 	// RepoRootForImportPath is vcs.RepoRootForImportPath by default. It may
 	// be overridden so that tests may avoid accessing the network.
@@ -76,7 +94,11 @@ type RemoteCache struct {
 	// repository. This is used by Head. It may be stubbed out for tests.
 	HeadCmd func(remote, vcs string) (string, error)
 
-	root, remote, head remoteCacheMap
+	// Proxy is a module proxy used to find module roots and versions. If nil,
+	// RepoRootForImportPath and HeadCmd are used instead.
+	Proxy *ModuleProxy
+
+	root, remote, head, latest remoteCacheMap
 }
 
 // remoteCacheMap is a thread-safe, idempotent cache. It is used to store
@@ -122,6 +144,7 @@ func NewRemoteCache(knownRepos []Repo) *RemoteCache {
 		root:                  remoteCacheMap{cache: make(map[string]*remoteCacheEntry)},
 		remote:                remoteCacheMap{cache: make(map[string]*remoteCacheEntry)},
 		head:                  remoteCacheMap{cache: make(map[string]*remoteCacheEntry)},
+		latest:                remoteCacheMap{cache: make(map[string]*remoteCacheEntry)},
 	}
 	for _, repo := range knownRepos {
 		r.root.cache[repo.GoPrefix] = &remoteCacheEntry{
@@ -181,6 +204,11 @@ func (r *RemoteCache) Root(importPath string) (root, name string, err error) {
 		}
 	}
 
+	// If there's a module proxy, it knows which modules exist, so don't guess.
+	if r.Proxy != nil {
+		return r.proxyRoot(importPath)
+	}
+
 	// Try known prefixes.
 	for _, p := range knownPrefixes {
 		if pathtools.HasPrefix(importPath, p.prefix) {
@@ -224,6 +252,45 @@ func (r *RemoteCache) Root(importPath string) (root, name string, err error) {
 	return value.root, value.name, nil
 }
 
+// proxyRoot finds the module that provides importPath by asking the module
+// proxy about each prefix of the import path, longest first.
+func (r *RemoteCache) proxyRoot(importPath string) (root, name string, err error) {
+	v, err := r.root.ensure(importPath, func() (interface{}, error) {
+		for prefix := importPath; prefix != "." && prefix != "/"; prefix = path.Dir(prefix) {
+			if _, err := r.LatestVersion(prefix); err == nil {
+				return rootValue{prefix, label.ImportPathToBazelRepoName(prefix)}, nil
+			} else if !IsModuleNotFound(err) {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("module proxy %s: no module provides import path %q", r.Proxy, importPath)
+	})
+	if err != nil {
+		return "", "", err
+	}
+	value := v.(rootValue)
+	return value.root, value.name, nil
+}
+
+// LatestVersion returns the latest version of the module with the given path,
+// according to the module proxy. An error is returned if Proxy is not set.
+func (r *RemoteCache) LatestVersion(modPath string) (string, error) {
+	if r.Proxy == nil {
+		return "", fmt.Errorf("could not find latest version of module %q: no module proxy", modPath)
+	}
+	v, err := r.latest.ensure(modPath, func() (interface{}, error) {
+		info, err := r.Proxy.Latest(modPath)
+		if err != nil {
+			return nil, err
+		}
+		return info.Version, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
 // Remote returns the VCS name and the remote URL for a repository with the
 // given root import path. This is suitable for creating new repository rules.
 func (r *RemoteCache) Remote(root string) (remote, vcs string, err error) {
@@ -0,0 +1,334 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repos
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"golang.org/x/tools/go/vcs"
+)
+
+// Repo describes an external repository rule declared in a Bazel
+// WORKSPACE file.
+type Repo struct {
+	// Name is the value of the "name" attribute of the repository rule.
+	Name string
+
+	// GoPrefix is the portion of the Go import path for the root of this
+	// repository. Usually the same as Remote.
+	GoPrefix string
+
+	// Commit is the revision at which a repository is checked out (for example,
+	// a Git commit id).
+	Commit string
+
+	// Tag is the name of the version at which a repository is checked out.
+	Tag string
+
+	// Remote is the URL the repository can be cloned or checked out from.
+	Remote string
+
+	// VCS is the version control system used to check out the repository.
+	// May also be "http" for HTTP archives.
+	VCS string
+
+	// Version is the module version the repository was imported at, if it was
+	// imported from a go.mod file. Commit or Tag is derived from this.
+	Version string
+
+	// Sum is the hash of the module's content, as recorded in go.sum
+	// (for example, "h1:..."). Only set together with Version. When both are
+	// set and Remote is not, a go_repository rule that downloads the module
+	// from a module proxy is generated instead of one that checks out Commit
+	// or Tag.
+	Sum string
+
+	// Replace is the path of a module that replaces the module at GoPrefix,
+	// for example, a fork. It is only set together with Version. The
+	// replacement is downloaded from a module proxy at Version.
+	Replace string
+
+	// LocalPath is the path to a directory on the local file system that
+	// contains the repository. If this is set, a local_repository rule is
+	// generated instead of go_repository. Relative paths are interpreted
+	// relative to the repository root.
+	LocalPath string
+}
+
+type byName []Repo
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type lockFileFormat int
+
+const (
+	unknownFormat lockFileFormat = iota
+	depFormat
+	moduleFormat
+	glideFormat
+	govendorFormat
+	godepFormat
+)
+
+var lockFileParsers = map[lockFileFormat]func(string) ([]Repo, error){
+	depFormat:      importRepoRulesDep,
+	moduleFormat:   importRepoRulesModules,
+	glideFormat:    importRepoRulesGlide,
+	govendorFormat: importRepoRulesGovendor,
+	godepFormat:    importRepoRulesGodep,
+}
+
+// ImportRepoRules reads the lock file of a vendoring tool and returns
+// a list of equivalent repository rules that can be merged into a WORKSPACE
+// file. The format of the file is inferred from its basename. Currently,
+// Gopkg.lock (dep), go.mod, glide.lock (Glide), vendor.json (govendor), and
+// Godeps.json (godep) are supported.
+//
+// repoRoot is the directory containing the WORKSPACE file. Paths of local
+// repositories are made relative to it when they are inside it.
+func ImportRepoRules(filename, repoRoot string) ([]*rule.Rule, error) {
+	format := getLockFileFormat(filename)
+	if format == unknownFormat {
+		return nil, fmt.Errorf(`%s: unrecognized lock file format. Expected "Gopkg.lock", "go.mod", "glide.lock", "vendor.json", or "Godeps.json"`, filename)
+	}
+	parser := lockFileParsers[format]
+	repos, err := parser(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %q: %v", filename, err)
+	}
+	for i := range repos {
+		if repos[i].LocalPath != "" {
+			repos[i].LocalPath, err = workspaceRelPath(repoRoot, repos[i].LocalPath)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %q: %v", filename, err)
+			}
+		}
+	}
+	sort.Stable(byName(repos))
+
+	rules := make([]*rule.Rule, 0, len(repos))
+	for _, repo := range repos {
+		rules = append(rules, GenerateRule(repo))
+	}
+	return rules, nil
+}
+
+// workspaceRelPath converts p, a path relative to the current directory, to
+// a slash-separated path relative to repoRoot. If p is outside repoRoot, an
+// absolute path is returned.
+func workspaceRelPath(repoRoot, p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	absRoot, err := filepath.Abs(repoRoot)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absRoot, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(abs), nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func getLockFileFormat(filename string) lockFileFormat {
+	switch filepath.Base(filename) {
+	case "Gopkg.lock":
+		return depFormat
+	case "go.mod":
+		return moduleFormat
+	case "glide.lock":
+		return glideFormat
+	case "vendor.json":
+		return govendorFormat
+	case "Godeps.json":
+		return godepFormat
+	default:
+		return unknownFormat
+	}
+}
+
+// lockedPackage is a package listed in the lock file of a vendoring tool
+// that records each vendored package separately instead of each repository
+// (govendor and godep).
+type lockedPackage struct {
+	importPath, origin, revision string
+}
+
+// reposFromPackages groups packages into the repositories that contain them.
+// Roots of packages on well-known hosts are found without accessing the
+// network. Other packages are grouped by revision, and the root of each group
+// is the longest common prefix of the import paths in it.
+func reposFromPackages(pkgs []lockedPackage) []Repo {
+	rc := NewRemoteCache(nil)
+	rc.RepoRootForImportPath = func(string, bool) (*vcs.RepoRoot, error) {
+		return nil, errors.New("repository root not known")
+	}
+
+	var repos []Repo
+	rootIndex := make(map[string]int)
+	add := func(root string, p lockedPackage) {
+		if i, ok := rootIndex[root]; ok {
+			if repos[i].Commit != p.revision {
+				log.Printf("%s: packages from repository %s are locked at different revisions (%s and %s); using %s", p.importPath, root, repos[i].Commit, p.revision, repos[i].Commit)
+			}
+			return
+		}
+		repo := Repo{
+			Name:     label.ImportPathToBazelRepoName(root),
+			GoPrefix: root,
+			Commit:   p.revision,
+		}
+		if p.origin != "" && p.origin != p.importPath && !strings.Contains(p.origin, "/vendor/") {
+			// The package was fetched from a fork. Find the root of the fork
+			// by removing the path of the package within the repository.
+			rel := strings.TrimPrefix(p.importPath, root)
+			if originRoot := strings.TrimSuffix(p.origin, rel); originRoot != p.origin || rel == "" {
+				repo.Remote = "https://" + originRoot
+				repo.VCS = "git"
+			}
+		}
+		rootIndex[root] = len(repos)
+		repos = append(repos, repo)
+	}
+
+	var unknownRevisions []string
+	unknownByRevision := make(map[string][]lockedPackage)
+	for _, p := range pkgs {
+		if p.importPath == "" {
+			continue
+		}
+		if root, _, err := rc.Root(p.importPath); err == nil {
+			add(root, p)
+			continue
+		}
+		if p.revision == "" {
+			add(p.importPath, p)
+			continue
+		}
+		if _, ok := unknownByRevision[p.revision]; !ok {
+			unknownRevisions = append(unknownRevisions, p.revision)
+		}
+		unknownByRevision[p.revision] = append(unknownByRevision[p.revision], p)
+	}
+	for _, rev := range unknownRevisions {
+		group := unknownByRevision[rev]
+		root := group[0].importPath
+		for _, p := range group[1:] {
+			root = commonPathPrefix(root, p.importPath)
+		}
+		if root == "" {
+			for _, p := range group {
+				add(p.importPath, p)
+			}
+			continue
+		}
+		for _, p := range group {
+			add(root, p)
+		}
+	}
+	return repos
+}
+
+// commonPathPrefix returns the longest sequence of leading slash-separated
+// path components shared by a and b.
+func commonPathPrefix(a, b string) string {
+	ac := strings.Split(a, "/")
+	bc := strings.Split(b, "/")
+	n := 0
+	for n < len(ac) && n < len(bc) && ac[n] == bc[n] {
+		n++
+	}
+	return strings.Join(ac[:n], "/")
+}
+
+// GenerateRule returns a repository rule for the given repository that can
+// be written in a WORKSPACE file.
+func GenerateRule(repo Repo) *rule.Rule {
+	if repo.LocalPath != "" {
+		r := rule.NewRule("local_repository", repo.Name)
+		r.SetAttr("path", repo.LocalPath)
+		return r
+	}
+
+	r := rule.NewRule("go_repository", repo.Name)
+	if repo.Version != "" && (repo.Sum != "" || repo.Replace != "") && repo.Remote == "" {
+		// The module can be downloaded from a module proxy and verified.
+		// Replacements are always downloaded from the proxy, since there is
+		// no other way to find them; sum is printed when it's not known.
+		r.SetAttr("importpath", repo.GoPrefix)
+		if repo.Replace != "" {
+			r.SetAttr("replace", repo.Replace)
+		}
+		if repo.Sum != "" {
+			r.SetAttr("sum", repo.Sum)
+		}
+		r.SetAttr("version", repo.Version)
+		return r
+	}
+	if repo.Commit != "" {
+		r.SetAttr("commit", repo.Commit)
+	}
+	if repo.Tag != "" {
+		r.SetAttr("tag", repo.Tag)
+	}
+	r.SetAttr("importpath", repo.GoPrefix)
+	if repo.Remote != "" {
+		r.SetAttr("remote", repo.Remote)
+	}
+	if repo.VCS != "" {
+		r.SetAttr("vcs", repo.VCS)
+	}
+	return r
+}
+
+// FindExternalRepo attempts to locate the directory where Bazel has fetched
+// the external repository with the given name. An error is returned if the
+// repository directory cannot be located.
+func FindExternalRepo(repoRoot, name string) (string, error) {
+	// See https://docs.bazel.build/versions/master/output_directories.html
+	// for documentation on Bazel directory layout.
+	// We expect the bazel-out symlink in the workspace root directory to point to
+	// <output-base>/execroot/<workspace-name>/bazel-out
+	// We expect the external repository to be checked out at
+	// <output-base>/external/<name>
+	// Note that users can change the prefix for most of the Bazel symlinks with
+	// --symlink_prefix, but this does not include bazel-out.
+	externalPath := strings.Join([]string{repoRoot, "bazel-out", "..", "..", "..", "external", name}, string(os.PathSeparator))
+	cleanPath, err := filepath.EvalSymlinks(externalPath)
+	if err != nil {
+		return "", err
+	}
+	st, err := os.Stat(cleanPath)
+	if err != nil {
+		return "", err
+	}
+	if !st.IsDir() {
+		return "", fmt.Errorf("%s: not a directory", externalPath)
+	}
+	return cleanPath, nil
+}
@@ -19,11 +19,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"reflect"
 	"strings"
 	"testing"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/rule"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
 func TestGenerateRepoRules(t *testing.T) {
@@ -33,7 +32,7 @@ func TestGenerateRepoRules(t *testing.T) {
 		Commit:   "123456",
 	}
 	r := GenerateRule(repo)
-	f := rule.EmptyFile("test")
+	f := rule.EmptyFile("test", "")
 	r.Insert(f)
 	got := strings.TrimSpace(string(f.Format()))
 	want := `go_repository(
@@ -82,41 +81,3 @@ func TestFindExternalRepo(t *testing.T) {
 		t.Errorf("got %q ; want %q", got, externalPath)
 	}
 }
-
-func TestListRepositories(t *testing.T) {
-	for _, tc := range []struct {
-		desc, workspace string
-		want            []Repo
-	}{
-		{
-			desc: "empty",
-			want: nil,
-		}, {
-			desc: "go_repository",
-			workspace: `
-go_repository(
-    name = "custom_repo",
-    commit = "123456",
-    remote = "https://example.com/repo",
-    importpath = "example.com/repo",
-)
-`,
-			want: []Repo{{
-				Name:     "custom_repo",
-				GoPrefix: "example.com/repo",
-				Remote:   "https://example.com/repo",
-				Commit:   "123456",
-			}},
-		},
-	} {
-		t.Run(tc.desc, func(t *testing.T) {
-			workspace, err := rule.LoadData("WORKSPACE", []byte(tc.workspace))
-			if err != nil {
-				t.Fatal(err)
-			}
-			if got := ListRepositories(workspace); !reflect.DeepEqual(got, tc.want) {
-				t.Errorf("got %#v ; want %#v", got, tc.want)
-			}
-		})
-	}
-}
@@ -0,0 +1,119 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolve
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+const resolveName = "resolve"
+
+// overrideSpec maps an import to an explicit label. It is configured with
+// a resolve directive.
+type overrideSpec struct {
+	imp  ImportSpec
+	lang string
+	dep  label.Label
+}
+
+// getOverrides returns the overrides configured for the directory c applies
+// to. The returned slice must not be modified.
+func getOverrides(c *config.Config) []overrideSpec {
+	overrides, _ := c.Exts[resolveName].([]overrideSpec)
+	return overrides
+}
+
+// Configurer reads resolve directives, which tell Gazelle to resolve imports
+// to explicit labels. Directives have one of the forms below.
+//
+//	# gazelle:resolve lang import-string label
+//	# gazelle:resolve lang import-lang import-string label
+//
+// lang is the language of the rule that has the dependency (for example,
+// "go"). import-lang is the language of the import string, which is the same
+// as lang by default (for example, "proto" for a go_proto_library that
+// imports a .proto file). Overrides apply in the directory where they are
+// set and in subdirectories.
+type Configurer struct{}
+
+func (*Configurer) KnownDirectives() []string {
+	return []string{resolveName}
+}
+
+func (*Configurer) Configure(c *config.Config, rel string, f *bzl.File, directives []config.Directive) {
+	var overrides []overrideSpec
+	for _, d := range directives {
+		if d.Key != resolveName {
+			continue
+		}
+		parts := strings.Fields(d.Value)
+		o := overrideSpec{}
+		var lbl string
+		switch len(parts) {
+		case 3:
+			o.imp.Lang = parts[0]
+			o.lang = parts[0]
+			o.imp.Imp = parts[1]
+			lbl = parts[2]
+		case 4:
+			o.imp.Lang = parts[1]
+			o.lang = parts[0]
+			o.imp.Imp = parts[2]
+			lbl = parts[3]
+		default:
+			config.ReportInvalidDirective(c, f, d, errors.New("expected gazelle:resolve lang [import-lang] import-string label"))
+			continue
+		}
+		var err error
+		o.dep, err = label.Parse(lbl)
+		if err != nil {
+			config.ReportInvalidDirective(c, f, d, err)
+			continue
+		}
+		o.dep = o.dep.Abs("", rel)
+		overrides = append(overrides, o)
+	}
+	if len(overrides) > 0 {
+		// Overrides in this directory take precedence over those inherited
+		// from parent directories. The inherited slice is shared, so copy it.
+		inherited := getOverrides(c)
+		all := make([]overrideSpec, 0, len(overrides)+len(inherited))
+		all = append(all, overrides...)
+		all = append(all, inherited...)
+		if c.Exts == nil {
+			c.Exts = make(map[string]interface{})
+		}
+		c.Exts[resolveName] = all
+	}
+}
+
+// FindRuleWithOverride searches for a label configured with a resolve
+// directive that may be used to import imp from a rule in the language
+// lang. Languages should call this before consulting the RuleIndex or
+// guessing labels for imports.
+func FindRuleWithOverride(c *config.Config, imp ImportSpec, lang string) (label.Label, bool) {
+	for _, o := range getOverrides(c) {
+		if o.imp == imp && o.lang == lang {
+			return o.dep, true
+		}
+	}
+	return label.NoLabel, false
+}
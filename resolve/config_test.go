@@ -0,0 +1,87 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolve
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func TestFindRuleWithOverride(t *testing.T) {
+	c := &config.Config{}
+	cr := &Configurer{}
+	for _, f := range []struct{ rel, content string }{
+		{
+			rel: "",
+			content: `
+# gazelle:resolve go example.com/a //third_party/a:lib
+# gazelle:resolve go proto foo/foo.proto @com_example_foo//:foo_go_proto
+# gazelle:resolve go example.com/invalid
+`,
+		}, {
+			rel: "sub",
+			content: `
+# gazelle:resolve go example.com/a :a_lib
+`,
+		},
+	} {
+		bf, err := bzl.Parse("BUILD.bazel", []byte(f.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c = c.Clone()
+		cr.Configure(c, f.rel, bf, config.ParseDirectives(bf))
+	}
+
+	for _, tc := range []struct {
+		desc string
+		imp  ImportSpec
+		lang string
+		want label.Label
+	}{
+		{
+			desc: "subdirectory_override",
+			imp:  ImportSpec{Lang: "go", Imp: "example.com/a"},
+			lang: "go",
+			want: label.New("", "sub", "a_lib"),
+		}, {
+			desc: "import_lang",
+			imp:  ImportSpec{Lang: "proto", Imp: "foo/foo.proto"},
+			lang: "go",
+			want: label.New("com_example_foo", "", "foo_go_proto"),
+		}, {
+			desc: "wrong_lang",
+			imp:  ImportSpec{Lang: "proto", Imp: "foo/foo.proto"},
+			lang: "proto",
+			want: label.NoLabel,
+		}, {
+			desc: "invalid",
+			imp:  ImportSpec{Lang: "go", Imp: "example.com/invalid"},
+			lang: "go",
+			want: label.NoLabel,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, ok := FindRuleWithOverride(c, tc.imp, tc.lang)
+			if ok != !tc.want.Equal(label.NoLabel) || !got.Equal(tc.want) {
+				t.Errorf("got %s, %v; want %s", got, ok, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,420 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolve
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/repos"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// ImportSpec describes a library to be imported. Imp is an import string for
+// the library. Lang is the language in which the import string appears (this
+// should match Resolver.Name).
+type ImportSpec struct {
+	Lang, Imp string
+}
+
+// Resolver is an interface that language extensions can implement to resolve
+// dependencies in rules they generate.
+type Resolver interface {
+	// Name returns the name of the language. This should be a prefix of the
+	// kinds of rules generated by the language, e.g., "go" for the Go extension
+	// since it generates "go_library" rules.
+	Name() string
+
+	// Imports returns a list of ImportSpecs that can be used to import the rule
+	// r. This is used to populate RuleIndex.
+	//
+	// If nil is returned, the rule will not be indexed. If any non-nil slice is
+	// returned, including an empty slice, the rule will be indexed.
+	Imports(c *config.Config, r *rule.Rule, f *rule.File) []ImportSpec
+
+	// Embeds returns a list of labels of rules that the given rule embeds. If
+	// a rule is embedded by another importable rule of the same language, only
+	// the embedding rule will be indexed. The embedding rule will inherit
+	// the imports of the embedded rule.
+	Embeds(r *rule.Rule, from label.Label) []label.Label
+
+	// Resolve translates imported libraries for a given rule into Bazel
+	// dependencies. A list of imported libraries is typically stored in a
+	// hidden attribute of the rule when it's generated (this interface doesn't
+	// dictate how that is stored or represented). Resolve generates a "deps"
+	// attribute (or the appropriate language-specific equivalent) for each
+	// import according to language-specific rules and heuristics. Imports
+	// that can't be resolved should be recorded with AddUnresolvedImport;
+	// they are reported as diagnostics after resolution.
+	Resolve(c *config.Config, ix *RuleIndex, rc *repos.RemoteCache, r *rule.Rule, from label.Label)
+}
+
+// UnresolvedImport describes an import that a Resolver could not translate
+// into a dependency.
+type UnresolvedImport struct {
+	Imp string
+	Err error
+}
+
+// unresolvedImportsKey is the private attribute where unresolved imports
+// are recorded.
+const unresolvedImportsKey = "_gazelle_unresolved_imports"
+
+// AddUnresolvedImport records that imp could not be resolved for r because
+// of err.
+func AddUnresolvedImport(r *rule.Rule, imp string, err error) {
+	unresolved := UnresolvedImports(r)
+	r.SetPrivateAttr(unresolvedImportsKey, append(unresolved, UnresolvedImport{Imp: imp, Err: err}))
+}
+
+// UnresolvedImports returns the imports recorded for r with
+// AddUnresolvedImport.
+func UnresolvedImports(r *rule.Rule) []UnresolvedImport {
+	unresolved, _ := r.PrivateAttr(unresolvedImportsKey).([]UnresolvedImport)
+	return unresolved
+}
+
+// RuleIndex is a table of rules in a workspace, indexed by label and by
+// import path. Used by Resolver to map import paths to labels.
+type RuleIndex struct {
+	rules     []*ruleRecord
+	labelMap  map[label.Label]*ruleRecord
+	importMap map[ImportSpec][]*ruleRecord
+	mrslv     func(r *rule.Rule) Resolver
+
+	// providers maps import strings to the labels of rules that may be
+	// imported with them in any language. Used by ImportProviders.
+	providers map[string][]label.Label
+
+	// dependents maps each package in the main repository to the set of
+	// other packages with rules that depend on it. Used by FindDependents.
+	dependents map[string]map[string]bool
+
+	// ruleDependents maps labels of rules in the main repository to the set
+	// of other packages with rules that depend on them. Used by
+	// FindRuleDependents.
+	ruleDependents map[label.Label]map[string]bool
+
+	// depsByPkg maps each package to the labels its rules depend on, so
+	// dependencies can be replaced by UpdateDependencies.
+	depsByPkg map[string][]label.Label
+}
+
+// ruleRecord contains information about a rule relevant to import indexing.
+type ruleRecord struct {
+	rule  *rule.Rule
+	label label.Label
+
+	// lang is the name of the Resolver that indexed the rule.
+	lang string
+
+	// importedAs is a list of ImportSpecs by which this rule may be imported.
+	// Used to build a map from ImportSpecs to ruleRecords.
+	importedAs []ImportSpec
+
+	// embeds is the transitive closure of labels for rules that this rule
+	// embeds (as determined by the Embeds method). This only includes rules
+	// in the same language (i.e., it includes a go_library embedding
+	// a go_proto_library, but not a go_proto_library embedding
+	// a proto_library).
+	embeds []label.Label
+
+	// embedded indicates whether another rule of the same language embeds this
+	// rule. Embedded rules should not be indexed.
+	embedded bool
+
+	didCollectEmbeds bool
+}
+
+// NewRuleIndex creates a new index.
+//
+// mrslv is a function that returns a Resolver for a given rule, usually
+// chosen by the rule's kind. It may return nil for rules that should not
+// be indexed.
+func NewRuleIndex(mrslv func(r *rule.Rule) Resolver) *RuleIndex {
+	return &RuleIndex{
+		labelMap:       make(map[label.Label]*ruleRecord),
+		mrslv:          mrslv,
+		dependents:     make(map[string]map[string]bool),
+		ruleDependents: make(map[label.Label]map[string]bool),
+		depsByPkg:      make(map[string][]label.Label),
+	}
+}
+
+// AddRulesFromFile adds existing rules to the index from file
+// (which must not be nil).
+//
+// AddRulesFromFile may not be called after Finish.
+func (ix *RuleIndex) AddRulesFromFile(c *config.Config, f *rule.File) {
+	for _, r := range f.Rules {
+		ix.addRule(c, r, f)
+		ix.addDependencies(r, f)
+	}
+}
+
+// addDependencies records the packages that r depends on. Labels are read
+// from every attribute except name and visibility, including deps, embed,
+// srcs, data and labels in select expressions, since Bazel checks the
+// visibility of all of them. All rules are considered, not just rules that
+// are indexed.
+func (ix *RuleIndex) addDependencies(r *rule.Rule, f *rule.File) {
+	for _, key := range r.AttrKeys() {
+		if key == "name" || key == "visibility" {
+			continue
+		}
+		bzl.Walk(r.Attr(key), func(x bzl.Expr, _ []bzl.Expr) {
+			// Labels in other packages are absolute. Other strings, like
+			// import paths and file names, are skipped.
+			str, ok := x.(*bzl.StringExpr)
+			if !ok || !strings.HasPrefix(str.Value, "//") && !strings.HasPrefix(str.Value, "@") {
+				return
+			}
+			l, err := label.Parse(str.Value)
+			if err != nil {
+				return
+			}
+			l = l.Abs("", f.Pkg)
+			if l.Repo != "" || l.Pkg == f.Pkg {
+				return
+			}
+			if ix.dependents[l.Pkg] == nil {
+				ix.dependents[l.Pkg] = make(map[string]bool)
+			}
+			ix.dependents[l.Pkg][f.Pkg] = true
+			if ix.ruleDependents[l] == nil {
+				ix.ruleDependents[l] = make(map[string]bool)
+			}
+			ix.ruleDependents[l][f.Pkg] = true
+			ix.depsByPkg[f.Pkg] = append(ix.depsByPkg[f.Pkg], l)
+		})
+	}
+}
+
+// UpdateDependencies replaces the dependencies recorded for rules in f with
+// the labels currently in the attributes of its rules. This is used after
+// dependencies in f have been resolved. Unlike AddRulesFromFile,
+// UpdateDependencies may be called after Finish.
+func (ix *RuleIndex) UpdateDependencies(f *rule.File) {
+	for _, l := range ix.depsByPkg[f.Pkg] {
+		delete(ix.dependents[l.Pkg], f.Pkg)
+		delete(ix.ruleDependents[l], f.Pkg)
+	}
+	delete(ix.depsByPkg, f.Pkg)
+	for _, r := range f.Rules {
+		ix.addDependencies(r, f)
+	}
+}
+
+func (ix *RuleIndex) addRule(c *config.Config, r *rule.Rule, f *rule.File) {
+	rslv := ix.mrslv(r)
+	if rslv == nil {
+		return
+	}
+	imps := rslv.Imports(c, r, f)
+	if imps == nil {
+		return
+	}
+
+	l := label.New("", f.Pkg, r.Name())
+	if _, ok := ix.labelMap[l]; ok {
+		start := r.Pos()
+		pos := diag.Position{Path: f.Path, Line: start.Line, Column: start.LineRune}
+		c.Diagnostics.Warnf(diag.DuplicateLabel, pos, "multiple rules found with label %s", l)
+		return
+	}
+	record := &ruleRecord{
+		rule:       r,
+		label:      l,
+		lang:       rslv.Name(),
+		importedAs: imps,
+		embeds:     rslv.Embeds(r, l),
+	}
+	ix.rules = append(ix.rules, record)
+	ix.labelMap[record.label] = record
+}
+
+// Finish constructs the import index and performs any other necessary indexing
+// actions after all rules have been added. This step is necessary because
+// a rule may be indexed differently based on what rules are added later.
+//
+// Finish must be called after all AddRulesFromFile calls but before any
+// FindRulesByImport calls.
+func (ix *RuleIndex) Finish() {
+	for _, r := range ix.rules {
+		ix.collectEmbeds(r)
+	}
+	ix.buildImportIndex()
+}
+
+// collectEmbeds marks rules embedded by other rules of the same language
+// and copies their imports into the embedding rule. Embedded rules of other
+// languages (for example, a proto_library embedded in a go_proto_library)
+// are left alone, but their imports are still inherited.
+func (ix *RuleIndex) collectEmbeds(r *ruleRecord) {
+	if r.didCollectEmbeds {
+		return
+	}
+	r.didCollectEmbeds = true
+	embedLabels := r.embeds
+	r.embeds = nil
+	for _, e := range embedLabels {
+		er, ok := ix.findRuleByLabel(e, r.label)
+		if !ok {
+			continue
+		}
+		ix.collectEmbeds(er)
+		if er.lang == r.lang {
+			er.embedded = true
+			r.embeds = append(r.embeds, er.label)
+			r.embeds = append(r.embeds, er.embeds...)
+		}
+		r.importedAs = append(r.importedAs, er.importedAs...)
+	}
+}
+
+// buildImportIndex constructs the map used by FindRulesByImport.
+func (ix *RuleIndex) buildImportIndex() {
+	ix.importMap = make(map[ImportSpec][]*ruleRecord)
+	ix.providers = make(map[string][]label.Label)
+	for _, r := range ix.rules {
+		if r.embedded {
+			continue
+		}
+		indexed := make(map[ImportSpec]bool)
+		for _, imp := range r.importedAs {
+			if indexed[imp] {
+				continue
+			}
+			indexed[imp] = true
+			ix.importMap[imp] = append(ix.importMap[imp], r)
+			ix.providers[imp.Imp] = append(ix.providers[imp.Imp], r.label)
+		}
+	}
+}
+
+// ImportProviders returns the labels of rules that may be imported with the
+// string imp in any language, in the order they were indexed. A label may
+// be returned more than once. Finish must be called first.
+func (ix *RuleIndex) ImportProviders(imp string) []label.Label {
+	return ix.providers[imp]
+}
+
+// FindDependents returns a sorted list of packages in the main repository
+// with rules that have dependencies on rules in pkg. Dependencies are read
+// from label attributes of rules as they were when their files were added
+// to the index or last passed to UpdateDependencies.
+func (ix *RuleIndex) FindDependents(pkg string) []string {
+	return sortedPkgs(ix.dependents[pkg])
+}
+
+// FindRuleDependents returns a sorted list of other packages in the main
+// repository with rules that have dependencies on the rule with label l.
+// Dependencies are read the same way as in FindDependents.
+func (ix *RuleIndex) FindRuleDependents(l label.Label) []string {
+	return sortedPkgs(ix.ruleDependents[l])
+}
+
+// HasRule returns whether a rule with label l was indexed.
+func (ix *RuleIndex) HasRule(l label.Label) bool {
+	_, ok := ix.labelMap[l]
+	return ok
+}
+
+func sortedPkgs(set map[string]bool) []string {
+	var pkgs []string
+	for pkg := range set {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// ProvidedImports returns the ImportSpecs by which rules in pkg may be
+// imported. Finish must be called first.
+func (ix *RuleIndex) ProvidedImports(pkg string) []ImportSpec {
+	var imps []ImportSpec
+	for _, r := range ix.rules {
+		if r.label.Pkg == pkg && !r.embedded {
+			imps = append(imps, r.importedAs...)
+		}
+	}
+	return imps
+}
+
+func (ix *RuleIndex) findRuleByLabel(label label.Label, from label.Label) (*ruleRecord, bool) {
+	label = label.Abs(from.Repo, from.Pkg)
+	r, ok := ix.labelMap[label]
+	return r, ok
+}
+
+// FindResult describes a rule that may be imported with a given ImportSpec.
+type FindResult struct {
+	// Label is the absolute label (including repository and package name) for
+	// a matched rule.
+	Label label.Label
+
+	// Rule is the matched rule.
+	Rule *rule.Rule
+
+	// Embeds is the transitive closure of labels for rules that the matched
+	// rule embeds. It may contains duplicates and does not include the label
+	// for the rule itself.
+	Embeds []label.Label
+}
+
+// IsSelfImport returns true if the result's label matches the given label
+// or the result's rule transitively embeds the rule with the given label.
+// Self imports cause cyclic dependencies, so the caller may want to omit
+// the dependency or report an error.
+func (r FindResult) IsSelfImport(from label.Label) bool {
+	if from.Equal(r.Label) {
+		return true
+	}
+	for _, e := range r.Embeds {
+		if from.Equal(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindRulesByImport attempts to resolve an import spec to labels of rules.
+// imp is the import to resolve (which includes the target language). lang is
+// the language of the rule with the dependency (for example, in
+// go_proto_library, imp will have "proto" and lang will be "go").
+//
+// Any number of rules may provide the same import. Rules are returned in the
+// order they were indexed. Callers are responsible for choosing among multiple
+// results and for reporting an error if nothing is found.
+func (ix *RuleIndex) FindRulesByImport(imp ImportSpec, lang string) []FindResult {
+	matches := ix.importMap[imp]
+	results := make([]FindResult, 0, len(matches))
+	for _, m := range matches {
+		if m.lang != lang {
+			continue
+		}
+		results = append(results, FindResult{
+			Label:  m.label,
+			Rule:   m.rule,
+			Embeds: m.embeds,
+		})
+	}
+	return results
+}
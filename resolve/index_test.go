@@ -0,0 +1,224 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolve
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/repos"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestFindDependents(t *testing.T) {
+	ix := NewRuleIndex(func(r *rule.Rule) Resolver { return nil })
+	for _, f := range []struct{ pkg, content string }{
+		{
+			pkg: "a",
+			content: `
+go_library(
+    name = "go_default_library",
+    deps = [
+        ":local",
+        "//c:go_default_library",
+        "@com_example_ext//:go_default_library",
+    ],
+)
+`,
+		}, {
+			pkg: "b",
+			content: `
+go_test(
+    name = "go_default_test",
+    deps = select({
+        "@io_bazel_rules_go//go/platform:linux": ["//c:go_default_library"],
+        "//conditions:default": [],
+    }),
+)
+`,
+		}, {
+			pkg: "c",
+			content: `
+go_library(
+    name = "go_default_library",
+    deps = ["//a:go_default_library"],
+)
+`,
+		}, {
+			pkg: "d",
+			content: `
+go_binary(
+    name = "bin",
+    srcs = glob(["*.go"]) + ["//e:gen.go"],
+    embed = ["//e:go_default_library"],
+    data = ["//f:testdata"],
+    importpath = "example.com/d",
+    visibility = ["//g:__pkg__"],
+)
+`,
+		},
+	} {
+		file, err := rule.LoadData(f.pkg+"/BUILD.bazel", f.pkg, []byte(f.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ix.AddRulesFromFile(&config.Config{}, file)
+	}
+	ix.Finish()
+
+	for _, tc := range []struct {
+		pkg  string
+		want []string
+	}{
+		{pkg: "a", want: []string{"c"}},
+		{pkg: "b", want: nil},
+		{pkg: "c", want: []string{"a", "b"}},
+		{pkg: "e", want: []string{"d"}},
+		{pkg: "f", want: []string{"d"}},
+		{pkg: "g", want: nil},
+		{pkg: "", want: nil},
+	} {
+		if got := ix.FindDependents(tc.pkg); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("FindDependents(%q): got %q; want %q", tc.pkg, got, tc.want)
+		}
+	}
+}
+
+func TestFindRuleDependents(t *testing.T) {
+	ix := NewRuleIndex(func(r *rule.Rule) Resolver { return nil })
+	files := make(map[string]*rule.File)
+	for _, f := range []struct{ pkg, content string }{
+		{
+			pkg: "a",
+			content: `
+go_library(
+    name = "go_default_library",
+    deps = ["//c:go_default_library"],
+)
+`,
+		}, {
+			pkg: "b",
+			content: `
+go_library(
+    name = "go_default_library",
+    deps = ["//c:other"],
+)
+`,
+		}, {
+			pkg: "c",
+			content: `
+go_library(name = "go_default_library")
+
+go_library(name = "other")
+`,
+		},
+	} {
+		file, err := rule.LoadData(f.pkg+"/BUILD.bazel", f.pkg, []byte(f.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ix.AddRulesFromFile(&config.Config{}, file)
+		files[f.pkg] = file
+	}
+	ix.Finish()
+
+	lib := label.New("", "c", "go_default_library")
+	other := label.New("", "c", "other")
+	if got, want := ix.FindRuleDependents(lib), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindRuleDependents(%s): got %q; want %q", lib, got, want)
+	}
+	if got, want := ix.FindRuleDependents(other), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindRuleDependents(%s): got %q; want %q", other, got, want)
+	}
+
+	// Dependencies are replaced when they change after indexing.
+	files["b"].Rules[0].SetAttr("deps", []string{"//c:go_default_library"})
+	ix.UpdateDependencies(files["b"])
+	if got, want := ix.FindRuleDependents(lib), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after update, FindRuleDependents(%s): got %q; want %q", lib, got, want)
+	}
+	if got := ix.FindRuleDependents(other); got != nil {
+		t.Errorf("after update, FindRuleDependents(%s): got %q; want none", other, got)
+	}
+	if got, want := ix.FindDependents("c"), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after update, FindDependents(c): got %q; want %q", got, want)
+	}
+}
+
+type testResolver struct{}
+
+func (testResolver) Name() string { return "test" }
+
+func (testResolver) Imports(c *config.Config, r *rule.Rule, f *rule.File) []ImportSpec {
+	var imps []ImportSpec
+	for _, imp := range r.AttrStrings("importpaths") {
+		imps = append(imps, ImportSpec{Lang: "test", Imp: imp})
+	}
+	return imps
+}
+
+func (testResolver) Embeds(r *rule.Rule, from label.Label) []label.Label {
+	var embeds []label.Label
+	for _, s := range r.AttrStrings("embed") {
+		if l, err := label.Parse(s); err == nil {
+			embeds = append(embeds, l.Abs(from.Repo, from.Pkg))
+		}
+	}
+	return embeds
+}
+
+func (testResolver) Resolve(c *config.Config, ix *RuleIndex, rc *repos.RemoteCache, r *rule.Rule, from label.Label) {
+}
+
+func TestProvidedImports(t *testing.T) {
+	ix := NewRuleIndex(func(r *rule.Rule) Resolver { return testResolver{} })
+	file, err := rule.LoadData("a/BUILD.bazel", "a", []byte(`
+test_library(
+    name = "embedded",
+    importpaths = ["a/embedded"],
+)
+
+test_library(
+    name = "lib",
+    importpaths = ["a/lib"],
+    embed = [":embedded"],
+)
+
+test_library(
+    name = "other",
+    importpaths = ["a/other"],
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.AddRulesFromFile(&config.Config{}, file)
+	ix.Finish()
+
+	want := []ImportSpec{
+		{Lang: "test", Imp: "a/lib"},
+		{Lang: "test", Imp: "a/embedded"},
+		{Lang: "test", Imp: "a/other"},
+	}
+	if got := ix.ProvidedImports("a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if got := ix.ProvidedImports("b"); got != nil {
+		t.Errorf("got %v for package without rules; want nil", got)
+	}
+}
@@ -18,10 +18,9 @@ package rule
 import (
 	"errors"
 	"fmt"
-	"log"
 	"sort"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
 	bzl "github.com/bazelbuild/buildtools/build"
 )
 
@@ -34,7 +33,7 @@ import (
 // If src has an attribute that is not in dst, it will be copied into dst.
 //
 // If src and dst have the same attribute and the attribute is mergeable
-// (see MergeableAttrs) and the attribute in dst is not marked with a
+// (it is in mergeable, usually taken from KindInfo) and the attribute in dst is not marked with a
 // "# keep" comment, values in the dst attribute not marked with a "# keep"
 // comment will be dropped, and values from src will be copied in.
 //
@@ -42,20 +41,22 @@ import (
 // marked with a "# keep" comment, values in the attribute not marked with
 // a "# keep" comment will be dropped. If the attribute is empty afterward,
 // it will be deleted.
-func MergeRules(src, dst *Rule, mergeable config.MergeableAttrs, filename string) {
+//
+// Attributes that can't be merged are left alone and reported to diags,
+// which may be nil. filename is the path to the file containing dst.
+func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string, diags *diag.Collector) {
 	if ShouldKeep(dst.call) {
 		return
 	}
 
 	// Process attributes that are in dst but not in src.
 	for key, dstAttr := range dst.attrs {
-		if _, ok := src.attrs[key]; ok || !mergeable[dst.Kind()][key] || ShouldKeep(dstAttr) {
+		if _, ok := src.attrs[key]; ok || !mergeable[key] || ShouldKeep(dstAttr) {
 			continue
 		}
 		dstValue := dstAttr.Y
 		if mergedValue, err := mergeExprs(nil, dstValue); err != nil {
-			start, end := dstValue.Span()
-			log.Printf("%s:%d.%d-%d.%d: could not merge expression", filename, start.Line, start.LineRune, end.Line, end.LineRune)
+			reportMergeConflict(diags, filename, key, dstValue, err)
 		} else if mergedValue == nil {
 			dst.DelAttr(key)
 		} else {
@@ -68,11 +69,10 @@ func MergeRules(src, dst *Rule, mergeable config.MergeableAttrs, filename string
 		srcValue := srcAttr.Y
 		if dstAttr, ok := dst.attrs[key]; !ok {
 			dst.SetAttr(key, srcValue)
-		} else if mergeable[dst.Kind()][key] && !ShouldKeep(dstAttr) {
+		} else if mergeable[key] && !ShouldKeep(dstAttr) {
 			dstValue := dstAttr.Y
 			if mergedValue, err := mergeExprs(srcValue, dstValue); err != nil {
-				start, end := dstValue.Span()
-				log.Printf("%s:%d.%d-%d.%d: could not merge expression", filename, start.Line, start.LineRune, end.Line, end.LineRune)
+				reportMergeConflict(diags, filename, key, dstValue, err)
 			} else {
 				dst.SetAttr(key, mergedValue)
 			}
@@ -80,6 +80,12 @@ func MergeRules(src, dst *Rule, mergeable config.MergeableAttrs, filename string
 	}
 }
 
+func reportMergeConflict(diags *diag.Collector, filename, key string, dstValue bzl.Expr, err error) {
+	start, _ := dstValue.Span()
+	pos := diag.Position{Path: filename, Line: start.Line, Column: start.LineRune}
+	diags.Warnf(diag.MergeConflict, pos, "could not merge expression in %s: %v", key, err)
+}
+
 // mergeExprs combines information from src and dst and returns a merged
 // expression. dst may be modified during this process. The returned expression
 // may be different from dst when a structural change is needed.
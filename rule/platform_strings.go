@@ -19,7 +19,7 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
+	"github.com/bazelbuild/bazel-gazelle/config"
 )
 
 // PlatformStrings contains a set of strings associated with a buildable
@@ -26,11 +26,13 @@ limitations under the License.
 package rule
 
 import (
+	"fmt"
 	"io/ioutil"
+	"regexp"
 	"sort"
 	"strings"
 
-	"github.com/bazelbuild/bazel-gazelle/internal/config"
+	"github.com/bazelbuild/bazel-gazelle/config"
 	bzl "github.com/bazelbuild/buildtools/build"
 	bt "github.com/bazelbuild/buildtools/tables"
 )
@@ -48,6 +50,11 @@ type File struct {
 	// Path is the file system path to the build file (same as File.Path).
 	Path string
 
+	// Pkg is the Bazel package this build file defines, i.e., the
+	// slash-separated path from the repository root to the directory
+	// containing the file. "" for the root directory itself.
+	Pkg string
+
 	// Directives is a list of configuration directives found in top-level
 	// comments in the file. This should not be modified after the file is read.
 	Directives []config.Directive
@@ -59,49 +66,274 @@ type File struct {
 	// Rules is a list of rules within the file (or function calls that look like
 	// rules). This should not be modified directly; use Rule methods instead.
 	Rules []*Rule
+
+	// DefName is the name of the function definition in a .bzl file that
+	// contains Rules. It is "" for build files and WORKSPACE, where rules are
+	// at the top level. See LoadMacroFile.
+	DefName string
+
+	// function is the function definition named DefName. It is nil if
+	// DefName is "".
+	function *function
+}
+
+// function describes a function definition in a .bzl file whose body
+// contains the rules of a File.
+type function struct {
+	stmt     *bzl.FuncDef
+	inserted bool
 }
 
 // EmptyFile creates a File wrapped around an empty syntax tree.
-func EmptyFile(path string) *File {
+func EmptyFile(path, pkg string) *File {
 	return &File{
 		File: &bzl.File{Path: path},
 		Path: path,
+		Pkg:  pkg,
 	}
 }
 
+// EmptyMacroFile creates a File wrapped around an empty syntax tree for a
+// .bzl file containing an empty function definition named defName. Rules
+// inserted into the file are added to the body of that function.
+func EmptyMacroFile(path, pkg, defName string) *File {
+	f := EmptyFile(path, pkg)
+	f.DefName = defName
+	f.function = newFunction(defName)
+	return f
+}
+
 // LoadFile loads a build file from disk, parses it, and scans for rules and
 // load statements. The syntax tree within the returned File will be modified
 // by editing methods.
 //
 // This function returns I/O and parse errors without modification. It's safe
 // to use os.IsNotExist and similar predicates.
-func LoadFile(path string) (*File, error) {
+func LoadFile(path, pkg string) (*File, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return LoadData(path, data)
+	return LoadData(path, pkg, data)
 }
 
 // LoadData parses a build file from a byte slice and scans it for rules and
 // load statements. The syntax tree within the returned File will be modified
 // by editing methods.
-func LoadData(path string, data []byte) (*File, error) {
+func LoadData(path, pkg string, data []byte) (*File, error) {
+	ast, err := bzl.Parse(path, data)
+	if err != nil {
+		return nil, err
+	}
+	return ScanAST(pkg, ast), nil
+}
+
+// LoadMacroFile loads a .bzl file from disk, parses it, and scans it for
+// load statements at the top level and rules in the body of the function
+// named defName. If there is no such function, an empty one is added to the
+// end of the file when the file is synced. The syntax tree within the
+// returned File will be modified by editing methods.
+//
+// This function returns I/O and parse errors without modification. It's safe
+// to use os.IsNotExist and similar predicates.
+func LoadMacroFile(path, pkg, defName string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadMacroData(path, pkg, defName, data)
+}
+
+// LoadMacroData parses a .bzl file from a byte slice and scans it for load
+// statements at the top level and rules in the body of the function named
+// defName. See LoadMacroFile.
+func LoadMacroData(path, pkg, defName string, data []byte) (*File, error) {
+	ast, err := parseMacro(path, defName, data)
+	if err != nil {
+		return nil, err
+	}
+	return ScanASTBody(pkg, defName, ast), nil
+}
+
+// parseMacro parses a .bzl file. The build file parser passes function
+// definitions through as raw Python blocks, so each top-level definition is
+// parsed separately and replaced with a FuncDef. A definition that can't be
+// parsed this way is left as it is, unless it's the definition of defName.
+// buildtools can parse definitions directly when tables.FormatBzlFiles is
+// set, but that is a global flag that affects every file parsed at the
+// same time.
+func parseMacro(path, defName string, data []byte) (*bzl.File, error) {
 	ast, err := bzl.Parse(path, data)
 	if err != nil {
 		return nil, err
 	}
-	return ScanAST(ast), nil
+	for i, stmt := range ast.Stmt {
+		block, ok := stmt.(*bzl.PythonBlock)
+		if !ok {
+			continue
+		}
+		m := funcDefRe.FindStringSubmatchIndex(block.Token)
+		if m == nil {
+			continue
+		}
+		def, err := parseFuncDef(path, block, m)
+		if err != nil {
+			if block.Token[m[2]:m[3]] == defName {
+				return nil, err
+			}
+			continue
+		}
+		ast.Stmt[i] = def
+	}
+	return ast, nil
+}
+
+var funcDefRe = regexp.MustCompile(`^def\s+([A-Za-z_][A-Za-z0-9_]*)\s*\(`)
+
+// parseFuncDef parses a raw Python block containing a function definition.
+// m is the match of funcDefRe against the block's text.
+func parseFuncDef(path string, block *bzl.PythonBlock, m []int) (*bzl.FuncDef, error) {
+	text := block.Token
+	name := text[m[2]:m[3]]
+	parseErr := func(msg string) error {
+		return fmt.Errorf("%s:%d: definition of %s: %s", path, block.Start.Line, name, msg)
+	}
+
+	// Find the end of the parameter list, then the colon that starts the body.
+	end := m[1]
+	depth := 1
+	var quote byte
+	for ; end < len(text) && depth > 0; end++ {
+		switch c := text[end]; {
+		case quote != 0 && c == '\\':
+			end++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		}
+	}
+	params := text[m[1] : end-1]
+	rest := strings.TrimLeft(text[end:], " \t")
+	if depth > 0 || !strings.HasPrefix(rest, ":") {
+		return nil, parseErr("could not find the end of the parameter list")
+	}
+	rest = rest[len(":"):]
+
+	paramFile, err := bzl.Parse(path, []byte("f("+params+")\n"))
+	if err != nil {
+		return nil, parseErr(err.Error())
+	}
+	call, ok := paramFile.Stmt[0].(*bzl.CallExpr)
+	if len(paramFile.Stmt) != 1 || !ok {
+		return nil, parseErr("could not parse the parameter list")
+	}
+
+	// The body either follows the colon on the same line or is indented on
+	// the lines after it.
+	var body string
+	if i := strings.IndexByte(rest, '\n'); i < 0 {
+		body = rest
+	} else if first := strings.TrimSpace(rest[:i]); first != "" && first[0] != '#' {
+		body = first + "\n"
+	} else {
+		body = dedent(rest[i+1:])
+	}
+	bodyFile, err := bzl.Parse(path, []byte(body))
+	if err != nil {
+		return nil, parseErr(err.Error())
+	}
+	stmts := bodyFile.Stmt
+	for _, stmt := range stmts {
+		// Nested blocks are raw text too. The printer indents their first
+		// line, so the rest are indented here.
+		if nested, ok := stmt.(*bzl.PythonBlock); ok {
+			nested.Token = strings.Replace(strings.TrimSuffix(nested.Token, "\n"), "\n", "\n  ", -1) + "\n"
+		}
+	}
+	if len(stmts) > 0 {
+		last := stmts[len(stmts)-1].Comment()
+		last.After = append(last.After, bodyFile.After...)
+	}
+
+	return &bzl.FuncDef{
+		Comments:     block.Comments,
+		Start:        block.Start,
+		Name:         name,
+		Args:         call.List,
+		Body:         bzl.CodeBlock{Statements: stmts},
+		ForceCompact: !strings.Contains(params, "\n"),
+	}, nil
+}
+
+// dedent removes the indentation of the first non-blank line in s from the
+// beginning of each line in s.
+func dedent(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	indent := ""
+	for _, line := range lines {
+		if trimmed := strings.TrimLeft(line, " \t"); trimmed != "" && trimmed != "\n" {
+			indent = line[:len(line)-len(trimmed)]
+			break
+		}
+	}
+	for i, line := range lines {
+		n := 0
+		for n < len(indent) && n < len(line) && (line[n] == ' ' || line[n] == '\t') {
+			n++
+		}
+		lines[i] = line[n:]
+	}
+	return strings.Join(lines, "")
 }
 
 // ScanAST creates a File wrapped around the given syntax tree. This tree
 // will be modified by editing methods.
-func ScanAST(bzlFile *bzl.File) *File {
+func ScanAST(pkg string, bzlFile *bzl.File) *File {
 	f := &File{
 		File: bzlFile,
 		Path: bzlFile.Path,
+		Pkg:  pkg,
+	}
+	f.Loads, f.Rules = scanStmts(f.File.Stmt)
+	f.Directives = config.ParseDirectives(bzlFile)
+	return f
+}
+
+// ScanASTBody creates a File wrapped around the given syntax tree of a .bzl
+// file. Load statements are read from the top level, and rules are read from
+// the body of the function named defName. This tree will be modified by
+// editing methods.
+func ScanASTBody(pkg, defName string, bzlFile *bzl.File) *File {
+	f := &File{
+		File:    bzlFile,
+		Path:    bzlFile.Path,
+		Pkg:     pkg,
+		DefName: defName,
+	}
+	f.Loads, _ = scanStmts(f.File.Stmt)
+	for _, stmt := range f.File.Stmt {
+		if def, ok := stmt.(*bzl.FuncDef); ok && def.Name == defName {
+			f.function = &function{stmt: def}
+			break
+		}
+	}
+	if f.function == nil {
+		f.function = newFunction(defName)
 	}
-	for i, stmt := range f.File.Stmt {
+	_, f.Rules = scanStmts(f.function.stmt.Body.Statements)
+	f.Directives = config.ParseDirectives(bzlFile)
+	return f
+}
+
+func scanStmts(stmts []bzl.Expr) (loads []*Load, rules []*Rule) {
+	for i, stmt := range stmts {
 		call, ok := stmt.(*bzl.CallExpr)
 		if !ok {
 			continue
@@ -112,16 +344,40 @@ func ScanAST(bzlFile *bzl.File) *File {
 		}
 		if x.Token == "load" {
 			if l := loadFromExpr(i, call); l != nil {
-				f.Loads = append(f.Loads, l)
+				loads = append(loads, l)
 			}
 		} else {
 			if r := ruleFromExpr(i, call); r != nil {
-				f.Rules = append(f.Rules, r)
+				rules = append(rules, r)
 			}
 		}
 	}
-	f.Directives = config.ParseDirectives(bzlFile)
-	return f
+	return loads, rules
+}
+
+func newFunction(name string) *function {
+	return &function{
+		stmt: &bzl.FuncDef{
+			Name: name,
+			Body: bzl.CodeBlock{
+				Statements: []bzl.Expr{&bzl.LiteralExpr{Token: "pass"}},
+			},
+			ForceCompact: true,
+		},
+		inserted: true,
+	}
+}
+
+// HasDefaultVisibility returns whether the file contains a "package" rule with
+// a "default_visibility" attribute. Rules generated by Gazelle should not
+// have their own visibility attributes if this is the case.
+func (f *File) HasDefaultVisibility() bool {
+	for _, r := range f.Rules {
+		if r.Kind() == "package" && r.Attr("default_visibility") != nil {
+			return true
+		}
+	}
+	return false
 }
 
 // Sync writes all changes back to the wrapped syntax tree. This should be
@@ -139,17 +395,17 @@ func (f *File) SyncIncludingHiddenAttrs() {
 }
 
 func (f *File) sync(includeHidden bool) {
-	var inserts, deletes []stmt
+	var loadInserts, loadDeletes, ruleInserts, ruleDeletes []stmt
 	var r, w int
 	for r, w = 0, 0; r < len(f.Loads); r++ {
 		s := f.Loads[r]
 		s.sync()
 		if s.deleted {
-			deletes = append(deletes, s)
+			loadDeletes = append(loadDeletes, s)
 			continue
 		}
 		if s.inserted {
-			inserts = append(inserts, s)
+			loadInserts = append(loadInserts, s)
 			s.inserted = false
 		}
 		f.Loads[w] = s
@@ -160,38 +416,91 @@ func (f *File) sync(includeHidden bool) {
 		s := f.Rules[r]
 		s.sync(includeHidden)
 		if s.deleted {
-			deletes = append(deletes, s)
+			ruleDeletes = append(ruleDeletes, s)
 			continue
 		}
 		if s.inserted {
-			inserts = append(inserts, s)
+			ruleInserts = append(ruleInserts, s)
 			s.inserted = false
 		}
 		f.Rules[w] = s
 		w++
 	}
 	f.Rules = f.Rules[:w]
+
+	if f.function == nil {
+		inserts := append(loadInserts, ruleInserts...)
+		deletes := append(loadDeletes, ruleDeletes...)
+		f.File.Stmt = updateStmts(f.File.Stmt, inserts, deletes)
+		f.reindex(f.File.Stmt, f.File.Stmt)
+		return
+	}
+
+	f.File.Stmt = updateStmts(f.File.Stmt, loadInserts, loadDeletes)
+	body := &f.function.stmt.Body
+	body.Statements = updateStmts(body.Statements, ruleInserts, ruleDeletes)
+	// A function body may not be empty. Keep "pass" only when there's nothing
+	// else in the body.
+	var stmts []bzl.Expr
+	for _, stmt := range body.Statements {
+		if l, ok := stmt.(*bzl.LiteralExpr); !ok || l.Token != "pass" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	if len(stmts) == 0 {
+		stmts = append(stmts, &bzl.LiteralExpr{Token: "pass"})
+	}
+	body.Statements = stmts
+	if f.function.inserted {
+		f.File.Stmt = append(f.File.Stmt, f.function.stmt)
+		f.function.inserted = false
+	}
+	f.reindex(f.File.Stmt, body.Statements)
+}
+
+// reindex updates the indices of loads and rules to match their positions
+// in the synced syntax tree, so that statements can be inserted relative to
+// them in later edits.
+func (f *File) reindex(loadStmts, ruleStmts []bzl.Expr) {
+	loadIndex := make(map[bzl.Expr]int)
+	for i, stmt := range loadStmts {
+		loadIndex[stmt] = i
+	}
+	for _, l := range f.Loads {
+		l.index = loadIndex[l.call]
+	}
+	ruleIndex := make(map[bzl.Expr]int)
+	for i, stmt := range ruleStmts {
+		ruleIndex[stmt] = i
+	}
+	for _, r := range f.Rules {
+		r.index = ruleIndex[r.call]
+	}
+}
+
+// updateStmts returns a copy of stmts with inserted statements added and
+// deleted statements removed.
+func updateStmts(oldStmt []bzl.Expr, inserts, deletes []stmt) []bzl.Expr {
 	sort.Stable(byIndex(deletes))
 	sort.Stable(byIndex(inserts))
-
-	oldStmt := f.File.Stmt
-	f.File.Stmt = make([]bzl.Expr, 0, len(oldStmt)-len(deletes)+len(inserts))
+	newStmt := make([]bzl.Expr, 0, len(oldStmt)-len(deletes)+len(inserts))
 	var ii, di int
 	for i, stmt := range oldStmt {
 		for ii < len(inserts) && inserts[ii].Index() == i {
-			f.File.Stmt = append(f.File.Stmt, inserts[ii].expr())
+			newStmt = append(newStmt, inserts[ii].expr())
 			ii++
 		}
 		if di < len(deletes) && deletes[di].Index() == i {
 			di++
 			continue
 		}
-		f.File.Stmt = append(f.File.Stmt, stmt)
+		newStmt = append(newStmt, stmt)
 	}
 	for ii < len(inserts) {
-		f.File.Stmt = append(f.File.Stmt, inserts[ii].expr())
+		newStmt = append(newStmt, inserts[ii].expr())
 		ii++
 	}
+	return newStmt
 }
 
 // Format formats the build file in a form that can be written to disk.
@@ -386,9 +695,10 @@ func (l *Load) sync() {
 // Rule represents a rule statement within a build file.
 type Rule struct {
 	baseStmt
-	kind  string
-	args  []bzl.Expr
-	attrs map[string]*bzl.BinaryExpr
+	kind    string
+	args    []bzl.Expr
+	attrs   map[string]*bzl.BinaryExpr
+	private map[string]interface{}
 }
 
 // NewRule creates a new, empty rule with the given kind and name.
@@ -405,8 +715,9 @@ func NewRule(kind, name string) *Rule {
 				List: []bzl.Expr{nameAttr},
 			},
 		},
-		kind:  kind,
-		attrs: map[string]*bzl.BinaryExpr{"name": nameAttr},
+		kind:    kind,
+		attrs:   map[string]*bzl.BinaryExpr{"name": nameAttr},
+		private: make(map[string]interface{}),
 	}
 	return r
 }
@@ -437,9 +748,10 @@ func ruleFromExpr(index int, expr bzl.Expr) *Rule {
 			index: index,
 			call:  call,
 		},
-		kind:  kind,
-		args:  args,
-		attrs: attrs,
+		kind:    kind,
+		args:    args,
+		attrs:   attrs,
+		private: make(map[string]interface{}),
 	}
 }
 
@@ -450,6 +762,13 @@ func (r *Rule) ShouldKeep() bool {
 	return ShouldKeep(r.call)
 }
 
+// Pos returns the position where the rule starts in its build file. Rules
+// that were not read from a file have a zero position.
+func (r *Rule) Pos() bzl.Position {
+	start, _ := r.call.Span()
+	return start
+}
+
 func (r *Rule) Kind() string {
 	return r.kind
 }
@@ -553,25 +872,44 @@ func (r *Rule) SetAttr(key string, value interface{}) {
 	r.updated = true
 }
 
+// PrivateAttr returns the private value associated with a key. Private
+// values are never written to build files. Languages may use them to pass
+// information about generated rules to other languages, for example, from
+// proto_library to go_proto_library.
+func (r *Rule) PrivateAttr(key string) interface{} {
+	return r.private[key]
+}
+
+// SetPrivateAttr associates a value with a key. Unlike SetAttr, this value
+// is not converted to a build syntax tree and will not be written to a build
+// file.
+func (r *Rule) SetPrivateAttr(key string, value interface{}) {
+	r.private[key] = value
+}
+
 // Insert marks this statement for insertion at the end of the file. Multiple
 // statements will be inserted in the order Insert is called.
 func (r *Rule) Insert(f *File) {
 	// TODO(jayconrod): should rules always be inserted at the end? Should there
 	// be some sort order?
-	r.index = len(f.File.Stmt)
+	if f.function != nil {
+		r.index = len(f.function.stmt.Body.Statements)
+	} else {
+		r.index = len(f.File.Stmt)
+	}
 	r.inserted = true
 	f.Rules = append(f.Rules, r)
 }
 
-// IsEmpty returns true when the rule contains none of the attributes in attrs
-// for its kind. attrs should contain attributes that make the rule buildable
+// IsEmpty returns true when the rule contains none of the attributes in
+// info.NonEmptyAttrs. These should be attributes that make the rule buildable
 // like srcs or deps and not descriptive attributes like name or visibility.
-func (r *Rule) IsEmpty(attrs config.MergeableAttrs) bool {
-	nonEmptyAttrs := attrs[r.kind]
-	if nonEmptyAttrs == nil {
+// Rules of kinds with no NonEmptyAttrs are never considered empty.
+func (r *Rule) IsEmpty(info KindInfo) bool {
+	if info.NonEmptyAttrs == nil {
 		return false
 	}
-	for k := range nonEmptyAttrs {
+	for k := range info.NonEmptyAttrs {
 		if _, ok := r.attrs[k]; ok {
 			return false
 		}
@@ -37,7 +37,7 @@ load("b.bzl", y_library = "y")
 
 y_library(name = "bar")
 `)
-	f, err := LoadData("old", old)
+	f, err := LoadData("old", "", old)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -76,8 +76,100 @@ z_library(name = "baz")
 	}
 }
 
+func TestEditAndSyncMacro(t *testing.T) {
+	old := []byte(`
+load("a.bzl", "x_library")
+
+def other():
+    x_library(name = "other")
+
+def deps():
+    x_library(name = "foo")
+    y_library(name = "bar")
+`)
+	f, err := LoadMacroData("old.bzl", "", "deps", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Loads) != 1 || len(f.Rules) != 2 {
+		t.Fatalf("got %d loads and %d rules; want 1 and 2", len(f.Loads), len(f.Rules))
+	}
+
+	f.Loads[0].Add("y_library")
+	f.Rules[0].Delete()
+	f.Rules[1].SetAttr("srcs", []string{"bar.y"})
+	baz := NewRule("z_library", "baz")
+	baz.Insert(f)
+	loadB := NewLoad("b.bzl")
+	loadB.Add("z_library")
+	loadB.Insert(f, 1)
+
+	got := strings.TrimSpace(string(f.Format()))
+	want := strings.TrimSpace(`
+load("a.bzl", "x_library", "y_library")
+load("b.bzl", "z_library")
+
+def other():
+  x_library(name = "other")
+
+def deps():
+  y_library(
+      name = "bar",
+      srcs = ["bar.y"],
+  )
+  z_library(name = "baz")
+`)
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEmptyMacro(t *testing.T) {
+	f, err := LoadMacroData("old.bzl", "", "deps", []byte(`load("a.bzl", "x_library")`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.TrimSpace(string(f.Format()))
+	want := strings.TrimSpace(`
+load("a.bzl", "x_library")
+
+def deps():
+  pass
+`)
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Rules inserted after the function was added replace "pass". Rules
+	// deleted later put it back.
+	r := NewRule("x_library", "foo")
+	r.Insert(f)
+	got = strings.TrimSpace(string(f.Format()))
+	want = strings.TrimSpace(`
+load("a.bzl", "x_library")
+
+def deps():
+  x_library(name = "foo")
+`)
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	r.Delete()
+	got = strings.TrimSpace(string(f.Format()))
+	want = strings.TrimSpace(`
+load("a.bzl", "x_library")
+
+def deps():
+  pass
+`)
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
 func TestSymbolsReturnsKeys(t *testing.T) {
-	f, err := LoadData("load", []byte(`load("a.bzl", "y", z = "a")`))
+	f, err := LoadData("load", "", []byte(`load("a.bzl", "y", z = "a")`))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -132,7 +224,7 @@ x_library(name = "x")
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			f, err := LoadData(tc.desc, []byte(tc.src))
+			f, err := LoadData(tc.desc, "", []byte(tc.src))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -218,7 +310,7 @@ func TestRuleAttrKeysDoesNotReturnHiddenSymbols(t *testing.T) {
 }
 
 func TestRuleHiddenAttrsNotSynced(t *testing.T) {
-	f := EmptyFile("BUILD")
+	f := EmptyFile("BUILD", "")
 	r := NewRule("go_library", "go_default_library")
 	r.SetAttr("srcs", []string{"x.go"})
 	r.SetAttr("_hidden", []string{"x.go"})
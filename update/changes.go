@@ -0,0 +1,138 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package update
+
+import (
+	"sort"
+
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// ruleKey identifies a rule in a build file. Rules are identified by kind
+// and name. index distinguishes calls with the same kind and name; this is
+// mostly needed for unnamed calls like package() and licenses().
+type ruleKey struct {
+	kind, name string
+	index      int
+}
+
+// ruleKeys returns a key for each rule in f, in the same order as f.Rules.
+func ruleKeys(f *rule.File) []ruleKey {
+	keys := make([]ruleKey, len(f.Rules))
+	seen := make(map[ruleKey]int)
+	for i, r := range f.Rules {
+		key := ruleKey{kind: r.Kind(), name: r.Name()}
+		n := seen[key]
+		seen[key] = n + 1
+		key.index = n
+		keys[i] = key
+	}
+	return keys
+}
+
+// ruleSnapshot records the formatted attributes of a rule before Gazelle
+// modifies it.
+type ruleSnapshot struct {
+	attrs map[string]string
+}
+
+// snapshotRules records the rules in f, so they can be compared with the
+// rules in f after it has been fixed, merged and resolved. f may be nil.
+func snapshotRules(f *rule.File) map[ruleKey]ruleSnapshot {
+	rules := make(map[ruleKey]ruleSnapshot)
+	if f == nil {
+		return rules
+	}
+	for i, key := range ruleKeys(f) {
+		rules[key] = ruleSnapshot{attrs: formatAttrs(f.Rules[i])}
+	}
+	return rules
+}
+
+func formatAttrs(r *rule.Rule) map[string]string {
+	attrs := make(map[string]string)
+	for _, key := range r.AttrKeys() {
+		attrs[key] = bzl.FormatString(r.Attr(key))
+	}
+	return attrs
+}
+
+// newFile compares the rules in v.file with the rules recorded before the
+// file was modified. Unresolved imports are read from the generated rules.
+// rel is the slash-separated path to the file relative to the repository
+// root. Content is not set.
+//
+// A rule whose kind changed is reported as deleted and created.
+func newFile(rel string, v visitRecord) File {
+	f := File{Path: rel, Pkg: v.pkgRel, Imports: v.imports}
+	after := make(map[ruleKey]bool)
+	for i, key := range ruleKeys(v.file) {
+		after[key] = true
+		old, ok := v.before[key]
+		if !ok {
+			f.Created = append(f.Created, RuleChange{Kind: key.kind, Name: key.name})
+			continue
+		}
+		attrs := formatAttrs(v.file.Rules[i])
+		var changes []AttrChange
+		for name, oldValue := range old.attrs {
+			if newValue := attrs[name]; newValue != oldValue {
+				changes = append(changes, AttrChange{Name: name, Old: oldValue, New: newValue})
+			}
+		}
+		for name, newValue := range attrs {
+			if _, ok := old.attrs[name]; !ok {
+				changes = append(changes, AttrChange{Name: name, New: newValue})
+			}
+		}
+		if len(changes) > 0 {
+			sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+			f.Updated = append(f.Updated, RuleChange{Kind: key.kind, Name: key.name, Attrs: changes})
+		}
+	}
+
+	var deleted []ruleKey
+	for key := range v.before {
+		if !after[key] {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		if deleted[i].name != deleted[j].name {
+			return deleted[i].name < deleted[j].name
+		}
+		if deleted[i].kind != deleted[j].kind {
+			return deleted[i].kind < deleted[j].kind
+		}
+		return deleted[i].index < deleted[j].index
+	})
+	for _, key := range deleted {
+		f.Deleted = append(f.Deleted, RuleChange{Kind: key.kind, Name: key.name})
+	}
+
+	for _, r := range v.rules {
+		for _, u := range resolve.UnresolvedImports(r) {
+			f.Unresolved = append(f.Unresolved, Unresolved{
+				Rule:   r.Name(),
+				Import: u.Imp,
+				Err:    u.Err,
+			})
+		}
+	}
+	return f
+}
@@ -0,0 +1,197 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package update
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+const mapKindName = "map_kind"
+
+// mappedKind describes a replacement for a kind of rule generated by Gazelle.
+type mappedKind struct {
+	// fromKind is the kind of rule Gazelle generates, for example, go_test.
+	fromKind string
+
+	// kindName is the kind Gazelle writes instead, for example, a macro
+	// named corp_go_test.
+	kindName string
+
+	// kindLoad is the label of the .bzl file that kindName is loaded from.
+	kindLoad string
+}
+
+// getKindMap returns the kinds mapped in the directory c applies to, keyed by
+// the kind they are mapped from. The returned map must not be modified.
+func getKindMap(c *config.Config) map[string]mappedKind {
+	kindMap, _ := c.Exts[mapKindName].(map[string]mappedKind)
+	return kindMap
+}
+
+// mappedKindFor returns the mapping for the replacement kind kind, if kind
+// is the replacement for a kind Gazelle generates.
+func mappedKindFor(c *config.Config, kind string) (mappedKind, bool) {
+	for _, mk := range getKindMap(c) {
+		if mk.kindName == kind {
+			return mk, true
+		}
+	}
+	return mappedKind{}, false
+}
+
+// kindConfigurer reads map_kind directives, which replace a kind of rule
+// Gazelle generates with another kind, for example, a macro that wraps the
+// original rule. Directives have the form below.
+//
+//	# gazelle:map_kind from_kind to_kind to_kind_load
+//
+// Mappings apply in the directory where they are set and in subdirectories.
+type kindConfigurer struct{}
+
+func (*kindConfigurer) KnownDirectives() []string {
+	return []string{mapKindName}
+}
+
+func (*kindConfigurer) Configure(c *config.Config, rel string, f *bzl.File, directives []config.Directive) {
+	var kindMap map[string]mappedKind
+	for _, d := range directives {
+		if d.Key != mapKindName {
+			continue
+		}
+		mk, err := parseMapKind(c, kindMap, d.Value)
+		if err != nil {
+			config.ReportInvalidDirective(c, f, d, err)
+			continue
+		}
+		if kindMap == nil {
+			// The map may be shared with the parent directory's configuration,
+			// so copy it before changing it.
+			kindMap = make(map[string]mappedKind)
+			for k, v := range getKindMap(c) {
+				kindMap[k] = v
+			}
+		}
+		kindMap[mk.fromKind] = mk
+	}
+	if kindMap != nil {
+		if c.Exts == nil {
+			c.Exts = make(map[string]interface{})
+		}
+		c.Exts[mapKindName] = kindMap
+	}
+}
+
+// parseMapKind parses the value of a map_kind directive, which has the form
+// "from_kind to_kind to_kind_load". kindMap holds mappings set earlier in
+// the same directory; it is nil if there are none.
+func parseMapKind(c *config.Config, kindMap map[string]mappedKind, value string) (mappedKind, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return mappedKind{}, fmt.Errorf("expected from_kind, to_kind, and to_kind_load; got %d arguments", len(fields))
+	}
+	mk := mappedKind{fromKind: fields[0], kindName: fields[1], kindLoad: fields[2]}
+	if mk.fromKind == mk.kindName {
+		return mappedKind{}, fmt.Errorf("kind %s is mapped to itself", mk.fromKind)
+	}
+	if kindMap == nil {
+		kindMap = getKindMap(c)
+	}
+	for _, other := range kindMap {
+		if other.kindName == mk.kindName && other.fromKind != mk.fromKind {
+			return mappedKind{}, fmt.Errorf("kind %s is already mapped from %s", mk.kindName, other.fromKind)
+		}
+	}
+	return mk, nil
+}
+
+// Kinds mapped with the map_kind directive are only used in build files.
+// While a file is fixed, merged, indexed and resolved, its rules have the
+// kinds Gazelle generates, so language extensions and the merger don't need
+// to know about mapped kinds. unmapKinds is called when a file is loaded,
+// and mapKinds is called before the file is formatted.
+
+// unmapKinds changes the kinds of rules in f that have mapped kinds back to
+// the kinds they were mapped from. The changed rules are returned.
+func unmapKinds(c *config.Config, f *rule.File) []*rule.Rule {
+	if f == nil || len(getKindMap(c)) == 0 {
+		return nil
+	}
+	var unmapped []*rule.Rule
+	for _, r := range f.Rules {
+		if mk, ok := mappedKindFor(c, r.Kind()); ok {
+			r.SetKind(mk.fromKind)
+			unmapped = append(unmapped, r)
+		}
+	}
+	return unmapped
+}
+
+// restoreKinds changes the kinds of rules returned by unmapKinds back to the
+// kinds they were mapped to.
+func restoreKinds(c *config.Config, unmapped []*rule.Rule) {
+	kindMap := getKindMap(c)
+	for _, r := range unmapped {
+		if mk, ok := kindMap[r.Kind()]; ok {
+			r.SetKind(mk.kindName)
+		}
+	}
+}
+
+// mapKinds changes the kinds of rules in f to the kinds they are mapped to.
+// Rules marked with "# keep" are not changed.
+func mapKinds(c *config.Config, f *rule.File) {
+	kindMap := getKindMap(c)
+	for _, r := range f.Rules {
+		if mk, ok := kindMap[r.Kind()]; ok && !r.ShouldKeep() {
+			r.SetKind(mk.kindName)
+		}
+	}
+}
+
+// mappedLoads returns loads with the load files for mapped kinds added, so
+// merger.FixLoads adds and removes loads of mapped kinds.
+func mappedLoads(c *config.Config, loads []rule.LoadInfo) []rule.LoadInfo {
+	kindMap := getKindMap(c)
+	if len(kindMap) == 0 {
+		return loads
+	}
+	fromKinds := make([]string, 0, len(kindMap))
+	for kind := range kindMap {
+		fromKinds = append(fromKinds, kind)
+	}
+	sort.Strings(fromKinds)
+
+	mapped := append([]rule.LoadInfo(nil), loads...)
+	for _, kind := range fromKinds {
+		mk := kindMap[kind]
+		i := 0
+		for i < len(mapped) && mapped[i].Name != mk.kindLoad {
+			i++
+		}
+		if i == len(mapped) {
+			mapped = append(mapped, rule.LoadInfo{Name: mk.kindLoad})
+		}
+		symbols := append([]string(nil), mapped[i].Symbols...)
+		mapped[i].Symbols = append(symbols, mk.kindName)
+	}
+	return mapped
+}
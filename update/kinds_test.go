@@ -0,0 +1,59 @@
+/*
+	Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package update
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+func TestKindConfigurer(t *testing.T) {
+	c := &config.Config{}
+	kc := &kindConfigurer{}
+	kc.Configure(c, "", nil, []config.Directive{
+		{Key: "map_kind", Value: "go_test corp_go_test //build:go.bzl"},
+		{Key: "map_kind", Value: "go_library corp_go_library //build:go.bzl"},
+		{Key: "map_kind", Value: "go_binary corp_go_test //build:go.bzl"},
+		{Key: "map_kind", Value: "go_binary //build:go.bzl"},
+	})
+	want := map[string]mappedKind{
+		"go_test":    {fromKind: "go_test", kindName: "corp_go_test", kindLoad: "//build:go.bzl"},
+		"go_library": {fromKind: "go_library", kindName: "corp_go_library", kindLoad: "//build:go.bzl"},
+	}
+	if got := getKindMap(c); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v ; want %#v", got, want)
+	}
+}
+
+func TestMapKindInherited(t *testing.T) {
+	parent := &config.Config{}
+	kc := &kindConfigurer{}
+	kc.Configure(parent, "", nil, []config.Directive{{Key: "map_kind", Value: "go_test corp_go_test //build:go.bzl"}})
+	child := parent.Clone()
+	kc.Configure(child, "sub", nil, []config.Directive{{Key: "map_kind", Value: "go_test other_go_test //other:go.bzl"}})
+
+	if got := getKindMap(parent)["go_test"].kindName; got != "corp_go_test" {
+		t.Errorf("parent: got go_test mapped to %q; want %q", got, "corp_go_test")
+	}
+	if got := getKindMap(child)["go_test"].kindName; got != "other_go_test" {
+		t.Errorf("child: got go_test mapped to %q; want %q", got, "other_go_test")
+	}
+	if mk, ok := mappedKindFor(child, "other_go_test"); !ok || mk.fromKind != "go_test" {
+		t.Errorf("child: got mappedKindFor(other_go_test) = %#v, %v; want go_test", mk, ok)
+	}
+}
@@ -0,0 +1,87 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package update
+
+import (
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// ruleNames records the names of the rules in f, so that rules renamed by
+// language extensions can be found later with findRenames. f may be nil.
+func ruleNames(f *rule.File) map[*rule.Rule]string {
+	names := make(map[*rule.Rule]string)
+	if f == nil {
+		return names
+	}
+	for _, r := range f.Rules {
+		names[r] = r.Name()
+	}
+	return names
+}
+
+// findRenames adds the labels of rules in f that were renamed since names
+// were recorded to renames, mapping old labels to new labels. f must be
+// synced, so that deleted rules are not considered.
+func findRenames(f *rule.File, names map[*rule.Rule]string, renames map[label.Label]label.Label) {
+	for _, r := range f.Rules {
+		if oldName, ok := names[r]; ok && oldName != r.Name() {
+			renames[label.New("", f.Pkg, oldName)] = label.New("", f.Pkg, r.Name())
+		}
+	}
+}
+
+// updateReferences replaces labels of renamed rules in the attributes of
+// rules in f. Only strings written with label syntax (starting with ":",
+// "//", or "@") are considered. Relative labels stay relative. It returns
+// whether any labels were replaced.
+func updateReferences(f *rule.File, renames map[label.Label]label.Label) bool {
+	changed := false
+	for _, r := range f.Rules {
+		for _, key := range r.AttrKeys() {
+			if key == "name" {
+				continue
+			}
+			bzl.Walk(r.Attr(key), func(e bzl.Expr, _ []bzl.Expr) {
+				str, ok := e.(*bzl.StringExpr)
+				if !ok || !isLabelString(str.Value) {
+					return
+				}
+				l, err := label.Parse(str.Value)
+				if err != nil {
+					return
+				}
+				to, ok := renames[l.Abs("", f.Pkg)]
+				if !ok {
+					return
+				}
+				if l.Relative && to.Pkg == f.Pkg {
+					to = label.Label{Name: to.Name, Relative: true}
+				}
+				str.Value = to.String()
+				changed = true
+			})
+		}
+	}
+	return changed
+}
+
+func isLabelString(s string) bool {
+	return strings.HasPrefix(s, ":") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "@")
+}
@@ -0,0 +1,670 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package update runs Gazelle in-process. It generates rules for the
+// directories in a repository, merges them into existing build files,
+// resolves dependencies, and returns the new content of each build file
+// together with a description of what changed. Nothing is written unless
+// Options.Write is set.
+//
+// cmd/gazelle uses this package to implement the fix, update and watch
+// commands. Other tools may use it directly instead of running the binary.
+package update
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/diag"
+	"github.com/bazelbuild/bazel-gazelle/internal/merger"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	golang "github.com/bazelbuild/bazel-gazelle/language/go"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
+	"github.com/bazelbuild/bazel-gazelle/packages"
+	"github.com/bazelbuild/bazel-gazelle/repos"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// Options controls how Update generates build files. Only RepoRoot is
+// required. Zero values of other fields have the same meaning as the
+// defaults of the corresponding gazelle command line flags.
+type Options struct {
+	// RepoRoot is the path to the root directory of the repository, which
+	// usually contains a WORKSPACE file.
+	RepoRoot string
+
+	// Dirs is a list of directories to update. Subdirectories are updated,
+	// too. Relative paths are interpreted relative to RepoRoot. If Dirs is
+	// empty, the whole repository is updated.
+	Dirs []string
+
+	// GoPrefix is the import path that corresponds to RepoRoot. A
+	// "# gazelle:prefix" directive in the root build file takes precedence.
+	GoPrefix string
+
+	// BuildFileNames is a list of valid build file names. The first name is
+	// used for new build files. Defaults to "BUILD.bazel" and "BUILD".
+	BuildFileNames []string
+
+	// BuildTags is a list of build tags. If it is empty, sources are not
+	// filtered by build constraints.
+	BuildTags []string
+
+	// DepMode determines how imports that are not provided by the repository
+	// are resolved.
+	DepMode config.DependencyMode
+
+	// ProtoMode determines how proto rules are generated. ProtoModeExplicit
+	// should be set if ProtoMode was set intentionally; otherwise, the mode
+	// may be inferred from existing rules.
+	ProtoMode         config.ProtoMode
+	ProtoModeExplicit bool
+
+	// Fix enables potentially breaking changes to existing rules, like
+	// "gazelle fix" does.
+	Fix bool
+
+	// KnownImports is a list of import path prefixes that are provided by
+	// external repositories, in addition to those declared in WORKSPACE.
+	KnownImports []string
+
+	// Proxy is a Go module proxy used to find the repositories that provide
+	// external imports. It may be nil.
+	Proxy *repos.ModuleProxy
+
+	// Languages is the list of language extensions used to generate rules.
+	// Defaults to proto and go.
+	Languages []language.Language
+
+	// Cache stores information about source files and directories between
+	// calls. Directories that haven't changed since their build files were
+	// written are skipped. It may be nil. Update does not save the cache.
+	Cache *packages.Cache
+
+	// Write causes Update to write build files that changed to disk.
+	// Directories are only marked up to date in Cache when this is set.
+	Write bool
+}
+
+// Result describes the build files generated by Update.
+type Result struct {
+	// Files lists the build files that were generated or updated, sorted by
+	// path. Files that were not changed are included.
+	Files []File
+
+	// Diagnostics lists problems found while generating build files, for
+	// example, imports that could not be resolved, sorted by position.
+	Diagnostics []diag.Diagnostic
+
+	// Dirs lists all directories that were visited, including directories
+	// that were not updated, as slash-separated paths relative to the
+	// repository root. The root is "".
+	Dirs []string
+
+	// Index is the index used to resolve dependencies. It includes rules
+	// from all visited build files.
+	Index *resolve.RuleIndex
+}
+
+// File describes a generated build file.
+type File struct {
+	// Path is the slash-separated path to the build file, relative to the
+	// repository root.
+	Path string
+
+	// Pkg is the slash-separated path to the directory containing the
+	// build file, relative to the repository root.
+	Pkg string
+
+	// OldContent is the content of the build file before it was updated.
+	// It is nil if the file did not exist.
+	OldContent []byte
+
+	// Content is the formatted content of the updated build file.
+	Content []byte
+
+	// Created lists rules that did not exist before.
+	Created []RuleChange
+
+	// Updated lists existing rules with attributes that were changed.
+	Updated []RuleChange
+
+	// Deleted lists rules that were removed, usually because they were empty.
+	Deleted []RuleChange
+
+	// Unresolved lists imports that could not be resolved to dependencies.
+	Unresolved []Unresolved
+
+	// Imports lists strings imported by the generated rules, before they
+	// were resolved to dependencies.
+	Imports []string
+}
+
+// Changed returns whether the content of the file is different from the
+// content on disk before Update was called.
+func (f File) Changed() bool {
+	return f.OldContent == nil || !bytes.Equal(f.OldContent, f.Content)
+}
+
+// RuleChange describes a rule that was created, updated or deleted.
+type RuleChange struct {
+	Kind, Name string
+
+	// Attrs lists attributes that were changed. It is only set for updated
+	// rules.
+	Attrs []AttrChange
+}
+
+// AttrChange describes a change to an attribute. Old and New are the
+// formatted values of the attribute; each is empty if the attribute was
+// added or removed.
+type AttrChange struct {
+	Name, Old, New string
+}
+
+// Unresolved describes an import that could not be resolved.
+type Unresolved struct {
+	// Rule is the name of the rule with the import.
+	Rule string
+
+	Import string
+	Err    error
+}
+
+// visitRecord stores information about about a directory visited with
+// packages.Walk.
+type visitRecord struct {
+	// pkgRel is the slash-separated path to the visited directory, relative to
+	// the repository root. "" for the repository root itself.
+	pkgRel string
+
+	// rules is a list of generated rules.
+	rules []*rule.Rule
+
+	// empty is a list of empty rules that may be deleted.
+	empty []*rule.Rule
+
+	// file is the build file being processed.
+	file *rule.File
+
+	// c is the configuration for the visited directory. Dependencies are
+	// resolved with this, so directives like resolve are respected.
+	c *config.Config
+
+	// before records the rules in the build file before it was modified.
+	before map[ruleKey]ruleSnapshot
+
+	// imports lists strings imported by the generated rules.
+	imports []string
+
+	// refsOnly is true if the directory was not updated, but references to
+	// renamed rules in its build file were.
+	refsOnly bool
+
+	// unmapped lists rules in the build file that had kinds mapped with the
+	// map_kind directive. Their kinds are changed back before the file is
+	// formatted.
+	unmapped []*rule.Rule
+}
+
+// Update generates build files in the directories named by opts.Dirs,
+// resolves dependencies, and returns the new content of each build file.
+// Files are only written if opts.Write is set. Update returns early with
+// ctx.Err() if ctx is cancelled.
+func Update(ctx context.Context, opts Options) (Result, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return Result{}, err
+	}
+	langs := opts.Languages
+	if langs == nil {
+		langs = []language.Language{proto.New(), golang.New()}
+	}
+
+	var knownRepos []repos.Repo
+	workspacePath := filepath.Join(c.RepoRoot, "WORKSPACE")
+	if workspace, err := rule.LoadFile(workspacePath, ""); err != nil {
+		if !os.IsNotExist(err) {
+			return Result{}, err
+		}
+	} else {
+		c.RepoName = findWorkspaceName(workspace)
+		knownRepos = repos.ListRepositories(workspace)
+	}
+	repoPrefixes := make(map[string]bool)
+	for _, r := range knownRepos {
+		repoPrefixes[r.GoPrefix] = true
+	}
+	for _, imp := range opts.KnownImports {
+		if repoPrefixes[imp] {
+			continue
+		}
+		knownRepos = append(knownRepos, repos.Repo{
+			Name:     label.ImportPathToBazelRepoName(imp),
+			GoPrefix: imp,
+		})
+	}
+
+	cexts := make([]config.Configurer, 0, len(langs)+4)
+	cexts = append(cexts, &config.CommonConfigurer{}, &language.VisibilityConfigurer{}, &kindConfigurer{}, &resolve.Configurer{})
+	kinds := make(map[string]rule.KindInfo)
+	kindToResolver := make(map[string]resolve.Resolver)
+	var loads []rule.LoadInfo
+	for _, lang := range langs {
+		cexts = append(cexts, lang)
+		for kind, info := range lang.Kinds() {
+			kinds[kind] = info
+			kindToResolver[kind] = lang
+		}
+		loads = append(loads, lang.Loads()...)
+	}
+	ruleIndex := resolve.NewRuleIndex(func(r *rule.Rule) resolve.Resolver {
+		return kindToResolver[r.Kind()]
+	})
+
+	result := Result{Index: ruleIndex}
+	var visits []visitRecord
+	var noFileDirs []string
+
+	// Build files are indexed after all directories are visited, in the
+	// order they were visited, so that references to rules renamed by
+	// language extensions can be updated first. others lists build files in
+	// directories that were not updated; references in them are updated, too.
+	var indexed, others []visitRecord
+	renames := make(map[label.Label]label.Label)
+
+	// Visit all directories in the repository. The walk can't be stopped
+	// early, but once ctx is done, nothing more is generated.
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	packages.WalkWithCache(c, cexts, c.RepoRoot, opts.Cache, func(dir, rel string, c *config.Config, pkg *packages.Package, file *rule.File, subdirs, regularFiles, genFiles []string, isUpdateDir bool) {
+		result.Dirs = append(result.Dirs, rel)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// If this file is ignored or if Gazelle was not asked to update this
+		// directory, just index the build file and move on.
+		if !isUpdateDir {
+			if file != nil {
+				v := visitRecord{pkgRel: rel, file: file, c: c, refsOnly: true}
+				indexed = append(indexed, v)
+				others = append(others, v)
+			}
+			return
+		}
+
+		before := snapshotRules(file)
+		unmapped := unmapKinds(c, file)
+
+		// Fix any problems in the file. Keep track of renamed rules, so
+		// references to them can be updated.
+		if file != nil {
+			names := ruleNames(file)
+			for _, lang := range langs {
+				lang.Fix(c, file)
+			}
+			file.Sync()
+			findRenames(file, names, renames)
+		}
+
+		// If no Go or proto code is present, create an empty package.
+		// This lets us delete existing rules.
+		if pkg == nil {
+			pkg = packages.EmptyPackage(c, dir, rel)
+		}
+
+		// Generate rules.
+		var empty, gen []*rule.Rule
+		for _, lang := range langs {
+			res, resEmpty := lang.GenerateRules(language.GenerateArgs{
+				Config:       c,
+				Dir:          dir,
+				Rel:          rel,
+				File:         file,
+				Subdirs:      subdirs,
+				RegularFiles: regularFiles,
+				GenFiles:     genFiles,
+				Package:      pkg,
+				OtherEmpty:   empty,
+				OtherGen:     gen,
+			})
+			empty = append(empty, resEmpty...)
+			gen = append(gen, res...)
+		}
+		if file == nil && len(gen) == 0 {
+			noFileDirs = append(noFileDirs, rel)
+			return
+		}
+
+		// Insert or merge rules into the build file.
+		if file == nil {
+			file = rule.EmptyFile(filepath.Join(c.RepoRoot, filepath.FromSlash(rel), c.DefaultBuildFileName()), rel)
+			for _, r := range gen {
+				r.Insert(file)
+			}
+		} else {
+			gen = merger.MergeFile(file, empty, gen, merger.PreResolve, kinds, c.Diagnostics)
+		}
+		var imports []string
+		for _, r := range gen {
+			if e := r.Attr(config.GazelleImportsKey); e != nil {
+				rule.MapExprStrings(e, func(imp string) string {
+					imports = append(imports, imp)
+					return imp
+				})
+			}
+		}
+		v := visitRecord{
+			pkgRel:   rel,
+			rules:    gen,
+			empty:    empty,
+			file:     file,
+			c:        c,
+			before:   before,
+			imports:  imports,
+			unmapped: unmapped,
+		}
+		visits = append(visits, v)
+		indexed = append(indexed, v)
+	})
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	// Update references to renamed rules. Build files in directories that
+	// were not updated are only included in the result if they changed.
+	refsOnlyDirs := make(map[string]bool)
+	if len(renames) > 0 {
+		for _, v := range visits {
+			updateReferences(v.file, renames)
+		}
+		for _, v := range others {
+			before := snapshotRules(v.file)
+			if updateReferences(v.file, renames) {
+				v.before = before
+				visits = append(visits, v)
+				refsOnlyDirs[v.pkgRel] = true
+			}
+		}
+	}
+
+	// Build the index for dependency resolution.
+	// Rules in directories that were not updated are indexed with the kinds
+	// their mapped kinds were mapped from.
+	for _, v := range indexed {
+		if v.refsOnly {
+			unmapped := unmapKinds(v.c, v.file)
+			ruleIndex.AddRulesFromFile(v.c, v.file)
+			restoreKinds(v.c, unmapped)
+		} else {
+			ruleIndex.AddRulesFromFile(v.c, v.file)
+		}
+	}
+	ruleIndex.Finish()
+
+	// Directories skipped because nothing in them changed may still import
+	// something that now resolves to a different rule, for example, a library
+	// added in another directory. Those directories are updated, too.
+	if opts.Cache != nil {
+		stale := false
+		for _, rel := range opts.Cache.SkippedDirs() {
+			imports, resolved := opts.Cache.SkippedImports(rel)
+			if resolutionHash(ruleIndex, imports) != resolved {
+				opts.Cache.Invalidate(rel)
+				stale = true
+			}
+		}
+		if stale {
+			return Update(ctx, opts)
+		}
+	}
+
+	// Resolve dependencies.
+	rc := repos.NewRemoteCache(knownRepos)
+	rc.Proxy = opts.Proxy
+	for _, v := range visits {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		for _, r := range v.rules {
+			from := label.New("", v.pkgRel, r.Name())
+			kindToResolver[r.Kind()].Resolve(v.c, ruleIndex, rc, r, from)
+		}
+		merger.MergeFile(v.file, v.empty, v.rules, merger.PostResolve, kinds, c.Diagnostics)
+	}
+
+	// Record resolved dependencies in the index, then narrow the visibility
+	// of rules in directories where minimal visibility is requested.
+	for _, v := range visits {
+		if !v.refsOnly {
+			ruleIndex.UpdateDependencies(v.file)
+		}
+	}
+	for _, v := range visits {
+		if !v.refsOnly && language.GetVisibilityMode(v.c) == language.MinimalVisibilityMode {
+			setMinimalVisibility(ruleIndex, v)
+		}
+	}
+
+	// Format merged files and compare them with the files on disk.
+	for _, v := range visits {
+		if !v.refsOnly {
+			restoreKinds(v.c, v.unmapped)
+			mapKinds(v.c, v.file)
+			merger.FixLoads(v.file, mappedLoads(v.c, loads))
+		}
+		v.file.Sync()
+		bzl.Rewrite(v.file.File, nil) // have buildifier 'format' our rules.
+
+		rel, err := filepath.Rel(c.RepoRoot, v.file.Path)
+		if err != nil {
+			return Result{}, err
+		}
+		f := newFile(filepath.ToSlash(rel), v)
+		f.Content = bzl.Format(v.file.File)
+		if data, err := ioutil.ReadFile(v.file.Path); err == nil {
+			f.OldContent = data
+		} else if !os.IsNotExist(err) {
+			return Result{}, err
+		}
+		reportUnresolved(c, v.file.Path, f)
+		result.Files = append(result.Files, f)
+	}
+	sort.Slice(result.Files, func(i, j int) bool { return result.Files[i].Path < result.Files[j].Path })
+
+	result.Diagnostics = c.Diagnostics.Diagnostics()
+
+	if opts.Write {
+		// Directories with problems are not marked up to date, so they are
+		// updated again on the next run, and problems found while generating
+		// and resolving rules are reported again. Problems found while parsing
+		// files are stored in the cache and reported each time it is used.
+		problemDirs := diagnosticDirs(result.Diagnostics, result.Dirs)
+		markUpToDate := func(rel string, content []byte, imports []string) {
+			if opts.Cache != nil && !problemDirs[rel] {
+				opts.Cache.MarkUpToDate(rel, content, imports, resolutionHash(ruleIndex, imports))
+			}
+		}
+		for _, f := range result.Files {
+			if f.Changed() {
+				if err := writeFile(filepath.Join(c.RepoRoot, filepath.FromSlash(f.Path)), f.Content); err != nil {
+					return result, err
+				}
+			}
+			if !refsOnlyDirs[f.Pkg] {
+				markUpToDate(f.Pkg, f.Content, f.Imports)
+			}
+		}
+		for _, rel := range noFileDirs {
+			markUpToDate(rel, nil, nil)
+		}
+	}
+	return result, nil
+}
+
+// resolutionHash identifies the rules in the index that may be imported
+// with each of imports. Imports of a directory resolve differently when this
+// changes.
+func resolutionHash(ix *resolve.RuleIndex, imports []string) string {
+	sorted := append([]string(nil), imports...)
+	sort.Strings(sorted)
+	h := sha256.New()
+	for i, imp := range sorted {
+		if i > 0 && imp == sorted[i-1] {
+			continue
+		}
+		fmt.Fprintf(h, "%q", imp)
+		for _, l := range ix.ImportProviders(imp) {
+			fmt.Fprintf(h, " %q", l.String())
+		}
+		fmt.Fprintln(h)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// diagnosticDirs returns the set of directories with diagnostics. A
+// diagnostic belongs to the directory at its position if that is one of
+// dirs ("." is the repository root). Otherwise, it belongs to the directory
+// containing the file at its position.
+func diagnosticDirs(diags []diag.Diagnostic, dirs []string) map[string]bool {
+	dirSet := make(map[string]bool)
+	for _, dir := range dirs {
+		dirSet[dir] = true
+	}
+	problemDirs := make(map[string]bool)
+	for _, d := range diags {
+		p := d.Pos.Path
+		if p != "." && !dirSet[p] {
+			p = path.Dir(p)
+		}
+		if p == "." {
+			p = ""
+		}
+		problemDirs[p] = true
+	}
+	return problemDirs
+}
+
+// newConfig builds the root configuration from opts. Paths are made
+// absolute, and symbolic links in RepoRoot are evaluated.
+func newConfig(opts Options) (*config.Config, error) {
+	if opts.RepoRoot == "" {
+		return nil, fmt.Errorf("repository root not set")
+	}
+	c := &config.Config{}
+	var err error
+	c.RepoRoot, err = filepath.Abs(opts.RepoRoot)
+	if err != nil {
+		return nil, err
+	}
+	c.RepoRoot, err = filepath.EvalSymlinks(c.RepoRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate symlinks for repo root: %v", err)
+	}
+	c.Diagnostics = diag.NewCollector(c.RepoRoot)
+
+	if len(opts.Dirs) == 0 {
+		c.Dirs = []string{c.RepoRoot}
+	}
+	for _, dir := range opts.Dirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(c.RepoRoot, dir)
+		}
+		if !isDescendingDir(dir, c.RepoRoot) {
+			return nil, fmt.Errorf("dir %q is not a subdirectory of repo root %q", dir, c.RepoRoot)
+		}
+		c.Dirs = append(c.Dirs, filepath.Clean(dir))
+	}
+
+	c.ValidBuildFileNames = opts.BuildFileNames
+	if len(c.ValidBuildFileNames) == 0 {
+		c.ValidBuildFileNames = config.DefaultValidBuildFileNames
+	}
+	if err := c.SetBuildTags(strings.Join(opts.BuildTags, ",")); err != nil {
+		return nil, err
+	}
+	c.PreprocessTags()
+
+	if err := config.CheckPrefix(opts.GoPrefix); err != nil {
+		return nil, err
+	}
+	c.GoPrefix = opts.GoPrefix
+	c.ShouldFix = opts.Fix
+	c.DepMode = opts.DepMode
+	c.ProtoMode = opts.ProtoMode
+	c.ProtoModeExplicit = opts.ProtoModeExplicit
+	return c, nil
+}
+
+// reportUnresolved reports imports in f that could not be resolved. Positions
+// refer to rules in the new content of the file, which is at path.
+func reportUnresolved(c *config.Config, path string, f File) {
+	if len(f.Unresolved) == 0 {
+		return
+	}
+	starts := make(map[string]bzl.Position)
+	if formatted, err := rule.LoadData(path, f.Pkg, f.Content); err == nil {
+		for _, r := range formatted.Rules {
+			starts[r.Name()] = r.Pos()
+		}
+	}
+	for _, u := range f.Unresolved {
+		start := starts[u.Rule]
+		pos := diag.Position{Path: path, Line: start.Line, Column: start.LineRune}
+		c.Diagnostics.Warnf(diag.UnresolvedImport, pos, "%s: could not resolve import %q: %v", u.Rule, u.Import, u.Err)
+	}
+}
+
+func writeFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0666)
+}
+
+func findWorkspaceName(f *rule.File) string {
+	for _, r := range f.Rules {
+		if r.Kind() == "workspace" {
+			return r.Name()
+		}
+	}
+	return ""
+}
+
+func isDescendingDir(dir, root string) bool {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return false
+	}
+	if rel == "." {
+		return true
+	}
+	return !strings.HasPrefix(rel, "..")
+}
@@ -0,0 +1,1333 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package update_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/diag"
+	"github.com/bazelbuild/bazel-gazelle/packages"
+	"github.com/bazelbuild/bazel-gazelle/update"
+)
+
+type fileSpec struct {
+	path, content string
+}
+
+func createFiles(t *testing.T, files []fileSpec) string {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "update_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestUpdate(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+`,
+		}, {
+			path: "a/a.go",
+			content: `package a
+
+import _ "example.com/repo/b"
+`,
+		}, {
+			path: "b/b.go",
+			content: `package b
+
+import _ "../../outside"
+`,
+		}, {
+			path: "b/BUILD.bazel",
+			content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["b.go"],
+    importpath = "example.com/repo/b",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	result, err := update.Update(context.Background(), update.Options{RepoRoot: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, f := range result.Files {
+		paths = append(paths, f.Path)
+	}
+	if want := []string{"BUILD.bazel", "a/BUILD.bazel", "b/BUILD.bazel"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("got files %q; want %q", paths, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a", "BUILD.bazel")); !os.IsNotExist(err) {
+		t.Errorf("a/BUILD.bazel was written without Write set")
+	}
+
+	a := result.Files[1]
+	if a.OldContent != nil || !a.Changed() {
+		t.Errorf("a/BUILD.bazel: got OldContent %q, Changed %v; want new file", a.OldContent, a.Changed())
+	}
+	if !strings.Contains(string(a.Content), `deps = ["//b:go_default_library"]`) {
+		t.Errorf("a/BUILD.bazel: dependency not resolved:\n%s", a.Content)
+	}
+	if len(a.Created) != 1 || a.Created[0].Name != "go_default_library" {
+		t.Errorf("a/BUILD.bazel: got created rules %v; want go_default_library", a.Created)
+	}
+	if want := []string{"example.com/repo/b"}; !reflect.DeepEqual(a.Imports, want) {
+		t.Errorf("a/BUILD.bazel: got imports %q; want %q", a.Imports, want)
+	}
+
+	b := result.Files[2]
+	if b.Changed() {
+		t.Errorf("b/BUILD.bazel: changed unexpectedly:\n%s", b.Content)
+	}
+	wantPos := diag.Position{Path: "b/BUILD.bazel", Line: 3, Column: 1}
+	if len(result.Diagnostics) != 1 || result.Diagnostics[0].Code != diag.UnresolvedImport || result.Diagnostics[0].Pos != wantPos {
+		t.Errorf("got diagnostics %v; want unresolved import at %v", result.Diagnostics, wantPos)
+	}
+
+	// Update again, writing files this time.
+	result, err = update.Update(context.Background(), update.Options{
+		RepoRoot: dir,
+		Dirs:     []string{"a"},
+		Write:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 || result.Files[0].Path != "a/BUILD.bazel" {
+		t.Fatalf("got files %v; want only a/BUILD.bazel", result.Files)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "a", "BUILD.bazel"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(result.Files[0].Content) {
+		t.Errorf("a/BUILD.bazel: got content on disk:\n%s\nwant:\n%s", data, result.Files[0].Content)
+	}
+}
+
+func TestUpdateCacheResolution(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+`,
+		}, {
+			path: "a/a.go",
+			content: `package a
+
+import _ "github.com/foo/bar"
+`,
+		}, {
+			path: "b/bar.go",
+			content: `package bar
+`,
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	cache, err := packages.LoadCache("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := update.Options{RepoRoot: dir, Cache: cache, Write: true}
+	if _, err := update.Update(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	aPath := filepath.Join(dir, "a", "BUILD.bazel")
+	data, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `deps = ["@com_github_foo_bar//:go_default_library"]`; !strings.Contains(string(data), want) {
+		t.Fatalf("a/BUILD.bazel: got:\n%s\nwant %s", data, want)
+	}
+
+	// Nothing in a changes, but its import is now provided by b.
+	bPath := filepath.Join(dir, "b", "BUILD.bazel")
+	if err := ioutil.WriteFile(bPath, []byte("# gazelle:prefix github.com/foo/bar\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := update.Update(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = ioutil.ReadFile(aPath); err != nil {
+		t.Fatal(err)
+	}
+	if want := `deps = ["//b:go_default_library"]`; !strings.Contains(string(data), want) {
+		t.Errorf("a/BUILD.bazel: got:\n%s\nwant %s", data, want)
+	}
+}
+
+func TestUpdateCacheDiagnostics(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+`,
+		}, {
+			path: "a/a.go",
+			content: `package a
+
+import "fmt
+`,
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	cachePath := filepath.Join(dir, "cache")
+	for i := 0; i < 2; i++ {
+		cache, err := packages.LoadCache(cachePath, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := update.Update(context.Background(), update.Options{RepoRoot: dir, Cache: cache, Write: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.Save(); err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, d := range result.Diagnostics {
+			if d.Code == diag.SourceError && d.Pos.Path == "a/a.go" && d.Severity == diag.Error {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("run %d: got diagnostics %v; want a source error in a/a.go", i+1, result.Diagnostics)
+		}
+	}
+}
+
+func TestUpdateUnnamedCalls(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+`,
+		}, {
+			path: "lib/lib.go",
+			content: `package lib
+`,
+		}, {
+			path: "lib/BUILD.bazel",
+			content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+package(default_visibility = ["//visibility:public"])
+
+licenses(["notice"])
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+)
+`,
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	result, err := update.Update(context.Background(), update.Options{
+		RepoRoot: dir,
+		Dirs:     []string{"lib"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 {
+		t.Fatalf("got %d files; want only lib/BUILD.bazel", len(result.Files))
+	}
+	lib := result.Files[0]
+	if lib.Changed() || len(lib.Created) > 0 || len(lib.Updated) > 0 || len(lib.Deleted) > 0 {
+		t.Errorf("lib/BUILD.bazel: got created %v, updated %v, deleted %v; want no changes:\n%s", lib.Created, lib.Updated, lib.Deleted, lib.Content)
+	}
+}
+
+func TestUpdateNamingConvention(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+# gazelle:go_naming_convention import
+`,
+		}, {
+			path:    "lib/lib.go",
+			content: "package lib\n",
+		}, {
+			path:    "lib/lib_test.go",
+			content: "package lib\n",
+		}, {
+			path: "lib/BUILD.bazel",
+			content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["lib_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+		}, {
+			path: "app/main.go",
+			content: `package main
+
+import _ "example.com/repo/lib"
+`,
+		}, {
+			path: "app/BUILD.bazel",
+			content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/app",
+    visibility = ["//visibility:private"],
+    deps = ["//lib:go_default_library"],
+)
+
+go_binary(
+    name = "app",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			path: "other/BUILD.bazel",
+			content: `filegroup(
+    name = "all",
+    srcs = [
+        "//app",
+        "//lib:go_default_library",
+    ],
+)
+`,
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	// Without Fix, rules keep their names.
+	result, err := update.Update(context.Background(), update.Options{
+		RepoRoot: dir,
+		Dirs:     []string{"app", "lib"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range result.Files {
+		if f.Changed() {
+			t.Errorf("%s: changed without Fix:\n%s", f.Path, f.Content)
+		}
+	}
+
+	// With Fix, rules are renamed, and references are updated, including
+	// references in directories that weren't updated.
+	result, err = update.Update(context.Background(), update.Options{
+		RepoRoot: dir,
+		Dirs:     []string{"app", "lib"},
+		Fix:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"app/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+go_library(
+    name = "app_lib",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/app",
+    visibility = ["//visibility:private"],
+    deps = ["//lib"],
+)
+
+go_binary(
+    name = "app",
+    embed = [":app_lib"],
+    visibility = ["//visibility:public"],
+)
+`,
+		"lib/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "lib",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "lib_test",
+    srcs = ["lib_test.go"],
+    embed = [":lib"],
+)
+`,
+		"other/BUILD.bazel": `filegroup(
+    name = "all",
+    srcs = [
+        "//app",
+        "//lib",
+    ],
+)
+`,
+	}
+	got := make(map[string]string)
+	for _, f := range result.Files {
+		got[f.Path] = string(f.Content)
+	}
+	for path, wantContent := range want {
+		if got[path] != wantContent {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", path, got[path], wantContent)
+		}
+	}
+}
+
+func TestUpdateMapKind(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+# gazelle:map_kind go_library corp_go_library //build:go.bzl
+# gazelle:map_kind go_test corp_go_test //build:go.bzl
+`,
+		}, {
+			path:    "lib/lib.go",
+			content: "package lib\n",
+		}, {
+			path:    "lib/lib_test.go",
+			content: "package lib\n",
+		}, {
+			path: "lib/BUILD.bazel",
+			content: `load("//build:go.bzl", "corp_go_library")
+
+corp_go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			path: "app/main.go",
+			content: `package main
+
+import (
+	_ "example.com/repo/lib"
+	_ "example.com/repo/other"
+)
+`,
+		}, {
+			path: "other/BUILD.bazel",
+			content: `load("//build:go.bzl", "corp_go_library")
+
+corp_go_library(
+    name = "go_default_library",
+    srcs = ["other.go"],
+    importpath = "example.com/repo/other",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	result, err := update.Update(context.Background(), update.Options{
+		RepoRoot: dir,
+		Dirs:     []string{"app", "lib"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"app/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_binary")
+load("//build:go.bzl", "corp_go_library")
+
+corp_go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/app",
+    visibility = ["//visibility:private"],
+    deps = [
+        "//lib:go_default_library",
+        "//other:go_default_library",
+    ],
+)
+
+go_binary(
+    name = "app",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+`,
+		"lib/BUILD.bazel": `load("//build:go.bzl", "corp_go_library", "corp_go_test")
+
+corp_go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+
+corp_go_test(
+    name = "go_default_test",
+    srcs = ["lib_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+	}
+	got := make(map[string]string)
+	for _, f := range result.Files {
+		got[f.Path] = string(f.Content)
+	}
+	for path, wantContent := range want {
+		if got[path] != wantContent {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", path, got[path], wantContent)
+		}
+	}
+	lib := result.Files[1]
+	if want := []update.RuleChange{{Kind: "corp_go_test", Name: "go_default_test"}}; !reflect.DeepEqual(lib.Created, want) || len(lib.Updated) > 0 {
+		t.Errorf("lib/BUILD.bazel: got created %v, updated %v; want created %v", lib.Created, lib.Updated, want)
+	}
+}
+
+func TestUpdateVisibility(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+# gazelle:visibility_mode minimal
+`,
+		}, {
+			path:    "lib/lib.go",
+			content: "package lib\n",
+		}, {
+			path: "lib/BUILD.bazel",
+			content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			path: "app/main.go",
+			content: `package main
+
+import _ "example.com/repo/lib"
+`,
+		}, {
+			path:    "unused/unused.go",
+			content: "package unused\n",
+		}, {
+			path: "other/BUILD.bazel",
+			content: `go_library(
+    name = "go_default_library",
+    srcs = ["other.go"],
+    importpath = "example.com/repo/other",
+    deps = ["//lib:go_default_library"],
+)
+`,
+		}, {
+			path: "team/BUILD.bazel",
+			content: `# gazelle:visibility_mode default
+# gazelle:default_visibility //team:__subpackages__
+`,
+		}, {
+			path:    "team/team.go",
+			content: "package team\n",
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	result, err := update.Update(context.Background(), update.Options{
+		RepoRoot: dir,
+		Dirs:     []string{"app", "lib", "team", "unused"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"lib/BUILD.bazel": `visibility = [
+        "//app:__pkg__",
+        "//other:__pkg__",
+    ],`,
+		"unused/BUILD.bazel": `visibility = ["//visibility:private"],`,
+		"team/BUILD.bazel":   `visibility = ["//team:__subpackages__"],`,
+	}
+	got := make(map[string]string)
+	for _, f := range result.Files {
+		got[f.Path] = string(f.Content)
+	}
+	for path, wantVisibility := range want {
+		if !strings.Contains(got[path], wantVisibility) {
+			t.Errorf("%s: got:\n%s\nwant visibility:\n%s", path, got[path], wantVisibility)
+		}
+	}
+}
+
+func TestUpdateGoTestFile(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+# gazelle:go_test file
+`,
+		}, {
+			path:    "helper/helper.go",
+			content: "package helper\n",
+		}, {
+			path:    "lib/lib.go",
+			content: "package lib\n",
+		}, {
+			path: "lib/fast_test.go",
+			content: `package lib
+
+import "testing"
+
+func TestFast(t *testing.T) {}
+`,
+		}, {
+			path: "lib/slow_test.go",
+			content: `package lib_test
+
+import (
+	"testing"
+
+	_ "example.com/repo/helper"
+	_ "example.com/repo/lib"
+)
+
+func TestSlow(t *testing.T) {}
+`,
+		}, {
+			path: "lib/main_test.go",
+			content: `package lib
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+`,
+		}, {
+			path: "lib/BUILD.bazel",
+			content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = [
+        "fast_test.go",
+        "slow_test.go",
+    ],
+    embed = [":go_default_library"],
+    deps = ["//helper:go_default_library"],
+)
+
+go_test(
+    name = "removed_test",
+    srcs = ["removed_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	result, err := update.Update(context.Background(), update.Options{
+		RepoRoot: dir,
+		Dirs:     []string{"lib"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "fast_test",
+    srcs = [
+        "fast_test.go",
+        "main_test.go",
+    ],
+    embed = [":go_default_library"],
+)
+
+go_test(
+    name = "slow_test",
+    srcs = [
+        "main_test.go",
+        "slow_test.go",
+    ],
+    embed = [":go_default_library"],
+    deps = ["//helper:go_default_library"],
+)
+`
+	if len(result.Files) != 1 {
+		t.Fatalf("got %d files; want only lib/BUILD.bazel", len(result.Files))
+	}
+	if got := string(result.Files[0].Content); got != want {
+		t.Errorf("lib/BUILD.bazel: got:\n%s\nwant:\n%s", got, want)
+	}
+	var deleted []string
+	for _, r := range result.Files[0].Deleted {
+		deleted = append(deleted, r.Name)
+	}
+	sort.Strings(deleted)
+	if want := []string{"go_default_test", "removed_test"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("got deleted rules %q; want %q", deleted, want)
+	}
+}
+
+func TestUpdateGoTestFileOff(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path:    "BUILD.bazel",
+			content: "# gazelle:prefix example.com/repo\n",
+		}, {
+			path:    "lib/lib.go",
+			content: "package lib\n",
+		}, {
+			path: "lib/fast_test.go",
+			content: `package lib
+
+import "testing"
+
+func TestFast(t *testing.T) {}
+`,
+		}, {
+			path: "lib/slow_test.go",
+			content: `package lib
+
+import "testing"
+
+func TestSlow(t *testing.T) {}
+`,
+		}, {
+			path: "lib/BUILD.bazel",
+			content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "fast_test",
+    srcs = ["fast_test.go"],
+    embed = [":go_default_library"],
+)
+
+go_test(
+    name = "slow_test",
+    srcs = ["slow_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	result, err := update.Update(context.Background(), update.Options{
+		RepoRoot: dir,
+		Dirs:     []string{"lib"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = [
+        "fast_test.go",
+        "slow_test.go",
+    ],
+    embed = [":go_default_library"],
+)
+`
+	if len(result.Files) != 1 {
+		t.Fatalf("got %d files; want only lib/BUILD.bazel", len(result.Files))
+	}
+	if got := string(result.Files[0].Content); got != want {
+		t.Errorf("lib/BUILD.bazel: got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUpdateGoTestKeepsManualRules(t *testing.T) {
+	for _, tc := range []struct {
+		desc, mode string
+	}{
+		{desc: "default", mode: "default"},
+		{desc: "file", mode: "file"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			buildFile := `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+# gazelle:go_test ` + tc.mode + `
+# gazelle:exclude integration_test.go
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "integration_test",
+    srcs = ["integration_test.go"],
+    embed = [":go_default_library"],
+    tags = ["manual"],
+)
+
+go_test(
+    name = "unit_test",
+    size = "small",
+    srcs = ["unit_test.go"],
+    embed = [":go_default_library"],
+)
+`
+			dir := createFiles(t, []fileSpec{
+				{path: "WORKSPACE"},
+				{
+					path:    "BUILD.bazel",
+					content: "# gazelle:prefix example.com/repo\n",
+				}, {
+					path:    "lib/lib.go",
+					content: "package lib\n",
+				}, {
+					path: "lib/integration_test.go",
+					content: `package lib
+
+import "testing"
+
+func TestIntegration(t *testing.T) {}
+`,
+				}, {
+					path:    "lib/BUILD.bazel",
+					content: buildFile,
+				},
+			})
+			defer os.RemoveAll(dir)
+
+			result, err := update.Update(context.Background(), update.Options{
+				RepoRoot: dir,
+				Dirs:     []string{"lib"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Files) != 1 {
+				t.Fatalf("got %d files; want only lib/BUILD.bazel", len(result.Files))
+			}
+			if got := string(result.Files[0].Content); got != buildFile {
+				t.Errorf("lib/BUILD.bazel: got:\n%s\nwant:\n%s", got, buildFile)
+			}
+			if deleted := result.Files[0].Deleted; len(deleted) != 0 {
+				t.Errorf("got deleted rules %v; want none", deleted)
+			}
+		})
+	}
+}
+
+func TestUpdateProtoGroups(t *testing.T) {
+	for _, tc := range []struct {
+		mode, want string
+	}{
+		{
+			mode: "file",
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+go_proto_library(
+    name = "api_go_proto",
+    importpath = "example.com/repo/api",
+    protos = [
+        ":bar_proto",
+        ":foo_proto",
+    ],
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":api_go_proto"],
+    importpath = "example.com/repo/api",
+    visibility = ["//visibility:public"],
+)
+
+proto_library(
+    name = "bar_proto",
+    srcs = ["bar.proto"],
+    visibility = ["//visibility:public"],
+)
+
+proto_library(
+    name = "baz_proto",
+    srcs = ["baz.proto"],
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+    visibility = ["//visibility:public"],
+    deps = [":bar_proto"],
+)
+
+go_proto_library(
+    name = "baz_go_proto",
+    compilers = ["@io_bazel_rules_go//proto:go_grpc"],
+    importpath = "example.com/repo/api/baz",
+    proto = ":baz_proto",
+    visibility = ["//visibility:public"],
+    deps = [":go_default_library"],
+)
+`,
+		}, {
+			mode: "package",
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+go_library(
+    name = "go_default_library",
+    embed = [":foo_go_proto"],
+    importpath = "example.com/repo/api",
+    visibility = ["//visibility:public"],
+)
+
+proto_library(
+    name = "baz_proto",
+    srcs = ["baz.proto"],
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+proto_library(
+    name = "foo_proto",
+    srcs = [
+        "bar.proto",
+        "foo.proto",
+    ],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    importpath = "example.com/repo/api",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "baz_go_proto",
+    compilers = ["@io_bazel_rules_go//proto:go_grpc"],
+    importpath = "example.com/repo/api/baz",
+    proto = ":baz_proto",
+    visibility = ["//visibility:public"],
+    deps = [":go_default_library"],
+)
+`,
+		},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			dir := createFiles(t, []fileSpec{
+				{path: "WORKSPACE"},
+				{
+					path: "BUILD.bazel",
+					content: `# gazelle:prefix example.com/repo
+# gazelle:proto ` + tc.mode + "\n",
+				}, {
+					path: "api/foo.proto",
+					content: `syntax = "proto3";
+
+package foo;
+
+import "api/bar.proto";
+`,
+				}, {
+					path: "api/bar.proto",
+					content: `syntax = "proto3";
+
+package foo;
+`,
+				}, {
+					path: "api/baz.proto",
+					content: `syntax = "proto3";
+
+package baz;
+
+option go_package = "example.com/repo/api/baz";
+
+import "api/foo.proto";
+
+service Baz {}
+`,
+				}, {
+					path: "api/BUILD.bazel",
+					content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "api_proto",
+    srcs = [
+        "bar.proto",
+        "baz.proto",
+        "foo.proto",
+    ],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "api_go_proto",
+    importpath = "example.com/repo/api",
+    proto = ":api_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":api_go_proto"],
+    importpath = "example.com/repo/api",
+    visibility = ["//visibility:public"],
+)
+`,
+				}, {
+					path: "client/client.proto",
+					content: `syntax = "proto3";
+
+package client;
+
+import "api/baz.proto";
+`,
+				},
+			})
+			defer os.RemoveAll(dir)
+
+			result, err := update.Update(context.Background(), update.Options{RepoRoot: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, f := range result.Files {
+				got[f.Path] = string(f.Content)
+			}
+			if got["api/BUILD.bazel"] != tc.want {
+				t.Errorf("api/BUILD.bazel: got:\n%s\nwant:\n%s", got["api/BUILD.bazel"], tc.want)
+			}
+			wantClientDeps := `deps = ["//api:baz_proto"],`
+			if !strings.Contains(got["client/BUILD.bazel"], wantClientDeps) {
+				t.Errorf("client/BUILD.bazel: got:\n%s\nwant deps:\n%s", got["client/BUILD.bazel"], wantClientDeps)
+			}
+		})
+	}
+}
+
+func TestUpdateProtoCompilers(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+# gazelle:go_grpc_compilers @io_bazel_rules_go//proto:go_grpc //:gateway=example.com/repo/gateway/runtime
+`,
+		}, {
+			path:    "gateway/runtime/runtime.go",
+			content: "package runtime\n",
+		}, {
+			path: "service/service.proto",
+			content: `syntax = "proto3";
+
+package service;
+
+service Service {}
+`,
+		}, {
+			path:    "validated/BUILD.bazel",
+			content: "# gazelle:go_proto_compilers //:validate\n",
+		}, {
+			path: "validated/validated.proto",
+			content: `syntax = "proto3";
+
+package validated;
+`,
+		}, {
+			path: "plain/plain.proto",
+			content: `syntax = "proto3";
+
+package plain;
+`,
+		}, {
+			path: "plain/BUILD.bazel",
+			content: `load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "plain_proto",
+    srcs = ["plain.proto"],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "plain_go_proto",
+    compilers = ["//:old"],
+    importpath = "example.com/repo/plain",
+    proto = ":plain_proto",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	result, err := update.Update(context.Background(), update.Options{RepoRoot: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, f := range result.Files {
+		got[f.Path] = string(f.Content)
+	}
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{
+			path: "service/BUILD.bazel",
+			want: `go_proto_library(
+    name = "service_go_proto",
+    compilers = [
+        "@io_bazel_rules_go//proto:go_grpc",
+        "//:gateway",
+    ],
+    importpath = "example.com/repo/service",
+    proto = ":service_proto",
+    visibility = ["//visibility:public"],
+    deps = ["//gateway/runtime:go_default_library"],
+)`,
+		}, {
+			path: "validated/BUILD.bazel",
+			want: `go_proto_library(
+    name = "validated_go_proto",
+    compilers = ["//:validate"],
+    importpath = "example.com/repo/validated",
+    proto = ":validated_proto",
+    visibility = ["//visibility:public"],
+)`,
+		}, {
+			path: "plain/BUILD.bazel",
+			want: `go_proto_library(
+    name = "plain_go_proto",
+    importpath = "example.com/repo/plain",
+    proto = ":plain_proto",
+    visibility = ["//visibility:public"],
+)`,
+		},
+	} {
+		if !strings.Contains(got[tc.path], tc.want) {
+			t.Errorf("%s: got:\n%s\nwant rule:\n%s", tc.path, got[tc.path], tc.want)
+		}
+	}
+}
+
+func TestUpdateProtoLangs(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{
+			path: "BUILD.bazel",
+			content: `# gazelle:prefix example.com/repo
+# gazelle:proto_langs go,java,cc,py
+`,
+		}, {
+			path: "foo/foo.proto",
+			content: `syntax = "proto3";
+
+package foo;
+`,
+		}, {
+			path:    "bar/BUILD.bazel",
+			content: "# gazelle:proto_langs java\n",
+		}, {
+			path: "bar/bar.proto",
+			content: `syntax = "proto3";
+
+package bar;
+
+import "foo/foo.proto";
+`,
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	result, err := update.Update(context.Background(), update.Options{RepoRoot: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"foo/BUILD.bazel": `load("@com_google_protobuf//bazel:py_proto_library.bzl", "py_proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+    visibility = ["//visibility:public"],
+)
+
+cc_proto_library(
+    name = "foo_cc_proto",
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+java_proto_library(
+    name = "foo_java_proto",
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+py_proto_library(
+    name = "foo_py_proto",
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    importpath = "example.com/repo/foo",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":foo_go_proto"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+)
+`,
+		"bar/BUILD.bazel": `# gazelle:proto_langs java
+
+proto_library(
+    name = "bar_proto",
+    srcs = ["bar.proto"],
+    visibility = ["//visibility:public"],
+    deps = ["//foo:foo_proto"],
+)
+
+java_proto_library(
+    name = "bar_java_proto",
+    visibility = ["//visibility:public"],
+    deps = [":bar_proto"],
+)
+`,
+	}
+	got := make(map[string]string)
+	for _, f := range result.Files {
+		got[f.Path] = string(f.Content)
+	}
+	for path, wantContent := range want {
+		if got[path] != wantContent {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", path, got[path], wantContent)
+		}
+	}
+}
+
+func TestUpdateCancelled(t *testing.T) {
+	dir := createFiles(t, []fileSpec{
+		{path: "WORKSPACE"},
+		{path: "a.go", content: "package a\n"},
+	})
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := update.Update(ctx, update.Options{RepoRoot: dir, Write: true}); err != context.Canceled {
+		t.Errorf("got error %v; want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "BUILD.bazel")); !os.IsNotExist(err) {
+		t.Errorf("BUILD.bazel was written after cancellation")
+	}
+}
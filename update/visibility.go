@@ -0,0 +1,56 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package update
+
+import (
+	"fmt"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// setMinimalVisibility narrows the visibility of rules in v that other rules
+// may depend on to the packages with rules that depend on them, as recorded
+// in ix. Rules without a visibility attribute are skipped, since their
+// visibility comes from the package's default_visibility. Rules and
+// visibility attributes marked with "# keep" are not changed.
+func setMinimalVisibility(ix *resolve.RuleIndex, v visitRecord) {
+	for _, r := range v.rules {
+		visibility := r.Attr("visibility")
+		if visibility == nil || r.ShouldKeep() || rule.ShouldKeep(visibility) {
+			continue
+		}
+		l := label.New("", v.pkgRel, r.Name())
+		if !ix.HasRule(l) {
+			continue
+		}
+		r.SetAttr("visibility", minimalVisibility(ix.FindRuleDependents(l)))
+	}
+}
+
+// minimalVisibility returns a visibility that includes exactly the packages
+// in pkgs, or private visibility if pkgs is empty.
+func minimalVisibility(pkgs []string) []string {
+	if len(pkgs) == 0 {
+		return []string{"//visibility:private"}
+	}
+	visibility := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		visibility = append(visibility, fmt.Sprintf("//%s:__pkg__", pkg))
+	}
+	return visibility
+}